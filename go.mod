@@ -9,6 +9,8 @@ require (
 	github.com/fatih/color v1.17.0
 	github.com/go-zookeeper/zk v1.0.3
 	github.com/google/gopacket v1.1.19
+	github.com/jmoiron/sqlx v1.4.0
+	github.com/mattn/go-sqlite3 v1.14.28
 	github.com/natefinch/lumberjack v2.0.0+incompatible
 	github.com/pquerna/otp v1.3.0
 	github.com/prometheus/client_golang v1.15.1
@@ -36,11 +38,6 @@ require (
 	software.sslmate.com/src/go-pkcs12 v0.4.0
 )
 
-require (
-	github.com/jmoiron/sqlx v1.4.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.28 // indirect
-)
-
 require (
 	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
@@ -0,0 +1,297 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	sseclient "github.com/ilaziness/gopkg/net/http/sse/client"
+	"github.com/ilaziness/gopkg/net/http/stream/ndjson"
+)
+
+// ErrSessionExpired 服务端找不到会话，需要重新Initialize
+var ErrSessionExpired = errors.New("mcp: session expired")
+
+// Client MCP客户端，字段在Initialize之前设置
+type Client struct {
+	URL string
+	// HTTPClient 为nil时用http.DefaultClient
+	HTTPClient *http.Client
+	// Accept 希望的响应格式，ContentTypeSSE、ContentTypeNDJSON或者ContentTypeJSON，默认SSE
+	Accept string
+	// ClientInfo initialize时发送的客户端信息
+	ClientInfo Implementation
+	// OnProgress 不为nil时CallTool带上progressToken，收到进度通知时调用
+	OnProgress func(ProgressParams)
+
+	nextID    atomic.Int64
+	mu        sync.Mutex
+	sessionID string
+}
+
+func NewClient(url string) *Client {
+	return &Client{URL: url, ClientInfo: Implementation{Name: "gopkg-mcp-client", Version: "0.1.0"}}
+}
+
+// Initialize 握手并建立会话，其他方法都要在这之后调用
+func (c *Client) Initialize(ctx context.Context) (*InitializeResult, error) {
+	c.mu.Lock()
+	c.sessionID = ""
+	c.mu.Unlock()
+	var res InitializeResult
+	err := c.call(ctx, "initialize", InitializeParams{
+		ProtocolVersion: ProtocolVersion,
+		Capabilities:    map[string]any{},
+		ClientInfo:      c.ClientInfo,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.ProtocolVersion != ProtocolVersion {
+		return nil, fmt.Errorf("mcp: unsupported protocol version %q", res.ProtocolVersion)
+	}
+	if err := c.notify(ctx, "notifications/initialized"); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	return c.call(ctx, "ping", nil, nil)
+}
+
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var res ListToolsResult
+	if err := c.call(ctx, "tools/list", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Tools, nil
+}
+
+// CallTool 调用工具，args编码成JSON对象
+// 工具执行出错时返回的结果IsError为true，不返回错误
+func (c *Client) CallTool(ctx context.Context, name string, args any) (*CallToolResult, error) {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	p := CallToolParams{Name: name, Arguments: b}
+	if c.OnProgress != nil {
+		p.Meta = &Meta{ProgressToken: "p" + strconv.FormatInt(c.nextID.Add(1), 10)}
+	}
+	var res CallToolResult
+	if err := c.call(ctx, "tools/call", p, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (c *Client) ListResources(ctx context.Context) ([]Resource, error) {
+	var res ListResourcesResult
+	if err := c.call(ctx, "resources/list", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Resources, nil
+}
+
+func (c *Client) ReadResource(ctx context.Context, uri string) ([]ResourceContents, error) {
+	var res ReadResourceResult
+	if err := c.call(ctx, "resources/read", ReadResourceParams{URI: uri}, &res); err != nil {
+		return nil, err
+	}
+	return res.Contents, nil
+}
+
+// Close 结束会话
+func (c *Client) Close(ctx context.Context) error {
+	c.mu.Lock()
+	id := c.sessionID
+	c.sessionID = ""
+	c.mu.Unlock()
+	if id == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(SessionHeader, id)
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) notify(ctx context.Context, method string) error {
+	m := &Message{JSONRPC: jsonrpcVersion, Method: method}
+	resp, err := c.post(ctx, m)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// call 发送请求，结果解码到result，服务端返回错误时是*Error
+func (c *Client) call(ctx context.Context, method string, params, result any) error {
+	id := json.RawMessage(strconv.FormatInt(c.nextID.Add(1), 10))
+	m := &Message{JSONRPC: jsonrpcVersion, ID: id, Method: method}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		m.Params = b
+	}
+	resp, err := c.post(ctx, m)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	reply, err := c.readReply(resp, id)
+	if err != nil {
+		return err
+	}
+	if reply.Error != nil {
+		return reply.Error
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// post 发送一条消息，返回200或202的响应
+func (c *Client) post(ctx context.Context, m *Message) (*http.Response, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	accept := c.Accept
+	if accept == "" {
+		accept = ContentTypeSSE
+	}
+	if accept != ContentTypeJSON {
+		accept = ContentTypeJSON + ", " + accept
+	}
+	req.Header.Set("Accept", accept)
+	c.mu.Lock()
+	if c.sessionID != "" {
+		req.Header.Set(SessionHeader, c.sessionID)
+	}
+	c.mu.Unlock()
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if id := resp.Header.Get(SessionHeader); id != "" {
+		c.mu.Lock()
+		c.sessionID = id
+		c.mu.Unlock()
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		return resp, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		if req.Header.Get(SessionHeader) != "" {
+			return nil, ErrSessionExpired
+		}
+	}
+	// 解析错误等情况下服务端也可能返回JSON-RPC错误
+	defer resp.Body.Close()
+	var reply Message
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(&reply); err == nil && reply.Error != nil {
+		return nil, reply.Error
+	}
+	return nil, fmt.Errorf("mcp: unexpected status %s", resp.Status)
+}
+
+// readReply 按Content-Type读取响应，流里id匹配的响应之前的通知交给handleNotification
+func (c *Client) readReply(resp *http.Response, id json.RawMessage) (*Message, error) {
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mt {
+	case ContentTypeJSON:
+		var reply Message
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			return nil, err
+		}
+		return &reply, nil
+	case ContentTypeSSE:
+		r := sseclient.NewReader(resp.Body)
+		for {
+			e, err := r.Next()
+			if err != nil {
+				return nil, streamEnded(err)
+			}
+			if e.Event != "message" {
+				continue
+			}
+			var m Message
+			if err := json.Unmarshal([]byte(e.Data), &m); err != nil {
+				return nil, err
+			}
+			if c.dispatch(&m, id) {
+				return &m, nil
+			}
+		}
+	case ContentTypeNDJSON:
+		r := ndjson.NewNDJSONReader[Message](resp.Body)
+		r.MaxLineSize = maxBodySize
+		for {
+			m, err := r.Read()
+			if err != nil {
+				return nil, streamEnded(err)
+			}
+			if c.dispatch(&m, id) {
+				return &m, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("mcp: unexpected content type %q", resp.Header.Get("Content-Type"))
+}
+
+// dispatch 处理流里的一条消息，是id对应的响应时返回true
+func (c *Client) dispatch(m *Message, id json.RawMessage) bool {
+	if m.isResponse() {
+		return bytes.Equal(m.ID, id)
+	}
+	if m.Method == "notifications/progress" && c.OnProgress != nil {
+		var p ProgressParams
+		if json.Unmarshal(m.Params, &p) == nil {
+			c.OnProgress(p)
+		}
+	}
+	return false
+}
+
+func streamEnded(err error) error {
+	if errors.Is(err, io.EOF) {
+		return errors.New("mcp: stream ended without response")
+	}
+	return err
+}
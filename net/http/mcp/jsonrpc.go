@@ -0,0 +1,94 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// JSON-RPC 2.0 https://www.jsonrpc.org/specification
+
+const jsonrpcVersion = "2.0"
+
+// JSON-RPC定义的错误码
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// CodeResourceNotFound MCP定义的资源不存在
+	CodeResourceNotFound = -32002
+)
+
+// Message 一条JSON-RPC消息，请求、通知和响应共用
+// 有Method没有ID是通知，有Method有ID是请求，没有Method是响应
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+func (m *Message) isNotification() bool {
+	return m.Method != "" && m.ID == nil
+}
+
+func (m *Message) isResponse() bool {
+	return m.Method == "" && m.ID != nil
+}
+
+// Error JSON-RPC错误
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mcp: %s (%d)", e.Message, e.Code)
+}
+
+func errorf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func newResponse(id json.RawMessage, result any) *Message {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return newErrorResponse(id, errorf(CodeInternalError, "marshal result: %v", err))
+	}
+	return &Message{JSONRPC: jsonrpcVersion, ID: id, Result: b}
+}
+
+func newErrorResponse(id json.RawMessage, e *Error) *Message {
+	if id == nil {
+		// 解析不出id时必须是null
+		id = json.RawMessage("null")
+	}
+	return &Message{JSONRPC: jsonrpcVersion, ID: id, Error: e}
+}
+
+func newNotification(method string, params any) (*Message, error) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{JSONRPC: jsonrpcVersion, Method: method, Params: b}, nil
+}
+
+// decodeMessages 解析单个消息或者批量消息，batch表示是数组
+func decodeMessages(body []byte) (msgs []*Message, batch bool, err error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		batch = true
+		err = json.Unmarshal(body, &msgs)
+	} else {
+		var m Message
+		err = json.Unmarshal(body, &m)
+		msgs = []*Message{&m}
+	}
+	return
+}
@@ -0,0 +1,323 @@
+package mcp
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type weatherArgs struct {
+	City string `json:"city" description:"城市名"`
+	Unit string `json:"unit,omitempty" enum:"celsius,fahrenheit"`
+	Days *int   `json:"days,omitempty"`
+}
+
+type weather struct {
+	City string  `json:"city"`
+	Temp float64 `json:"temp"`
+}
+
+type node struct {
+	Name     string          `json:"name"`
+	Children []node          `json:"children,omitempty"`
+	Tags     map[string]bool `json:"tags,omitempty"`
+	At       time.Time       `json:"at"`
+	Raw      []byte          `json:"raw,omitempty"`
+	Hash     [4]byte         `json:"hash,omitempty"`
+	Skip     string          `json:"-"`
+	hidden   string
+	weather
+}
+
+func TestSchemaFor(t *testing.T) {
+	b, _ := json.Marshal(SchemaFor[weatherArgs]())
+	want := `{"type":"object","properties":{"city":{"type":"string","description":"城市名"},` +
+		`"days":{"type":"integer"},"unit":{"type":"string","enum":["celsius","fahrenheit"]}},"required":["city"]}`
+	if string(b) != want {
+		t.Errorf("schema %s\n期望 %s", b, want)
+	}
+
+	s := SchemaFor[node]()
+	if got := s.Properties["children"].Items; got.Type != "object" || got.Properties != nil {
+		t.Errorf("递归引用不展开 %+v", got)
+	}
+	if s.Properties["tags"].AdditionalProperties.Type != "boolean" {
+		t.Errorf("map %+v", s.Properties["tags"])
+	}
+	if at := s.Properties["at"]; at.Type != "string" || at.Format != "date-time" {
+		t.Errorf("time.Time %+v", at)
+	}
+	if s.Properties["raw"].Format != "byte" {
+		t.Errorf("[]byte %+v", s.Properties["raw"])
+	}
+	// [N]byte编码成数字数组
+	if h := s.Properties["hash"]; h.Type != "array" || h.Items.Type != "integer" {
+		t.Errorf("[4]byte %+v", h)
+	}
+	if _, ok := s.Properties["Skip"]; ok {
+		t.Error("json:\"-\"的字段应该忽略")
+	}
+	if _, ok := s.Properties["temp"]; !ok {
+		t.Error("匿名结构体的字段应该提升")
+	}
+	if !reflect.DeepEqual(s.Required, []string{"name", "at", "city", "temp"}) {
+		t.Errorf("required %v", s.Required)
+	}
+}
+
+func newTestServer() *Server {
+	s := NewServer("test", "1.0")
+	AddTool(s, "get_weather", "查询天气", func(ctx context.Context, in weatherArgs) (weather, error) {
+		if in.City == "" {
+			return weather{}, errors.New("city is empty")
+		}
+		for i := 1; i <= 3; i++ {
+			if err := Progress(ctx, float64(i), 3, "querying"); err != nil {
+				return weather{}, err
+			}
+		}
+		return weather{City: in.City, Temp: 22.5}, nil
+	})
+	AddTool(s, "echo", "原样返回", func(ctx context.Context, in map[string]any) (string, error) {
+		return in["text"].(string), nil
+	})
+	s.AddResource(Resource{URI: "file:///readme.md", Name: "readme", MIMEType: "text/markdown"}, func(context.Context) ([]byte, error) {
+		return []byte("# hello"), nil
+	})
+	s.AddResource(Resource{URI: "file:///logo.png", Name: "logo", MIMEType: "image/png"}, func(context.Context) ([]byte, error) {
+		return []byte{0x89, 'P', 'N', 'G'}, nil
+	})
+	return s
+}
+
+func TestClientServer(t *testing.T) {
+	srv := httptest.NewServer(newTestServer())
+	defer srv.Close()
+
+	for _, accept := range []string{ContentTypeSSE, ContentTypeNDJSON, ContentTypeJSON} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		c := NewClient(srv.URL)
+		c.Accept = accept
+		var progress []float64
+		c.OnProgress = func(p ProgressParams) {
+			progress = append(progress, p.Progress)
+		}
+
+		init, err := c.Initialize(ctx)
+		if err != nil {
+			t.Fatalf("%s initialize: %v", accept, err)
+		}
+		if init.ServerInfo.Name != "test" || init.Capabilities.Tools == nil || init.Capabilities.Resources == nil {
+			t.Errorf("%s initialize %+v", accept, init)
+		}
+		if err := c.Ping(ctx); err != nil {
+			t.Errorf("%s ping: %v", accept, err)
+		}
+
+		tools, err := c.ListTools(ctx)
+		if err != nil || len(tools) != 2 || tools[0].Name != "get_weather" || tools[0].InputSchema.Required[0] != "city" {
+			t.Errorf("%s tools/list %+v %v", accept, tools, err)
+		}
+
+		res, err := c.CallTool(ctx, "get_weather", weatherArgs{City: "Shanghai"})
+		if err != nil {
+			t.Fatalf("%s tools/call: %v", accept, err)
+		}
+		var w weather
+		if err := json.Unmarshal([]byte(res.Text()), &w); err != nil || w.City != "Shanghai" || w.Temp != 22.5 || res.IsError {
+			t.Errorf("%s 工具结果 %+v %v", accept, res, err)
+		}
+		// JSON响应不能在结果之前发送通知
+		if accept == ContentTypeJSON && len(progress) != 0 {
+			t.Errorf("JSON响应不应该有进度通知 %v", progress)
+		}
+		if accept != ContentTypeJSON && !reflect.DeepEqual(progress, []float64{1, 2, 3}) {
+			t.Errorf("%s 进度通知 %v", accept, progress)
+		}
+
+		res, err = c.CallTool(ctx, "echo", map[string]string{"text": "hi"})
+		if err != nil || res.Text() != "hi" {
+			t.Errorf("%s echo %+v %v", accept, res, err)
+		}
+
+		contents, err := c.ReadResource(ctx, "file:///readme.md")
+		if err != nil || len(contents) != 1 || contents[0].Text != "# hello" {
+			t.Errorf("%s 读文本资源 %+v %v", accept, contents, err)
+		}
+		contents, err = c.ReadResource(ctx, "file:///logo.png")
+		if err != nil || len(contents) != 1 || contents[0].Blob != base64.StdEncoding.EncodeToString([]byte{0x89, 'P', 'N', 'G'}) {
+			t.Errorf("%s 读二进制资源 %+v %v", accept, contents, err)
+		}
+		resources, err := c.ListResources(ctx)
+		if err != nil || len(resources) != 2 {
+			t.Errorf("%s resources/list %+v %v", accept, resources, err)
+		}
+
+		if err := c.Close(ctx); err != nil {
+			t.Errorf("%s close: %v", accept, err)
+		}
+		cancel()
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := httptest.NewServer(newTestServer())
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := NewClient(srv.URL)
+
+	if _, err := c.ListTools(ctx); err == nil {
+		t.Error("没有initialize时应该出错")
+	}
+	if _, err := c.Initialize(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 工具自身的错误在结果里
+	res, err := c.CallTool(ctx, "get_weather", map[string]string{"city": ""})
+	if err != nil || !res.IsError || res.Text() != "city is empty" {
+		t.Errorf("工具出错 %+v %v", res, err)
+	}
+
+	var e *Error
+	_, err = c.CallTool(ctx, "get_weather", map[string]string{})
+	if !errors.As(err, &e) || e.Code != CodeInvalidParams {
+		t.Errorf("缺少必填参数 %v", err)
+	}
+	_, err = c.CallTool(ctx, "get_weather", map[string]int{"city": 1})
+	if !errors.As(err, &e) || e.Code != CodeInvalidParams {
+		t.Errorf("参数类型错误 %v", err)
+	}
+	_, err = c.CallTool(ctx, "nope", nil)
+	if !errors.As(err, &e) || e.Code != CodeInvalidParams {
+		t.Errorf("不存在的工具 %v", err)
+	}
+	_, err = c.ReadResource(ctx, "file:///nope")
+	if !errors.As(err, &e) || e.Code != CodeResourceNotFound {
+		t.Errorf("不存在的资源 %v", err)
+	}
+	if err := c.call(ctx, "nope/nope", nil, nil); !errors.As(err, &e) || e.Code != CodeMethodNotFound {
+		t.Errorf("不存在的方法 %v", err)
+	}
+
+	// 会话结束后需要重新initialize
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c.sessionID = "deadbeef"
+	if err := c.Ping(ctx); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("会话过期 %v", err)
+	}
+}
+
+// post 直接发送请求体，返回响应
+func post(t *testing.T, url, session, accept, body string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", ContentTypeJSON)
+	req.Header.Set("Accept", accept)
+	if session != "" {
+		req.Header.Set(SessionHeader, session)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+func TestRawJSONRPC(t *testing.T) {
+	srv := httptest.NewServer(newTestServer())
+	defer srv.Close()
+
+	resp, body := post(t, srv.URL, "", ContentTypeJSON, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}`)
+	session := resp.Header.Get(SessionHeader)
+	if session == "" || !strings.Contains(body, `"protocolVersion":"`+ProtocolVersion+`"`) {
+		t.Fatalf("initialize %s %s", resp.Status, body)
+	}
+
+	resp, body = post(t, srv.URL, session, ContentTypeJSON, `{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	if resp.StatusCode != http.StatusAccepted || body != "" {
+		t.Errorf("通知应该返回202 %s %q", resp.Status, body)
+	}
+
+	resp, body = post(t, srv.URL, session, ContentTypeJSON, `{"jsonrpc":"2.0","id":1,`)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, `"id":null`) || !strings.Contains(body, `-32700`) {
+		t.Errorf("解析错误 %s %s", resp.Status, body)
+	}
+
+	// 批量请求按顺序返回，通知没有响应
+	_, body = post(t, srv.URL, session, ContentTypeJSON, `[{"jsonrpc":"2.0","id":"a","method":"ping"},`+
+		`{"jsonrpc":"2.0","method":"notifications/cancelled"},{"jsonrpc":"1.0","id":"b","method":"ping"}]`)
+	var resps []Message
+	if err := json.Unmarshal([]byte(body), &resps); err != nil || len(resps) != 2 ||
+		string(resps[0].ID) != `"a"` || resps[0].Error != nil ||
+		string(resps[1].ID) != `"b"` || resps[1].Error == nil || resps[1].Error.Code != CodeInvalidRequest {
+		t.Errorf("批量请求 %s", body)
+	}
+
+	// SSE响应里通知在结果之前
+	resp, body = post(t, srv.URL, session, "application/json, text/event-stream",
+		`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"get_weather","arguments":{"city":"x"},"_meta":{"progressToken":"tok"}}}`)
+	if resp.Header.Get("Content-Type") != ContentTypeSSE || strings.Count(body, "event: message\n") != 4 ||
+		strings.Index(body, `"progressToken":"tok"`) > strings.Index(body, `"id":7`) {
+		t.Errorf("SSE响应 %s", body)
+	}
+
+	resp, _ = post(t, srv.URL, "", ContentTypeJSON, `{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("没有会话id %s", resp.Status)
+	}
+	resp, _ = http.Get(srv.URL)
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET %s", resp.Status)
+	}
+	resp.Body.Close()
+}
+
+func TestSessions(t *testing.T) {
+	s := newTestServer()
+	s.MaxSessions = 2
+	s.SessionTimeout = 100 * time.Millisecond
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// initialize失败不创建会话
+	resp, body := post(t, srv.URL, "", ContentTypeJSON, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":"x"}`)
+	if resp.Header.Get(SessionHeader) != "" || !strings.Contains(body, `-32602`) {
+		t.Errorf("initialize失败 %s %s", resp.Header.Get(SessionHeader), body)
+	}
+
+	init := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"` + ProtocolVersion + `"}}`
+	ping := `{"jsonrpc":"2.0","id":2,"method":"ping"}`
+	var ids []string
+	for range 3 {
+		resp, _ := post(t, srv.URL, "", ContentTypeJSON, init)
+		ids = append(ids, resp.Header.Get(SessionHeader))
+	}
+	if len(s.sessions) != 2 {
+		t.Errorf("会话数 %d 超过上限", len(s.sessions))
+	}
+	if resp, _ := post(t, srv.URL, ids[0], ContentTypeJSON, ping); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("最早的会话应该被删除 %s", resp.Status)
+	}
+	if resp, _ := post(t, srv.URL, ids[2], ContentTypeJSON, ping); resp.StatusCode != http.StatusOK {
+		t.Errorf("新会话 %s", resp.Status)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if resp, _ := post(t, srv.URL, ids[2], ContentTypeJSON, ping); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("超时的会话应该被删除 %s", resp.Status)
+	}
+}
@@ -0,0 +1,125 @@
+package mcp
+
+import "encoding/json"
+
+// MCP的消息类型，按2025-03-26版本
+// https://modelcontextprotocol.io/specification/2025-03-26
+
+const ProtocolVersion = "2025-03-26"
+
+// 响应的格式，客户端在Accept里声明
+const (
+	ContentTypeJSON   = "application/json"
+	ContentTypeSSE    = "text/event-stream"
+	ContentTypeNDJSON = "application/x-ndjson"
+)
+
+// SessionHeader initialize时服务端分配的会话id，之后的请求都要带上
+const SessionHeader = "Mcp-Session-Id"
+
+// Implementation 客户端或者服务端的名称和版本
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type InitializeParams struct {
+	ProtocolVersion string         `json:"protocolVersion"`
+	Capabilities    map[string]any `json:"capabilities"`
+	ClientInfo      Implementation `json:"clientInfo"`
+}
+
+type InitializeResult struct {
+	ProtocolVersion string             `json:"protocolVersion"`
+	Capabilities    ServerCapabilities `json:"capabilities"`
+	ServerInfo      Implementation     `json:"serverInfo"`
+	Instructions    string             `json:"instructions,omitempty"`
+}
+
+type ServerCapabilities struct {
+	Tools     *struct{} `json:"tools,omitempty"`
+	Resources *struct{} `json:"resources,omitempty"`
+}
+
+// Tool tools/list返回的工具描述
+type Tool struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	InputSchema *Schema `json:"inputSchema"`
+}
+
+type ListToolsResult struct {
+	Tools []Tool `json:"tools"`
+}
+
+type CallToolParams struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Meta      *Meta           `json:"_meta,omitempty"`
+}
+
+// Meta 请求的附加信息，有ProgressToken时服务端可以发送进度通知
+type Meta struct {
+	ProgressToken any `json:"progressToken,omitempty"`
+}
+
+// Content 工具返回的内容，Type为text时是Text，为image时是Data和MIMEType
+type Content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MIMEType string `json:"mimeType,omitempty"`
+}
+
+// CallToolResult 工具执行的结果，工具自身出错时IsError为true，错误信息在Content里
+type CallToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Text 把所有text内容连起来
+func (r *CallToolResult) Text() string {
+	var s string
+	for _, c := range r.Content {
+		if c.Type == "text" {
+			s += c.Text
+		}
+	}
+	return s
+}
+
+// Resource resources/list返回的资源描述
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MIMEType    string `json:"mimeType,omitempty"`
+}
+
+type ListResourcesResult struct {
+	Resources []Resource `json:"resources"`
+}
+
+type ReadResourceParams struct {
+	URI string `json:"uri"`
+}
+
+// ResourceContents 资源内容，文本放在Text，二进制base64编码后放在Blob
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MIMEType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+type ReadResourceResult struct {
+	Contents []ResourceContents `json:"contents"`
+}
+
+// ProgressParams notifications/progress的参数
+type ProgressParams struct {
+	ProgressToken any     `json:"progressToken"`
+	Progress      float64 `json:"progress"`
+	Total         float64 `json:"total,omitempty"`
+	Message       string  `json:"message,omitempty"`
+}
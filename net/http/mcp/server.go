@@ -0,0 +1,531 @@
+// Package mcp Model Context Protocol的服务端和客户端
+//
+// 传输用Streamable HTTP：客户端POST JSON-RPC消息，服务端按Accept返回
+// application/json、text/event-stream或者application/x-ndjson，
+// 流式响应里可以在结果之前先发送进度通知
+//
+// 支持initialize、ping、tools/list、tools/call、resources/list和resources/read
+package mcp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/http/sse/broker"
+	"github.com/ilaziness/gopkg/net/http/stream/ndjson"
+)
+
+// maxBodySize 请求体的最大字节数
+const maxBodySize = 4 << 20
+
+const (
+	DefaultSessionTimeout = 30 * time.Minute
+	DefaultMaxSessions    = 10000
+)
+
+// Server MCP服务端，工具和资源在开始服务之前注册
+type Server struct {
+	info Implementation
+	// Instructions initialize时返回给客户端的使用说明
+	Instructions string
+	// SessionTimeout 会话超过这个时间没有请求就删除，<=0时用DefaultSessionTimeout
+	SessionTimeout time.Duration
+	// MaxSessions 最多保留的会话数，超过时删除最久没用的，<=0时用DefaultMaxSessions
+	MaxSessions int
+
+	mu        sync.RWMutex
+	tools     map[string]*tool
+	toolNames []string
+	resources map[string]*resource
+	resURIs   []string
+	// sessions 会话id到最后使用时间
+	sessions map[string]time.Time
+}
+
+type tool struct {
+	Tool
+	required []string
+	call     func(ctx context.Context, args json.RawMessage) (*CallToolResult, error)
+}
+
+type resource struct {
+	Resource
+	read func(ctx context.Context) ([]byte, error)
+}
+
+func NewServer(name, version string) *Server {
+	return &Server{
+		info:      Implementation{Name: name, Version: version},
+		tools:     map[string]*tool{},
+		resources: map[string]*resource{},
+		sessions:  map[string]time.Time{},
+	}
+}
+
+// AddTool 注册工具，参数的JSON Schema由In生成，见SchemaFor
+//
+// Out是string时作为文本返回，是*CallToolResult时原样返回，其他类型编码成JSON文本；
+// fn返回错误时结果的IsError为true，错误信息作为文本返回给模型
+func AddTool[In, Out any](s *Server, name, description string, fn func(ctx context.Context, in In) (Out, error)) {
+	schema := SchemaFor[In]()
+	if schema.Type != "object" {
+		panic(fmt.Sprintf("mcp: arguments of tool %s must be a struct or map", name))
+	}
+	t := &tool{
+		Tool:     Tool{Name: name, Description: description, InputSchema: schema},
+		required: schema.Required,
+	}
+	t.call = func(ctx context.Context, args json.RawMessage) (*CallToolResult, error) {
+		var in In
+		if len(args) > 0 {
+			if err := json.Unmarshal(args, &in); err != nil {
+				return nil, errorf(CodeInvalidParams, "invalid arguments: %v", err)
+			}
+		}
+		out, err := fn(ctx, in)
+		if err != nil {
+			return &CallToolResult{Content: []Content{{Type: "text", Text: err.Error()}}, IsError: true}, nil
+		}
+		return toolResult(out)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tools[name]; !ok {
+		s.toolNames = append(s.toolNames, name)
+	}
+	s.tools[name] = t
+}
+
+func toolResult(out any) (*CallToolResult, error) {
+	switch v := out.(type) {
+	case string:
+		return &CallToolResult{Content: []Content{{Type: "text", Text: v}}}, nil
+	case *CallToolResult:
+		return v, nil
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return nil, errorf(CodeInternalError, "marshal tool result: %v", err)
+	}
+	return &CallToolResult{Content: []Content{{Type: "text", Text: string(b)}}}, nil
+}
+
+// AddResource 注册资源，read在每次resources/read时调用
+// MIMEType是text/*或者JSON、XML时内容作为文本返回，否则base64编码
+func (s *Server) AddResource(r Resource, read func(ctx context.Context) ([]byte, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.resources[r.URI]; !ok {
+		s.resURIs = append(s.resURIs, r.URI)
+	}
+	s.resources[r.URI] = &resource{Resource: r, read: read}
+}
+
+// ServeHTTP POST发送消息，DELETE结束会话
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.handlePost(w, r)
+	case http.MethodDelete:
+		id := r.Header.Get(SessionHeader)
+		s.mu.Lock()
+		_, ok := s.sessions[id]
+		delete(s.sessions, id)
+		s.mu.Unlock()
+		if !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+		}
+	default:
+		// 没有服务端主动发起的消息，不支持GET
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePost(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	msgs, batch, err := decodeMessages(body)
+	if err != nil || len(msgs) == 0 {
+		writeJSON(w, http.StatusBadRequest, newErrorResponse(nil, errorf(CodeParseError, "parse error")))
+		return
+	}
+
+	var requests []*Message
+	initialize := false
+	for _, m := range msgs {
+		if m.Method == "initialize" {
+			initialize = true
+		}
+		if !m.isNotification() && !m.isResponse() {
+			requests = append(requests, m)
+		}
+	}
+	handle := s.handle
+	if initialize {
+		if batch {
+			writeJSON(w, http.StatusBadRequest, newErrorResponse(nil, errorf(CodeInvalidRequest, "initialize must not be part of a batch")))
+			return
+		}
+		if len(requests) == 1 {
+			// 初始化成功后才创建会话，会话id要在写响应之前放进header
+			resp := s.handle(r.Context(), requests[0], nil)
+			if resp.Error == nil {
+				w.Header().Set(SessionHeader, s.newSession())
+			}
+			handle = func(context.Context, *Message, func(*Message) error) *Message { return resp }
+		}
+	} else if !s.checkSession(w, r) {
+		return
+	}
+	if len(requests) == 0 {
+		// 只有通知和响应时不需要返回内容
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	ctx := r.Context()
+	switch negotiate(r.Header.Get("Accept")) {
+	case ContentTypeNDJSON:
+		nw := ndjson.NewNDJSONWriter[*Message](w, r)
+		serveStream(ctx, requests, handle, nw.Write)
+	case ContentTypeSSE:
+		serveStream(ctx, requests, handle, newSSEWriter(w).write)
+	default:
+		var resps []*Message
+		for _, m := range requests {
+			resps = append(resps, handle(ctx, m, nil))
+		}
+		if batch {
+			writeJSON(w, http.StatusOK, resps)
+		} else {
+			writeJSON(w, http.StatusOK, resps[0])
+		}
+	}
+}
+
+// serveStream 依次处理请求，处理过程中的通知和最后的响应都写到流里
+func serveStream(ctx context.Context, requests []*Message, handle handleFunc, write func(*Message) error) {
+	var mu sync.Mutex
+	send := func(m *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return write(m)
+	}
+	for _, m := range requests {
+		if err := send(handle(ctx, m, send)); err != nil {
+			slog.Debug("mcp: write response", "err", err)
+			return
+		}
+	}
+}
+
+// newSession 分配会话id，同时清理超时的会话，数量超过上限时删除最久没用的
+func (s *Server) newSession() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timeout := s.sessionTimeout()
+	var oldest string
+	for sid, used := range s.sessions {
+		if now.Sub(used) > timeout {
+			delete(s.sessions, sid)
+		} else if oldest == "" || used.Before(s.sessions[oldest]) {
+			oldest = sid
+		}
+	}
+	limit := s.MaxSessions
+	if limit <= 0 {
+		limit = DefaultMaxSessions
+	}
+	if len(s.sessions) >= limit {
+		delete(s.sessions, oldest)
+	}
+	s.sessions[id] = now
+	return id
+}
+
+func (s *Server) sessionTimeout() time.Duration {
+	if s.SessionTimeout <= 0 {
+		return DefaultSessionTimeout
+	}
+	return s.SessionTimeout
+}
+
+// checkSession 没有会话id返回400，会话不存在返回404，客户端需要重新initialize
+func (s *Server) checkSession(w http.ResponseWriter, r *http.Request) bool {
+	id := r.Header.Get(SessionHeader)
+	if id == "" {
+		http.Error(w, "missing "+SessionHeader, http.StatusBadRequest)
+		return false
+	}
+	now := time.Now()
+	s.mu.Lock()
+	used, ok := s.sessions[id]
+	if ok && now.Sub(used) > s.sessionTimeout() {
+		delete(s.sessions, id)
+		ok = false
+	}
+	if ok {
+		s.sessions[id] = now
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+	}
+	return ok
+}
+
+// negotiate 按Accept选择响应格式，NDJSON优先，其次SSE，都不接受时返回JSON
+func negotiate(accept string) string {
+	sse := false
+	for _, part := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case ContentTypeNDJSON:
+			return ContentTypeNDJSON
+		case ContentTypeSSE:
+			sse = true
+		}
+	}
+	if sse {
+		return ContentTypeSSE
+	}
+	return ContentTypeJSON
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// sseWriter 每条消息作为一个message事件
+type sseWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", ContentTypeSSE)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	return &sseWriter{w: w, rc: http.NewResponseController(w)}
+}
+
+func (sw *sseWriter) write(m *Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := (broker.Event{Event: "message", Data: string(b)}).WriteTo(sw.w); err != nil {
+		return err
+	}
+	if err := sw.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// notifier 流式响应时工具用来发送通知
+type notifier struct {
+	send  func(*Message) error
+	token any
+}
+
+type notifierKey struct{}
+
+// Progress 在工具里发送进度通知，total未知时为0
+// 只有响应是流式的并且客户端请求时带了progressToken才会发送，否则什么都不做
+func Progress(ctx context.Context, progress, total float64, message string) error {
+	n, ok := ctx.Value(notifierKey{}).(*notifier)
+	if !ok {
+		return nil
+	}
+	m, err := newNotification("notifications/progress", ProgressParams{
+		ProgressToken: n.token, Progress: progress, Total: total, Message: message,
+	})
+	if err != nil {
+		return err
+	}
+	return n.send(m)
+}
+
+// handleFunc 处理一个请求，send不为nil时可以发送通知
+type handleFunc func(ctx context.Context, m *Message, send func(*Message) error) *Message
+
+// handle 处理一个请求，send不为nil时可以发送通知
+func (s *Server) handle(ctx context.Context, m *Message, send func(*Message) error) *Message {
+	if m.JSONRPC != jsonrpcVersion || m.Method == "" {
+		return newErrorResponse(m.ID, errorf(CodeInvalidRequest, "invalid request"))
+	}
+	switch m.Method {
+	case "initialize":
+		var p InitializeParams
+		if err := unmarshalParams(m.Params, &p); err != nil {
+			return newErrorResponse(m.ID, err)
+		}
+		return newResponse(m.ID, s.initialize(p))
+	case "ping":
+		return newResponse(m.ID, struct{}{})
+	case "tools/list":
+		return newResponse(m.ID, s.listTools())
+	case "tools/call":
+		var p CallToolParams
+		if err := unmarshalParams(m.Params, &p); err != nil {
+			return newErrorResponse(m.ID, err)
+		}
+		if send != nil && p.Meta != nil && p.Meta.ProgressToken != nil {
+			ctx = context.WithValue(ctx, notifierKey{}, &notifier{send: send, token: p.Meta.ProgressToken})
+		}
+		res, err := s.callTool(ctx, p)
+		if err != nil {
+			return newErrorResponse(m.ID, err)
+		}
+		return newResponse(m.ID, res)
+	case "resources/list":
+		return newResponse(m.ID, s.listResources())
+	case "resources/read":
+		var p ReadResourceParams
+		if err := unmarshalParams(m.Params, &p); err != nil {
+			return newErrorResponse(m.ID, err)
+		}
+		res, err := s.readResource(ctx, p.URI)
+		if err != nil {
+			return newErrorResponse(m.ID, err)
+		}
+		return newResponse(m.ID, res)
+	}
+	return newErrorResponse(m.ID, errorf(CodeMethodNotFound, "method not found: %s", m.Method))
+}
+
+func unmarshalParams(params json.RawMessage, v any) *Error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return errorf(CodeInvalidParams, "invalid params: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) initialize(p InitializeParams) *InitializeResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := &InitializeResult{
+		// 不支持客户端的版本时返回自己的版本，由客户端决定是否断开
+		ProtocolVersion: ProtocolVersion,
+		ServerInfo:      s.info,
+		Instructions:    s.Instructions,
+	}
+	if len(s.tools) > 0 {
+		res.Capabilities.Tools = &struct{}{}
+	}
+	if len(s.resources) > 0 {
+		res.Capabilities.Resources = &struct{}{}
+	}
+	return res
+}
+
+func (s *Server) listTools() *ListToolsResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := &ListToolsResult{Tools: []Tool{}}
+	for _, name := range s.toolNames {
+		res.Tools = append(res.Tools, s.tools[name].Tool)
+	}
+	return res
+}
+
+func (s *Server) callTool(ctx context.Context, p CallToolParams) (res *CallToolResult, rerr *Error) {
+	s.mu.RLock()
+	t, ok := s.tools[p.Name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errorf(CodeInvalidParams, "unknown tool: %s", p.Name)
+	}
+	if len(t.required) > 0 {
+		var args map[string]json.RawMessage
+		if err := json.Unmarshal(p.Arguments, &args); err != nil {
+			return nil, errorf(CodeInvalidParams, "invalid arguments: %v", err)
+		}
+		for _, name := range t.required {
+			if _, ok := args[name]; !ok {
+				return nil, errorf(CodeInvalidParams, "missing argument: %s", name)
+			}
+		}
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("mcp: tool panic", "tool", p.Name, "panic", r)
+			res, rerr = nil, errorf(CodeInternalError, "tool %s panicked", p.Name)
+		}
+	}()
+	res, err := t.call(ctx, p.Arguments)
+	if err != nil {
+		var e *Error
+		if errors.As(err, &e) {
+			return nil, e
+		}
+		return nil, errorf(CodeInternalError, "%v", err)
+	}
+	return res, nil
+}
+
+func (s *Server) listResources() *ListResourcesResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := &ListResourcesResult{Resources: []Resource{}}
+	for _, uri := range s.resURIs {
+		res.Resources = append(res.Resources, s.resources[uri].Resource)
+	}
+	return res
+}
+
+func (s *Server) readResource(ctx context.Context, uri string) (*ReadResourceResult, *Error) {
+	s.mu.RLock()
+	r, ok := s.resources[uri]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, errorf(CodeResourceNotFound, "resource not found: %s", uri)
+	}
+	b, err := r.read(ctx)
+	if err != nil {
+		return nil, errorf(CodeInternalError, "read %s: %v", uri, err)
+	}
+	c := ResourceContents{URI: uri, MIMEType: r.MIMEType}
+	if isText(r.MIMEType) {
+		c.Text = string(b)
+	} else {
+		c.Blob = base64.StdEncoding.EncodeToString(b)
+	}
+	return &ReadResourceResult{Contents: []ResourceContents{c}}, nil
+}
+
+func isText(mimeType string) bool {
+	mt, _, _ := mime.ParseMediaType(mimeType)
+	return mt == "" || strings.HasPrefix(mt, "text/") ||
+		mt == "application/json" || mt == "application/xml" ||
+		strings.HasSuffix(mt, "+json") || strings.HasSuffix(mt, "+xml")
+}
@@ -0,0 +1,328 @@
+// Package broker SSE(Server-Sent Events)消息分发
+//
+// 事件发布到命名的topic，客户端连接时订阅一个或多个topic
+// 每个事件分配全局递增的ID，每个topic保留最近的事件，
+// 客户端重连时带上Last-Event-ID请求头，补发这个ID之后的事件
+// 连接空闲时定时发送注释行作为心跳，发送队列满的慢客户端会被断开
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBufferSize   = 100
+	DefaultClientBuffer = 16
+	DefaultHeartbeat    = 15 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+var ErrClosed = errors.New("broker: closed")
+
+// Options Broker参数
+type Options struct {
+	// BufferSize 每个topic保留的事件数，用于断线重连后补发，默认100，小于0不保留
+	BufferSize int
+	// ClientBuffer 每个客户端待发送事件队列长度，队列满了断开这个客户端，默认16
+	ClientBuffer int
+	// Heartbeat 心跳间隔，默认15秒，小于0不发心跳
+	Heartbeat time.Duration
+	// WriteTimeout 每次写连接的超时时间，默认10秒
+	WriteTimeout time.Duration
+	// Retry 大于0时连接建立后通知客户端重连间隔
+	Retry time.Duration
+}
+
+func (opts *Options) setDefaults() {
+	if opts.BufferSize == 0 {
+		opts.BufferSize = DefaultBufferSize
+	} else if opts.BufferSize < 0 {
+		opts.BufferSize = 0
+	}
+	if opts.ClientBuffer <= 0 {
+		opts.ClientBuffer = DefaultClientBuffer
+	}
+	if opts.Heartbeat == 0 {
+		opts.Heartbeat = DefaultHeartbeat
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = DefaultWriteTimeout
+	}
+}
+
+// Broker 按topic分发事件，可以并发调用
+type Broker struct {
+	opts Options
+
+	mu     sync.Mutex
+	seq    uint64
+	topics map[string]*topic
+	closed bool
+	done   chan struct{}
+}
+
+// topic 一个主题的订阅者和最近的事件
+type topic struct {
+	// events 最近的事件，按ID递增
+	events  []record
+	clients map[*client]struct{}
+}
+
+type record struct {
+	seq   uint64
+	event Event
+}
+
+// client 一个连接
+type client struct {
+	topics []string
+	ch     chan Event
+	// evicted 发送队列满了被断开时关闭
+	evicted chan struct{}
+}
+
+func New(opts Options) *Broker {
+	opts.setDefaults()
+	return &Broker{
+		opts:   opts,
+		topics: make(map[string]*topic),
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *Broker) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{clients: make(map[*client]struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Publish 发布事件到topic，返回分配的ID
+// 不会阻塞，发送队列满的客户端直接断开
+func (b *Broker) Publish(name string, e Event) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return "", ErrClosed
+	}
+	b.seq++
+	e.ID = strconv.FormatUint(b.seq, 10)
+	t := b.topic(name)
+	if b.opts.BufferSize > 0 {
+		if len(t.events) == b.opts.BufferSize {
+			t.events = slices.Delete(t.events, 0, 1)
+		}
+		t.events = append(t.events, record{seq: b.seq, event: e})
+	}
+	for c := range t.clients {
+		select {
+		case c.ch <- e:
+		default:
+			b.evict(c)
+		}
+	}
+	b.release(name, t)
+	return e.ID, nil
+}
+
+// evict 断开慢客户端，调用时持有锁
+func (b *Broker) evict(c *client) {
+	b.remove(c)
+	close(c.evicted)
+}
+
+// remove 取消订阅，调用时持有锁
+func (b *Broker) remove(c *client) {
+	for _, name := range c.topics {
+		if t, ok := b.topics[name]; ok {
+			delete(t.clients, c)
+			b.release(name, t)
+		}
+	}
+}
+
+// release 没有订阅者也没有保留事件的topic删除，客户端订阅任意名字不会一直占用内存
+// 调用时持有锁
+func (b *Broker) release(name string, t *topic) {
+	if len(t.clients) == 0 && len(t.events) == 0 {
+		delete(b.topics, name)
+	}
+}
+
+// subscribe 订阅topics，返回last之后需要补发的事件
+// 注册和取补发事件在同一个锁里，补发和之后收到的事件不会重复也不会遗漏
+func (b *Broker) subscribe(topics []string, last uint64, replay bool) (*client, []Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, nil, ErrClosed
+	}
+	c := &client{
+		topics:  topics,
+		ch:      make(chan Event, b.opts.ClientBuffer),
+		evicted: make(chan struct{}),
+	}
+	var missed []record
+	for _, name := range topics {
+		t := b.topic(name)
+		t.clients[c] = struct{}{}
+		if !replay {
+			continue
+		}
+		for _, r := range t.events {
+			if r.seq > last {
+				missed = append(missed, r)
+			}
+		}
+	}
+	// 多个topic的事件按ID合并
+	slices.SortFunc(missed, func(a, b record) int {
+		if a.seq < b.seq {
+			return -1
+		}
+		return 1
+	})
+	events := make([]Event, len(missed))
+	for i, r := range missed {
+		events[i] = r.event
+	}
+	return c, events, nil
+}
+
+func (b *Broker) unsubscribe(c *client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(c)
+}
+
+// Clients topic当前的订阅数
+func (b *Broker) Clients(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[name]; ok {
+		return len(t.clients)
+	}
+	return 0
+}
+
+// Close 断开所有客户端，之后不能再发布和订阅
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	return nil
+}
+
+// ServeHTTP 订阅查询参数topic指定的主题，可以有多个
+//
+//	GET /events?topic=news&topic=weather
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	topics := r.URL.Query()["topic"]
+	if len(topics) == 0 {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+	b.serve(w, r, topics)
+}
+
+// Handler 订阅固定主题的http.Handler
+func (b *Broker) Handler(topics ...string) http.Handler {
+	topics = slices.Clone(topics)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.serve(w, r, topics)
+	})
+}
+
+func (b *Broker) serve(w http.ResponseWriter, r *http.Request, topics []string) {
+	topics = slices.Compact(slices.Sorted(slices.Values(topics)))
+	var last uint64
+	replay := false
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if n, err := strconv.ParseUint(id, 10, 64); err == nil {
+			last, replay = n, true
+		}
+	}
+	c, missed, err := b.subscribe(topics, last, replay)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	// handler返回后不能再写w，取消订阅后Publish不会再往c.ch发送
+	defer b.unsubscribe(c)
+
+	rc := http.NewResponseController(w)
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// nginx不缓冲
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// write 每次写都设置超时，卡住的连接在超时后返回错误
+	write := func(fn func() error) bool {
+		// httptest.ResponseRecorder等不支持超时，忽略
+		_ = rc.SetWriteDeadline(time.Now().Add(b.opts.WriteTimeout))
+		if err := fn(); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !write(func() error {
+		if b.opts.Retry > 0 {
+			// 只有retry没有data的块不会触发客户端事件
+			if _, err := fmt.Fprintf(w, "retry: %d\n\n", b.opts.Retry.Milliseconds()); err != nil {
+				return err
+			}
+		}
+		// 立即把响应头发给客户端
+		return writeComment(w, "connected")
+	}) {
+		return
+	}
+	for _, e := range missed {
+		if !write(func() error { _, err := e.WriteTo(w); return err }) {
+			return
+		}
+	}
+
+	var heartbeat <-chan time.Time
+	// idle 发送事件后重新计时，只在空闲时发心跳
+	idle := func() {}
+	if b.opts.Heartbeat > 0 {
+		t := time.NewTicker(b.opts.Heartbeat)
+		defer t.Stop()
+		heartbeat = t.C
+		idle = func() { t.Reset(b.opts.Heartbeat) }
+	}
+	for {
+		select {
+		case e := <-c.ch:
+			if !write(func() error { _, err := e.WriteTo(w); return err }) {
+				return
+			}
+			idle()
+		case <-heartbeat:
+			if !write(func() error { return writeComment(w, "heartbeat") }) {
+				return
+			}
+		case <-c.evicted:
+			return
+		case <-b.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
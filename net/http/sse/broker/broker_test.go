@@ -0,0 +1,299 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// block 读一个以空行结束的块，返回所有行
+func block(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("读事件失败: %v, 已读 %q", err, lines)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+// nextEvent 跳过注释块，返回下一个事件的行
+func nextEvent(t *testing.T, r *bufio.Reader) []string {
+	t.Helper()
+	for {
+		lines := block(t, r)
+		if len(lines) > 0 && !strings.HasPrefix(lines[0], ":") && !strings.HasPrefix(lines[0], "retry:") {
+			return lines
+		}
+	}
+}
+
+// connect 连接broker，等到订阅生效后返回
+func connect(t *testing.T, ctx context.Context, b *Broker, url, lastID string, topics ...string) *bufio.Reader {
+	t.Helper()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("状态码 %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	// 收到第一个块时已经订阅
+	block(t, r)
+	for _, topic := range topics {
+		if b.Clients(topic) == 0 {
+			t.Fatalf("%s没有订阅", topic)
+		}
+	}
+	return r
+}
+
+func TestPublishTopics(t *testing.T) {
+	b := New(Options{})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	// 先断开客户端，srv.Close才不会等待
+	defer b.Close()
+	ctx := context.Background()
+
+	news := connect(t, ctx, b, srv.URL+"?topic=news", "", "news")
+	both := connect(t, ctx, b, srv.URL+"?topic=news&topic=weather", "", "news", "weather")
+
+	if _, err := b.Publish("weather", Event{Event: "rain", Data: "line1\nline2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Publish("news", Event{Data: "hello", ID: "ignored"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"id: 2", "data: hello"}
+	if got := nextEvent(t, news); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("news订阅者收到 %q, 期望 %q", got, want)
+	}
+	if got := nextEvent(t, both); strings.Join(got, "|") != "id: 1|event: rain|data: line1|data: line2" {
+		t.Errorf("多行数据 %q", got)
+	}
+	if got := nextEvent(t, both); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("第二个事件 %q", got)
+	}
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("没有topic应该返回400, 得到 %d", resp.StatusCode)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	b := New(Options{BufferSize: 3, Retry: 500 * time.Millisecond})
+	srv := httptest.NewServer(b.Handler("a", "b"))
+	defer srv.Close()
+	defer b.Close()
+
+	for i, topic := range []string{"a", "b", "a", "c", "b", "a"} {
+		if _, err := b.Publish(topic, Event{Data: topic + string(rune('0'+i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a保留了1 3 6，b保留了2 5
+	r := connect(t, context.Background(), b, srv.URL, "2", "a", "b")
+	for _, want := range []string{"a2", "b4", "a5"} {
+		got := nextEvent(t, r)
+		if got[len(got)-1] != "data: "+want {
+			t.Errorf("补发 %q, 期望 %s", got, want)
+		}
+	}
+	if _, err := b.Publish("b", Event{Data: "live"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := nextEvent(t, r); strings.Join(got, "|") != "id: 7|data: live" {
+		t.Errorf("补发之后的实时事件 %q", got)
+	}
+
+	// 不带Last-Event-ID不补发，retry在第一个块
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	first := block(t, bufio.NewReader(resp.Body))
+	if len(first) != 1 || first[0] != "retry: 500" {
+		t.Errorf("第一个块 %q", first)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	b := New(Options{Heartbeat: 10 * time.Millisecond})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	defer b.Close()
+	r := connect(t, context.Background(), b, srv.URL+"?topic=x", "", "x")
+	if got := block(t, r); len(got) != 1 || got[0] != ": heartbeat" {
+		t.Errorf("心跳 %q", got)
+	}
+}
+
+func TestHeartbeatIdleOnly(t *testing.T) {
+	b := New(Options{Heartbeat: 100 * time.Millisecond})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	defer b.Close()
+	r := connect(t, context.Background(), b, srv.URL+"?topic=x", "", "x")
+	go func() {
+		for i := 0; i < 10; i++ {
+			b.Publish("x", Event{Data: strconv.Itoa(i)})
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	// 一直有事件时不发心跳
+	for i := 0; i < 10; i++ {
+		if got := block(t, r); len(got) == 0 || got[0] == ": heartbeat" {
+			t.Fatalf("第%d个块 %q", i, got)
+		}
+	}
+	if got := block(t, r); len(got) != 1 || got[0] != ": heartbeat" {
+		t.Errorf("空闲后心跳 %q", got)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	b := New(Options{})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	connect(t, ctx, b, srv.URL+"?topic=x", "", "x")
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Clients("x") != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("客户端断开后没有取消订阅")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	r := connect(t, context.Background(), b, srv.URL+"?topic=x", "", "x")
+	b.Close()
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Error("Close后连接应该结束")
+	}
+	if _, err := b.Publish("x", Event{Data: "late"}); err != ErrClosed {
+		t.Errorf("Close后发布 err=%v", err)
+	}
+}
+
+func TestReleaseTopics(t *testing.T) {
+	b := New(Options{})
+	defer b.Close()
+	// 订阅任意名字，取消订阅后topic删除
+	c, _, err := b.subscribe([]string{"a", "b", "c"}, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.unsubscribe(c)
+	if len(b.topics) != 0 {
+		t.Errorf("取消订阅后还有 %d 个topic", len(b.topics))
+	}
+
+	// 有保留事件的topic保留，用于补发
+	b.Publish("a", Event{Data: "1"})
+	c, _, _ = b.subscribe([]string{"a", "b"}, 0, false)
+	b.unsubscribe(c)
+	if _, ok := b.topics["a"]; !ok || len(b.topics) != 1 {
+		t.Errorf("topics %v", b.topics)
+	}
+
+	// 不保留事件时发布也不会留下topic
+	nb := New(Options{BufferSize: -1})
+	defer nb.Close()
+	nb.Publish("x", Event{Data: "1"})
+	if len(nb.topics) != 0 {
+		t.Errorf("不保留事件时还有 %d 个topic", len(nb.topics))
+	}
+}
+
+func TestEvictSlowClient(t *testing.T) {
+	b := New(Options{ClientBuffer: 2})
+	defer b.Close()
+	slow, _, err := b.subscribe([]string{"x"}, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, _, err := b.subscribe([]string{"x"}, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		drain(fast)
+		if _, err := b.Publish("x", Event{Data: "x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-slow.evicted:
+	default:
+		t.Fatal("发送队列满的客户端应该被断开")
+	}
+	select {
+	case <-fast.evicted:
+		t.Fatal("及时读取的客户端不应该被断开")
+	default:
+	}
+	if n := b.Clients("x"); n != 1 {
+		t.Errorf("剩余订阅数 %d", n)
+	}
+}
+
+// drain 取走队列里的事件
+func drain(c *client) {
+	for {
+		select {
+		case <-c.ch:
+		default:
+			return
+		}
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	var b strings.Builder
+	e := Event{ID: "1\n2", Event: "up\rdate", Data: "a\r\nb\rc", Retry: 1500 * time.Millisecond}
+	if _, err := e.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "id: 12\nevent: update\nretry: 1500\ndata: a\ndata: b\ndata: c\n\n"
+	if b.String() != want {
+		t.Errorf("编码 %q, 期望 %q", b.String(), want)
+	}
+	b.Reset()
+	Event{}.WriteTo(&b)
+	if b.String() != "data: \n\n" {
+		t.Errorf("空事件 %q", b.String())
+	}
+}
@@ -0,0 +1,59 @@
+package broker
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event 一条SSE事件
+type Event struct {
+	// ID 由Broker分配，发布时设置的值会被覆盖
+	ID string
+	// Event 事件名，为空时客户端按message事件处理
+	Event string
+	// Data 事件内容，有换行时拆成多个data字段
+	Data string
+	// Retry 大于0时通知客户端断线后的重连间隔
+	Retry time.Duration
+}
+
+// 字段值里不能有换行，否则会被当成新的字段
+var lineBreaks = strings.NewReplacer("\r\n", "", "\r", "", "\n", "")
+
+// WriteTo 按text/event-stream格式写出事件，以空行结束
+func (e Event) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	if e.ID != "" {
+		b.WriteString("id: ")
+		b.WriteString(lineBreaks.Replace(e.ID))
+		b.WriteByte('\n')
+	}
+	if e.Event != "" {
+		b.WriteString("event: ")
+		b.WriteString(lineBreaks.Replace(e.Event))
+		b.WriteByte('\n')
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: ")
+		b.WriteString(strconv.FormatInt(e.Retry.Milliseconds(), 10))
+		b.WriteByte('\n')
+	}
+	data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return b.WriteTo(w)
+}
+
+// writeComment 写注释行，客户端忽略，用作心跳
+func writeComment(w io.Writer, text string) error {
+	_, err := io.WriteString(w, ": "+lineBreaks.Replace(text)+"\n\n")
+	return err
+}
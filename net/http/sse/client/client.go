@@ -0,0 +1,180 @@
+// Package client SSE(Server-Sent Events)客户端
+//
+// 解析完整的event-stream格式，连接断开后按服务器通知的retry间隔自动重连，
+// 重连时带上Last-Event-ID请求头，服务器可以补发断开期间的事件
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"net/http"
+	"time"
+)
+
+const DefaultRetry = 3 * time.Second
+
+var (
+	// ErrNoContent 服务器返回204，通知客户端不要再重连
+	ErrNoContent = errors.New("sse: server returned 204 No Content")
+	// ErrTooManyRetries 连续重连失败的次数超过了MaxRetries
+	ErrTooManyRetries = errors.New("sse: too many retries")
+)
+
+// StatusError 服务器返回了200以外的状态码，不再重连
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "sse: unexpected status " + e.Status
+}
+
+// Client SSE客户端，字段在Events之前设置
+type Client struct {
+	URL string
+	// HTTPClient 为nil时用http.DefaultClient，不要设置Timeout，否则长连接会被断开
+	HTTPClient *http.Client
+	// Header 每次请求附加的请求头
+	Header http.Header
+	// Retry 重连间隔，服务器通知retry后使用服务器的值，默认3秒
+	Retry time.Duration
+	// MaxRetries 连续连接失败多少次后放弃，0表示一直重连
+	// 连接成功并且收到事件后重新计数
+	MaxRetries int
+	// LastEventID 第一次连接时的Last-Event-ID，之后更新为最近收到的id
+	LastEventID string
+}
+
+func New(url string) *Client {
+	return &Client{URL: url}
+}
+
+// Events 连接服务器，返回收到的事件
+// 连接出错时返回错误后继续重连，调用方可以在循环里break停止
+// ctx结束、服务器返回204或者200以外的状态码、重连次数用完时迭代结束
+//
+//	for e, err := range c.Events(ctx) {
+//		if err != nil {
+//			log.Println(err)
+//			continue
+//		}
+//		fmt.Println(e.Event, e.Data)
+//	}
+func (c *Client) Events(ctx context.Context) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		retry := c.Retry
+		if retry <= 0 {
+			retry = DefaultRetry
+		}
+		failures := 0
+		for {
+			received, err := c.stream(ctx, &retry, yield)
+			if ctx.Err() != nil {
+				return
+			}
+			var stop *stopError
+			if errors.As(err, &stop) {
+				if stop.err != nil {
+					yield(Event{}, stop.err)
+				}
+				return
+			}
+			if received {
+				failures = 0
+			}
+			failures++
+			if err != nil && !yield(Event{}, err) {
+				return
+			}
+			if c.MaxRetries > 0 && failures > c.MaxRetries {
+				yield(Event{}, ErrTooManyRetries)
+				return
+			}
+			t := time.NewTimer(retry)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return
+			}
+		}
+	}
+}
+
+// stopError 不再重连，err为nil表示调用方停止了迭代
+type stopError struct {
+	err error
+}
+
+func (e *stopError) Error() string {
+	if e.err == nil {
+		return "sse: stopped"
+	}
+	return e.err.Error()
+}
+
+// stream 建立一次连接并读取事件，返回是否收到过事件
+// 连接正常结束时返回nil，需要重连
+func (c *Client) stream(ctx context.Context, retry *time.Duration, yield func(Event, error) bool) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return false, &stopError{err}
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if c.LastEventID != "" {
+		req.Header.Set("Last-Event-ID", c.LastEventID)
+	}
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return false, &stopError{ErrNoContent}
+	case resp.StatusCode != http.StatusOK:
+		return false, &stopError{&StatusError{StatusCode: resp.StatusCode, Status: resp.Status}}
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/event-stream" {
+		return false, &stopError{fmt.Errorf("sse: unexpected content type %q", resp.Header.Get("Content-Type"))}
+	}
+
+	r := NewReader(resp.Body)
+	r.SetLastEventID(c.LastEventID)
+	received := false
+	for {
+		e, err := r.Next()
+		// 没有分发的事件也可能更新了id和retry
+		c.LastEventID = r.LastEventID()
+		if d := r.Retry(); d > 0 {
+			*retry = d
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return received, ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				// 服务器正常关闭连接，重连
+				return received, nil
+			}
+			return received, err
+		}
+		received = true
+		if !yield(e, nil) {
+			return received, &stopError{}
+		}
+	}
+}
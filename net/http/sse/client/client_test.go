@@ -0,0 +1,231 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/http/sse/broker"
+)
+
+func TestReader(t *testing.T) {
+	// 标准里的例子，加上\r和\r\n行尾、BOM、注释和未知字段
+	stream := "\uFEFFdata: first\r\n" +
+		"\r\n" +
+		": comment\n" +
+		"event: add\n" +
+		"data:no space\n" +
+		"data\n" +
+		"data:  two spaces\n" +
+		"id: 7\n" +
+		"foo: bar\n" +
+		"\n" +
+		"retry: 1500\r" +
+		"retry: x\r" +
+		"\r" +
+		"id: 8\x00\n" +
+		"data: keeps id\n" +
+		"\n" +
+		"id\n" +
+		"event: empty\n" +
+		"\n" +
+		"data: unfinished\n"
+	r := NewReader(strings.NewReader(stream))
+	want := []Event{
+		{Event: "message", Data: "first"},
+		{ID: "7", Event: "add", Data: "no space\n\n two spaces"},
+		{ID: "7", Event: "message", Data: "keeps id"},
+	}
+	for i, w := range want {
+		e, err := r.Next()
+		if err != nil {
+			t.Fatalf("第%d个事件: %v", i, err)
+		}
+		if e != w {
+			t.Errorf("第%d个事件 %+v, 期望 %+v", i, e, w)
+		}
+	}
+	if r.Retry() != 1500*time.Millisecond {
+		t.Errorf("retry %v", r.Retry())
+	}
+	// 只有id没有data的块不分发，但是会清空id；没有空行结束的事件丢弃
+	if e, err := r.Next(); err != io.EOF {
+		t.Errorf("流结束应该返回EOF, 得到 %+v %v", e, err)
+	}
+	if r.LastEventID() != "" {
+		t.Errorf("id字段为空时清空, 得到 %q", r.LastEventID())
+	}
+}
+
+func TestReaderLineTooLong(t *testing.T) {
+	r := NewReader(strings.NewReader("data: " + strings.Repeat("x", MaxLineSize) + "\n\n"))
+	if _, err := r.Next(); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("超长的行 err=%v", err)
+	}
+}
+
+// collect 读n个事件，遇到错误时记录
+func collect(t *testing.T, ctx context.Context, c *Client, n int, onEvent func(Event)) ([]Event, []error) {
+	t.Helper()
+	var events []Event
+	var errs []error
+	for e, err := range c.Events(ctx) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		events = append(events, e)
+		if onEvent != nil {
+			onEvent(e)
+		}
+		if len(events) == n {
+			break
+		}
+	}
+	return events, errs
+}
+
+// waitClients 等到topic有n个订阅，会在其他goroutine里调用，不能用Fatal
+func waitClients(t *testing.T, b *broker.Broker, topic string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Clients(topic) != n {
+		if time.Now().After(deadline) {
+			t.Errorf("%s的订阅数没有变成%d", topic, n)
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+}
+
+func TestBrokerRoundTrip(t *testing.T) {
+	b := broker.New(broker.Options{})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	defer b.Close()
+
+	c := New(srv.URL + "?topic=news")
+	go func() {
+		waitClients(t, b, "news", 1)
+		b.Publish("news", broker.Event{Event: "add", Data: "a\nb"})
+		b.Publish("other", broker.Event{Data: "not mine"})
+		b.Publish("news", broker.Event{Data: "c"})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, errs := collect(t, ctx, c, 2, nil)
+	if len(errs) > 0 {
+		t.Errorf("错误 %v", errs)
+	}
+	want := []Event{{ID: "1", Event: "add", Data: "a\nb"}, {ID: "3", Event: "message", Data: "c"}}
+	if len(events) != 2 || events[0] != want[0] || events[1] != want[1] {
+		t.Errorf("收到 %+v, 期望 %+v", events, want)
+	}
+	if c.LastEventID != "3" {
+		t.Errorf("LastEventID %q", c.LastEventID)
+	}
+	// break之后连接关闭，broker取消订阅
+	waitClients(t, b, "news", 0)
+}
+
+func TestReconnect(t *testing.T) {
+	b := broker.New(broker.Options{Retry: 20 * time.Millisecond})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+	defer b.Close()
+
+	// 客户端设置的间隔很长，只有用了服务器的retry才能及时重连
+	c := New(srv.URL + "?topic=x")
+	c.Retry = time.Minute
+	go func() {
+		waitClients(t, b, "x", 1)
+		b.Publish("x", broker.Event{Data: "0"})
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, _ := collect(t, ctx, c, 5, func(e Event) {
+		n, _ := strconv.Atoi(e.Data)
+		if n == 1 {
+			// 断开连接，断开期间发布的事件重连后补发
+			srv.CloseClientConnections()
+			waitClients(t, b, "x", 0)
+			b.Publish("x", broker.Event{Data: "2"})
+			b.Publish("x", broker.Event{Data: "3"})
+			b.Publish("x", broker.Event{Data: "4"})
+			return
+		}
+		if n == 0 {
+			b.Publish("x", broker.Event{Data: "1"})
+		}
+	})
+	var got []string
+	for _, e := range events {
+		got = append(got, e.ID+"="+e.Data)
+	}
+	if strings.Join(got, ",") != "1=0,2=1,3=2,4=3,5=4" {
+		t.Errorf("重连后的事件 %v", got)
+	}
+}
+
+func TestStop(t *testing.T) {
+	var attempts int
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch r.URL.Path {
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		case "/status":
+			w.WriteHeader(status)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+		case "/eof":
+			// 每次连接发一个事件就结束
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			io.WriteString(w, "id: "+strconv.Itoa(attempts)+"\ndata: x\n\n")
+		}
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, errs := collect(t, ctx, New(srv.URL+"/nocontent"), 1, nil)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrNoContent) {
+		t.Errorf("204 %v", errs)
+	}
+	_, errs = collect(t, ctx, New(srv.URL+"/status"), 1, nil)
+	var se *StatusError
+	if len(errs) != 1 || !errors.As(errs[0], &se) || se.StatusCode != status {
+		t.Errorf("503 %v", errs)
+	}
+	_, errs = collect(t, ctx, New(srv.URL+"/html"), 1, nil)
+	if len(errs) != 1 {
+		t.Errorf("错误的Content-Type %v", errs)
+	}
+
+	// 服务器正常结束连接时重连，不返回错误
+	c := New(srv.URL + "/eof")
+	c.Retry = time.Millisecond
+	attempts = 0
+	events, errs := collect(t, ctx, c, 3, nil)
+	if len(errs) != 0 || len(events) != 3 || events[2].ID != "3" {
+		t.Errorf("重连 %+v %v", events, errs)
+	}
+
+	// 连不上时重试MaxRetries次后放弃
+	addr := srv.URL
+	srv.Close()
+	c = New(addr)
+	c.Retry = time.Millisecond
+	c.MaxRetries = 2
+	_, errs = collect(t, ctx, c, 1, nil)
+	if len(errs) != 4 || !errors.Is(errs[3], ErrTooManyRetries) {
+		t.Errorf("重试次数 %v", errs)
+	}
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 按WHATWG HTML标准的event-stream格式解析
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+
+// MaxLineSize 一行的最大长度
+const MaxLineSize = 1 << 20
+
+var ErrLineTooLong = errors.New("sse: line too long")
+
+// Event 收到的事件
+type Event struct {
+	// ID 最近一次收到的id，没有id字段的事件沿用之前的值
+	ID string
+	// Event 事件名，默认message
+	Event string
+	// Data 多个data字段用\n连接
+	Data string
+}
+
+// Reader 从event-stream里读取事件
+type Reader struct {
+	br *bufio.Reader
+	// skipLF 上一行以\r结束，下一个字节是\n时跳过
+	skipLF bool
+	first  bool
+
+	lastID string
+	retry  time.Duration
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{br: bufio.NewReader(r), first: true}
+}
+
+// LastEventID 最近一次收到的id，重连时放在Last-Event-ID请求头里
+func (r *Reader) LastEventID() string {
+	return r.lastID
+}
+
+// SetLastEventID 设置初始的id，接着之前的连接继续读
+func (r *Reader) SetLastEventID(id string) {
+	r.lastID = id
+}
+
+// Retry 服务器最近一次通知的重连间隔，没有通知时为0
+func (r *Reader) Retry() time.Duration {
+	return r.retry
+}
+
+// Next 读下一个事件，注释和没有data的块不返回
+// 流结束时没有以空行结束的事件丢弃，返回io.EOF
+func (r *Reader) Next() (Event, error) {
+	var (
+		event   string
+		data    strings.Builder
+		hasData bool
+	)
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return Event{}, err
+		}
+		if line == "" {
+			// 空行分发事件
+			if !hasData {
+				event = ""
+				continue
+			}
+			e := Event{ID: r.lastID, Event: event, Data: strings.TrimSuffix(data.String(), "\n")}
+			if e.Event == "" {
+				e.Event = "message"
+			}
+			return e, nil
+		}
+		if line[0] == ':' {
+			// 注释
+			continue
+		}
+		field, value, ok := strings.Cut(line, ":")
+		if ok {
+			value = strings.TrimPrefix(value, " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				r.lastID = value
+			}
+		case "retry":
+			// 只能是数字，ParseUint不接受符号
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				r.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+		// 其他字段忽略
+	}
+}
+
+// readLine 读一行，行尾可以是\r\n、\n或者\r
+func (r *Reader) readLine() (string, error) {
+	var line []byte
+	for {
+		b, err := r.br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if r.skipLF {
+			r.skipLF = false
+			if b == '\n' {
+				continue
+			}
+		}
+		switch b {
+		case '\r':
+			r.skipLF = true
+			fallthrough
+		case '\n':
+			s := string(line)
+			if r.first {
+				// 开头的BOM去掉
+				r.first = false
+				s = strings.TrimPrefix(s, "\uFEFF")
+			}
+			return s, nil
+		}
+		if len(line) >= MaxLineSize {
+			return "", ErrLineTooLong
+		}
+		line = append(line, b)
+	}
+}
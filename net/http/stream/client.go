@@ -2,11 +2,14 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/ilaziness/gopkg/net/http/mcp"
+	"github.com/ilaziness/gopkg/net/http/stream/ndjson"
 )
 
 type StreamMessage struct {
@@ -15,9 +18,22 @@ type StreamMessage struct {
 	Index   int    `json:"index,omitempty"`
 }
 
+// go run ./net/http/stream -path /stream
+// go run ./net/http/stream -path /mcp -accept application/x-ndjson
 func main() {
-	//resp, err := http.Get("http://localhost:8080/stream")
-	resp, err := http.Get("http://localhost:8080/mcp-stream")
+	addr := flag.String("addr", "http://localhost:8080", "server address")
+	path := flag.String("path", "/stream", "/stream or /mcp")
+	accept := flag.String("accept", mcp.ContentTypeSSE, "MCP response format: text/event-stream, application/x-ndjson or application/json")
+	flag.Parse()
+
+	if *path == "/mcp" {
+		if err := callMCP(*addr+*path, *accept); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	resp, err := http.Get(*addr + *path)
 	if err != nil {
 		log.Fatal("Failed to connect:", err)
 	}
@@ -27,18 +43,60 @@ func main() {
 		log.Fatalf("Server returned error: %s", resp.Status)
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		var msg StreamMessage
-		if err := json.Unmarshal(line, &msg); err != nil {
-			log.Printf("Failed to parse line: %s, error: %v", string(line), err)
+	if err := receive(ndjson.NewNDJSONReader[StreamMessage](resp.Body)); err != nil {
+		log.Fatal("Error reading stream:", err)
+	}
+}
+
+// receive 打印收到的每条消息，解析失败的行跳过
+func receive[T any](r *ndjson.NDJSONReader[T]) error {
+	for msg, err := range r.All() {
+		if _, ok := err.(*ndjson.LineError); ok {
+			log.Printf("Failed to parse line: %v", err)
 			continue
 		}
+		if err != nil {
+			return err
+		}
 		fmt.Printf("Received: %+v\n", msg)
 	}
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatal("Error reading stream:", err)
+// callMCP 列出工具和资源，调用get_weather，流式响应时先打印进度
+func callMCP(url, accept string) error {
+	ctx := context.Background()
+	c := mcp.NewClient(url)
+	c.Accept = accept
+	c.OnProgress = func(p mcp.ProgressParams) {
+		fmt.Printf("Progress: %v/%v %s\n", p.Progress, p.Total, p.Message)
+	}
+	info, err := c.Initialize(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close(ctx)
+	fmt.Printf("Server: %s %s\n", info.ServerInfo.Name, info.ServerInfo.Version)
+
+	tools, err := c.ListTools(ctx)
+	if err != nil {
+		return err
+	}
+	for _, t := range tools {
+		fmt.Printf("Tool: %s - %s\n", t.Name, t.Description)
+	}
+	res, err := c.CallTool(ctx, "get_weather", map[string]string{"city": "Shanghai"})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Result: %s\n", res.Text())
+
+	contents, err := c.ReadResource(ctx, "demo://cities")
+	if err != nil {
+		return err
+	}
+	for _, rc := range contents {
+		fmt.Printf("Resource %s: %s\n", rc.URI, rc.Text)
 	}
+	return nil
 }
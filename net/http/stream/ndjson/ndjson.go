@@ -0,0 +1,180 @@
+// Package ndjson 按行分隔的JSON流(NDJSON)，每行一个JSON值
+//
+// 服务端用NDJSONWriter逐条写出并立即flush，客户端断开后写入返回错误；
+// 客户端用NDJSONReader逐行解码，单行太长或者JSON错误时跳过这一行继续读
+// 不依赖传输方式，HTTP/1.1的chunked和HTTP/2的数据帧都可以
+package ndjson
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+)
+
+const (
+	ContentType        = "application/x-ndjson"
+	DefaultMaxLineSize = 1 << 20
+)
+
+var ErrLineTooLong = errors.New("ndjson: line too long")
+
+// LineError 某一行解码失败，跳过这一行后可以继续读
+type LineError struct {
+	// Line 行号，从1开始
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("ndjson: line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// NDJSONWriter 把T类型的值逐行写到http响应
+type NDJSONWriter[T any] struct {
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+	ctx context.Context
+	buf bytes.Buffer
+}
+
+// NewNDJSONWriter 设置响应头并立即发送，r.Context()结束后Write返回错误
+func NewNDJSONWriter[T any](w http.ResponseWriter, r *http.Request) *NDJSONWriter[T] {
+	h := w.Header()
+	h.Set("Content-Type", ContentType)
+	h.Set("Cache-Control", "no-cache")
+	// nginx不缓冲
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	nw := &NDJSONWriter[T]{w: w, rc: http.NewResponseController(w), ctx: r.Context()}
+	nw.flush()
+	return nw
+}
+
+// Write 写一行并flush，客户端已经断开时返回ctx的错误
+func (w *NDJSONWriter[T]) Write(v T) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	w.buf.Reset()
+	// Encoder会在末尾加换行，字符串里的换行会被转义，不会拆成多行
+	if err := json.NewEncoder(&w.buf).Encode(v); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.buf.Bytes()); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+func (w *NDJSONWriter[T]) flush() error {
+	if err := w.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// NDJSONReader 从r逐行解码T类型的值
+type NDJSONReader[T any] struct {
+	br *bufio.Reader
+	// MaxLineSize 一行的最大字节数，默认DefaultMaxLineSize
+	MaxLineSize int
+	line        int
+}
+
+func NewNDJSONReader[T any](r io.Reader) *NDJSONReader[T] {
+	return &NDJSONReader[T]{br: bufio.NewReader(r), MaxLineSize: DefaultMaxLineSize}
+}
+
+// Read 读下一个值，空行跳过
+// 行太长或者JSON错误返回*LineError，可以继续调用Read；读完返回io.EOF
+func (r *NDJSONReader[T]) Read() (T, error) {
+	var v T
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return v, err
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(line, &v); err != nil {
+			return v, &LineError{Line: r.line, Err: err}
+		}
+		return v, nil
+	}
+}
+
+// readLine 读一行，不包括换行，最后一行可以没有换行
+// 超过MaxLineSize时丢弃这一行剩下的内容，返回LineError
+func (r *NDJSONReader[T]) readLine() ([]byte, error) {
+	max := r.MaxLineSize
+	if max <= 0 {
+		max = DefaultMaxLineSize
+	}
+	var line []byte
+	tooLong := false
+	for {
+		chunk, err := r.br.ReadSlice('\n')
+		n := len(line) + len(chunk)
+		if err == nil {
+			// 换行不算长度
+			n--
+		}
+		if !tooLong && n > max {
+			tooLong = true
+			line = nil
+		}
+		if !tooLong {
+			line = append(line, chunk...)
+		}
+		switch {
+		case err == nil:
+			r.line++
+			if tooLong {
+				return nil, &LineError{Line: r.line, Err: ErrLineTooLong}
+			}
+			return line[:len(line)-1], nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && (len(line) > 0 || tooLong):
+			r.line++
+			if tooLong {
+				return nil, &LineError{Line: r.line, Err: ErrLineTooLong}
+			}
+			return line, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// All 依次返回每个值，解码错误的行返回*LineError后继续，读完或者读出错时结束
+func (r *NDJSONReader[T]) All() iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		for {
+			v, err := r.Read()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			var le *LineError
+			if err != nil && !errors.As(err, &le) {
+				yield(v, err)
+				return
+			}
+			if !yield(v, err) {
+				return
+			}
+		}
+	}
+}
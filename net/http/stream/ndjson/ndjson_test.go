@@ -0,0 +1,179 @@
+package ndjson
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type message struct {
+	Type    string `json:"type"`
+	Content string `json:"content,omitempty"`
+	Index   int    `json:"index,omitempty"`
+}
+
+func TestReaderRecovery(t *testing.T) {
+	stream := `{"type":"a","index":1}` + "\r\n" +
+		"\n" +
+		`{"type":` + "\n" +
+		`{"type":"b","content":"` + strings.Repeat("x", 64) + `"}` + "\n" +
+		`  {"type":"c","content":"line1\nline2"}  ` + "\n" +
+		`"not an object"` + "\n" +
+		`{"type":"d"}`
+	r := NewNDJSONReader[message](strings.NewReader(stream))
+	r.MaxLineSize = 60
+
+	var got []string
+	var lines []int
+	for v, err := range r.All() {
+		var le *LineError
+		if errors.As(err, &le) {
+			lines = append(lines, le.Line)
+			if le.Line == 4 && !errors.Is(err, ErrLineTooLong) {
+				t.Errorf("第4行应该是太长 %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, v.Type+v.Content)
+	}
+	if strings.Join(got, ",") != "a,cline1\nline2,d" {
+		t.Errorf("解码结果 %q", got)
+	}
+	if len(lines) != 3 || lines[0] != 3 || lines[1] != 4 || lines[2] != 6 {
+		t.Errorf("出错的行号 %v", lines)
+	}
+}
+
+// errReader 读完内容后返回连接错误
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *errReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		return n, e.err
+	}
+	return n, err
+}
+
+func TestReaderError(t *testing.T) {
+	reset := errors.New("connection reset")
+	r := NewNDJSONReader[message](&errReader{r: strings.NewReader(`{"type":"a"}` + "\n" + `{"type":"b"`), err: reset})
+	var errs []error
+	n := 0
+	for _, err := range r.All() {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+	if n != 1 || len(errs) != 1 || !errors.Is(errs[0], reset) {
+		t.Errorf("读出错时应该结束 n=%d errs=%v", n, errs)
+	}
+}
+
+func handler(n int, done chan<- error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nw := NewNDJSONWriter[message](w, r)
+		for i := 0; n < 0 || i < n; i++ {
+			if err := nw.Write(message{Type: "token", Content: r.Proto, Index: i}); err != nil {
+				if done != nil {
+					done <- err
+				}
+				return
+			}
+			if n < 0 {
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+		if done != nil {
+			done <- nil
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		srv := httptest.NewUnstartedServer(handler(5, nil))
+		proto := "HTTP/1.1"
+		if h2 {
+			srv.EnableHTTP2 = true
+			srv.StartTLS()
+			proto = "HTTP/2.0"
+		} else {
+			srv.Start()
+		}
+		resp, err := srv.Client().Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Proto != proto {
+			t.Errorf("协议 %s, 期望 %s", resp.Proto, proto)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+			t.Errorf("Content-Type %q", ct)
+		}
+		if !h2 && (len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked") {
+			t.Errorf("HTTP/1.1应该是chunked %v", resp.TransferEncoding)
+		}
+		var got []int
+		for v, err := range NewNDJSONReader[message](resp.Body).All() {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Content != proto {
+				t.Errorf("服务端看到的协议 %s", v.Content)
+			}
+			got = append(got, v.Index)
+		}
+		resp.Body.Close()
+		srv.Close()
+		if len(got) != 5 || got[4] != 4 {
+			t.Errorf("%s 收到 %v", proto, got)
+		}
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		done := make(chan error, 1)
+		srv := httptest.NewUnstartedServer(handler(-1, done))
+		if h2 {
+			srv.EnableHTTP2 = true
+			srv.StartTLS()
+		} else {
+			srv.Start()
+		}
+		resp, err := srv.Client().Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 每条消息flush后立即能读到
+		r := NewNDJSONReader[message](resp.Body)
+		for i := 0; i < 2; i++ {
+			if _, err := r.Read(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp.Body.Close()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("客户端断开后Write应该返回错误")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("客户端断开后服务端没有停止写入")
+		}
+		srv.Close()
+	}
+}
@@ -5,23 +5,27 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/http/stream/ndjson"
 )
 
 func streamHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/x-ndjson")
 	w.Header().Set("Access-Control-Allow-Origin", "*") // 允许跨域
 
-	flusher := w.(http.Flusher)
+	// HTTP/2没有chunked，每次flush发送一个DATA帧
+	nw := ndjson.NewNDJSONWriter[map[string]string](w, r)
 	for i := 0; i < 10; i++ {
-		json.NewEncoder(w).Encode(map[string]string{
+		err := nw.Write(map[string]string{
 			"content": fmt.Sprintf("Token %d (via %s)", i, r.Proto),
 		})
-		flusher.Flush()
+		if err != nil {
+			log.Printf("Client disconnected: %v", err)
+			return
+		}
 		time.Sleep(400 * time.Millisecond)
 	}
 }
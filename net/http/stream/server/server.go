@@ -2,11 +2,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/http/mcp"
+	"github.com/ilaziness/gopkg/net/http/stream/ndjson"
 )
 
 type StreamMessage struct {
@@ -15,55 +19,23 @@ type StreamMessage struct {
 	Index   int    `json:"index,omitempty"`
 }
 
-type MCPMessage struct {
-	Type      string      `json:"type"` // "message", "tool_call", "tool_result", "done"
-	Role      string      `json:"role,omitempty"`
-	Content   string      `json:"content,omitempty"`
-	ToolName  string      `json:"tool_name,omitempty"`
-	Arguments interface{} `json:"arguments,omitempty"`
-	Result    string      `json:"result,omitempty"`
-}
-
 func streamHandler(w http.ResponseWriter, r *http.Request) {
-	// 设置响应头：NDJSON + 流式传输
-	w.Header().Set("Content-Type", "application/x-ndjson")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*") // 支持跨域
-
-	// 确保 header 被立即发送（某些中间件可能缓冲）
-	if f, ok := w.(http.Flusher); ok {
-		f.Flush()
-	}
+	// 设置NDJSON响应头并立即发送，每次Write后flush，客户端断开后Write返回错误
+	nw := ndjson.NewNDJSONWriter[StreamMessage](w, r)
 
 	// 模拟逐步生成数据（如 LLM token）
-	// 监听客户端断开连接
-	ctx := r.Context()
 	for i := 0; i < 5; i++ {
-		select {
-		case <-ctx.Done():
-			log.Println("Client disconnected")
-			return
-		default:
-		}
 		msg := StreamMessage{
 			Type:    "message",
 			Content: fmt.Sprintf("Token %d", i),
 			Index:   i,
 		}
-
-		// 序列化为 JSON 并写入一行
-		if err := json.NewEncoder(w).Encode(msg); err != nil {
-			log.Printf("Error encoding message: %v", err)
+		if err := nw.Write(msg); err != nil {
+			log.Printf("Client disconnected: %v", err)
 			return
 		}
-
-		// 强制 flush，确保客户端立即收到
-		if f, ok := w.(http.Flusher); ok {
-			log.Printf("flush %v", msg)
-			f.Flush()
-		}
-
+		log.Printf("flush %v", msg)
 		time.Sleep(1 * time.Second) // 模拟生成延迟
 	}
 }
@@ -81,40 +53,37 @@ func stream2Handler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(MCPMessage{
-		Type: "message", Role: "assistant", Content: "The weather in Shanghai is 22°C and sunny.",
+	json.NewEncoder(w).Encode(StreamMessage{
+		Type: "message", Content: "The weather in Shanghai is 22°C and sunny.",
 	})
 	flusher.Flush() // 👈 关键：强制将缓冲区数据立即发送
 }
 
-func mcpStreamHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/x-ndjson")
-	w.Header().Set("Access-Control-Allow-Origin", "*") // 支持跨域
-	if f, ok := w.(http.Flusher); ok {
-		f.Flush()
-	}
-
-	messages := []MCPMessage{
-		{Type: "message", Role: "assistant", Content: "I'll check the weather for you."},
-		{Type: "tool_call", ToolName: "get_weather", Arguments: map[string]string{"city": "Shanghai"}},
-		{Type: "tool_result", ToolName: "get_weather", Result: "22°C, sunny"},
-		{Type: "message", Role: "assistant", Content: "The weather in Shanghai is 22°C and sunny."},
-		{Type: "done"},
-	}
+type WeatherArgs struct {
+	City string `json:"city" description:"城市名，如Shanghai"`
+}
 
-	for _, msg := range messages {
-		json.NewEncoder(w).Encode(msg)
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
+// newMCPServer 真实的MCP服务，响应按Accept返回JSON、SSE或者NDJSON
+func newMCPServer() *mcp.Server {
+	s := mcp.NewServer("stream-demo", "0.1.0")
+	mcp.AddTool(s, "get_weather", "查询城市的天气", func(ctx context.Context, in WeatherArgs) (string, error) {
+		// 模拟耗时的查询，流式响应时客户端先收到进度
+		for i := 1; i <= 3; i++ {
+			mcp.Progress(ctx, float64(i), 3, "querying "+in.City)
+			time.Sleep(300 * time.Millisecond)
 		}
-		time.Sleep(800 * time.Millisecond)
-	}
+		return fmt.Sprintf("The weather in %s is 22°C and sunny.", in.City), nil
+	})
+	s.AddResource(mcp.Resource{URI: "demo://cities", Name: "cities", MIMEType: "application/json"}, func(context.Context) ([]byte, error) {
+		return json.Marshal([]string{"Shanghai", "Beijing", "Shenzhen"})
+	})
+	return s
 }
 
 func main() {
 	http.HandleFunc("/stream", streamHandler)
 	http.HandleFunc("/stream2", stream2Handler)
-	http.HandleFunc("/mcp-stream", mcpStreamHandler)
+	http.Handle("/mcp", newMCPServer())
 	log.Println("Server listening on :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
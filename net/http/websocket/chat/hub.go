@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/http/websocket"
+)
+
+const (
+	// writeWait 写一条消息的超时时间
+	writeWait = 10 * time.Second
+	// pongWait 超过这个时间没有收到任何消息（包括pong）认为连接断开
+	pongWait = 60 * time.Second
+	// pingPeriod 必须小于pongWait
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize 客户端消息的最大字节数
+	maxMessageSize = 4096
+	// sendBuffer 每个客户端待发送队列长度，满了断开这个客户端
+	sendBuffer = 64
+)
+
+// Hub 维护所有连接，把收到的消息广播给每个连接
+// 连接集合只在run里修改，不需要加锁
+type Hub struct {
+	clients    map[*Client]struct{}
+	broadcast  chan []byte
+	register   chan *Client
+	unregister chan *Client
+}
+
+func newHub() *Hub {
+	return &Hub{
+		clients:    map[*Client]struct{}{},
+		broadcast:  make(chan []byte),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+	}
+}
+
+func (h *Hub) run() {
+	for {
+		select {
+		case c := <-h.register:
+			h.clients[c] = struct{}{}
+			log.Printf("join %s, %d online", c.conn.RemoteAddr(), len(h.clients))
+		case c := <-h.unregister:
+			h.remove(c)
+		case msg := <-h.broadcast:
+			for c := range h.clients {
+				select {
+				case c.send <- msg:
+				default:
+					// 慢客户端不能拖住其他人
+					h.remove(c)
+				}
+			}
+		}
+	}
+}
+
+// remove 关闭send后writePump发送关闭帧结束连接
+func (h *Hub) remove(c *Client) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	close(c.send)
+	log.Printf("leave %s, %d online", c.conn.RemoteAddr(), len(h.clients))
+}
+
+// Client 一个连接，读写各一个goroutine
+// 只有writePump写连接，readPump只读
+type Client struct {
+	hub  *Hub
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// readPump 读客户端的消息交给hub广播，连接出错时注销
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister <- c
+	}()
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func([]byte) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("read %s: %v", c.conn.RemoteAddr(), err)
+			}
+			return
+		}
+		c.hub.broadcast <- msg
+	}
+}
+
+// writePump 发送广播消息，定时ping，send关闭后发起关闭握手
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.Close(websocket.CloseNormalClosure, "")
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteControl(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/ilaziness/gopkg/net/http/websocket"
+)
+
+// 广播聊天室：每个连接发送的消息转发给所有连接
+//
+// go run ./net/http/websocket/chat
+// 浏览器打开client.html，可以开多个窗口
+
+var upgrader = websocket.Upgrader{
+	EnableCompression: true,
+	// client.html直接用浏览器打开，Origin是null，示例允许任意来源
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen address")
+	flag.Parse()
+
+	hub := newHub()
+	go hub.run()
+
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		c := &Client{hub: hub, conn: conn, send: make(chan []byte, sendBuffer)}
+		hub.register <- c
+		go c.writePump()
+		c.readPump()
+	})
+	log.Println("Server listening on", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,187 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// ErrBadHandshake 服务端没有返回101，Dial同时返回服务端的响应
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Dialer 客户端连接参数
+type Dialer struct {
+	// NetDialContext 为nil时用net.Dialer
+	NetDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSClientConfig wss连接的TLS配置
+	TLSClientConfig *tls.Config
+	// HandshakeTimeout 建立连接和握手的超时时间，默认DefaultHandshakeTimeout
+	HandshakeTimeout time.Duration
+	// Subprotocols 请求的子协议
+	Subprotocols []string
+	// EnableCompression 请求permessage-deflate，服务端同意时启用
+	EnableCompression bool
+	// ReadLimit 一条消息的最大字节数，默认DefaultReadLimit
+	ReadLimit int64
+}
+
+// Dial 用默认参数连接ws://或者wss://地址
+func Dial(ctx context.Context, urlStr string, header http.Header) (*Conn, *http.Response, error) {
+	var d Dialer
+	return d.Dial(ctx, urlStr, header)
+}
+
+// Dial 连接服务端并握手，header是附加的请求头，比如Origin和Cookie
+// 握手失败时返回ErrBadHandshake和服务端的响应，响应的Body可以读取
+func (d *Dialer) Dial(ctx context.Context, urlStr string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, err
+	}
+	addr := u.Host
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "wss":
+		u.Scheme = "https"
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		}
+	default:
+		return nil, nil, errors.New("websocket: unsupported scheme " + u.Scheme)
+	}
+
+	timeout := d.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dial := d.NetDialContext
+	if dial == nil {
+		var nd net.Dialer
+		dial = nd.DialContext
+	}
+	netConn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			netConn.Close()
+		}
+	}()
+	// ctx结束时中断握手，TLS连接的deadline也设置在底层连接上
+	raw := netConn
+	stop := context.AfterFunc(ctx, func() {
+		raw.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	if u.Scheme == "https" {
+		cfg := d.TLSClientConfig.Clone()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		// 只能用HTTP/1.1升级
+		cfg.NextProtos = []string{"http/1.1"}
+		tc := tls.Client(netConn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return nil, nil, err
+		}
+		netConn = tc
+	}
+
+	keyBytes := make([]byte, 16)
+	rand.Read(keyBytes)
+	key := base64.StdEncoding.EncodeToString(keyBytes)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+	if d.EnableCompression {
+		req.Header.Set("Sec-WebSocket-Extensions", deflateOffer)
+	}
+	if err := req.Write(netConn); err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(resp.Header, "Upgrade", "websocket") ||
+		!headerContains(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		// 读出一部分响应体，调用方可以看到错误信息
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return nil, resp, ErrBadHandshake
+	}
+	protocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if protocol != "" && !slices.Contains(d.Subprotocols, protocol) {
+		return nil, resp, handshakeError("unexpected subprotocol " + protocol)
+	}
+	var compress, peerTakeover bool
+	if d.EnableCompression {
+		if compress, peerTakeover, err = checkDeflateResponse(resp.Header); err != nil {
+			return nil, resp, err
+		}
+	} else if len(resp.Header.Values("Sec-WebSocket-Extensions")) > 0 {
+		return nil, resp, handshakeError("unexpected Sec-WebSocket-Extensions")
+	}
+	resp.Body = http.NoBody
+
+	if !stop() {
+		// ctx已经结束，deadline被设置过了
+		return nil, resp, ctx.Err()
+	}
+	netConn.SetDeadline(time.Time{})
+	c := newConn(netConn, br, false)
+	c.subprotocol = protocol
+	c.compress = compress
+	c.peerTakeover = peerTakeover
+	if d.ReadLimit > 0 {
+		c.SetReadLimit(d.ReadLimit)
+	}
+	ok = true
+	return c, resp, nil
+}
@@ -0,0 +1,191 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// permessage-deflate https://www.rfc-editor.org/rfc/rfc7692
+//
+// 自己发送的消息每条单独压缩（no_context_takeover），不用保存压缩状态；
+// 对端保留上下文时用之前解压出的32KB内容作字典解压
+
+const (
+	extDeflate = "permessage-deflate"
+	// maxWindow flate的窗口固定是32KB
+	maxWindow = 1 << 15
+)
+
+// deflateTail 每条消息压缩后去掉的结尾，解压时补回
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// deflateFinal 补在结尾的空的最后一个块，让flate读到EOF
+var deflateFinal = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+type compressor struct {
+	buf bytes.Buffer
+	w   *flate.Writer
+}
+
+func newCompressor() *compressor {
+	c := &compressor{}
+	c.w, _ = flate.NewWriter(&c.buf, flate.BestSpeed)
+	return c
+}
+
+// compress 返回的内容在下次调用前有效
+func (c *compressor) compress(p []byte) []byte {
+	c.buf.Reset()
+	c.w.Reset(&c.buf)
+	c.w.Write(p)
+	c.w.Flush()
+	// Flush以空的stored块结束，去掉最后4个字节，空消息剩下一个0x00
+	return bytes.TrimSuffix(c.buf.Bytes(), deflateTail)
+}
+
+type decompressor struct {
+	r    io.ReadCloser
+	dict []byte
+}
+
+// decompress 解压一条消息，解压后超过limit返回ErrMessageTooBig
+func (c *Conn) decompress(p []byte, limit int64) ([]byte, error) {
+	d := c.decompressor
+	if d == nil {
+		d = &decompressor{}
+		c.decompressor = d
+	}
+	src := io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateFinal))
+	if d.r == nil {
+		d.r = flate.NewReaderDict(src, d.dict)
+	} else {
+		d.r.(flate.Resetter).Reset(src, d.dict)
+	}
+	out, err := io.ReadAll(io.LimitReader(d.r, limit+1))
+	if err != nil {
+		return nil, protocolError("invalid compressed data: " + err.Error())
+	}
+	if int64(len(out)) > limit {
+		return nil, ErrMessageTooBig
+	}
+	if c.peerTakeover {
+		d.dict = append(d.dict, out...)
+		if len(d.dict) > maxWindow {
+			d.dict = append(d.dict[:0], d.dict[len(d.dict)-maxWindow:]...)
+		}
+	}
+	return out, nil
+}
+
+type extension struct {
+	name   string
+	params map[string]string
+}
+
+// parseExtensions 解析Sec-WebSocket-Extensions，格式不对的扩展跳过
+//
+//	permessage-deflate; client_max_window_bits, permessage-deflate
+func parseExtensions(h http.Header) []extension {
+	var exts []extension
+	for _, line := range h.Values("Sec-WebSocket-Extensions") {
+		for _, item := range strings.Split(line, ",") {
+			parts := strings.Split(item, ";")
+			e := extension{name: strings.ToLower(strings.TrimSpace(parts[0])), params: map[string]string{}}
+			if e.name == "" {
+				continue
+			}
+			ok := true
+			for _, p := range parts[1:] {
+				k, v, _ := strings.Cut(p, "=")
+				k = strings.ToLower(strings.TrimSpace(k))
+				v = strings.Trim(strings.TrimSpace(v), `"`)
+				if _, dup := e.params[k]; dup || k == "" {
+					ok = false
+					break
+				}
+				e.params[k] = v
+			}
+			if ok {
+				exts = append(exts, e)
+			}
+		}
+	}
+	return exts
+}
+
+// acceptDeflate 服务端从客户端的offer里选一个能接受的，返回响应头和对端是否保留上下文
+func acceptDeflate(h http.Header) (resp string, peerTakeover, ok bool) {
+	for _, e := range parseExtensions(h) {
+		if e.name != extDeflate {
+			continue
+		}
+		peerTakeover = true
+		valid := true
+		for k, v := range e.params {
+			switch k {
+			case "client_no_context_takeover":
+				valid = valid && v == ""
+				peerTakeover = false
+			case "server_no_context_takeover":
+				valid = valid && v == ""
+			case "server_max_window_bits":
+				// 压缩的窗口不能改小
+				valid = valid && v == "15"
+			case "client_max_window_bits":
+				// 只是告诉服务端客户端支持这个参数，解压任何窗口都可以
+				if v != "" {
+					n, err := strconv.Atoi(v)
+					valid = valid && err == nil && n >= 8 && n <= 15
+				}
+			default:
+				valid = false
+			}
+		}
+		if valid {
+			resp = extDeflate + "; server_no_context_takeover"
+			if !peerTakeover {
+				resp += "; client_no_context_takeover"
+			}
+			return resp, peerTakeover, true
+		}
+	}
+	return "", false, false
+}
+
+// deflateOffer 客户端的offer，自己不保留上下文
+const deflateOffer = extDeflate + "; client_no_context_takeover"
+
+// checkDeflateResponse 客户端检查服务端的响应，返回服务端是否保留上下文
+func checkDeflateResponse(h http.Header) (enabled, peerTakeover bool, err error) {
+	exts := parseExtensions(h)
+	if len(exts) == 0 {
+		if len(h.Values("Sec-WebSocket-Extensions")) > 0 {
+			return false, false, handshakeError("invalid Sec-WebSocket-Extensions")
+		}
+		return false, false, nil
+	}
+	if len(exts) > 1 || exts[0].name != extDeflate {
+		return false, false, handshakeError("unexpected extension " + exts[0].name)
+	}
+	peerTakeover = true
+	for k, v := range exts[0].params {
+		switch k {
+		case "server_no_context_takeover":
+			peerTakeover = false
+		case "client_no_context_takeover":
+		case "server_max_window_bits":
+			// 窗口更小时字典也不会超过32KB
+			if n, err := strconv.Atoi(v); err != nil || n < 8 || n > 15 {
+				return false, false, handshakeError("invalid server_max_window_bits")
+			}
+		default:
+			// 没有发送client_max_window_bits，服务端不能返回
+			return false, false, handshakeError("unexpected extension parameter " + k)
+		}
+	}
+	return true, peerTakeover, nil
+}
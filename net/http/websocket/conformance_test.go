@@ -0,0 +1,437 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 参照Autobahn TestSuite的分类，用原始帧检查服务端的行为
+// 服务端把收到的消息原样发回，每个用例发送一组帧，检查收到的帧和最后的关闭状态码
+
+// rawFrame 测试里收发的帧，客户端发送时自动加掩码
+type rawFrame struct {
+	fin     bool
+	rsv     byte
+	op      byte
+	payload []byte
+	// masked 为false时发送不带掩码的帧，只在测试掩码时使用
+	unmasked bool
+}
+
+func frame(op byte, payload string) rawFrame {
+	return rawFrame{fin: true, op: op, payload: []byte(payload)}
+}
+
+func frag(op byte, payload string) rawFrame {
+	return rawFrame{op: op, payload: []byte(payload)}
+}
+
+func closeFrame(code int, reason string) rawFrame {
+	p := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return rawFrame{fin: true, op: opClose, payload: append(p, reason...)}
+}
+
+// noStatus 关闭帧没有状态码
+const noStatus = -1
+
+type conformanceCase struct {
+	id   string
+	desc string
+	send []rawFrame
+	want []rawFrame
+	// close 服务端最后发送的关闭状态码，为0时测试发送1000正常关闭
+	close int
+}
+
+// rawClient 直接读写帧的客户端
+type rawClient struct {
+	t          *testing.T
+	c          net.Conn
+	br         *bufio.Reader
+	compressed bool
+	fw         *flate.Writer
+	fbuf       bytes.Buffer
+}
+
+// dialRaw 用RFC里的例子握手，ext是Sec-WebSocket-Extensions
+func dialRaw(t *testing.T, srv *httptest.Server, ext string) (*rawClient, *http.Response) {
+	t.Helper()
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	req := "GET /chat HTTP/1.1\r\nHost: " + srv.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	if ext != "" {
+		req += "Sec-WebSocket-Extensions: " + ext + "\r\n"
+	}
+	io.WriteString(c, req+"\r\n")
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("握手 %s %v", resp.Status, resp.Header)
+	}
+	rc := &rawClient{t: t, c: c, br: br, compressed: resp.Header.Get("Sec-WebSocket-Extensions") != ""}
+	t.Cleanup(func() { c.Close() })
+	return rc, resp
+}
+
+func (r *rawClient) write(f rawFrame) {
+	h := frameHeader{fin: f.fin, rsv: f.rsv, opcode: f.op, masked: !f.unmasked, length: int64(len(f.payload))}
+	h.mask = [4]byte{0x12, 0x34, 0x56, 0x78}
+	b := appendFrameHeader(nil, h)
+	n := len(b)
+	b = append(b, f.payload...)
+	if h.masked {
+		maskBytes(h.mask, 0, b[n:])
+	}
+	if _, err := r.c.Write(b); err != nil {
+		// 服务端可能已经因为前面的帧关闭了连接
+		r.t.Logf("写帧: %v", err)
+	}
+}
+
+// deflate 压缩一条消息，takeover为true时保留上下文
+func (r *rawClient) deflate(p string, takeover bool) []byte {
+	if r.fw == nil || !takeover {
+		r.fbuf.Reset()
+		r.fw, _ = flate.NewWriter(&r.fbuf, flate.BestCompression)
+	}
+	start := r.fbuf.Len()
+	r.fw.Write([]byte(p))
+	r.fw.Flush()
+	out := bytes.Clone(r.fbuf.Bytes()[start:])
+	return bytes.TrimSuffix(out, deflateTail)
+}
+
+// read 读一帧，服务端压缩的帧解压后返回
+func (r *rawClient) read() (rawFrame, error) {
+	h, err := readFrameHeader(r.br)
+	if err != nil {
+		return rawFrame{}, err
+	}
+	if h.masked {
+		r.t.Errorf("服务端的帧不能有掩码")
+	}
+	p := make([]byte, h.length)
+	if _, err := io.ReadFull(r.br, p); err != nil {
+		return rawFrame{}, err
+	}
+	f := rawFrame{fin: h.fin, rsv: h.rsv, op: h.opcode, payload: p}
+	if h.rsv&rsv1Bit != 0 {
+		if !r.compressed {
+			r.t.Errorf("没有协商压缩时不能设置RSV1")
+		}
+		fr := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateFinal)))
+		if f.payload, err = io.ReadAll(fr); err != nil {
+			r.t.Errorf("解压服务端的消息: %v", err)
+		}
+		f.rsv = 0
+	}
+	return f, nil
+}
+
+// expectClose 下一帧是关闭帧，之后服务端关闭连接
+func (r *rawClient) expectClose(code int) {
+	r.t.Helper()
+	f, err := r.read()
+	if err != nil {
+		r.t.Errorf("期望关闭帧%d, 读出错 %v", code, err)
+		return
+	}
+	got := noStatus
+	if len(f.payload) >= 2 {
+		got = int(binary.BigEndian.Uint16(f.payload))
+	}
+	if f.op != opClose || got != code {
+		r.t.Errorf("期望关闭帧%d, 收到 op=%d code=%d %q", code, f.op, got, f.payload)
+		return
+	}
+	// 服务端关闭时还有没读的数据会发送RST
+	if _, err := r.br.ReadByte(); err != io.EOF && !errors.Is(err, syscall.ECONNRESET) {
+		r.t.Errorf("关闭后服务端应该断开连接 %v", err)
+	}
+}
+
+func echoHandler(u *Upgrader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		for {
+			mt, p, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, p); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func runConformance(t *testing.T, srv *httptest.Server, ext string, cases []conformanceCase) {
+	for _, tc := range cases {
+		t.Run(tc.id, func(t *testing.T) {
+			r, _ := dialRaw(t, srv, ext)
+			for _, f := range tc.send {
+				r.write(f)
+			}
+			for i, w := range tc.want {
+				f, err := r.read()
+				if err != nil {
+					t.Fatalf("%s: 第%d帧: %v", tc.desc, i, err)
+				}
+				if f.op != w.op || !f.fin || !bytes.Equal(f.payload, w.payload) {
+					t.Fatalf("%s: 第%d帧 op=%d %.40q, 期望 op=%d %.40q", tc.desc, i, f.op, f.payload, w.op, w.payload)
+				}
+			}
+			if tc.close == 0 {
+				r.write(closeFrame(CloseNormalClosure, ""))
+				r.expectClose(CloseNormalClosure)
+			} else {
+				r.expectClose(tc.close)
+			}
+		})
+	}
+}
+
+func TestConformance(t *testing.T) {
+	srv := httptest.NewServer(echoHandler(&Upgrader{}))
+	defer srv.Close()
+
+	var cases []conformanceCase
+	// 1 帧长度，覆盖7位、16位和64位的长度编码
+	for i, n := range []int{0, 125, 126, 127, 128, 65535, 65536, 1 << 20} {
+		p := strings.Repeat("*", n)
+		cases = append(cases,
+			conformanceCase{id: fmt.Sprintf("1.1.%d", i+1), desc: fmt.Sprintf("%d字节文本", n),
+				send: []rawFrame{frame(opText, p)}, want: []rawFrame{frame(opText, p)}},
+			conformanceCase{id: fmt.Sprintf("1.2.%d", i+1), desc: fmt.Sprintf("%d字节二进制", n),
+				send: []rawFrame{frame(opBinary, "\xfe"+p)}, want: []rawFrame{frame(opBinary, "\xfe"+p)}},
+		)
+	}
+
+	ping125 := strings.Repeat("\xfe", 125)
+	cases = append(cases,
+		// 2 ping/pong
+		conformanceCase{id: "2.1", desc: "空ping", send: []rawFrame{frame(opPing, "")}, want: []rawFrame{frame(opPong, "")}},
+		conformanceCase{id: "2.2", desc: "带内容的ping", send: []rawFrame{frame(opPing, "Hello, world!")}, want: []rawFrame{frame(opPong, "Hello, world!")}},
+		conformanceCase{id: "2.3", desc: "125字节的ping", send: []rawFrame{frame(opPing, ping125)}, want: []rawFrame{frame(opPong, ping125)}},
+		conformanceCase{id: "2.4", desc: "126字节的ping", send: []rawFrame{frame(opPing, ping125+"x")}, close: CloseProtocolError},
+		conformanceCase{id: "2.5", desc: "没有请求的pong忽略",
+			send: []rawFrame{frame(opPong, "unsolicited"), frame(opText, "after")}, want: []rawFrame{frame(opText, "after")}},
+		conformanceCase{id: "2.6", desc: "连续10个ping",
+			send: repeat(frame(opPing, "p"), 10), want: repeat(frame(opPong, "p"), 10)},
+
+		// 3 保留位
+		conformanceCase{id: "3.1", desc: "没有协商扩展时RSV1", send: []rawFrame{{fin: true, rsv: 0x40, op: opText, payload: []byte("x")}}, close: CloseProtocolError},
+		conformanceCase{id: "3.2", desc: "RSV2", send: []rawFrame{frame(opText, "ok"), {fin: true, rsv: 0x20, op: opText}},
+			want: []rawFrame{frame(opText, "ok")}, close: CloseProtocolError},
+		conformanceCase{id: "3.3", desc: "ping的RSV3", send: []rawFrame{{fin: true, rsv: 0x10, op: opPing}}, close: CloseProtocolError},
+		conformanceCase{id: "3.4", desc: "全部保留位", send: []rawFrame{{fin: true, rsv: 0x70, op: opBinary}}, close: CloseProtocolError},
+	)
+	// 4 保留的opcode
+	for _, op := range []byte{3, 4, 5, 6, 7, 0xb, 0xc, 0xd, 0xe, 0xf} {
+		cases = append(cases, conformanceCase{id: fmt.Sprintf("4.%d", op), desc: fmt.Sprintf("opcode %d", op),
+			send: []rawFrame{frame(opText, "before"), frame(op, "x")}, want: []rawFrame{frame(opText, "before")}, close: CloseProtocolError})
+	}
+
+	cases = append(cases,
+		// 5 分片
+		conformanceCase{id: "5.1", desc: "分片的ping", send: []rawFrame{frag(opPing, "a"), frame(opContinuation, "b")}, close: CloseProtocolError},
+		conformanceCase{id: "5.2", desc: "分片的pong", send: []rawFrame{frag(opPong, "a"), frame(opContinuation, "b")}, close: CloseProtocolError},
+		conformanceCase{id: "5.3", desc: "两个分片的文本",
+			send: []rawFrame{frag(opText, "frag"), frame(opContinuation, "ment")}, want: []rawFrame{frame(opText, "fragment")}},
+		conformanceCase{id: "5.4", desc: "分片之间插入ping",
+			send: []rawFrame{frag(opText, "a"), frame(opPing, "p"), frag(opContinuation, "b"), frame(opPing, "q"), frame(opContinuation, "c")},
+			want: []rawFrame{frame(opPong, "p"), frame(opPong, "q"), frame(opText, "abc")}},
+		conformanceCase{id: "5.5", desc: "没有开始的延续帧", send: []rawFrame{frame(opContinuation, "x")}, close: CloseProtocolError},
+		conformanceCase{id: "5.6", desc: "分片没结束又开始新消息", send: []rawFrame{frag(opText, "a"), frame(opText, "b")}, close: CloseProtocolError},
+		conformanceCase{id: "5.7", desc: "空分片",
+			send: []rawFrame{frag(opText, ""), frag(opContinuation, ""), frame(opContinuation, "x")}, want: []rawFrame{frame(opText, "x")}},
+		conformanceCase{id: "5.8", desc: "分片的二进制",
+			send: []rawFrame{frag(opBinary, "\x00\x01"), frag(opContinuation, "\x02"), frame(opContinuation, "\x03")}, want: []rawFrame{frame(opBinary, "\x00\x01\x02\x03")}},
+		conformanceCase{id: "5.9", desc: "分片之间的关闭帧", send: []rawFrame{frag(opText, "a"), closeFrame(CloseGoingAway, "")}, close: CloseGoingAway},
+		conformanceCase{id: "5.10", desc: "分片的关闭帧", send: []rawFrame{{op: opClose, payload: []byte{0x03, 0xe8}}}, close: CloseProtocolError},
+	)
+
+	// 6 UTF-8
+	kosme := "κόσμε"
+	var perByte []rawFrame
+	for i := 0; i < len(kosme); i++ {
+		op := byte(opContinuation)
+		if i == 0 {
+			op = opText
+		}
+		perByte = append(perByte, rawFrame{fin: i == len(kosme)-1, op: op, payload: []byte{kosme[i]}})
+	}
+	cases = append(cases,
+		conformanceCase{id: "6.1", desc: "合法的UTF-8", send: []rawFrame{frame(opText, kosme)}, want: []rawFrame{frame(opText, kosme)}},
+		conformanceCase{id: "6.2", desc: "在字符中间分片", send: perByte, want: []rawFrame{frame(opText, kosme)}},
+		conformanceCase{id: "6.3", desc: "代理对", send: []rawFrame{frame(opText, "\xce\xba\xe1\xbd\xb9\xcf\x83\xce\xbc\xce\xb5\xed\xa0\x80edited")}, close: CloseInvalidFramePayloadData},
+		conformanceCase{id: "6.4", desc: "超长编码", send: []rawFrame{frame(opText, "\xc0\xaf")}, close: CloseInvalidFramePayloadData},
+		conformanceCase{id: "6.5", desc: "结尾不完整", send: []rawFrame{frame(opText, "κ\xce")}, close: CloseInvalidFramePayloadData},
+		conformanceCase{id: "6.6", desc: "分片里的非法字节", send: []rawFrame{frag(opText, "ok"), frame(opContinuation, "\xff")}, close: CloseInvalidFramePayloadData},
+		conformanceCase{id: "6.7", desc: "二进制消息不检查UTF-8", send: []rawFrame{frame(opBinary, "\xff\xfe")}, want: []rawFrame{frame(opBinary, "\xff\xfe")}},
+		conformanceCase{id: "6.8", desc: "最大码点", send: []rawFrame{frame(opText, "\xf4\x8f\xbf\xbf")}, want: []rawFrame{frame(opText, "\xf4\x8f\xbf\xbf")}},
+		conformanceCase{id: "6.9", desc: "超过最大码点", send: []rawFrame{frame(opText, "\xf4\x90\x80\x80")}, close: CloseInvalidFramePayloadData},
+
+		// 7 关闭
+		conformanceCase{id: "7.1", desc: "没有状态码的关闭帧", send: []rawFrame{frame(opClose, "")}, close: noStatus},
+		conformanceCase{id: "7.2", desc: "1字节的关闭帧", send: []rawFrame{frame(opClose, "\x03")}, close: CloseProtocolError},
+		conformanceCase{id: "7.3", desc: "带原因的关闭帧", send: []rawFrame{closeFrame(CloseNormalClosure, "bye")}, close: CloseNormalClosure},
+		conformanceCase{id: "7.4", desc: "123字节的原因", send: []rawFrame{closeFrame(CloseNormalClosure, strings.Repeat("*", 123))}, close: CloseNormalClosure},
+		conformanceCase{id: "7.5", desc: "原因不是UTF-8", send: []rawFrame{closeFrame(CloseNormalClosure, "\xce\xba\xe1\xbd")}, close: CloseInvalidFramePayloadData},
+		conformanceCase{id: "7.6", desc: "关闭后的消息忽略",
+			send: []rawFrame{closeFrame(CloseNormalClosure, ""), frame(opText, "late"), frame(opPing, "late")}, close: CloseNormalClosure},
+	)
+	for _, code := range []int{1000, 1001, 1002, 1003, 1007, 1008, 1009, 1010, 1011, 3000, 3999, 4000, 4999} {
+		cases = append(cases, conformanceCase{id: fmt.Sprintf("7.7.%d", code), desc: "合法的状态码",
+			send: []rawFrame{closeFrame(code, "")}, close: code})
+	}
+	for _, code := range []int{0, 999, 1004, 1005, 1006, 1015, 1016, 1100, 2000, 2999, 5000, 65535} {
+		cases = append(cases, conformanceCase{id: fmt.Sprintf("7.9.%d", code), desc: "非法的状态码",
+			send: []rawFrame{closeFrame(code, "")}, close: CloseProtocolError})
+	}
+
+	// 掩码
+	cases = append(cases, conformanceCase{id: "10.1", desc: "客户端的帧没有掩码",
+		send: []rawFrame{{fin: true, op: opText, payload: []byte("x"), unmasked: true}}, close: CloseProtocolError})
+
+	runConformance(t, srv, "", cases)
+}
+
+func repeat(f rawFrame, n int) []rawFrame {
+	fs := make([]rawFrame, n)
+	for i := range fs {
+		fs[i] = f
+	}
+	return fs
+}
+
+func TestConformanceLimits(t *testing.T) {
+	srv := httptest.NewServer(echoHandler(&Upgrader{ReadLimit: 1024}))
+	defer srv.Close()
+	full := strings.Repeat("*", 1024)
+	runConformance(t, srv, "", []conformanceCase{
+		{id: "9.1", desc: "等于限制", send: []rawFrame{frame(opBinary, full)}, want: []rawFrame{frame(opBinary, full)}},
+		{id: "9.2", desc: "超过限制", send: []rawFrame{frame(opBinary, full+"*")}, close: CloseMessageTooBig},
+		{id: "9.3", desc: "分片合计超过限制", send: []rawFrame{frag(opBinary, full), frame(opContinuation, "*")}, close: CloseMessageTooBig},
+	})
+}
+
+func TestConformanceCompression(t *testing.T) {
+	srv := httptest.NewServer(echoHandler(&Upgrader{EnableCompression: true, ReadLimit: 64 << 10}))
+	defer srv.Close()
+
+	// 12/13 协商
+	for _, tc := range []struct {
+		offer, want string
+	}{
+		{"permessage-deflate", "permessage-deflate; server_no_context_takeover"},
+		{"permessage-deflate; client_no_context_takeover", "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"permessage-deflate; client_max_window_bits", "permessage-deflate; server_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=15; server_no_context_takeover", "permessage-deflate; server_no_context_takeover"},
+		// 不支持更小的压缩窗口，跳过这个offer用下一个
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", "permessage-deflate; server_no_context_takeover"},
+		{"x-webkit-deflate-frame, permessage-deflate; client_no_context_takeover", "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=10", ""},
+		{"permessage-deflate; foo", ""},
+		{"permessage-deflate; client_no_context_takeover; client_no_context_takeover", ""},
+		{"x-webkit-deflate-frame", ""},
+	} {
+		_, resp := dialRaw(t, srv, tc.offer)
+		if got := resp.Header.Get("Sec-WebSocket-Extensions"); got != tc.want {
+			t.Errorf("offer %q 响应 %q, 期望 %q", tc.offer, got, tc.want)
+		}
+	}
+
+	text := strings.Repeat("Hello, compression! ", 50)
+	compressed := func(r *rawClient, p string, takeover bool) rawFrame {
+		return rawFrame{fin: true, rsv: rsv1Bit, op: opText, payload: r.deflate(p, takeover)}
+	}
+	t.Run("12.1", func(t *testing.T) {
+		// 客户端保留上下文，服务端需要用之前的内容作字典
+		r, _ := dialRaw(t, srv, "permessage-deflate")
+		for i := 0; i < 3; i++ {
+			r.write(compressed(r, text, true))
+			f, err := r.read()
+			if err != nil || string(f.payload) != text {
+				t.Fatalf("第%d条 %v %.40q", i, err, f.payload)
+			}
+		}
+		r.write(closeFrame(CloseNormalClosure, ""))
+		r.expectClose(CloseNormalClosure)
+	})
+	t.Run("12.2", func(t *testing.T) {
+		// 服务端的消息每条单独压缩，第二条和第一条一样也要完整压缩
+		r, _ := dialRaw(t, srv, "permessage-deflate; client_no_context_takeover")
+		for i := 0; i < 2; i++ {
+			r.write(compressed(r, text, false))
+			h, err := readFrameHeader(r.br)
+			if err != nil || h.rsv != rsv1Bit || h.length >= int64(len(text)) {
+				t.Fatalf("服务端的压缩帧 %+v %v", h, err)
+			}
+			p := make([]byte, h.length)
+			io.ReadFull(r.br, p)
+			out, err := io.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateFinal))))
+			if err != nil || string(out) != text {
+				t.Fatalf("单独解压第%d条 %v", i, err)
+			}
+		}
+	})
+
+	r := &rawClient{t: t}
+	payload := r.deflate(text, false)
+	bomb := r.deflate(strings.Repeat("\x00", 1<<20), false)
+	runConformance(t, srv, "permessage-deflate", []conformanceCase{
+		{id: "13.1", desc: "压缩的消息分片，只有第一帧有RSV1",
+			send: []rawFrame{{rsv: rsv1Bit, op: opText, payload: payload[:10]}, {op: opContinuation, payload: payload[10:20]}, {fin: true, op: opContinuation, payload: payload[20:]}},
+			want: []rawFrame{frame(opText, text)}},
+		{id: "13.2", desc: "没有压缩的消息", send: []rawFrame{frame(opText, "plain")}, want: []rawFrame{frame(opText, "plain")}},
+		{id: "13.3", desc: "压缩的空消息", send: []rawFrame{{fin: true, rsv: rsv1Bit, op: opBinary, payload: []byte{0x00}}}, want: []rawFrame{frame(opBinary, "")}},
+		{id: "13.4", desc: "延续帧设置RSV1",
+			send: []rawFrame{{rsv: rsv1Bit, op: opText, payload: payload[:10]}, {fin: true, rsv: rsv1Bit, op: opContinuation, payload: payload[10:]}}, close: CloseProtocolError},
+		{id: "13.5", desc: "控制帧设置RSV1", send: []rawFrame{{fin: true, rsv: rsv1Bit, op: opPing}}, close: CloseProtocolError},
+		{id: "13.6", desc: "错误的压缩数据", send: []rawFrame{{fin: true, rsv: rsv1Bit, op: opText, payload: []byte{0xff, 0xff, 0xff}}}, close: CloseProtocolError},
+		{id: "13.7", desc: "解压后超过限制", send: []rawFrame{{fin: true, rsv: rsv1Bit, op: opBinary, payload: bomb}}, close: CloseMessageTooBig},
+		{id: "13.8", desc: "解压后不是UTF-8", send: []rawFrame{{fin: true, rsv: rsv1Bit, op: opText, payload: r.deflate("\xff", false)}}, close: CloseInvalidFramePayloadData},
+	})
+}
+
+func TestConformanceRejectsData(t *testing.T) {
+	// 握手请求后面紧跟着帧
+	srv := httptest.NewServer(echoHandler(&Upgrader{}))
+	defer srv.Close()
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n\x81\x80\x00\x00\x00\x00")
+	_, err = http.ReadResponse(bufio.NewReader(c), nil)
+	if err == nil || !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		t.Errorf("提前发送数据的连接应该被关闭 %v", err)
+	}
+}
@@ -0,0 +1,431 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType 消息类型，值和帧的opcode相同
+type MessageType int
+
+const (
+	TextMessage   MessageType = opText
+	BinaryMessage MessageType = opBinary
+	CloseMessage  MessageType = opClose
+	PingMessage   MessageType = opPing
+	PongMessage   MessageType = opPong
+)
+
+const (
+	// DefaultReadLimit 一条消息的最大字节数，压缩的消息按解压后的大小计算
+	DefaultReadLimit = 32 << 20
+	// closeTimeout 发送关闭帧后等待对端关闭帧的时间
+	closeTimeout = 5 * time.Second
+)
+
+var (
+	// ErrMessageTooBig 消息超过了读取限制，连接用1009关闭
+	ErrMessageTooBig = errors.New("websocket: message too big")
+	// ErrCloseSent 已经发送了关闭帧，不能再发送消息
+	ErrCloseSent = errors.New("websocket: close sent")
+)
+
+// Conn WebSocket连接
+//
+// 同一时间只能有一个goroutine读，写方法可以并发调用；
+// 读的时候自动回复ping，收到关闭帧时回复关闭帧并关闭底层连接
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	isServer    bool
+	subprotocol string
+
+	// compress 协商了permessage-deflate
+	compress bool
+	// peerTakeover 对端压缩时保留上下文，解压需要用之前的内容作字典
+	peerTakeover bool
+
+	writeMu      sync.Mutex
+	wbuf         []byte
+	closeSent    bool
+	compressor   *compressor
+	fragmentSize int
+
+	readMu       sync.Mutex
+	readErr      error
+	readLimit    atomic.Int64
+	decompressor *decompressor
+	pingHandler  func(data []byte) error
+	pongHandler  func(data []byte) error
+}
+
+func newConn(c net.Conn, br *bufio.Reader, isServer bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(c)
+	}
+	conn := &Conn{conn: c, br: br, isServer: isServer}
+	conn.readLimit.Store(DefaultReadLimit)
+	return conn
+}
+
+// Subprotocol 握手时协商的子协议
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// Compressed 握手时是否协商了permessage-deflate
+func (c *Conn) Compressed() bool {
+	return c.compress
+}
+
+// NetConn 底层连接
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// SetReadLimit 设置一条消息的最大字节数，超过时用1009关闭连接
+func (c *Conn) SetReadLimit(n int64) {
+	c.readLimit.Store(n)
+}
+
+// SetFragmentSize 发送的数据消息按n字节分片，0表示不分片
+func (c *Conn) SetFragmentSize(n int) {
+	c.writeMu.Lock()
+	c.fragmentSize = n
+	c.writeMu.Unlock()
+}
+
+// SetPingHandler 收到ping时在读消息的goroutine里调用，默认回复相同内容的pong
+// 在开始读之前设置
+func (c *Conn) SetPingHandler(h func(data []byte) error) {
+	c.pingHandler = h
+}
+
+// SetPongHandler 收到pong时在读消息的goroutine里调用，默认什么都不做
+// 在开始读之前设置
+func (c *Conn) SetPongHandler(h func(data []byte) error) {
+	c.pongHandler = h
+}
+
+// ReadMessage 读一条完整的消息，分片会合并，控制帧在这里处理
+//
+// 收到关闭帧时返回*CloseError；对端违反协议时用对应的状态码关闭连接并返回错误，
+// 返回错误后连接不能再读
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	mt, data, err := c.readMessage()
+	if err != nil {
+		c.readErr = err
+		c.fail(err)
+	}
+	return mt, data, err
+}
+
+// fail 读出错后关闭连接，协议错误先发送关闭帧告诉对端原因
+func (c *Conn) fail(err error) {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		// 已经回复过关闭帧
+		c.conn.Close()
+		return
+	}
+	code := 0
+	var pe protocolError
+	switch {
+	case errors.As(err, &pe):
+		code = CloseProtocolError
+	case errors.Is(err, errInvalidUTF8):
+		code = CloseInvalidFramePayloadData
+	case errors.Is(err, ErrMessageTooBig):
+		code = CloseMessageTooBig
+	}
+	if code != 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeClose(code, "")
+	}
+	c.conn.Close()
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		msgType    byte
+		buf        []byte
+		compressed bool
+	)
+	limit := c.readLimit.Load()
+	for {
+		h, err := readFrameHeader(c.br)
+		if err != nil {
+			return 0, nil, err
+		}
+		if err := c.checkHeader(h, msgType); err != nil {
+			return 0, nil, err
+		}
+		if isControl(h.opcode) {
+			payload := make([]byte, h.length)
+			if err := c.readPayload(h, payload); err != nil {
+				return 0, nil, err
+			}
+			if err := c.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		if h.opcode != opContinuation {
+			msgType = h.opcode
+			compressed = h.rsv&rsv1Bit != 0
+		}
+		if int64(len(buf))+h.length > limit {
+			return 0, nil, ErrMessageTooBig
+		}
+		n := len(buf)
+		buf = append(buf, make([]byte, h.length)...)
+		if err := c.readPayload(h, buf[n:]); err != nil {
+			return 0, nil, err
+		}
+		if !h.fin {
+			continue
+		}
+		if compressed {
+			if buf, err = c.decompress(buf, limit); err != nil {
+				return 0, nil, err
+			}
+		}
+		if msgType == opText && !utf8.Valid(buf) {
+			return 0, nil, errInvalidUTF8
+		}
+		return MessageType(msgType), buf, nil
+	}
+}
+
+// checkHeader 检查帧头，msgType是正在接收的分片消息的类型，没有时为0
+func (c *Conn) checkHeader(h frameHeader, msgType byte) error {
+	// 客户端发送的帧必须有掩码，服务端发送的不能有
+	if h.masked != c.isServer {
+		if c.isServer {
+			return protocolError("unmasked client frame")
+		}
+		return protocolError("masked server frame")
+	}
+	rsv := h.rsv
+	if c.compress && h.opcode != opContinuation && !isControl(h.opcode) {
+		// 压缩的消息在第一帧设置RSV1
+		rsv &^= rsv1Bit
+	}
+	if rsv != 0 {
+		return protocolError("reserved bits set")
+	}
+	switch h.opcode {
+	case opClose, opPing, opPong:
+		if !h.fin {
+			return protocolError("fragmented control frame")
+		}
+		if h.length > maxControlPayload {
+			return protocolError("control frame too long")
+		}
+	case opText, opBinary:
+		if msgType != 0 {
+			return protocolError("expected continuation frame")
+		}
+	case opContinuation:
+		if msgType == 0 {
+			return protocolError("unexpected continuation frame")
+		}
+	default:
+		return protocolError("unknown opcode")
+	}
+	return nil
+}
+
+func (c *Conn) readPayload(h frameHeader, p []byte) error {
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if h.masked {
+		maskBytes(h.mask, 0, p)
+	}
+	return nil
+}
+
+func (c *Conn) handleControl(op byte, payload []byte) error {
+	switch op {
+	case opPing:
+		if c.pingHandler != nil {
+			return c.pingHandler(payload)
+		}
+		err := c.WriteControl(PongMessage, payload)
+		if errors.Is(err, ErrCloseSent) {
+			return nil
+		}
+		return err
+	case opPong:
+		if c.pongHandler != nil {
+			return c.pongHandler(payload)
+		}
+		return nil
+	}
+
+	// 关闭帧
+	ce := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return protocolError("invalid close payload")
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		if !validCloseCode(ce.Code) {
+			return protocolError("invalid close code")
+		}
+		if !utf8.Valid(payload[2:]) {
+			return errInvalidUTF8
+		}
+		ce.Text = string(payload[2:])
+	}
+	// 回复相同的状态码，自己先发送了关闭帧时不再回复
+	code := ce.Code
+	if code == CloseNoStatusReceived {
+		code = 0
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeClose(code, "")
+	return ce
+}
+
+// WriteMessage 发送一条数据消息，设置了SetFragmentSize时分片发送
+// 也可以发送控制消息，相当于WriteControl
+func (c *Conn) WriteMessage(mt MessageType, data []byte) error {
+	if mt != TextMessage && mt != BinaryMessage {
+		return c.WriteControl(mt, data)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	var rsv byte
+	if c.compress {
+		if c.compressor == nil {
+			c.compressor = newCompressor()
+		}
+		data = c.compressor.compress(data)
+		rsv = rsv1Bit
+	}
+	op := byte(mt)
+	for {
+		chunk := data
+		if c.fragmentSize > 0 && len(chunk) > c.fragmentSize {
+			chunk = chunk[:c.fragmentSize]
+		}
+		data = data[len(chunk):]
+		if err := c.writeFrame(op, rsv, len(data) == 0, chunk); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		op, rsv = opContinuation, 0
+	}
+}
+
+// WriteControl 发送ping、pong或者关闭帧，data最多125字节
+// 可以在其他goroutine写数据消息的时候调用，但是不会插在一条分片消息的中间
+func (c *Conn) WriteControl(mt MessageType, data []byte) error {
+	switch mt {
+	case PingMessage, PongMessage, CloseMessage:
+	default:
+		return errors.New("websocket: not a control message")
+	}
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: control frame too long")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if mt == CloseMessage {
+		c.closeSent = true
+	}
+	return c.writeFrame(byte(mt), 0, true, data)
+}
+
+// writeClose 发送关闭帧，code为0时不带状态码
+func (c *Conn) writeClose(code int, reason string) error {
+	var p []byte
+	if code != 0 {
+		p = binary.BigEndian.AppendUint16(nil, uint16(code))
+		p = append(p, reason...)
+	}
+	return c.WriteControl(CloseMessage, p)
+}
+
+// writeFrame 调用时持有writeMu，客户端发送的帧用随机掩码
+func (c *Conn) writeFrame(op, rsv byte, fin bool, payload []byte) error {
+	h := frameHeader{fin: fin, rsv: rsv, opcode: op, masked: !c.isServer, length: int64(len(payload))}
+	if h.masked {
+		rand.Read(h.mask[:])
+	}
+	b := appendFrameHeader(c.wbuf[:0], h)
+	n := len(b)
+	b = append(b, payload...)
+	if h.masked {
+		// 复制后再加掩码，不改调用方的数据
+		maskBytes(h.mask, 0, b[n:])
+	}
+	if cap(b) <= 64<<10 {
+		c.wbuf = b
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// Close 关闭握手：发送关闭帧，等待对端的关闭帧后关闭底层连接
+//
+// 没有goroutine在读时自己读完剩下的消息直到收到关闭帧或者超时；
+// 有goroutine在读时由它收到关闭帧后关闭连接，ReadMessage返回*CloseError
+func (c *Conn) Close(code int, reason string) error {
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+	err := c.writeClose(code, reason)
+	if errors.Is(err, ErrCloseSent) {
+		return c.conn.Close()
+	}
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	if !c.readMu.TryLock() {
+		return err
+	}
+	defer c.readMu.Unlock()
+	if c.readErr == nil {
+		for {
+			if _, _, c.readErr = c.readMessage(); c.readErr != nil {
+				break
+			}
+		}
+	}
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 帧格式 https://www.rfc-editor.org/rfc/rfc6455#section-5.2
+//
+//	 0                   1                   2                   3
+//	 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+//	+-+-+-+-+-------+-+-------------+-------------------------------+
+//	|F|R|R|R| opcode|M| Payload len |    Extended payload length    |
+//	|I|S|S|S|  (4)  |A|     (7)     |             (16/64)           |
+//	|N|V|V|V|       |S|             |   (if payload len==126/127)   |
+//	| |1|2|3|       |K|             |                               |
+//	+-+-+-+-+-------+-+-------------+ - - - - - - - - - - - - - - - +
+//	|     Extended payload length continued, if payload len == 127  |
+//	+ - - - - - - - - - - - - - - - +-------------------------------+
+//	|                               |Masking-key, if MASK set to 1  |
+//	+-------------------------------+-------------------------------+
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+
+	finBit  = 0x80
+	rsv1Bit = 0x40
+	rsvBits = 0x70
+	maskBit = 0x80
+
+	// maxControlPayload 控制帧的最大长度
+	maxControlPayload = 125
+	maxHeaderSize     = 14
+)
+
+func isControl(op byte) bool {
+	return op&0x8 != 0
+}
+
+type frameHeader struct {
+	fin    bool
+	rsv    byte
+	opcode byte
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+// protocolError 对端违反协议，用1002关闭连接
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "websocket: protocol error: " + string(e)
+}
+
+var errInvalidUTF8 = errors.New("websocket: invalid UTF-8 in text message")
+
+func readFrameHeader(br *bufio.Reader) (frameHeader, error) {
+	var h frameHeader
+	var b [8]byte
+	if _, err := io.ReadFull(br, b[:2]); err != nil {
+		return h, err
+	}
+	h.fin = b[0]&finBit != 0
+	h.rsv = b[0] & rsvBits
+	h.opcode = b[0] & 0x0f
+	h.masked = b[1]&maskBit != 0
+	switch n := b[1] & 0x7f; n {
+	case 126:
+		if _, err := io.ReadFull(br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(br, b[:8]); err != nil {
+			return h, err
+		}
+		v := binary.BigEndian.Uint64(b[:8])
+		if v>>63 != 0 {
+			return h, protocolError("payload length overflow")
+		}
+		h.length = int64(v)
+	default:
+		h.length = int64(n)
+	}
+	if h.masked {
+		if _, err := io.ReadFull(br, h.mask[:]); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// appendFrameHeader 长度用最短的编码
+func appendFrameHeader(b []byte, h frameHeader) []byte {
+	b0 := h.rsv | h.opcode
+	if h.fin {
+		b0 |= finBit
+	}
+	var b1 byte
+	if h.masked {
+		b1 = maskBit
+	}
+	switch {
+	case h.length <= 125:
+		b = append(b, b0, b1|byte(h.length))
+	case h.length <= 0xffff:
+		b = append(b, b0, b1|126)
+		b = binary.BigEndian.AppendUint16(b, uint16(h.length))
+	default:
+		b = append(b, b0, b1|127)
+		b = binary.BigEndian.AppendUint64(b, uint64(h.length))
+	}
+	if h.masked {
+		b = append(b, h.mask[:]...)
+	}
+	return b
+}
+
+// maskBytes 用key异或b，pos是b在整个payload里的偏移，返回下一个偏移
+func maskBytes(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[pos&3]
+		pos++
+	}
+	return pos & 3
+}
+
+// 关闭状态码 https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+)
+
+// validCloseCode 可以出现在关闭帧里的状态码
+// 1005、1006和1015只在本地表示状态，不能发送；3000-4999给应用和库使用
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003:
+		return true
+	case code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// CloseError 收到对端的关闭帧，Code为CloseNoStatusReceived表示关闭帧里没有状态码
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// IsCloseError err是CloseError并且状态码是codes之一
+func IsCloseError(err error, codes ...int) bool {
+	var ce *CloseError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	for _, c := range codes {
+		if ce.Code == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+// Package websocket RFC 6455 WebSocket的服务端和客户端
+//
+// 服务端通过net/http的Hijack接管连接，客户端直接拨号后发送升级请求
+// 支持分片、ping/pong、关闭握手和permessage-deflate压缩(RFC 7692)，
+// 读消息时检查协议错误，出错时按RFC用对应的状态码关闭连接
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// acceptGUID 计算Sec-WebSocket-Accept用的固定值
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// HandshakeError 握手失败
+type HandshakeError struct {
+	msg string
+}
+
+func (e HandshakeError) Error() string {
+	return "websocket: bad handshake: " + e.msg
+}
+
+func handshakeError(msg string) error {
+	return HandshakeError{msg}
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Upgrader 把HTTP请求升级成WebSocket连接
+type Upgrader struct {
+	// CheckOrigin 返回false时拒绝请求，为nil时Origin的host必须和请求的Host相同
+	CheckOrigin func(r *http.Request) bool
+	// Subprotocols 服务端支持的子协议，按优先级排列
+	Subprotocols []string
+	// EnableCompression 客户端请求时启用permessage-deflate
+	EnableCompression bool
+	// ReadLimit 一条消息的最大字节数，默认DefaultReadLimit
+	ReadLimit int64
+	// HandshakeTimeout 写握手响应的超时时间，0表示不限制
+	HandshakeTimeout time.Duration
+}
+
+// Upgrade 升级连接，header是附加到101响应里的头
+// 失败时已经给客户端返回了错误响应
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request, header http.Header) (*Conn, error) {
+	fail := func(status int, msg string) (*Conn, error) {
+		http.Error(w, http.StatusText(status), status)
+		return nil, handshakeError(msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "method is not GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") {
+		return fail(http.StatusBadRequest, "missing Connection: upgrade")
+	}
+	if !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "missing Upgrade: websocket")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	b.WriteString(acceptKey(key))
+	b.WriteString("\r\n")
+	protocol := u.selectSubprotocol(r)
+	if protocol != "" {
+		b.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+	ext, peerTakeover, compress := "", false, false
+	if u.EnableCompression {
+		ext, peerTakeover, compress = acceptDeflate(r.Header)
+		if compress {
+			b.WriteString("Sec-WebSocket-Extensions: " + ext + "\r\n")
+		}
+	}
+	for k, vs := range header {
+		if k == "Sec-Websocket-Protocol" || k == "Sec-Websocket-Extensions" {
+			continue
+		}
+		for _, v := range vs {
+			b.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+
+	// HTTP/2的请求不能Hijack
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "hijack: "+err.Error())
+	}
+	if brw.Reader.Buffered() > 0 {
+		// 握手完成前客户端不能发送帧
+		netConn.Close()
+		return nil, handshakeError("client sent data before handshake")
+	}
+	if u.HandshakeTimeout > 0 {
+		netConn.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+	if _, err := netConn.Write([]byte(b.String())); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+
+	c := newConn(netConn, brw.Reader, true)
+	c.subprotocol = protocol
+	c.compress = compress
+	c.peerTakeover = peerTakeover
+	if u.ReadLimit > 0 {
+		c.SetReadLimit(u.ReadLimit)
+	}
+	return c, nil
+}
+
+// selectSubprotocol 按服务端的优先级选客户端也支持的子协议
+func (u *Upgrader) selectSubprotocol(r *http.Request) string {
+	offered := tokenList(r.Header, "Sec-WebSocket-Protocol")
+	for _, p := range u.Subprotocols {
+		if slices.Contains(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// IsWebSocketUpgrade 请求是不是WebSocket升级请求
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// sameOrigin 没有Origin头时不是浏览器发出的请求，允许
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// tokenList 逗号分隔的头，可以有多行
+func tokenList(h http.Header, name string) []string {
+	var tokens []string
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, t := range tokenList(h, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,339 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFrameHeader(t *testing.T) {
+	for _, n := range []int64{0, 125, 126, 0xffff, 0x10000, 1 << 40} {
+		for _, masked := range []bool{false, true} {
+			h := frameHeader{fin: n%2 == 0, rsv: rsv1Bit, opcode: opBinary, masked: masked, length: n}
+			if masked {
+				h.mask = [4]byte{1, 2, 3, 4}
+			}
+			b := appendFrameHeader(nil, h)
+			got, err := readFrameHeader(bufio.NewReader(bytes.NewReader(b)))
+			if err != nil || got != h {
+				t.Errorf("长度%d 解析 %+v %v", n, got, err)
+			}
+		}
+	}
+	// 64位长度的最高位必须是0
+	_, err := readFrameHeader(bufio.NewReader(bytes.NewReader([]byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 0})))
+	var pe protocolError
+	if !errors.As(err, &pe) {
+		t.Errorf("长度溢出 %v", err)
+	}
+
+	// 分段加掩码和一次加掩码结果相同
+	p := []byte("hello websocket")
+	key := [4]byte{0xa, 0xb, 0xc, 0xd}
+	whole := bytes.Clone(p)
+	maskBytes(key, 0, whole)
+	pos := maskBytes(key, 0, p[:5])
+	maskBytes(key, pos, p[5:])
+	if !bytes.Equal(p, whole) {
+		t.Errorf("分段掩码 %q %q", p, whole)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// RFC 6455 1.3的例子
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("accept %s", got)
+	}
+}
+
+func wsURL(srv *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	srv := httptest.NewServer(echoHandler(&Upgrader{EnableCompression: true, Subprotocols: []string{"v2", "v1"}}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, compress := range []bool{false, true} {
+		d := Dialer{EnableCompression: compress, Subprotocols: []string{"v1", "v2"}}
+		c, resp, err := d.Dial(ctx, wsURL(srv), nil)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Errorf("状态码 %d", resp.StatusCode)
+		}
+		// 按服务端的优先级选择
+		if c.Subprotocol() != "v2" || c.Compressed() != compress {
+			t.Errorf("子协议 %q 压缩 %v", c.Subprotocol(), c.Compressed())
+		}
+
+		big := bytes.Repeat([]byte("0123456789"), 100000)
+		msgs := []struct {
+			mt MessageType
+			p  []byte
+		}{
+			{TextMessage, []byte("hello")},
+			{BinaryMessage, []byte{0, 1, 2, 0xff}},
+			{TextMessage, []byte{}},
+			{BinaryMessage, big},
+		}
+		for i, fragment := range []int{0, 7} {
+			c.SetFragmentSize(fragment)
+			for _, m := range msgs {
+				if err := c.WriteMessage(m.mt, m.p); err != nil {
+					t.Fatal(err)
+				}
+				mt, p, err := c.ReadMessage()
+				if err != nil || mt != m.mt || !bytes.Equal(p, m.p) {
+					t.Fatalf("第%d轮 压缩%v 回显 %d %.20q %v", i, compress, mt, p, err)
+				}
+			}
+		}
+		if err := c.Close(CloseNormalClosure, ""); err != nil {
+			t.Errorf("close: %v", err)
+		}
+	}
+}
+
+func TestPingPong(t *testing.T) {
+	srv := httptest.NewServer(echoHandler(&Upgrader{}))
+	defer srv.Close()
+	c, _, err := Dial(context.Background(), wsURL(srv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(CloseNormalClosure, "")
+
+	pong := make(chan string, 1)
+	c.SetPongHandler(func(p []byte) error {
+		pong <- string(p)
+		return nil
+	})
+	if err := c.WriteControl(PingMessage, []byte("hi")); err != nil {
+		t.Fatal(err)
+	}
+	c.WriteMessage(TextMessage, []byte("after"))
+	// pong在ReadMessage里处理
+	if _, p, err := c.ReadMessage(); err != nil || string(p) != "after" {
+		t.Fatalf("%q %v", p, err)
+	}
+	if got := <-pong; got != "hi" {
+		t.Errorf("pong %q", got)
+	}
+	if err := c.WriteControl(PingMessage, make([]byte, 126)); err == nil {
+		t.Error("控制帧超过125字节应该出错")
+	}
+}
+
+func TestCloseHandshake(t *testing.T) {
+	serverErr := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				serverErr <- err
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	// 客户端发起关闭，服务端在读的时候收到关闭帧
+	c, _, err := Dial(context.Background(), wsURL(srv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(CloseGoingAway, "bye"); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	err = <-serverErr
+	var ce *CloseError
+	if !errors.As(err, &ce) || ce.Code != CloseGoingAway || ce.Text != "bye" {
+		t.Errorf("服务端收到 %v", err)
+	}
+	if !IsCloseError(err, CloseNormalClosure, CloseGoingAway) {
+		t.Error("IsCloseError")
+	}
+	if err := c.WriteMessage(TextMessage, []byte("x")); !errors.Is(err, ErrCloseSent) {
+		t.Errorf("关闭后写 %v", err)
+	}
+}
+
+func TestCloseWhileReading(t *testing.T) {
+	conns := make(chan *Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	defer srv.Close()
+	c, _, err := Dial(context.Background(), wsURL(srv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-conns
+
+	// 服务端在另一个goroutine读的时候关闭，由读的goroutine收到客户端的关闭帧
+	serverRead := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage()
+		serverRead <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := server.Close(CloseNormalClosure, "shutdown"); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = c.ReadMessage()
+	if !IsCloseError(err, CloseNormalClosure) {
+		t.Errorf("客户端收到 %v", err)
+	}
+	select {
+	case err := <-serverRead:
+		if !IsCloseError(err, CloseNormalClosure) {
+			t.Errorf("服务端读到 %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("服务端没有收到客户端回复的关闭帧")
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	srv := httptest.NewServer(echoHandler(&Upgrader{}))
+	defer srv.Close()
+	c, _, err := Dial(context.Background(), wsURL(srv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(CloseNormalClosure, "")
+	c.SetFragmentSize(3)
+
+	const writers, n = 4, 50
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				if err := c.WriteMessage(TextMessage, []byte("message")); err != nil {
+					t.Error(err)
+					return
+				}
+				c.WriteControl(PingMessage, nil)
+			}
+		}()
+	}
+	// 分片消息不能和其他消息交错
+	for i := 0; i < writers*n; i++ {
+		if _, p, err := c.ReadMessage(); err != nil || string(p) != "message" {
+			t.Fatalf("第%d条 %q %v", i, p, err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestHandshakeErrors(t *testing.T) {
+	srv := httptest.NewServer(echoHandler(&Upgrader{}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// 跨域
+	_, resp, err := Dial(ctx, wsURL(srv), http.Header{"Origin": {"http://evil.example"}})
+	if !errors.Is(err, ErrBadHandshake) || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("跨域 %v %v", resp, err)
+	}
+	c, _, err := Dial(ctx, wsURL(srv), http.Header{"Origin": {srv.URL}})
+	if err != nil {
+		t.Fatalf("同源 %v", err)
+	}
+	c.Close(CloseNormalClosure, "")
+
+	// 普通HTTP请求
+	resp, err = http.Get(srv.URL)
+	if err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("不是升级请求 %v %v", resp, err)
+	}
+
+	// 版本不对时返回支持的版本
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("版本 %v %v", resp, err)
+	}
+
+	// 服务端不是WebSocket
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not websocket")
+	}))
+	defer plain.Close()
+	_, resp, err = Dial(ctx, wsURL(plain), nil)
+	if !errors.Is(err, ErrBadHandshake) {
+		t.Fatalf("普通服务端 %v", err)
+	}
+	if b, _ := io.ReadAll(resp.Body); string(b) != "not websocket" {
+		t.Errorf("响应体 %q", b)
+	}
+
+	if _, _, err := Dial(ctx, "http://"+srv.Listener.Addr().String(), nil); err == nil {
+		t.Error("http地址应该出错")
+	}
+}
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(echoHandler(&Upgrader{}))
+	defer srv.Close()
+	d := Dialer{TLSClientConfig: srv.Client().Transport.(*http.Transport).TLSClientConfig}
+	c, _, err := d.Dial(context.Background(), wsURL(srv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(CloseNormalClosure, "")
+	c.WriteMessage(TextMessage, []byte("secure"))
+	if _, p, err := c.ReadMessage(); err != nil || string(p) != "secure" {
+		t.Errorf("%q %v", p, err)
+	}
+}
+
+func TestClientReadLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(BinaryMessage, make([]byte, 100))
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+	d := Dialer{ReadLimit: 99}
+	c, _, err := d.Dial(context.Background(), wsURL(srv), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ReadMessage(); !errors.Is(err, ErrMessageTooBig) {
+		t.Errorf("超过限制 %v", err)
+	}
+	// 出错后不能再读
+	if _, _, err := c.ReadMessage(); !errors.Is(err, ErrMessageTooBig) {
+		t.Errorf("再次读 %v", err)
+	}
+}
@@ -1,59 +1,82 @@
-package main
+package lanscan
 
 import (
 	"context"
-	"log"
+	"encoding/binary"
+	"fmt"
 	"net"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 	manuf "github.com/timest/gomanuf"
 )
 
-// listenARP 监听arp响应
-// ifName 网络接口名称
-func listenARP(ctx context.Context, ifName string, macChan chan LanIpInfo) {
-	handle, err := pcap.OpenLive(ifName, 1024, false, 10*time.Second)
-	if err != nil {
-		log.Fatal("pcap打开失败:", err)
+// listenARP 从ps读取arp响应，直到ctx结束或者数据源读完
+// requests为true时arp请求的发送方也算发现的主机，被动模式用
+func listenARP(ctx context.Context, ps *gopacket.PacketSource, requests bool, macChan chan<- LanIpInfo) error {
+	parse := parseARPPacket
+	if requests {
+		parse = parseARPSenderPacket
 	}
-	defer handle.Close()
-	handle.SetBPFFilter("arp")
-	ps := gopacket.NewPacketSource(handle, handle.LinkType())
+	return listen(ctx, ps, parse, macChan)
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case p := <-ps.Packets():
-			arp := p.Layer(layers.LayerTypeARP).(*layers.ARP)
-			if arp.Operation == 2 {
-				mac := net.HardwareAddr(arp.SourceHwAddress)
-				m := manuf.Search(mac.String())
-				macChan <- LanIpInfo{
-					IP:           ParseIP(arp.SourceProtAddress).String(),
-					Mac:          mac,
-					Manufacturer: m,
-				}
-				// if strings.Contains(m, "Apple") {
-				// 	go sendMdns(ParseIP(arp.SourceProtAddress), mac)
-				// } else {
-				// 	go sendNbns(ParseIP(arp.SourceProtAddress), mac)
-				// }
-			}
-		}
+func parseARPPacket(p gopacket.Packet) []LanIpInfo {
+	if info, ok := parseARP(p, false); ok {
+		return []LanIpInfo{info}
 	}
+	return nil
+}
+
+func parseARPSenderPacket(p gopacket.Packet) []LanIpInfo {
+	if info, ok := parseARP(p, true); ok {
+		return []LanIpInfo{info}
+	}
+	return nil
+}
+
+// parseARP 从arp响应里解析出ip和mac，requests为true时也解析请求的发送方
+// 发送方ip是0.0.0.0的是地址冲突探测，忽略
+func parseARP(p gopacket.Packet, requests bool) (LanIpInfo, bool) {
+	arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok || len(arp.SourceProtAddress) != 4 {
+		return LanIpInfo{}, false
+	}
+	if arp.Operation != layers.ARPReply && (!requests || arp.Operation != layers.ARPRequest) {
+		return LanIpInfo{}, false
+	}
+	if net.IP(arp.SourceProtAddress).IsUnspecified() {
+		return LanIpInfo{}, false
+	}
+	mac := net.HardwareAddr(arp.SourceHwAddress)
+	return LanIpInfo{
+		IP:           ParseIP(arp.SourceProtAddress).String(),
+		Mac:          mac,
+		Manufacturer: manuf.Search(mac.String()),
+	}, true
+}
+
+// 以太网首部14字节，arp目标ip在arp报文的第24字节
+const arpDstIPOffset = 14 + 24
+
+// packetWriter 发送原始数据包，*pcap.Handle实现了这个接口
+type packetWriter interface {
+	WritePacketData(data []byte) error
 }
 
-// 往目标ip发送arp包
-// localIp: 本机ip，localIfName：本地网络接口名称，localMac：本地网络接口MAC地址, lanIp：内网目标ip
-func sendArpPackage(localIp net.IP, localIfName string, localMac net.HardwareAddr, lanIp IP) {
+// arpSender 用同一个句柄发送arp请求
+// 请求包只序列化一次，每次发送只替换目标ip
+type arpSender struct {
+	w    packetWriter
+	tmpl []byte
+}
+
+// newArpSender localIp: 本机ip，localMac：本地网络接口MAC地址
+func newArpSender(w packetWriter, localIp net.IP, localMac net.HardwareAddr) (*arpSender, error) {
 	srcIp := localIp.To4()
-	dstIp := net.ParseIP(lanIp.String()).To4()
-	if srcIp == nil || dstIp == nil {
-		log.Fatal("ip 解析出问题")
+	if srcIp == nil {
+		return nil, fmt.Errorf("ip 解析出问题: %s", localIp)
 	}
 	// 以太网首部
 	// EthernetType 0x0806  ARP
@@ -68,26 +91,63 @@ func sendArpPackage(localIp net.IP, localIfName string, localMac net.HardwareAdd
 		Protocol:          layers.EthernetTypeIPv4,
 		HwAddressSize:     uint8(6),
 		ProtAddressSize:   uint8(4),
-		Operation:         uint16(1), // 0x0001 arp request 0x0002 arp response
+		Operation:         layers.ARPRequest, // 0x0001 arp request 0x0002 arp response
 		SourceHwAddress:   localMac,
 		SourceProtAddress: srcIp,
 		DstHwAddress:      net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
-		DstProtAddress:    dstIp,
+		DstProtAddress:    net.IPv4zero.To4(),
 	}
 
 	buffer := gopacket.NewSerializeBuffer()
 	var opt gopacket.SerializeOptions
-	gopacket.SerializeLayers(buffer, opt, ether, a)
-	outgoingPacket := buffer.Bytes()
+	if err := gopacket.SerializeLayers(buffer, opt, ether, a); err != nil {
+		return nil, err
+	}
+	return &arpSender{w: w, tmpl: buffer.Bytes()}, nil
+}
 
-	handle, err := pcap.OpenLive(localIfName, 2048, false, 30*time.Second)
-	if err != nil {
-		log.Fatal("pcap打开失败:", err)
+// send 往目标ip发送arp请求，不能并发调用
+func (s *arpSender) send(lanIp IP) error {
+	binary.BigEndian.PutUint32(s.tmpl[arpDstIPOffset:], uint32(lanIp))
+	if err := s.w.WritePacketData(s.tmpl); err != nil {
+		return fmt.Errorf("发送arp数据包失败: %w", err)
 	}
-	defer handle.Close()
+	return nil
+}
+
+// rateLimiter 把发送速率限制在每秒rate个包以内
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
 
-	err = handle.WritePacketData(outgoingPacket)
-	if err != nil {
-		log.Fatal("发送arp数据包失败..")
+func newRateLimiter(rate int) *rateLimiter {
+	l := &rateLimiter{next: time.Now()}
+	if rate > 0 {
+		l.interval = time.Second / time.Duration(rate)
+	}
+	return l
+}
+
+// wait 等到可以发送下一个包，ctx结束返回false
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	if l.interval == 0 {
+		return ctx.Err() == nil
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		// 落后太多时不补发，避免突发
+		l.next = now
+	}
+	if d := l.next.Sub(now); d > 0 {
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return false
+		}
 	}
+	l.next = l.next.Add(l.interval)
+	return ctx.Err() == nil
 }
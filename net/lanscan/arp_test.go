@@ -0,0 +1,117 @@
+package lanscan
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// recordWriter 记录发送的arp请求目标ip
+type recordWriter struct {
+	dst []string
+}
+
+func (w *recordWriter) WritePacketData(data []byte) error {
+	p := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok {
+		return nil
+	}
+	w.dst = append(w.dst, net.IP(arp.DstProtAddress).String())
+	return nil
+}
+
+func TestArpSender(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	w := &recordWriter{}
+	s, err := newArpSender(w, net.ParseIP("192.168.1.2"), mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"192.168.1.1", "192.168.1.254"} {
+		if err := s.send(ParseIPString(ip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(w.dst) != 2 || w.dst[0] != "192.168.1.1" || w.dst[1] != "192.168.1.254" {
+		t.Errorf("arp请求目标ip错误: %v", w.dst)
+	}
+	if _, err := newArpSender(w, net.ParseIP("fd00::1"), mac); err == nil {
+		t.Errorf("IPv6地址应该返回错误")
+	}
+}
+
+func TestSendARPRetry(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	_, ipNet, _ := net.ParseCIDR("192.168.1.0/29")
+	it := NetInterface{
+		Name:     "eth0",
+		HardAddr: mac,
+		LocalIP:  net.ParseIP("192.168.1.1"),
+		LanIPs:   listLanIps(ipNet),
+	}
+	opts := Options{Retries: 2, RetryWait: time.Millisecond, Rate: -1}
+	seen := func(ip string) bool { return ip == "192.168.1.2" || ip == "192.168.1.3" }
+	w := &recordWriter{}
+	if err := NewScanner().sendARP(context.Background(), w, []NetInterface{it}, opts, seen); err != nil {
+		t.Fatal(err)
+	}
+	// .2-.6 第一轮5个，后两轮各3个，本机.1不发
+	if len(w.dst) != 5+3+3 {
+		t.Errorf("发送数量错误: %d %v", len(w.dst), w.dst)
+	}
+	for _, ip := range w.dst {
+		if ip == "192.168.1.1" {
+			t.Errorf("不应该给本机发arp请求")
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1000)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		l.wait(context.Background())
+	}
+	if d := time.Since(start); d < 90*time.Millisecond {
+		t.Errorf("限速无效，100个包用了%s", d)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if newRateLimiter(1).wait(ctx) {
+		t.Errorf("ctx取消后应该返回false")
+	}
+}
+
+func TestParseARPRequests(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3b:bf:11:22:33")
+	packet := func(op uint16, src string) gopacket.Packet {
+		buf := gopacket.NewSerializeBuffer()
+		err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{},
+			&layers.Ethernet{SrcMAC: mac, DstMAC: net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, EthernetType: layers.EthernetTypeARP},
+			&layers.ARP{AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4, HwAddressSize: 6, ProtAddressSize: 4,
+				Operation: op, SourceHwAddress: mac, SourceProtAddress: net.ParseIP(src).To4(),
+				DstHwAddress: make(net.HardwareAddr, 6), DstProtAddress: net.ParseIP("192.168.1.1").To4()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	}
+	req := packet(layers.ARPRequest, "192.168.1.60")
+	if _, ok := parseARP(req, false); ok {
+		t.Errorf("主动扫描不应该解析arp请求")
+	}
+	if info, ok := parseARP(req, true); !ok || info.IP != "192.168.1.60" || info.Mac.String() != mac.String() {
+		t.Errorf("被动模式arp请求 %+v %v", info, ok)
+	}
+	if _, ok := parseARP(packet(layers.ARPRequest, "0.0.0.0"), true); ok {
+		t.Errorf("地址冲突探测不应该解析出结果")
+	}
+	if info, ok := parseARP(packet(layers.ARPReply, "192.168.1.61"), false); !ok || info.IP != "192.168.1.61" {
+		t.Errorf("arp响应 %+v %v", info, ok)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/lanscan"
+	"github.com/ilaziness/gopkg/net/lanscan/inventory"
+)
+
+// 局域网扫描
+// sudo go run ./net/lanscan/cmd/lanscan -i eth0
+// 录制：sudo go run ./net/lanscan/cmd/lanscan -i eth0 -w scan.pcap
+// 回放：go run ./net/lanscan/cmd/lanscan -r scan.pcap
+// 被动监听5分钟：sudo go run ./net/lanscan/cmd/lanscan -i eth0 -passive -timeout 5m
+// 保存设备清单并报告变化：sudo go run ./net/lanscan/cmd/lanscan -i eth0 -db lan.db -export lan.csv
+
+func main() {
+	var (
+		ifName    string
+		timeout   time.Duration
+		idle      time.Duration
+		rate      int
+		retries   int
+		ipv6      bool
+		mdns      bool
+		nbns      bool
+		llmnr     bool
+		ssdp      bool
+		passive   bool
+		ports     string
+		readFile  string
+		writeFile string
+		dbFile    string
+		export    string
+		verbose   bool
+	)
+	flag.StringVar(&ifName, "i", "", "Network interface name")
+	flag.DurationVar(&timeout, "timeout", 0, "max scan duration, 0 for no limit; listen window in passive mode, 0 for 1m")
+	flag.DurationVar(&idle, "idle", lanscan.DefaultIdle, "finish after no new response for this long")
+	flag.IntVar(&rate, "rate", lanscan.DefaultRate, "arp packets per second per interface, -1 for no limit")
+	flag.IntVar(&retries, "retries", lanscan.DefaultRetries, "resend rounds for hosts without reply, -1 for none")
+	flag.BoolVar(&ipv6, "ipv6", true, "discover ipv6 neighbors with multicast ping and ndp")
+	flag.BoolVar(&mdns, "mdns", true, "query and parse mdns for hostnames and services")
+	flag.BoolVar(&nbns, "nbns", true, "query netbios node status for windows and samba hosts")
+	flag.BoolVar(&llmnr, "llmnr", true, "query llmnr reverse names")
+	flag.BoolVar(&ssdp, "ssdp", true, "discover upnp devices with ssdp and fetch their descriptions")
+	flag.BoolVar(&passive, "passive", false, "only sniff arp, dhcp, mdns, ssdp and nbns traffic, send nothing")
+	flag.StringVar(&ports, "p", "", `tcp ports to scan on found hosts, e.g. "22,80,8000-8100", "default" for common ports`)
+	flag.StringVar(&readFile, "r", "", "replay packets from pcap file instead of scanning")
+	flag.StringVar(&writeFile, "w", "", "record captured packets to pcap file")
+	flag.StringVar(&dbFile, "db", "", "sqlite inventory file, report new, disappeared and conflicting devices")
+	flag.StringVar(&export, "export", "", "export inventory to .json or .csv file, requires -db")
+	flag.BoolVar(&verbose, "v", false, "verbose log")
+	flag.Parse()
+	if verbose {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	results := make(chan lanscan.LanIpInfo)
+	printed := make(chan struct{})
+	go func() {
+		defer close(printed)
+		for ip := range results {
+			services := make([]string, 0, len(ip.Services))
+			for _, svc := range ip.Services {
+				services = append(services, fmt.Sprintf("%s %s:%d", svc.Type, svc.Instance, svc.Port))
+			}
+			open := make([]string, 0, len(ip.Ports))
+			for _, p := range ip.Ports {
+				open = append(open, fmt.Sprintf("%d/%s %s", p.Port, p.Service, p.Banner))
+			}
+			upnp := make([]string, 0, len(ip.UPnP))
+			for _, dev := range ip.UPnP {
+				upnp = append(upnp, fmt.Sprintf("%s (%s %s) %s", dev.FriendlyName, dev.Manufacturer, dev.ModelName, dev.Location))
+			}
+			slog.Info("ip info", "IP", ip.IP, "IPv6", ip.IPv6, "MAC", ip.Mac.String(), "Manuf", ip.Manufacturer, "Hostname", ip.Hostname, "NetBIOS", ip.NetBIOSName, "Workgroup", ip.Workgroup, "Services", services, "UPnP", upnp, "Ports", open)
+		}
+	}()
+
+	opts := lanscan.Options{
+		Interface:    ifName,
+		Timeout:      timeout,
+		Idle:         idle,
+		Rate:         rate,
+		Retries:      retries,
+		DisableIPv6:  !ipv6,
+		DisableMDNS:  !mdns,
+		DisableNBNS:  !nbns,
+		DisableLLMNR: !llmnr,
+		DisableSSDP:  !ssdp,
+		Passive:      passive,
+		Results:      results,
+	}
+	if passive && ports != "" {
+		slog.Error("-p sends packets and can not be used with -passive")
+		os.Exit(1)
+	}
+	if ports != "" {
+		opts.Ports = &lanscan.PortOptions{}
+		if ports != "default" {
+			list, err := lanscan.ParsePorts(ports)
+			if err != nil {
+				slog.Error(err.Error())
+				os.Exit(1)
+			}
+			opts.Ports.Ports = list
+		}
+	}
+	if writeFile != "" {
+		f, err := os.Create(writeFile)
+		if err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+		defer f.Close()
+		opts.Record = f
+	}
+
+	var infos []lanscan.LanIpInfo
+	var err error
+	scanner := lanscan.NewScanner()
+	if readFile != "" {
+		infos, err = scanner.ReplayFile(ctx, readFile, opts)
+	} else {
+		infos, err = scanner.Scan(ctx, opts)
+	}
+	close(results)
+	<-printed
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	slog.Info("lan scan completed", "hosts", len(infos))
+
+	if dbFile != "" {
+		if err := saveInventory(dbFile, export, infos); err != nil {
+			slog.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+}
+
+// saveInventory 保存扫描结果，输出和上一次扫描相比的变化
+func saveInventory(dbFile, export string, infos []lanscan.LanIpInfo) error {
+	inv, err := inventory.Open(dbFile)
+	if err != nil {
+		return err
+	}
+	defer inv.Close()
+
+	report, err := inv.Record(time.Now(), infos)
+	if err != nil {
+		return err
+	}
+	for _, d := range report.New {
+		slog.Info("new device", "MAC", d.MAC, "IP", d.IP, "Manuf", d.Manufacturer, "Hostname", d.Hostname)
+	}
+	for _, d := range report.Disappeared {
+		slog.Info("device disappeared", "MAC", d.MAC, "IP", d.IP, "Hostname", d.Hostname, "LastSeen", d.LastSeen.Local())
+	}
+	for _, c := range report.Conflicts {
+		slog.Warn("ip conflict", "IP", c.IP, "Kind", c.Kind, "MACs", c.MACs)
+	}
+
+	if export == "" {
+		return nil
+	}
+	f, err := os.Create(export)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	switch filepath.Ext(export) {
+	case ".csv":
+		err = inv.ExportCSV(f)
+	case ".json":
+		err = inv.ExportJSON(f)
+	default:
+		err = fmt.Errorf("unsupported export format: %s", export)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
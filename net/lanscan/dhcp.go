@@ -0,0 +1,82 @@
+package lanscan
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	manuf "github.com/timest/gomanuf"
+)
+
+// DHCP报文只在被动模式下抓取
+// 客户端请求里有mac和主机名，服务器的ACK里有分配给这个mac的ip
+
+const dhcpFilter = "udp and (port 67 or port 68)"
+
+// listenDHCP 从ps读取dhcp报文，直到ctx结束或者数据源读完
+func listenDHCP(ctx context.Context, ps *gopacket.PacketSource, info chan<- LanIpInfo) error {
+	return listen(ctx, ps, parseDHCPPacket, info)
+}
+
+func parseDHCPPacket(p gopacket.Packet) []LanIpInfo {
+	dhcp, ok := p.Layer(layers.LayerTypeDHCPv4).(*layers.DHCPv4)
+	if !ok {
+		return nil
+	}
+	if info, ok := parseDHCP(dhcp); ok {
+		return []LanIpInfo{info}
+	}
+	return nil
+}
+
+// parseDHCP 从客户端请求取mac、主机名和已经在用的ip，从ACK取分配的ip
+// 请求里的Requested IP还没有确认，不使用
+func parseDHCP(dhcp *layers.DHCPv4) (LanIpInfo, bool) {
+	if dhcp.HardwareType != layers.LinkTypeEthernet || len(dhcp.ClientHWAddr) != 6 {
+		return LanIpInfo{}, false
+	}
+	var msgType layers.DHCPMsgType
+	var hostname string
+	for _, opt := range dhcp.Options {
+		switch opt.Type {
+		case layers.DHCPOptMessageType:
+			if len(opt.Data) == 1 {
+				msgType = layers.DHCPMsgType(opt.Data[0])
+			}
+		case layers.DHCPOptHostname:
+			hostname = strings.TrimRight(string(opt.Data), "\x00")
+		}
+	}
+	mac := dhcp.ClientHWAddr
+	info := LanIpInfo{
+		Mac:          mac,
+		Hostname:     hostname,
+		Manufacturer: manuf.Search(mac.String()),
+	}
+	switch dhcp.Operation {
+	case layers.DHCPOpRequest:
+		if ip := dhcp.ClientIP.To4(); ip != nil && !ip.Equal(net.IPv4zero) {
+			info.IP = ip.String()
+		}
+	case layers.DHCPOpReply:
+		if msgType != layers.DHCPMsgTypeAck {
+			return LanIpInfo{}, false
+		}
+		// ACK里的主机名是服务器给的，不一定是客户端自己的
+		info.Hostname = ""
+		ip := dhcp.YourClientIP.To4()
+		if ip == nil || ip.Equal(net.IPv4zero) {
+			// 回复INFORM的ACK没有分配地址
+			ip = dhcp.ClientIP.To4()
+		}
+		if ip == nil || ip.Equal(net.IPv4zero) {
+			return LanIpInfo{}, false
+		}
+		info.IP = ip.String()
+	default:
+		return LanIpInfo{}, false
+	}
+	return info, true
+}
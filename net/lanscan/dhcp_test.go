@@ -0,0 +1,53 @@
+package lanscan
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestParseDHCP(t *testing.T) {
+	mac, _ := net.ParseMAC("d8:3b:bf:11:22:33")
+	msg := func(op layers.DHCPOp, typ layers.DHCPMsgType, ciaddr, yiaddr string) *layers.DHCPv4 {
+		return &layers.DHCPv4{
+			Operation:    op,
+			HardwareType: layers.LinkTypeEthernet,
+			HardwareLen:  6,
+			ClientIP:     net.ParseIP(ciaddr),
+			YourClientIP: net.ParseIP(yiaddr),
+			ClientHWAddr: mac,
+			Options: layers.DHCPOptions{
+				layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(typ)}),
+				layers.NewDHCPOption(layers.DHCPOptHostname, []byte("Pixel-7\x00")),
+			},
+		}
+	}
+	tests := []struct {
+		name     string
+		msg      *layers.DHCPv4
+		ok       bool
+		ip       string
+		hostname string
+	}{
+		{"DISCOVER只有mac和主机名", msg(layers.DHCPOpRequest, layers.DHCPMsgTypeDiscover, "0.0.0.0", "0.0.0.0"), true, "", "Pixel-7"},
+		{"续租请求带在用的ip", msg(layers.DHCPOpRequest, layers.DHCPMsgTypeRequest, "192.168.1.60", "0.0.0.0"), true, "192.168.1.60", "Pixel-7"},
+		{"OFFER还没有确认", msg(layers.DHCPOpReply, layers.DHCPMsgTypeOffer, "0.0.0.0", "192.168.1.60"), false, "", ""},
+		{"ACK分配的ip，不用服务器给的主机名", msg(layers.DHCPOpReply, layers.DHCPMsgTypeAck, "0.0.0.0", "192.168.1.60"), true, "192.168.1.60", ""},
+		{"INFORM的ACK", msg(layers.DHCPOpReply, layers.DHCPMsgTypeAck, "192.168.1.61", "0.0.0.0"), true, "192.168.1.61", ""},
+		{"没有地址的ACK", msg(layers.DHCPOpReply, layers.DHCPMsgTypeAck, "0.0.0.0", "0.0.0.0"), false, "", ""},
+	}
+	for _, tt := range tests {
+		info, ok := parseDHCP(tt.msg)
+		if ok != tt.ok {
+			t.Errorf("%s: ok=%v, 期望 %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if info.IP != tt.ip || info.Hostname != tt.hostname || info.Mac.String() != mac.String() {
+			t.Errorf("%s: 解析结果 %+v", tt.name, info)
+		}
+	}
+}
@@ -1,42 +1,104 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net"
 	"os"
-	"strconv"
+	"os/signal"
 	"strings"
 	"time"
-
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
 )
 
+// 并发ping扫描局域网，统计每个主机多轮的RTT和丢包率
+// go run ./net/lanscan/icmp -i eth0 -n 3
+// 默认用非特权的udp4 ICMP socket，需要 sysctl -w net.ipv4.ping_group_range="0 2147483647"
+// 或者加 -privileged 用raw socket，需要root
+
 func main() {
+	var (
+		ifName     string
+		privileged bool
+		opts       SweepOptions
+	)
+	flag.StringVar(&ifName, "i", "", "Network interface name, empty for the first suitable one")
+	flag.BoolVar(&privileged, "privileged", false, "use raw ip4:icmp socket instead of unprivileged udp4")
+	flag.IntVar(&opts.Rounds, "n", 3, "ping rounds")
+	flag.DurationVar(&opts.Interval, "interval", time.Second, "interval between rounds")
+	flag.DurationVar(&opts.Timeout, "timeout", time.Second, "wait for replies after the last round")
+	flag.IntVar(&opts.Rate, "rate", 1000, "max packets per second, 0 for no limit")
+	flag.Parse()
+
 	// 获取局域网的子网掩码和网关
-	gateway, subnet, err := getLocalNetworkInfo()
+	local, ipnet, err := getLocalNetworkInfo(ifName)
 	if err != nil {
 		fmt.Println("Error getting local network info:", err)
-		return
+		os.Exit(1)
+	}
+	size, _ := ipnet.Mask.Size()
+	fmt.Printf("Local network: %s/%d\n", local, size)
+
+	network := "udp4"
+	if privileged {
+		network = "ip4:icmp"
+	}
+	s, err := newSweeper(network)
+	if err != nil {
+		fmt.Println("Error creating ICMP socket:", err)
+		os.Exit(1)
 	}
-	fmt.Printf("Local network: %s/%s\n", gateway, subnet)
+	defer s.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	// 扫描局域网中的主机
-	scanNetwork(gateway, subnet)
+	stats, err := s.sweep(ctx, hosts(local, ipnet), opts)
+	if err != nil {
+		fmt.Println("Error scanning:", err)
+	}
+	up := 0
+	for _, st := range stats {
+		if st.Recv == 0 {
+			continue
+		}
+		up++
+		fmt.Printf("Host %-15s up  min/avg/max = %s/%s/%s  loss %.0f%% (%d/%d)\n",
+			st.IP, st.Min, st.Avg, st.Max, st.Loss()*100, st.Sent-st.Recv, st.Sent)
+	}
+	fmt.Printf("%d hosts up, %d scanned\n", up, len(stats))
+}
+
+// hosts 网段内除了网络地址、广播地址和本机的所有地址
+func hosts(local net.IP, ipnet *net.IPNet) []net.IP {
+	var list []net.IP
+	network := ipnet.IP.Mask(ipnet.Mask)
+	broadcast := make(net.IP, len(network))
+	for i := range network {
+		broadcast[i] = network[i] | ^ipnet.Mask[i]
+	}
+	for ip := incrementIP(network); ipnet.Contains(ip) && !ip.Equal(broadcast); ip = incrementIP(ip) {
+		if ip.Equal(local) {
+			continue // 跳过本机
+		}
+		list = append(list, ip)
+	}
+	return list
 }
 
-// getLocalNetworkInfo 获取局域网的网关和子网掩码
-func getLocalNetworkInfo() (string, string, error) {
+// getLocalNetworkInfo 获取网卡的IPv4地址和网段，ifName为空时用第一个合适的网卡
+func getLocalNetworkInfo(ifName string) (net.IP, *net.IPNet, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return "", "", err
+		return nil, nil, err
 	}
 	for _, iface := range ifaces {
-		if iface.Name != "以太网" {
+		if ifName != "" && iface.Name != ifName {
 			continue
 		}
-		// 排除虚拟网卡
-		if isVirtualInterface(iface) {
+		// 没指定网卡时排除虚拟网卡
+		if ifName == "" && isVirtualInterface(iface) {
 			continue
 		}
 		if iface.Flags&net.FlagUp == 0 {
@@ -47,20 +109,19 @@ func getLocalNetworkInfo() (string, string, error) {
 		}
 		addrs, err := iface.Addrs()
 		if err != nil {
-			return "", "", err
+			return nil, nil, err
 		}
 
 		for _, addr := range addrs {
 			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-				if ipnet.IP.To4() != nil {
-					size, _ := ipnet.Mask.Size()
-					return ipnet.IP.String(), strconv.Itoa(size), nil
+				if ip4 := ipnet.IP.To4(); ip4 != nil {
+					return ip4, &net.IPNet{IP: ip4.Mask(ipnet.Mask), Mask: ipnet.Mask}, nil
 				}
 			}
 		}
 	}
 
-	return "", "", fmt.Errorf("no suitable network interface found")
+	return nil, nil, fmt.Errorf("no suitable network interface found")
 }
 
 // isVirtualInterface 判断是否为虚拟网卡
@@ -79,77 +140,15 @@ func isVirtualInterface(iface net.Interface) bool {
 	return false
 }
 
-// scanNetwork 扫描局域网中的主机
-func scanNetwork(gateway string, subnet string) {
-	// 创建ICMP套接字
-	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
-	if err != nil {
-		fmt.Println("Error creating ICMP socket:", err)
-		return
-	}
-	defer conn.Close()
-
-	// 构造ICMP回显请求消息
-	message := icmp.Message{
-		Type: ipv4.ICMPTypeEcho, Code: 0,
-		Body: &icmp.Echo{
-			ID: os.Getpid() & 0xffff, Seq: 1,
-			Data: []byte("Hello, World!"),
-		},
-	}
-	wm, _ := message.Marshal(nil)
-
-	// 解析子网
-	_, ipnet, err := net.ParseCIDR(fmt.Sprintf("%s/%s", gateway, subnet))
-	if err != nil {
-		fmt.Println("Error parsing subnet:", err)
-		return
-	}
-
-	// 遍历子网中的IP地址并发送ICMP请求
-	for ip := ipnet.IP.Mask(ipnet.Mask); ipnet.Contains(ip); incrementIP(ip) {
-		if ip.String() == gateway {
-			continue // 跳过网关
-		}
-
-		fmt.Printf("Scanning %s...\n", ip)
-		_, err := conn.WriteTo(wm, &net.IPAddr{IP: ip})
-		if err != nil {
-			fmt.Printf("Error sending ICMP request to %s: %v\n", ip, err)
-			continue
-		}
-
-		// 设置超时时间
-		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		buffer := make([]byte, 1024)
-		n, addr, err := conn.ReadFrom(buffer)
-		if err != nil {
-			if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
-				continue // 超时，未收到响应
-			}
-			fmt.Printf("Error receiving ICMP response from %s: %v\n", ip, err)
-			continue
-		}
-
-		// 解析ICMP响应
-		rm, err := icmp.ParseMessage(1, buffer[:n])
-		if err != nil {
-			fmt.Printf("Error parsing ICMP response from %s: %v\n", ip, err)
-			continue
-		}
-
-		if rm.Type == ipv4.ICMPTypeEchoReply {
-			fmt.Printf("Host %s is up\n", addr.String())
-		}
-	}
-}
-
-// incrementIP 增加IP地址
-func incrementIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
+// incrementIP 返回下一个IP地址
+func incrementIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for j := len(next) - 1; j >= 0; j-- {
+		next[j]++
+		if next[j] > 0 {
 			break
 		}
 	}
+	return next
 }
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// ICMP协议号，解析响应用
+const protocolICMP = 1
+
+// HostStats 一个主机多轮ping的统计
+type HostStats struct {
+	IP   net.IP
+	Sent int
+	Recv int
+	Min  time.Duration
+	Avg  time.Duration
+	Max  time.Duration
+	// 所有响应的RTT之和，用来算平均值
+	total time.Duration
+}
+
+// Loss 丢包率，0-1
+func (s *HostStats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Sent-s.Recv) / float64(s.Sent)
+}
+
+func (s *HostStats) add(rtt time.Duration) {
+	if s.Recv == 0 || rtt < s.Min {
+		s.Min = rtt
+	}
+	if rtt > s.Max {
+		s.Max = rtt
+	}
+	s.Recv++
+	s.total += rtt
+	s.Avg = s.total / time.Duration(s.Recv)
+}
+
+// SweepOptions 扫描参数
+type SweepOptions struct {
+	// Rounds ping的轮数
+	Rounds int
+	// Interval 每轮之间的间隔
+	Interval time.Duration
+	// Timeout 最后一轮发完后等待响应的时间，超过时间没有响应算丢包
+	Timeout time.Duration
+	// Rate 每秒最多发送的包数，0不限速
+	Rate int
+}
+
+// pending 已经发出还没收到响应的请求
+type pending struct {
+	host   int
+	sentAt time.Time
+}
+
+// sweeper 并发ping，所有请求用同一个socket发送，一个goroutine读取响应，按ID和序号匹配
+type sweeper struct {
+	conn *icmp.PacketConn
+	// udp4是非特权的ICMP socket，内核会把ID改成本地端口
+	udp bool
+	id  int
+
+	mu      sync.Mutex
+	seq     uint16
+	pending map[uint16]pending
+	stats   []*HostStats
+}
+
+// newSweeper network是udp4(非特权，需要net.ipv4.ping_group_range包含当前用户组)或ip4:icmp(需要root)
+func newSweeper(network string) (*sweeper, error) {
+	conn, err := icmp.ListenPacket(network, "0.0.0.0")
+	if err != nil {
+		return nil, err
+	}
+	s := &sweeper{
+		conn:    conn,
+		udp:     network == "udp4",
+		id:      rand.IntN(0xffff),
+		pending: make(map[uint16]pending),
+	}
+	if s.udp {
+		s.id = conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	return s, nil
+}
+
+func (s *sweeper) Close() error {
+	return s.conn.Close()
+}
+
+// sweep ping所有targets，返回每个主机的统计，顺序和targets一样
+func (s *sweeper) sweep(ctx context.Context, targets []net.IP, opts SweepOptions) ([]*HostStats, error) {
+	if opts.Rounds <= 0 {
+		opts.Rounds = 1
+	}
+	s.mu.Lock()
+	s.stats = make([]*HostStats, len(targets))
+	for i, ip := range targets {
+		s.stats[i] = &HostStats{IP: ip}
+	}
+	s.mu.Unlock()
+
+	s.conn.SetReadDeadline(time.Time{})
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- s.read()
+	}()
+
+	var interval time.Duration
+	if opts.Rate > 0 {
+		interval = time.Second / time.Duration(opts.Rate)
+	}
+	err := s.send(ctx, targets, opts, interval)
+	if err == nil {
+		// 等最后一轮的响应
+		err = sleep(ctx, opts.Timeout)
+	}
+	// 读goroutine在socket超时后退出
+	s.conn.SetReadDeadline(time.Now())
+	if rerr := <-readErr; err == nil && rerr != nil {
+		err = rerr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats, err
+}
+
+func (s *sweeper) send(ctx context.Context, targets []net.IP, opts SweepOptions, interval time.Duration) error {
+	for round := 0; round < opts.Rounds; round++ {
+		if round > 0 {
+			if err := sleep(ctx, opts.Interval); err != nil {
+				return err
+			}
+		}
+		for i, ip := range targets {
+			if err := s.echo(i, ip); err != nil {
+				return err
+			}
+			if interval > 0 {
+				if err := sleep(ctx, interval); err != nil {
+					return err
+				}
+			} else if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+// echo 给第host个目标发送一个请求
+func (s *sweeper) echo(host int, ip net.IP) error {
+	s.mu.Lock()
+	// 序号回绕后覆盖的旧请求早已超时，算丢包
+	s.seq++
+	seq := s.seq
+	now := time.Now()
+	s.pending[seq] = pending{host: host, sentAt: now}
+	s.stats[host].Sent++
+	s.mu.Unlock()
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: s.id, Seq: int(seq), Data: []byte("lanscan")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+	var dst net.Addr = &net.IPAddr{IP: ip}
+	if s.udp {
+		dst = &net.UDPAddr{IP: ip}
+	}
+	if _, err := s.conn.WriteTo(b, dst); err != nil && errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	// 单个地址发送失败(比如没有路由)算丢包，不中断扫描
+	return nil
+}
+
+// read 读取响应直到socket关闭或超时
+func (s *sweeper) read() error {
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		now := time.Now()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		msg, err := icmp.ParseMessage(protocolICMP, buf[:n])
+		if err != nil || msg.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || (!s.udp && echo.ID != s.id) {
+			// raw socket会收到所有的ICMP包，只要自己发的
+			continue
+		}
+		s.reply(uint16(echo.Seq), peerIP(peer), now)
+	}
+}
+
+// reply 按序号找到请求，来源地址也要对得上
+func (s *sweeper) reply(seq uint16, from net.IP, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.pending[seq]
+	if !ok {
+		// 重复的响应
+		return
+	}
+	st := s.stats[p.host]
+	if !st.IP.Equal(from) {
+		return
+	}
+	delete(s.pending, seq)
+	st.add(now.Sub(p.sentAt))
+}
+
+func peerIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// openSweeper 优先用非特权socket，都不能用时跳过
+func openSweeper(t *testing.T) *sweeper {
+	for _, network := range []string{"udp4", "ip4:icmp"} {
+		if s, err := newSweeper(network); err == nil {
+			return s
+		}
+	}
+	t.Skip("没有权限创建ICMP socket")
+	return nil
+}
+
+func TestSweep(t *testing.T) {
+	s := openSweeper(t)
+	defer s.Close()
+	targets := []net.IP{net.ParseIP("127.0.0.1").To4(), net.ParseIP("127.0.0.2").To4()}
+	opts := SweepOptions{Rounds: 3, Interval: 10 * time.Millisecond, Timeout: 200 * time.Millisecond}
+	stats, err := s.sweep(context.Background(), targets, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range stats {
+		if st.Sent != 3 || st.Recv != 3 || st.Loss() != 0 {
+			t.Errorf("%s 发送%d 收到%d", st.IP, st.Sent, st.Recv)
+		}
+		if st.Min <= 0 || st.Min > st.Avg || st.Avg > st.Max {
+			t.Errorf("%s RTT统计错误: %s/%s/%s", st.IP, st.Min, st.Avg, st.Max)
+		}
+	}
+
+	// 同一个sweeper可以再次扫描，ctx取消时返回错误
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.sweep(ctx, targets, SweepOptions{Rounds: 2, Interval: time.Second}); err == nil {
+		t.Errorf("ctx取消后应该返回错误")
+	}
+}
+
+func TestHosts(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("192.168.1.0/29")
+	list := hosts(net.ParseIP("192.168.1.3").To4(), ipnet)
+	want := []string{"192.168.1.1", "192.168.1.2", "192.168.1.4", "192.168.1.5", "192.168.1.6"}
+	if len(list) != len(want) {
+		t.Fatalf("地址数量错误: %v", list)
+	}
+	for i, ip := range list {
+		if ip.String() != want[i] {
+			t.Errorf("第%d个地址应该是%s，实际%s", i, want[i], ip)
+		}
+	}
+}
+
+func TestHostStats(t *testing.T) {
+	st := &HostStats{Sent: 4}
+	for _, d := range []time.Duration{3, 1, 2} {
+		st.add(d * time.Millisecond)
+	}
+	if st.Min != time.Millisecond || st.Max != 3*time.Millisecond || st.Avg != 2*time.Millisecond {
+		t.Errorf("RTT统计错误: %s/%s/%s", st.Min, st.Avg, st.Max)
+	}
+	if st.Loss() != 0.25 {
+		t.Errorf("丢包率应该是0.25，实际%v", st.Loss())
+	}
+}
@@ -0,0 +1,46 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// ExportJSON 把所有设备导出成JSON数组
+func (inv *Inventory) ExportJSON(w io.Writer) error {
+	list, err := inv.Devices()
+	if err != nil {
+		return err
+	}
+	if list == nil {
+		list = []Device{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
+
+// ExportCSV 把所有设备导出成CSV，第一行是表头
+func (inv *Inventory) ExportCSV(w io.Writer) error {
+	list, err := inv.Devices()
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	header := []string{"mac", "ip", "ipv6", "hostname", "manufacturer", "netbios", "workgroup", "services", "ports", "first_seen", "last_seen"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, d := range list {
+		record := []string{
+			d.MAC, d.IP, d.IPv6, d.Hostname, d.Manufacturer, d.NetBIOSName, d.Workgroup, d.Services, d.Ports,
+			d.FirstSeen.Format(time.RFC3339), d.LastSeen.Format(time.RFC3339),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,305 @@
+// Package inventory 把lanscan的扫描结果保存到SQLite，按mac记录设备的首次和最后出现时间
+//
+// 每次保存扫描结果时和上一次扫描比较，报告新设备、消失的设备和IP/MAC冲突
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/ilaziness/gopkg/net/lanscan"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS device (
+	mac          TEXT PRIMARY KEY,
+	ip           TEXT NOT NULL DEFAULT '',
+	ipv6         TEXT NOT NULL DEFAULT '',
+	hostname     TEXT NOT NULL DEFAULT '',
+	manufacturer TEXT NOT NULL DEFAULT '',
+	netbios      TEXT NOT NULL DEFAULT '',
+	workgroup    TEXT NOT NULL DEFAULT '',
+	services     TEXT NOT NULL DEFAULT '',
+	ports        TEXT NOT NULL DEFAULT '',
+	first_seen   DATETIME NOT NULL,
+	last_seen    DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS scan (
+	id    INTEGER PRIMARY KEY AUTOINCREMENT,
+	at    DATETIME NOT NULL,
+	hosts INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS sighting (
+	scan_id INTEGER NOT NULL REFERENCES scan(id),
+	mac     TEXT NOT NULL,
+	ip      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS sighting_scan ON sighting(scan_id);
+`
+
+// Device 保存的设备，多个值的字段用逗号分隔
+type Device struct {
+	MAC          string    `db:"mac" json:"mac"`
+	IP           string    `db:"ip" json:"ip"`
+	IPv6         string    `db:"ipv6" json:"ipv6"`
+	Hostname     string    `db:"hostname" json:"hostname"`
+	Manufacturer string    `db:"manufacturer" json:"manufacturer"`
+	NetBIOSName  string    `db:"netbios" json:"netbios"`
+	Workgroup    string    `db:"workgroup" json:"workgroup"`
+	Services     string    `db:"services" json:"services"`
+	Ports        string    `db:"ports" json:"ports"`
+	FirstSeen    time.Time `db:"first_seen" json:"first_seen"`
+	LastSeen     time.Time `db:"last_seen" json:"last_seen"`
+}
+
+// 冲突类型
+const (
+	// ConflictDuplicate 同一次扫描里一个IP有多个mac
+	ConflictDuplicate = "duplicate"
+	// ConflictChanged IP在上一次扫描时属于另一个mac
+	ConflictChanged = "changed"
+)
+
+// Conflict IP/MAC冲突
+type Conflict struct {
+	IP   string
+	Kind string
+	// MACs 涉及的mac，changed时第一个是之前的mac
+	MACs []string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s %s %s", c.IP, c.Kind, strings.Join(c.MACs, ","))
+}
+
+// Report 一次扫描和上一次扫描比较的结果
+type Report struct {
+	ScanID int64
+	// New 第一次出现的设备
+	New []Device
+	// Disappeared 上一次扫描有，这一次没有的设备
+	Disappeared []Device
+	Conflicts   []Conflict
+}
+
+var ErrNoMAC = errors.New("inventory: no host with mac address")
+
+// Inventory 设备清单
+type Inventory struct {
+	// SkipEmpty 为true时没有带mac主机的扫描不保存，Record返回ErrNoMAC，
+	// 抓包出问题时不会把所有设备都报告为消失
+	SkipEmpty bool
+
+	db *sqlx.DB
+}
+
+// Open 打开或创建数据库，path可以是:memory:
+func Open(path string) (*Inventory, error) {
+	db, err := sqlx.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite只允许一个写连接，内存数据库每个连接都是独立的库
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Inventory{db: db}, nil
+}
+
+func (inv *Inventory) Close() error {
+	return inv.db.Close()
+}
+
+// Record 保存一次扫描的结果，没有mac的主机不保存
+// 空的扫描结果也保存，上一次的设备都报告为消失
+func (inv *Inventory) Record(at time.Time, infos []lanscan.LanIpInfo) (*Report, error) {
+	at = at.UTC()
+	devices := make(map[string]Device)
+	var macs []string
+	ipMACs := make(map[string][]string)
+	for _, info := range infos {
+		if len(info.Mac) == 0 {
+			continue
+		}
+		d := fromInfo(info)
+		if _, ok := devices[d.MAC]; !ok {
+			macs = append(macs, d.MAC)
+		}
+		devices[d.MAC] = d
+		if d.IP != "" && !contains(ipMACs[d.IP], d.MAC) {
+			ipMACs[d.IP] = append(ipMACs[d.IP], d.MAC)
+		}
+	}
+	if len(devices) == 0 && inv.SkipEmpty {
+		return nil, ErrNoMAC
+	}
+	sort.Strings(macs)
+
+	tx, err := inv.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// 上一次扫描
+	var prevID int64
+	if err := tx.Get(&prevID, `SELECT COALESCE(MAX(id), 0) FROM scan`); err != nil {
+		return nil, err
+	}
+	var prev []struct {
+		MAC string `db:"mac"`
+		IP  string `db:"ip"`
+	}
+	if err := tx.Select(&prev, `SELECT mac, ip FROM sighting WHERE scan_id = ?`, prevID); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.Exec(`INSERT INTO scan (at, hosts) VALUES (?, ?)`, at, len(devices))
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{}
+	if report.ScanID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	for _, mac := range macs {
+		d := devices[mac]
+		var exists int
+		if err := tx.Get(&exists, `SELECT COUNT(*) FROM device WHERE mac = ?`, mac); err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			d.FirstSeen, d.LastSeen = at, at
+			_, err = tx.NamedExec(`INSERT INTO device
+				(mac, ip, ipv6, hostname, manufacturer, netbios, workgroup, services, ports, first_seen, last_seen)
+				VALUES (:mac, :ip, :ipv6, :hostname, :manufacturer, :netbios, :workgroup, :services, :ports, :first_seen, :last_seen)`, d)
+			report.New = append(report.New, d)
+		} else {
+			// 这次没有的字段保留之前的值
+			d.LastSeen = at
+			_, err = tx.NamedExec(`UPDATE device SET
+				ip = CASE WHEN :ip = '' THEN ip ELSE :ip END,
+				ipv6 = CASE WHEN :ipv6 = '' THEN ipv6 ELSE :ipv6 END,
+				hostname = CASE WHEN :hostname = '' THEN hostname ELSE :hostname END,
+				manufacturer = CASE WHEN :manufacturer = '' THEN manufacturer ELSE :manufacturer END,
+				netbios = CASE WHEN :netbios = '' THEN netbios ELSE :netbios END,
+				workgroup = CASE WHEN :workgroup = '' THEN workgroup ELSE :workgroup END,
+				services = CASE WHEN :services = '' THEN services ELSE :services END,
+				ports = CASE WHEN :ports = '' THEN ports ELSE :ports END,
+				last_seen = :last_seen
+				WHERE mac = :mac`, d)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`INSERT INTO sighting (scan_id, mac, ip) VALUES (?, ?, ?)`, report.ScanID, mac, d.IP); err != nil {
+			return nil, err
+		}
+	}
+
+	prevIP := make(map[string]string)
+	for _, p := range prev {
+		if _, ok := devices[p.MAC]; !ok {
+			d, err := device(tx, p.MAC)
+			if err != nil {
+				return nil, err
+			}
+			report.Disappeared = append(report.Disappeared, d)
+		}
+		if p.IP != "" {
+			prevIP[p.IP] = p.MAC
+		}
+	}
+
+	ips := make([]string, 0, len(ipMACs))
+	for ip := range ipMACs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	for _, ip := range ips {
+		owners := ipMACs[ip]
+		if len(owners) > 1 {
+			sort.Strings(owners)
+			report.Conflicts = append(report.Conflicts, Conflict{IP: ip, Kind: ConflictDuplicate, MACs: owners})
+			continue
+		}
+		if old, ok := prevIP[ip]; ok && old != owners[0] {
+			report.Conflicts = append(report.Conflicts, Conflict{IP: ip, Kind: ConflictChanged, MACs: []string{old, owners[0]}})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Devices 所有设备，按IP排序，没有IPv4地址的排在后面
+func (inv *Inventory) Devices() ([]Device, error) {
+	var list []Device
+	if err := inv.db.Select(&list, `SELECT * FROM device`); err != nil {
+		return nil, err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if (a.IP == "") != (b.IP == "") {
+			return a.IP != ""
+		}
+		if a.IP != b.IP {
+			return lanscan.ParseIPString(a.IP) < lanscan.ParseIPString(b.IP)
+		}
+		return a.MAC < b.MAC
+	})
+	return list, nil
+}
+
+// Device 按mac查询设备，不存在返回sql.ErrNoRows
+func (inv *Inventory) Device(mac string) (Device, error) {
+	return device(inv.db, mac)
+}
+
+func device(q sqlx.Queryer, mac string) (Device, error) {
+	var d Device
+	err := sqlx.Get(q, &d, `SELECT * FROM device WHERE mac = ?`, mac)
+	return d, err
+}
+
+func fromInfo(info lanscan.LanIpInfo) Device {
+	services := make([]string, 0, len(info.Services))
+	for _, s := range info.Services {
+		services = append(services, s.Instance+"."+s.Type)
+	}
+	ports := make([]string, 0, len(info.Ports))
+	for _, p := range info.Ports {
+		ports = append(ports, fmt.Sprintf("%d/%s", p.Port, p.Service))
+	}
+	return Device{
+		MAC:          info.Mac.String(),
+		IP:           info.IP,
+		IPv6:         strings.Join(info.IPv6, ","),
+		Hostname:     info.Hostname,
+		Manufacturer: info.Manufacturer,
+		NetBIOSName:  info.NetBIOSName,
+		Workgroup:    info.Workgroup,
+		Services:     strings.Join(services, ","),
+		Ports:        strings.Join(ports, ","),
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package inventory
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/lanscan"
+)
+
+func host(ip, mac string) lanscan.LanIpInfo {
+	hw, _ := net.ParseMAC(mac)
+	return lanscan.LanIpInfo{IP: ip, Mac: hw}
+}
+
+func macs(list []Device) []string {
+	var out []string
+	for _, d := range list {
+		out = append(out, d.MAC)
+	}
+	return out
+}
+
+const (
+	macA = "00:11:22:33:44:01"
+	macB = "00:11:22:33:44:02"
+	macC = "00:11:22:33:44:03"
+)
+
+func TestRecord(t *testing.T) {
+	inv, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inv.Close()
+
+	t1 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	a := host("192.168.1.2", macA)
+	a.Hostname = "nas"
+	report, err := inv.Record(t1, []lanscan.LanIpInfo{a, host("192.168.1.3", macB), {IP: "192.168.1.9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := macs(report.New); !reflect.DeepEqual(got, []string{macA, macB}) {
+		t.Errorf("第一次扫描新设备 %v", got)
+	}
+	if len(report.Disappeared) != 0 || len(report.Conflicts) != 0 {
+		t.Errorf("第一次扫描不应该有消失的设备和冲突 %+v", report)
+	}
+
+	// B消失，C拿到了B之前的IP，A的主机名这次没有解析到
+	t2 := t1.Add(time.Hour)
+	report, err = inv.Record(t2, []lanscan.LanIpInfo{host("192.168.1.2", macA), host("192.168.1.3", macC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := macs(report.New); !reflect.DeepEqual(got, []string{macC}) {
+		t.Errorf("新设备 %v", got)
+	}
+	if got := macs(report.Disappeared); !reflect.DeepEqual(got, []string{macB}) {
+		t.Errorf("消失的设备 %v", got)
+	}
+	want := []Conflict{{IP: "192.168.1.3", Kind: ConflictChanged, MACs: []string{macB, macC}}}
+	if !reflect.DeepEqual(report.Conflicts, want) {
+		t.Errorf("冲突 %v, 期望 %v", report.Conflicts, want)
+	}
+
+	d, err := inv.Device(macA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.FirstSeen.Equal(t1) || !d.LastSeen.Equal(t2) {
+		t.Errorf("时间 first=%v last=%v", d.FirstSeen, d.LastSeen)
+	}
+	if d.Hostname != "nas" {
+		t.Errorf("没有解析到的字段应该保留之前的值, hostname=%q", d.Hostname)
+	}
+	if _, err := inv.Device("00:00:00:00:00:00"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("不存在的设备 err=%v", err)
+	}
+
+	// 同一个IP两个mac
+	report, err = inv.Record(t2.Add(time.Hour), []lanscan.LanIpInfo{host("192.168.1.2", macA), host("192.168.1.2", macB)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Conflict{{IP: "192.168.1.2", Kind: ConflictDuplicate, MACs: []string{macA, macB}}}
+	if !reflect.DeepEqual(report.Conflicts, want) {
+		t.Errorf("冲突 %v, 期望 %v", report.Conflicts, want)
+	}
+	if got := macs(report.Disappeared); !reflect.DeepEqual(got, []string{macC}) {
+		t.Errorf("消失的设备 %v", got)
+	}
+	if len(report.New) != 0 {
+		t.Errorf("B之前出现过，不是新设备 %v", macs(report.New))
+	}
+
+	// 设备都离线时空的扫描也保存，之前的设备都消失
+	report, err = inv.Record(t2.Add(2*time.Hour), []lanscan.LanIpInfo{{IP: "192.168.1.9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := macs(report.Disappeared); !reflect.DeepEqual(got, []string{macA, macB}) {
+		t.Errorf("空扫描消失的设备 %v", got)
+	}
+
+	inv.SkipEmpty = true
+	if _, err := inv.Record(t2.Add(3*time.Hour), nil); !errors.Is(err, ErrNoMAC) {
+		t.Errorf("SkipEmpty时没有mac的结果 err=%v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.db")
+	inv, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t1 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := inv.Record(t1, []lanscan.LanIpInfo{host("192.168.1.2", macA)}); err != nil {
+		t.Fatal(err)
+	}
+	inv.Close()
+
+	inv, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inv.Close()
+	report, err := inv.Record(t1.Add(time.Hour), []lanscan.LanIpInfo{host("192.168.1.3", macB)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := macs(report.Disappeared); !reflect.DeepEqual(got, []string{macA}) {
+		t.Errorf("重新打开后消失的设备 %v", got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	inv, err := Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inv.Close()
+	t1 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	a := host("192.168.1.10", macA)
+	a.IPv6 = []string{"fe80::1", "fe80::2"}
+	a.Ports = []lanscan.Port{{Port: 22, Service: "ssh"}, {Port: 80, Service: "http"}}
+	b := host("192.168.1.9", macB)
+	c := lanscan.LanIpInfo{IPv6: []string{"fe80::3"}}
+	c.Mac, _ = net.ParseMAC(macC)
+	if _, err := inv.Record(t1, []lanscan.LanIpInfo{a, b, c}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := inv.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var list []Device
+	if err := json.Unmarshal(buf.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	// 按IP数值排序，只有IPv6的排最后
+	if got := macs(list); !reflect.DeepEqual(got, []string{macB, macA, macC}) {
+		t.Errorf("导出顺序 %v", got)
+	}
+	if list[1].IPv6 != "fe80::1,fe80::2" || list[1].Ports != "22/ssh,80/http" {
+		t.Errorf("导出字段 %+v", list[1])
+	}
+
+	buf.Reset()
+	if err := inv.ExportCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || records[0][0] != "mac" || records[2][0] != macA {
+		t.Errorf("csv %v", records)
+	}
+	if records[2][9] != "2024-05-01T10:00:00Z" {
+		t.Errorf("csv first_seen %q", records[2][9])
+	}
+}
@@ -0,0 +1,42 @@
+package lanscan
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/ilaziness/gopkg/net/udp/dns"
+)
+
+// LLMNR(RFC 4795)报文格式和DNS一样，Windows在没有DNS时用它解析主机名
+// 反向查询必须单播发到主机的5355端口
+
+const llmnrPort = 5355
+
+// parseLLMNRPacket 解析源端口是5355的udp包
+func parseLLMNRPacket(p gopacket.Packet) []LanIpInfo {
+	udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp.SrcPort != llmnrPort {
+		return nil
+	}
+	return parseLLMNR(udp.Payload)
+}
+
+// parseLLMNR 从LLMNR响应的PTR和A记录里取主机名
+func parseLLMNR(payload []byte) []LanIpInfo {
+	var msg dns.Message
+	if err := msg.Unpack(payload); err != nil || !msg.Response {
+		return nil
+	}
+	var infos []LanIpInfo
+	for _, rr := range msg.Answers {
+		switch d := rr.Data.(type) {
+		case *dns.PTR:
+			if ip := reverseIP(dns.CanonicalName(rr.Name)); ip != "" {
+				infos = append(infos, LanIpInfo{IP: ip, Hostname: hostname(d.Target)})
+			}
+		case *dns.A:
+			infos = append(infos, LanIpInfo{IP: d.IP.String(), Hostname: hostname(rr.Name)})
+		}
+	}
+	return infos
+}
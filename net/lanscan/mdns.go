@@ -1,165 +1,276 @@
-package main
+package lanscan
 
 import (
-	"bytes"
-	"context"
-	"encoding/binary"
-	"log"
 	"net"
+	"slices"
+	"sort"
 	"strings"
-	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
+
+	"github.com/ilaziness/gopkg/net/udp/dns"
 )
 
-func listenMDNS(ctx context.Context, deviceName string, info chan LanIpInfo) {
-	handle, err := pcap.OpenLive(deviceName, 1024, false, 10*time.Second)
-	if err != nil {
-		log.Fatal("pcap打开失败:", err)
-	}
-	defer handle.Close()
-	handle.SetBPFFilter("udp and port 5353")
-	ps := gopacket.NewPacketSource(handle, handle.LinkType())
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case p := <-ps.Packets():
-			if len(p.Layers()) == 4 {
-				c := p.Layers()[3].LayerContents()
-				if c[2] == 0x84 && c[3] == 0x00 && c[6] == 0x00 && c[7] == 0x01 {
-					// 从网络层(ipv4)拿IP, 不考虑IPv6
-					i := p.Layer(layers.LayerTypeIPv4)
-					if i == nil {
-						continue
-					}
-					ipv4 := i.(*layers.IPv4)
-					ip := ipv4.SrcIP.String()
-					// 把 hostname 存入到数据库
-					hostname := ParseMdns(c)
-					if len(hostname) > 0 {
-						info <- LanIpInfo{
-							IP:       ip,
-							Hostname: hostname,
-						}
-					}
+const mdnsPort = 5353
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// browseTypes 扫描开始时用组播查询的常见服务类型
+var browseTypes = []string{
+	"_services._dns-sd._udp.local.",
+	"_http._tcp.local.",
+	"_ssh._tcp.local.",
+	"_smb._tcp.local.",
+	"_ipp._tcp.local.",
+	"_printer._tcp.local.",
+	"_airplay._tcp.local.",
+	"_raop._tcp.local.",
+	"_googlecast._tcp.local.",
+	"_companion-link._tcp.local.",
+	"_device-info._tcp.local.",
+	"_hap._tcp.local.",
+	"_spotify-connect._tcp.local.",
+}
+
+// Service mDNS(DNS-SD)广播的服务
+type Service struct {
+	// Instance 实例名，如 Living Room
+	Instance string
+	// Type 服务类型，如 _airplay._tcp
+	Type string
+	// Port 服务端口，只收到PTR记录时为0
+	Port uint16
+	// TXT 服务的TXT记录，key=value拆开，没有=的key值为空
+	TXT map[string]string
+}
+
+// parseMDNSPacket 解析源端口是5353的udp包
+func parseMDNSPacket(p gopacket.Packet) []LanIpInfo {
+	udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp.SrcPort != mdnsPort {
+		return nil
+	}
+	var src net.IP
+	if ip, ok := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+		src = ip.SrcIP
+	}
+	return parseMDNS(udp.Payload, src)
+}
+
+// parseMDNS 从mdns响应里提取主机名和服务
+// src是响应的来源IPv4地址，记录里没有A记录时主机信息和AAAA记录的IPv6地址归到src
+func parseMDNS(payload []byte, src net.IP) []LanIpInfo {
+	var msg dns.Message
+	if err := msg.Unpack(payload); err != nil || !msg.Response {
+		return nil
+	}
+	records := make([]dns.RR, 0, len(msg.Answers)+len(msg.Additionals))
+	records = append(records, msg.Answers...)
+	records = append(records, msg.Additionals...)
+
+	// 主机名 -> IPv4地址
+	hosts := make(map[string][]string)
+	// 主机名 -> IPv6地址，names6按出现顺序保存原始主机名
+	hosts6 := make(map[string][]string)
+	var names6 []string
+	// 实例名 -> 服务
+	services := make(map[string]*Service)
+	// 实例名 -> 提供服务的主机名
+	targets := make(map[string]string)
+	infos := make(map[string]*LanIpInfo)
+	var order []string
+	host := func(ip string) *LanIpInfo {
+		info, ok := infos[ip]
+		if !ok {
+			info = &LanIpInfo{IP: ip}
+			infos[ip] = info
+			order = append(order, ip)
+		}
+		return info
+	}
+	service := func(name string) *Service {
+		s, ok := services[name]
+		if !ok {
+			instance, typ, ok := splitInstance(name)
+			if !ok {
+				return nil
+			}
+			s = &Service{Instance: instance, Type: typ}
+			services[name] = s
+		}
+		return s
+	}
+
+	for _, rr := range records {
+		name := dns.CanonicalName(rr.Name)
+		switch d := rr.Data.(type) {
+		case *dns.A:
+			ip := d.IP.String()
+			hosts[name] = append(hosts[name], ip)
+			host(ip).Hostname = hostname(rr.Name)
+		case *dns.AAAA:
+			// 主机信息以IPv4为准，所有记录处理完再归到同名A记录的主机
+			if _, ok := hosts6[name]; !ok {
+				names6 = append(names6, rr.Name)
+			}
+			hosts6[name] = append(hosts6[name], d.IP.String())
+		case *dns.PTR:
+			if ip := reverseIP(name); ip != "" {
+				// 反向解析：ip -> 主机名
+				host(ip).Hostname = hostname(d.Target)
+				continue
+			}
+			if name == "_services._dns-sd._udp.local." {
+				continue
+			}
+			service(d.Target)
+		case *dns.SRV:
+			if s := service(rr.Name); s != nil {
+				s.Port = d.Port
+				targets[dns.CanonicalName(rr.Name)] = dns.CanonicalName(d.Target)
+			}
+		case *dns.TXT:
+			if s := service(rr.Name); s != nil {
+				s.TXT = parseTXT(d.Text)
+			}
+		}
+	}
+
+	for _, rawName := range names6 {
+		name := dns.CanonicalName(rawName)
+		ips := hosts[name]
+		if len(ips) == 0 {
+			// 没有A记录时归到来源地址
+			if src == nil {
+				continue
+			}
+			ips = []string{src.String()}
+			if info := host(ips[0]); info.Hostname == "" {
+				info.Hostname = hostname(rawName)
+			}
+		}
+		for _, ip := range ips {
+			info := host(ip)
+			for _, ip6 := range hosts6[name] {
+				if !slices.Contains(info.IPv6, ip6) {
+					info.IPv6 = append(info.IPv6, ip6)
 				}
 			}
 		}
 	}
-}
 
-// 根据ip生成含mdns请求包，包存储在 buffer里
-func mdns(buffer *Buffer, ip string) {
-	b := buffer.PrependBytes(12)
-	binary.BigEndian.PutUint16(b, uint16(0))          // 0x0000 标识
-	binary.BigEndian.PutUint16(b[2:], uint16(0x0100)) // 标识
-	binary.BigEndian.PutUint16(b[4:], uint16(1))      // 问题数
-	binary.BigEndian.PutUint16(b[6:], uint16(0))      // 资源数
-	binary.BigEndian.PutUint16(b[8:], uint16(0))      // 授权资源记录数
-	binary.BigEndian.PutUint16(b[10:], uint16(0))     // 额外资源记录数
-	// 查询问题
-	ipList := strings.Split(ip, ".")
-	for j := len(ipList) - 1; j >= 0; j-- {
-		ip := ipList[j]
-		b = buffer.PrependBytes(len(ip) + 1)
-		b[0] = uint8(len(ip))
-		for i := 0; i < len(ip); i++ {
-			b[i+1] = uint8(ip[i])
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ips := hosts[targets[dns.CanonicalName(name)]]
+		if len(ips) == 0 && src != nil {
+			ips = []string{src.String()}
 		}
+		for _, ip := range ips {
+			info := host(ip)
+			info.Services = append(info.Services, *services[name])
+		}
+	}
+
+	list := make([]LanIpInfo, 0, len(order))
+	for _, ip := range order {
+		list = append(list, *infos[ip])
 	}
-	b = buffer.PrependBytes(8)
-	b[0] = 7 // 后续总字节
-	copy(b[1:], []byte{'i', 'n', '-', 'a', 'd', 'd', 'r'})
-	b = buffer.PrependBytes(5)
-	b[0] = 4 // 后续总字节
-	copy(b[1:], []byte{'a', 'r', 'p', 'a'})
-	b = buffer.PrependBytes(1)
-	// terminator
-	b[0] = 0
-	// type 和 classIn
-	b = buffer.PrependBytes(4)
-	binary.BigEndian.PutUint16(b, uint16(12))
-	binary.BigEndian.PutUint16(b[2:], 1)
+	return list
 }
 
-func sendMdns(localIfName string, localIp net.IP, localMac net.HardwareAddr, lanIp IP, dstHardAddr net.HardwareAddr) {
-	srcIp := localIp.To4()
-	dstIp := net.ParseIP(lanIp.String()).To4()
-	ether := &layers.Ethernet{
-		SrcMAC:       localMac,
-		DstMAC:       dstHardAddr,
-		EthernetType: layers.EthernetTypeIPv4,
-	}
-
-	ip4 := &layers.IPv4{
-		Version:  uint8(4),
-		IHL:      uint8(5),
-		TTL:      uint8(255),
-		Protocol: layers.IPProtocolUDP,
-		SrcIP:    srcIp,
-		DstIP:    dstIp,
-	}
-	bf := NewBuffer()
-	mdns(bf, lanIp.String())
-	udpPayload := bf.data
-	udp := &layers.UDP{
-		SrcPort: layers.UDPPort(60666),
-		DstPort: layers.UDPPort(5353),
-	}
-	udp.SetNetworkLayerForChecksum(ip4)
-	udp.Payload = udpPayload // todo
-	buffer := gopacket.NewSerializeBuffer()
-	opt := gopacket.SerializeOptions{
-		FixLengths:       true, // 自动计算长度
-		ComputeChecksums: true, // 自动计算checksum
-	}
-	err := gopacket.SerializeLayers(buffer, opt, ether, ip4, udp, gopacket.Payload(udpPayload))
-	if err != nil {
-		log.Fatal("Serialize layers出现问题:", err)
-	}
-	outgoingPacket := buffer.Bytes()
-
-	handle, err := pcap.OpenLive(localIfName, 1024, false, 10*time.Second)
-	if err != nil {
-		log.Fatal("pcap打开失败:", err)
-	}
-	defer handle.Close()
-	err = handle.WritePacketData(outgoingPacket)
-	if err != nil {
-		log.Fatal("发送udp数据包失败..")
+// hostname 去掉.local后缀的主机名
+func hostname(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if i := len(name) - len(".local"); i > 0 && strings.EqualFold(name[i:], ".local") {
+		name = name[:i]
 	}
+	return unescapeName(name)
 }
 
-// 参数data  开头是 dns的协议头 0x0000 0x8400 0x0000 0x0001(ans) 0x0000 0x0000
-// 从 mdns响应报文中获取主机名
-func ParseMdns(data []byte) string {
-	var buf bytes.Buffer
-	i := bytes.Index(data, []byte{0x05, 0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x00})
-	if i < 0 {
+// reverseIP in-addr.arpa反向解析域名对应的IPv4地址
+func reverseIP(name string) string {
+	s, ok := strings.CutSuffix(name, ".in-addr.arpa.")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
 		return ""
 	}
+	for i, j := 0, 3; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	ip := net.ParseIP(strings.Join(parts, ".")).To4()
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// splitInstance 把服务实例名拆成实例和服务类型
+// Living\ Room._airplay._tcp.local. -> Living Room, _airplay._tcp
+func splitInstance(name string) (instance, typ string, ok bool) {
+	name = strings.TrimSuffix(name, ".")
+	i := strings.LastIndex(name, "._tcp.")
+	if i < 0 {
+		i = strings.LastIndex(name, "._udp.")
+	}
+	if i < 0 {
+		return "", "", false
+	}
+	proto := name[i+1 : i+5]
+	rest := name[:i]
+	j := strings.LastIndex(rest, "._")
+	if j < 0 || strings.HasSuffix(rest[:j], "._sub") {
+		return "", "", false
+	}
+	return unescapeName(rest[:j]), rest[j+1:] + "." + proto, true
+}
 
-	for s := i - 1; s > 1; s-- {
-		num := i - s
-		if s-2 < 0 {
-			break
-		}
-		// 包括 .local_ 7 个字符
-		if bto16([]byte{data[s-2], data[s-1]}) == uint16(num+7) {
-			return Reverse(buf.String())
+// unescapeName 去掉域名标签里的转义
+func unescapeName(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
 		}
-		buf.WriteByte(data[s])
+		b.WriteByte(s[i])
 	}
+	return b.String()
+}
 
-	return ""
+func parseTXT(text []string) map[string]string {
+	m := make(map[string]string, len(text))
+	for _, t := range text {
+		if t == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(t, "=")
+		m[k] = v
+	}
+	return m
 }
 
-func bto16(b []byte) uint16 {
-	if len(b) != 2 {
-		log.Fatal("b只能是2个字节")
+// mdnsBrowseQuery 组播查询常见服务类型的报文
+func mdnsBrowseQuery() ([]byte, error) {
+	msg := &dns.Message{}
+	for _, t := range browseTypes {
+		msg.Questions = append(msg.Questions, dns.Question{Name: t, Type: dns.TypePTR, Class: dns.ClassINET})
 	}
-	return uint16(b[0])<<8 + uint16(b[1])
+	return msg.Pack()
+}
+
+// reverseQuery ip的PTR反向查询报文，mdns和llmnr都用
+func reverseQuery(id uint16, ip net.IP) ([]byte, error) {
+	msg := dns.NewQuery(id, dns.ReverseName(ip), dns.TypePTR)
+	msg.RecursionDesired = false
+	return msg.Pack()
 }
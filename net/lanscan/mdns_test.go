@@ -0,0 +1,86 @@
+package lanscan
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ilaziness/gopkg/net/udp/dns"
+)
+
+func TestParseMDNS(t *testing.T) {
+	msg := &dns.Message{Header: dns.Header{Response: true}}
+	msg.Answers = []dns.RR{
+		{Name: "_ssh._tcp.local.", Class: dns.ClassINET, TTL: 120, Data: &dns.PTR{Target: "nas._ssh._tcp.local."}},
+		{Name: "nas._ssh._tcp.local.", Class: dns.ClassINET, TTL: 120, Data: &dns.SRV{Port: 22, Target: "NAS.home.arpa."}},
+		// 不是.local的主机名保留完整域名
+		{Name: "NAS.home.arpa.", Class: dns.ClassINET | dns.ClassCacheFlush, TTL: 120, Data: &dns.A{IP: net.ParseIP("10.0.0.8")}},
+		{Name: "NAS.home.arpa.", Class: dns.ClassINET | dns.ClassCacheFlush, TTL: 120, Data: &dns.A{IP: net.ParseIP("10.0.1.8")}},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos := parseMDNS(b, net.ParseIP("10.0.0.99"))
+	if len(infos) != 2 {
+		t.Fatalf("应该解析出2个主机，实际%d", len(infos))
+	}
+	for _, info := range infos {
+		if info.Hostname != "NAS.home.arpa" {
+			t.Errorf("%s 主机名错误: %q", info.IP, info.Hostname)
+		}
+		if len(info.Services) != 1 || info.Services[0].Port != 22 || info.Services[0].Type != "_ssh._tcp" {
+			t.Errorf("%s 服务错误: %+v", info.IP, info.Services)
+		}
+	}
+
+	// 查询和损坏的报文忽略
+	q, _ := dns.NewQuery(0, "nas.local.", dns.TypeA).Pack()
+	if infos := parseMDNS(q, nil); len(infos) != 0 {
+		t.Errorf("查询报文不应该有结果: %+v", infos)
+	}
+	if infos := parseMDNS(b[:len(b)-3], nil); len(infos) != 0 {
+		t.Errorf("截断的报文不应该有结果: %+v", infos)
+	}
+}
+
+func TestSplitInstance(t *testing.T) {
+	tests := []struct {
+		name, instance, typ string
+		ok                  bool
+	}{
+		{`Living\ Room._airplay._tcp.local.`, "Living Room", "_airplay._tcp", true},
+		{`a\.b._http._tcp.local.`, "a.b", "_http._tcp", true},
+		{"Chromecast._googlecast._tcp.local.", "Chromecast", "_googlecast._tcp", true},
+		{"x._sleep-proxy._udp.local.", "x", "_sleep-proxy._udp", true},
+		{"_printer._sub._http._tcp.local.", "", "", false},
+		{"host.local.", "", "", false},
+	}
+	for _, tt := range tests {
+		instance, typ, ok := splitInstance(tt.name)
+		if instance != tt.instance || typ != tt.typ || ok != tt.ok {
+			t.Errorf("%s: 得到 %q %q %v", tt.name, instance, typ, ok)
+		}
+	}
+	if ip := reverseIP("5.1.168.192.in-addr.arpa."); ip != "192.168.1.5" {
+		t.Errorf("反向解析地址错误: %s", ip)
+	}
+}
+
+func TestMergeServices(t *testing.T) {
+	info := LanIpInfo{IP: "192.168.1.20"}
+	svc := Service{Instance: "tv", Type: "_airplay._tcp"}
+	if !info.merge(LanIpInfo{Services: []Service{svc}}) {
+		t.Errorf("新服务应该返回有更新")
+	}
+	svc.Port = 7000
+	if !info.merge(LanIpInfo{Services: []Service{svc}}) || info.Services[0].Port != 7000 {
+		t.Errorf("端口应该更新: %+v", info.Services)
+	}
+	// 只有PTR的记录不会把端口清掉
+	if info.merge(LanIpInfo{Services: []Service{{Instance: "tv", Type: "_airplay._tcp"}}}) {
+		t.Errorf("重复的服务不应该返回有更新")
+	}
+	if len(info.Services) != 1 || info.Services[0].Port != 7000 {
+		t.Errorf("服务合并错误: %+v", info.Services)
+	}
+}
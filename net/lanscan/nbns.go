@@ -0,0 +1,217 @@
+package lanscan
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// NetBIOS名称服务(RFC 1002)，Windows和Samba主机用它公布计算机名和工作组
+// 这里实现节点状态查询：向主机的137端口查询*，响应里列出主机注册的所有名字
+// 另外解析主机广播的名字注册和刷新请求，被动模式下也能拿到计算机名
+
+const (
+	nbnsPort = 137
+	// 节点状态查询和响应的类型
+	nbnsTypeNBSTAT = 0x21
+	// 名字后缀，0x00是工作站服务
+	nbnsSuffixWorkstation = 0x00
+	// 名字标志里的组名标志
+	nbnsGroupFlag = 0x8000
+	// NB记录的类型
+	nbnsTypeNB = 0x20
+	// 操作码：注册、刷新和多宿主注册
+	nbnsOpRegister     = 5
+	nbnsOpRefresh      = 8
+	nbnsOpRefreshAlt   = 9
+	nbnsOpMultiHomed   = 15
+	nbnsEncodedNameLen = 32
+)
+
+// nbnsStatusQuery 节点状态查询报文
+func nbnsStatusQuery(id uint16) []byte {
+	b := make([]byte, 12, 50)
+	binary.BigEndian.PutUint16(b, id)
+	binary.BigEndian.PutUint16(b[4:], 1) // 问题数
+	b = append(b, 0x20)
+	b = append(b, encodeNetBIOSName("*")...)
+	b = append(b, 0x00)
+	b = binary.BigEndian.AppendUint16(b, nbnsTypeNBSTAT)
+	b = binary.BigEndian.AppendUint16(b, 1) // IN
+	return b
+}
+
+// encodeNetBIOSName 一级编码，名字补齐到16字节，每个字节拆成两个半字节加'A'
+// "*"后面补0，其他名字补空格
+func encodeNetBIOSName(name string) []byte {
+	var raw [16]byte
+	pad := byte(' ')
+	if name == "*" {
+		pad = 0
+	}
+	for i := range raw {
+		raw[i] = pad
+	}
+	copy(raw[:15], strings.ToUpper(name))
+	if name != "*" {
+		raw[15] = nbnsSuffixWorkstation
+	}
+	out := make([]byte, 32)
+	for i, c := range raw {
+		out[2*i] = 'A' + c>>4
+		out[2*i+1] = 'A' + c&0x0F
+	}
+	return out
+}
+
+// parseNBNSPacket 解析源端口是137的udp包
+func parseNBNSPacket(p gopacket.Packet) []LanIpInfo {
+	udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || udp.SrcPort != nbnsPort {
+		return nil
+	}
+	ip, ok := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if !ok {
+		return nil
+	}
+	info, ok := parseNBNS(udp.Payload, ip.SrcIP)
+	if !ok {
+		return nil
+	}
+	return []LanIpInfo{info}
+}
+
+// parseNBNS 从节点状态响应里取计算机名和工作组，请求报文交给parseNBNSRegistration
+func parseNBNS(msg []byte, src net.IP) (LanIpInfo, bool) {
+	if len(msg) < 12 {
+		return LanIpInfo{}, false
+	}
+	if msg[2]&0x80 == 0 {
+		return parseNBNSRegistration(msg)
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	an := int(binary.BigEndian.Uint16(msg[6:]))
+	if an == 0 {
+		return LanIpInfo{}, false
+	}
+	off := 12
+	for i := 0; i < qd; i++ {
+		if off = skipNBNSName(msg, off); off < 0 || off+4 > len(msg) {
+			return LanIpInfo{}, false
+		}
+		off += 4
+	}
+	// 第一个回答
+	if off = skipNBNSName(msg, off); off < 0 || off+10 > len(msg) {
+		return LanIpInfo{}, false
+	}
+	typ := binary.BigEndian.Uint16(msg[off:])
+	rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if typ != nbnsTypeNBSTAT || off+rdlen > len(msg) || rdlen < 1 {
+		return LanIpInfo{}, false
+	}
+	rdata := msg[off : off+rdlen]
+	n := int(rdata[0])
+	if 1+n*18 > len(rdata) {
+		return LanIpInfo{}, false
+	}
+	info := LanIpInfo{IP: src.String()}
+	for i := 0; i < n; i++ {
+		entry := rdata[1+i*18 : 1+(i+1)*18]
+		name := strings.TrimRight(string(entry[:15]), " \x00")
+		suffix := entry[15]
+		flags := binary.BigEndian.Uint16(entry[16:])
+		if suffix != nbnsSuffixWorkstation || name == "" {
+			continue
+		}
+		if flags&nbnsGroupFlag == 0 {
+			if info.NetBIOSName == "" {
+				info.NetBIOSName = name
+			}
+		} else if info.Workgroup == "" {
+			info.Workgroup = name
+		}
+	}
+	return info, info.NetBIOSName != ""
+}
+
+// parseNBNSRegistration 从名字注册和刷新请求里取名字和附加记录里的ip
+// 后缀0x00的唯一名字是计算机名，组名是工作组
+func parseNBNSRegistration(msg []byte) (LanIpInfo, bool) {
+	switch (msg[2] >> 3) & 0x0F {
+	case nbnsOpRegister, nbnsOpRefresh, nbnsOpRefreshAlt, nbnsOpMultiHomed:
+	default:
+		return LanIpInfo{}, false
+	}
+	qd := binary.BigEndian.Uint16(msg[4:])
+	ar := binary.BigEndian.Uint16(msg[10:])
+	if qd != 1 || ar != 1 || len(msg) < 13 || int(msg[12]) != nbnsEncodedNameLen || len(msg) < 13+nbnsEncodedNameLen {
+		return LanIpInfo{}, false
+	}
+	name, suffix, ok := decodeNetBIOSName(msg[13 : 13+nbnsEncodedNameLen])
+	if !ok || suffix != nbnsSuffixWorkstation || name == "" {
+		return LanIpInfo{}, false
+	}
+	off := skipNBNSName(msg, 12)
+	if off < 0 || off+4 > len(msg) {
+		return LanIpInfo{}, false
+	}
+	off += 4
+	// 附加记录：名字(一般是压缩指针)、类型、类、TTL、长度、NB标志、地址
+	if off = skipNBNSName(msg, off); off < 0 || off+16 > len(msg) {
+		return LanIpInfo{}, false
+	}
+	typ := binary.BigEndian.Uint16(msg[off:])
+	rdlen := binary.BigEndian.Uint16(msg[off+8:])
+	if typ != nbnsTypeNB || rdlen < 6 {
+		return LanIpInfo{}, false
+	}
+	flags := binary.BigEndian.Uint16(msg[off+10:])
+	ip := net.IP(msg[off+12 : off+16])
+	if ip.IsUnspecified() {
+		return LanIpInfo{}, false
+	}
+	info := LanIpInfo{IP: ip.String()}
+	if flags&nbnsGroupFlag == 0 {
+		info.NetBIOSName = name
+	} else {
+		info.Workgroup = name
+	}
+	return info, true
+}
+
+// decodeNetBIOSName 一级编码的反向操作，返回去掉填充的名字和后缀
+func decodeNetBIOSName(b []byte) (string, byte, bool) {
+	if len(b) != nbnsEncodedNameLen {
+		return "", 0, false
+	}
+	var raw [16]byte
+	for i := range raw {
+		hi, lo := b[2*i]-'A', b[2*i+1]-'A'
+		if hi > 0x0F || lo > 0x0F {
+			return "", 0, false
+		}
+		raw[i] = hi<<4 | lo
+	}
+	return strings.TrimRight(string(raw[:15]), " \x00"), raw[15], true
+}
+
+// skipNBNSName 跳过报文里的名字，返回名字后面的偏移，出错返回-1
+func skipNBNSName(msg []byte, off int) int {
+	for off < len(msg) {
+		c := int(msg[off])
+		switch {
+		case c == 0:
+			return off + 1
+		case c&0xC0 == 0xC0:
+			return off + 2
+		default:
+			off += 1 + c
+		}
+	}
+	return -1
+}
@@ -0,0 +1,89 @@
+package lanscan
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNBNSQuery(t *testing.T) {
+	if got := string(encodeNetBIOSName("*")); got != "CK"+strings.Repeat("A", 30) {
+		t.Errorf("*编码错误: %s", got)
+	}
+	// FRED补空格，后缀0x00
+	if got := string(encodeNetBIOSName("fred")); got != "EGFCEFEE"+strings.Repeat("CA", 11)+"AA" {
+		t.Errorf("FRED编码错误: %s", got)
+	}
+	q := nbnsStatusQuery(0x1234)
+	if len(q) != 50 || q[0] != 0x12 || q[1] != 0x34 || q[12] != 0x20 || q[47] != 0x21 {
+		t.Errorf("节点状态查询报文错误: %x", q)
+	}
+	// 查询报文不是响应
+	if _, ok := parseNBNS(q, net.ParseIP("192.168.1.2")); ok {
+		t.Errorf("查询报文不应该解析出结果")
+	}
+}
+
+func TestNameFilter(t *testing.T) {
+	if f := nameFilter(Options{}); f != "udp and (src port 5353 or src port 137 or src port 5355 or port 1900)" {
+		t.Errorf("过滤条件错误: %s", f)
+	}
+	if f := nameFilter(Options{DisableMDNS: true, DisableLLMNR: true, DisableSSDP: true}); f != "udp and (src port 137)" {
+		t.Errorf("过滤条件错误: %s", f)
+	}
+	if f := nameFilter(Options{DisableMDNS: true, DisableNBNS: true, DisableLLMNR: true}); f != "udp and (port 1900)" {
+		t.Errorf("过滤条件错误: %s", f)
+	}
+	if f := nameFilter(Options{DisableMDNS: true, DisableNBNS: true, DisableLLMNR: true, DisableSSDP: true}); f != "" {
+		t.Errorf("都关闭时应该为空: %s", f)
+	}
+}
+
+func TestParseNBNSRegistration(t *testing.T) {
+	register := func(name string, flags uint16, ip string) []byte {
+		b := []byte{0x12, 0x34, 0x29, 0x10, 0, 1, 0, 0, 0, 0, 0, 1, 0x20}
+		b = append(b, encodeNetBIOSName(name)...)
+		b = append(b, 0, 0, nbnsTypeNB, 0, 1)
+		b = append(b, 0xc0, 0x0c, 0, nbnsTypeNB, 0, 1, 0, 0x04, 0x93, 0xe0, 0, 6, byte(flags>>8), byte(flags))
+		return append(b, net.ParseIP(ip).To4()...)
+	}
+	info, ok := parseNBNS(register("desktop-ab12", 0, "192.168.1.70"), net.ParseIP("192.168.1.70"))
+	if !ok || info.IP != "192.168.1.70" || info.NetBIOSName != "DESKTOP-AB12" || info.Workgroup != "" {
+		t.Errorf("计算机名注册 %+v", info)
+	}
+	info, ok = parseNBNS(register("WORKGROUP", nbnsGroupFlag, "192.168.1.70"), nil)
+	if !ok || info.Workgroup != "WORKGROUP" || info.NetBIOSName != "" {
+		t.Errorf("工作组注册 %+v", info)
+	}
+
+	// 名字查询不是注册
+	q := register("DESKTOP-AB12", 0, "192.168.1.70")
+	q[2] = 0x01
+	if _, ok := parseNBNS(q, nil); ok {
+		t.Errorf("名字查询不应该解析出结果")
+	}
+	// 地址是0.0.0.0
+	if _, ok := parseNBNS(register("DESKTOP-AB12", 0, "0.0.0.0"), nil); ok {
+		t.Errorf("没有地址的注册不应该解析出结果")
+	}
+	// 报文不完整
+	b := register("DESKTOP-AB12", 0, "192.168.1.70")
+	if _, ok := parseNBNS(b[:len(b)-3], nil); ok {
+		t.Errorf("不完整的报文不应该解析出结果")
+	}
+}
+
+func TestDecodeNetBIOSName(t *testing.T) {
+	name, suffix, ok := decodeNetBIOSName(encodeNetBIOSName("nas"))
+	if !ok || name != "NAS" || suffix != nbnsSuffixWorkstation {
+		t.Errorf("解码结果 %q %#x %v", name, suffix, ok)
+	}
+	if _, _, ok := decodeNetBIOSName([]byte("short")); ok {
+		t.Errorf("长度不对应该失败")
+	}
+	bad := encodeNetBIOSName("nas")
+	bad[0] = 'z'
+	if _, _, ok := decodeNetBIOSName(bad); ok {
+		t.Errorf("非法字符应该失败")
+	}
+}
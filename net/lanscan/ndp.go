@@ -0,0 +1,168 @@
+package lanscan
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	manuf "github.com/timest/gomanuf"
+)
+
+// IPv6邻居发现(RFC 4861)
+// 向ff02::1发送组播ping，链路上的主机都会回复；再向回复的地址发送邻居请求，
+// 从邻居通告里拿到mac。其他主机的邻居请求和路由器通告也会被解析
+
+var (
+	allNodes    = net.ParseIP("ff02::1")
+	allNodesMAC = net.HardwareAddr{0x33, 0x33, 0x00, 0x00, 0x00, 0x01}
+)
+
+const (
+	// ndp选项类型
+	ndpOptSourceLinkAddr = 1
+	ndpOptTargetLinkAddr = 2
+	// 组播ping的标识
+	ndpEchoID = 0x6c73
+)
+
+// listenNDP 从ps读取邻居通告和ping响应，直到ctx结束或者数据源读完
+func listenNDP(ctx context.Context, ps *gopacket.PacketSource, ch chan<- LanIpInfo) error {
+	return listen(ctx, ps, parseNDPPacket, ch)
+}
+
+// parseNDPPacket 从ping响应、邻居通告、邻居请求和路由器通告里取IPv6地址和mac
+func parseNDPPacket(p gopacket.Packet) []LanIpInfo {
+	ip6, ok := p.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	if !ok || ip6.NextHeader != layers.IPProtocolICMPv6 {
+		return nil
+	}
+	var addr net.IP
+	var mac net.HardwareAddr
+	if icmp, ok := p.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6); ok && icmp.TypeCode.Type() == layers.ICMPv6TypeEchoReply {
+		// 链路上的响应，以太网源地址就是主机的mac
+		addr = ip6.SrcIP
+		if eth, ok := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet); ok {
+			mac = eth.SrcMAC
+		}
+	}
+	if na, ok := p.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement); ok {
+		addr = na.TargetAddress
+		mac = ndpLinkAddr(na.Options, ndpOptTargetLinkAddr)
+	}
+	if ns, ok := p.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation); ok {
+		// 源地址是::的是重复地址检测，还没有地址
+		addr = ip6.SrcIP
+		mac = ndpLinkAddr(ns.Options, ndpOptSourceLinkAddr)
+	}
+	if ra, ok := p.Layer(layers.LayerTypeICMPv6RouterAdvertisement).(*layers.ICMPv6RouterAdvertisement); ok {
+		addr = ip6.SrcIP
+		mac = ndpLinkAddr(ra.Options, ndpOptSourceLinkAddr)
+	}
+	if addr == nil || addr.IsUnspecified() || addr.IsMulticast() || len(mac) != 6 {
+		return nil
+	}
+	return []LanIpInfo{{
+		IPv6:         []string{addr.String()},
+		Mac:          mac,
+		Manufacturer: manuf.Search(mac.String()),
+	}}
+}
+
+func ndpLinkAddr(opts layers.ICMPv6Options, typ layers.ICMPv6Opt) net.HardwareAddr {
+	for _, o := range opts {
+		if o.Type == typ && len(o.Data) >= 6 {
+			return net.HardwareAddr(o.Data[:6])
+		}
+	}
+	return nil
+}
+
+// ndpSender 发送组播ping和邻居请求，可以并发调用
+type ndpSender struct {
+	mu    sync.Mutex
+	w     packetWriter
+	mac   net.HardwareAddr
+	local []net.IP
+	seq   uint16
+}
+
+func newNdpSender(w packetWriter, mac net.HardwareAddr, local []net.IP) *ndpSender {
+	return &ndpSender{w: w, mac: mac, local: local}
+}
+
+// ping 从每个本机地址向ff02::1发送ping
+func (s *ndpSender) ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, src := range s.local {
+		s.seq++
+		icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoRequest, 0)}
+		echo := &layers.ICMPv6Echo{Identifier: ndpEchoID, SeqNumber: s.seq}
+		if err := s.write(src, allNodes, allNodesMAC, 1, icmp, echo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// solicit 向target的请求节点组播地址发送邻居请求
+func (s *ndpSender) solicit(target net.IP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target = target.To16()
+	src := s.source(target)
+	if src == nil || target == nil {
+		return nil
+	}
+	// ff02::1:ffXX:XXXX，对应的mac是33:33:ff:XX:XX:XX
+	group := net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, target[13], target[14], target[15]}
+	groupMAC := net.HardwareAddr{0x33, 0x33, 0xff, target[13], target[14], target[15]}
+	icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0)}
+	ns := &layers.ICMPv6NeighborSolicitation{
+		TargetAddress: target,
+		Options: layers.ICMPv6Options{
+			{Type: ndpOptSourceLinkAddr, Data: s.mac},
+		},
+	}
+	return s.write(src, group, groupMAC, 255, icmp, ns)
+}
+
+// source 选一个和target作用域相同的本机地址
+func (s *ndpSender) source(target net.IP) net.IP {
+	var fallback net.IP
+	for _, ip := range s.local {
+		if ip.IsLinkLocalUnicast() == target.IsLinkLocalUnicast() {
+			return ip
+		}
+		if fallback == nil {
+			fallback = ip
+		}
+	}
+	return fallback
+}
+
+func (s *ndpSender) write(src, dst net.IP, dstMAC net.HardwareAddr, hopLimit uint8, icmp *layers.ICMPv6, body gopacket.SerializableLayer) error {
+	ether := &layers.Ethernet{
+		SrcMAC:       s.mac,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+	ip6 := &layers.IPv6{
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		HopLimit:   hopLimit,
+		SrcIP:      src,
+		DstIP:      dst,
+	}
+	if err := icmp.SetNetworkLayerForChecksum(ip6); err != nil {
+		return err
+	}
+	buffer := gopacket.NewSerializeBuffer()
+	opt := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buffer, opt, ether, ip6, icmp, body); err != nil {
+		return err
+	}
+	return s.w.WritePacketData(buffer.Bytes())
+}
@@ -0,0 +1,83 @@
+package lanscan
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+type packetRecorder struct {
+	packets []gopacket.Packet
+}
+
+func (w *packetRecorder) WritePacketData(data []byte) error {
+	w.packets = append(w.packets, gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default))
+	return nil
+}
+
+func TestNdpSender(t *testing.T) {
+	mac, _ := net.ParseMAC("00:0c:29:aa:bb:cc")
+	w := &packetRecorder{}
+	local := []net.IP{net.ParseIP("fe80::20c:29ff:feaa:bbcc"), net.ParseIP("2001:db8::2")}
+	s := newNdpSender(w, mac, local)
+	if err := s.ping(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.solicit(net.ParseIP("2001:db8::12:3456")); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.packets) != 3 {
+		t.Fatalf("应该发送3个包，实际%d", len(w.packets))
+	}
+	for _, p := range w.packets[:2] {
+		ip6 := p.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+		if !ip6.DstIP.Equal(allNodes) {
+			t.Errorf("组播ping目标地址错误: %s", ip6.DstIP)
+		}
+	}
+
+	p := w.packets[2]
+	eth := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	ip6 := p.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	ns, ok := p.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation)
+	if !ok {
+		t.Fatal("没有邻居请求")
+	}
+	if eth.DstMAC.String() != "33:33:ff:12:34:56" || ip6.DstIP.String() != "ff02::1:ff12:3456" {
+		t.Errorf("请求节点组播地址错误: %s %s", eth.DstMAC, ip6.DstIP)
+	}
+	if !ip6.SrcIP.Equal(local[1]) || ip6.HopLimit != 255 {
+		t.Errorf("全局地址应该用全局地址做源地址: %s hop %d", ip6.SrcIP, ip6.HopLimit)
+	}
+	if !ns.TargetAddress.Equal(net.ParseIP("2001:db8::12:3456")) || ndpLinkAddr(ns.Options, ndpOptSourceLinkAddr).String() != mac.String() {
+		t.Errorf("邻居请求内容错误: %+v", ns)
+	}
+	// 本机发出的邻居请求也能解析出本机mac，由Scan过滤
+	if infos := parseNDPPacket(p); len(infos) != 1 || infos[0].Mac.String() != mac.String() {
+		t.Errorf("邻居请求解析错误: %+v", infos)
+	}
+}
+
+func TestResultDualStack(t *testing.T) {
+	mac, _ := net.ParseMAC("b8:27:eb:44:55:66")
+	r := newResult()
+	r.add(LanIpInfo{IPv6: []string{"fe80::1"}, Mac: mac})
+	r.add(LanIpInfo{IPv6: []string{"2001:db8::1"}, Mac: mac})
+	if list := r.list(); len(list) != 1 || len(list[0].IPv6) != 2 || list[0].IP != "" {
+		t.Fatalf("同一个mac的IPv6地址应该合并: %+v", list)
+	}
+	info, ok := r.add(LanIpInfo{IP: "192.168.1.5", Mac: mac})
+	if !ok || info.IP != "192.168.1.5" || len(info.IPv6) != 2 {
+		t.Errorf("IPv4记录应该合并IPv6记录: %+v", info)
+	}
+	r.add(LanIpInfo{IPv6: []string{"fe80::2"}, Mac: mac})
+	list := r.list()
+	if len(list) != 1 || len(list[0].IPv6) != 3 || !r.has("192.168.1.5") {
+		t.Errorf("合并后的记录错误: %+v", list)
+	}
+	if _, ok := r.add(LanIpInfo{IPv6: []string{"fe80::3"}}); ok {
+		t.Errorf("没有mac的IPv6记录不能合并")
+	}
+}
@@ -0,0 +1,124 @@
+package lanscan
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// 被动发现：只抓包，不发送arp、ndp和名字查询，也不获取UPnP描述文件
+// 主机信息来自网络上本来就有的arp、dhcp、mdns、ssdp、nbns和ndp报文
+// 主动扫描的arp请求会触发一些网络的入侵检测告警，这时用被动模式
+
+// DefaultPassiveWindow 被动模式默认监听时长
+const DefaultPassiveWindow = time.Minute
+
+// sniff 在监听时长内被动收集主机信息
+// 到了时长正常结束，ctx取消时返回已经发现的主机和ctx的错误
+func (s *Scanner) sniff(ctx context.Context, opts Options) ([]LanIpInfo, error) {
+	nci, err := Interfaces(opts.Interface)
+	if err != nil {
+		return nil, err
+	}
+	type capture struct {
+		filter string
+		listen func(ctx context.Context, ps *gopacket.PacketSource, ch chan<- LanIpInfo) error
+	}
+	captures := []capture{
+		{"arp", func(ctx context.Context, ps *gopacket.PacketSource, ch chan<- LanIpInfo) error {
+			return listenARP(ctx, ps, true, ch)
+		}},
+		{dhcpFilter, listenDHCP},
+	}
+	if filter := nameFilter(opts); filter != "" {
+		captures = append(captures, capture{filter, listenNames})
+	}
+
+	devices := groupByDevice(nci)
+	var handles []*pcap.Handle
+	// 最后关闭，抓包的goroutine退出后才能关
+	defer func() {
+		for _, h := range handles {
+			h.Close()
+		}
+	}()
+	type listener struct {
+		handle *pcap.Handle
+		listen func(ctx context.Context, ps *gopacket.PacketSource, ch chan<- LanIpInfo) error
+	}
+	var listeners []listener
+	for _, d := range devices {
+		list := captures
+		if len(d.localIPv6()) > 0 && !opts.DisableIPv6 {
+			list = append(list[:len(list):len(list)], capture{"icmp6", listenNDP})
+		}
+		for _, c := range list {
+			h, err := openLive(d.device, c.filter)
+			if err != nil {
+				return nil, err
+			}
+			handles = append(handles, h)
+			listeners = append(listeners, listener{h, c.listen})
+		}
+	}
+	packetSource, err := newPacketSources(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	window := opts.Timeout
+	if window <= 0 {
+		window = DefaultPassiveWindow
+	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, window)
+	var wg sync.WaitGroup
+	// 先取消再等待，保证抓包的goroutine都退出了
+	defer wg.Wait()
+	defer cancel()
+
+	found := make(chan LanIpInfo, 64)
+	errc := make(chan error, len(listeners))
+	for _, l := range listeners {
+		ps := packetSource(l.handle)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.listen(ctx, ps, found); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	slog.Debug(fmt.Sprintf("passive listen %s on %d interfaces", window, len(devices)))
+
+	// 本机的dhcp和arp报文也会被抓到，忽略本机的mac
+	localMACs := make(map[string]bool)
+	for _, it := range nci {
+		localMACs[it.HardAddr.String()] = true
+	}
+	res := newResult()
+	for {
+		select {
+		case info := <-found:
+			if len(info.Mac) > 0 && localMACs[info.Mac.String()] {
+				continue
+			}
+			if updated, ok := res.add(info); ok && opts.Results != nil {
+				select {
+				case opts.Results <- updated:
+				case <-ctx.Done():
+				}
+			}
+		case err := <-errc:
+			return res.list(), err
+		case <-ctx.Done():
+			// 到了监听时长是正常结束，调用方取消才返回错误
+			return res.list(), parent.Err()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package lanscan
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// 回放和录制抓包文件
+//
+// 回放：从pcap文件读数据包，用和实时扫描相同的解析器解析，不发送任何数据包
+// 录制：实时扫描时把抓到的数据包写入pcap文件，之后可以回放
+
+// packetSource 数据包来源，*pcap.Handle和*pcapgo.Reader都实现了这个接口
+type packetSource interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// parsers 解析数据包得到主机信息，回放时每个数据包都交给所有解析器
+var parsers = []func(p gopacket.Packet) []LanIpInfo{
+	parseARPPacket,
+	parseNamePacket,
+	parseNDPPacket,
+}
+
+// passiveParsers 被动模式的解析器，arp请求的发送方也算发现的主机，另外解析dhcp
+var passiveParsers = []func(p gopacket.Packet) []LanIpInfo{
+	parseARPSenderPacket,
+	parseNamePacket,
+	parseDHCPPacket,
+	parseNDPPacket,
+}
+
+// listen 从ps读取数据包交给parse解析，结果发到ch，直到ctx结束或者数据源读完
+func listen(ctx context.Context, ps *gopacket.PacketSource, parse func(p gopacket.Packet) []LanIpInfo, ch chan<- LanIpInfo) error {
+	packets := ps.Packets()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case p, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			for _, info := range parse(p) {
+				select {
+				case ch <- info:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// ReplayFile 回放pcap文件，支持libpcap能打开的所有格式
+func (s *Scanner) ReplayFile(ctx context.Context, path string, opts Options) ([]LanIpInfo, error) {
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+	return s.replay(ctx, handle, opts)
+}
+
+// Replay 从r读取pcap格式的数据回放，不依赖libpcap
+// 只使用opts.Results和opts.Passive，其他扫描参数忽略
+func (s *Scanner) Replay(ctx context.Context, r io.Reader, opts Options) ([]LanIpInfo, error) {
+	reader, err := pcapgo.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.replay(ctx, reader, opts)
+}
+
+func (s *Scanner) replay(ctx context.Context, src packetSource, opts Options) ([]LanIpInfo, error) {
+	res := newResult()
+	list := parsers
+	if opts.Passive {
+		list = passiveParsers
+	}
+	ps := gopacket.NewPacketSource(src, src.LinkType())
+	for {
+		p, err := ps.NextPacket()
+		if err == io.EOF {
+			return res.list(), nil
+		}
+		if err != nil {
+			return res.list(), err
+		}
+		for _, parse := range list {
+			for _, info := range parse(p) {
+				updated, ok := res.add(info)
+				if !ok || opts.Results == nil {
+					continue
+				}
+				select {
+				case opts.Results <- updated:
+				case <-ctx.Done():
+					return res.list(), ctx.Err()
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return res.list(), ctx.Err()
+		}
+	}
+}
+
+// pcapWriter 多个网卡共用的pcap文件写入，可以并发调用
+type pcapWriter struct {
+	mu sync.Mutex
+	w  *pcapgo.Writer
+}
+
+func newPcapWriter(w io.Writer, snaplen uint32) (*pcapWriter, error) {
+	pw := pcapgo.NewWriter(w)
+	if err := pw.WriteFileHeader(snaplen, layers.LinkTypeEthernet); err != nil {
+		return nil, err
+	}
+	return &pcapWriter{w: pw}, nil
+}
+
+func (w *pcapWriter) write(ci gopacket.CaptureInfo, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.WritePacket(ci, data)
+}
+
+// newPacketSources 返回从句柄创建PacketSource的函数，设置了opts.Record时抓到的包同时写入
+func newPacketSources(opts Options) (func(h *pcap.Handle) *gopacket.PacketSource, error) {
+	var record *pcapWriter
+	if opts.Record != nil {
+		var err error
+		if record, err = newPcapWriter(opts.Record, snaplen); err != nil {
+			return nil, err
+		}
+	}
+	return func(h *pcap.Handle) *gopacket.PacketSource {
+		var src gopacket.PacketDataSource = h
+		if record != nil {
+			src = &recordSource{src: src, w: record}
+		}
+		return gopacket.NewPacketSource(src, h.LinkType())
+	}, nil
+}
+
+// recordSource 读数据包的同时写入pcap文件
+type recordSource struct {
+	src gopacket.PacketDataSource
+	w   *pcapWriter
+}
+
+func (r *recordSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := r.src.ReadPacketData()
+	if err != nil {
+		return data, ci, err
+	}
+	if err := r.w.write(ci, data); err != nil {
+		// 写失败不影响扫描
+		log.Println("record packet:", err)
+	}
+	return data, ci, nil
+}
@@ -0,0 +1,141 @@
+package lanscan
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var update = flag.Bool("update", false, "更新testdata下的golden文件")
+
+// formatInfos 把扫描结果格式化成golden文件内容
+func formatInfos(infos []LanIpInfo) []byte {
+	var b bytes.Buffer
+	for _, info := range infos {
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", info.IP, strings.Join(info.IPv6, ","), info.Mac, info.Manufacturer, info.Hostname, info.NetBIOSName, info.Workgroup)
+		for _, svc := range info.Services {
+			txt := make([]string, 0, len(svc.TXT))
+			for k, v := range svc.TXT {
+				txt = append(txt, k+"="+v)
+			}
+			sort.Strings(txt)
+			fmt.Fprintf(&b, "\tservice\t%s\t%s\t%d\t%s\n", svc.Type, svc.Instance, svc.Port, strings.Join(txt, " "))
+		}
+		for _, dev := range info.UPnP {
+			fmt.Fprintf(&b, "\tupnp\t%s\t%s\t%s\t%s\t%s\t%s\n", dev.Location, dev.UUID, dev.Server, dev.FriendlyName, dev.Manufacturer, dev.ModelName)
+		}
+	}
+	return b.Bytes()
+}
+
+// TestReplayGolden 回放testdata下的抓包文件，结果和同名的.golden文件比较
+// passive开头的文件按被动模式回放
+// go test ./net/lanscan -run TestReplayGolden -update 重新生成golden文件
+func TestReplayGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("testdata下没有抓包文件")
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".pcap")
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			opts := Options{Passive: strings.HasPrefix(name, "passive")}
+			infos, err := NewScanner().Replay(context.Background(), f, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := formatInfos(infos)
+			golden := strings.TrimSuffix(file, ".pcap") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("回放结果和%s不一致\n得到:\n%s\n期望:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestReplayResults(t *testing.T) {
+	f, err := os.Open("testdata/arp.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	results := make(chan LanIpInfo, 16)
+	infos, err := NewScanner().Replay(context.Background(), f, Options{Results: results})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(results)
+	n := 0
+	for range results {
+		n++
+	}
+	// 重复的arp响应不会再次发送
+	if n != len(infos) {
+		t.Errorf("Results收到%d条，结果%d条", n, len(infos))
+	}
+}
+
+// TestRecord 录制的数据包可以原样回放
+func TestRecord(t *testing.T) {
+	data, err := os.ReadFile("testdata/arp.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pcapgo.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := newPcapWriter(&buf, snaplen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := gopacket.NewPacketSource(&recordSource{src: r, w: w}, layers.LinkTypeEthernet)
+	n := 0
+	for range ps.Packets() {
+		n++
+	}
+	if n == 0 {
+		t.Fatal("没有读到数据包")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	recorded, err := NewScanner().Replay(ctx, &buf, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, _ := NewScanner().Replay(ctx, bytes.NewReader(data), Options{})
+	if !bytes.Equal(formatInfos(recorded), formatInfos(orig)) {
+		t.Errorf("录制的文件回放结果不一致\n%s\n%s", formatInfos(recorded), formatInfos(orig))
+	}
+}
@@ -0,0 +1,299 @@
+package lanscan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TCP connect端口扫描，抓取banner识别服务
+
+// DefaultPorts 默认扫描的端口
+var DefaultPorts = []int{
+	21, 22, 23, 25, 53, 80, 110, 139, 143, 443, 445, 548, 554, 631,
+	1883, 3306, 3389, 5000, 5432, 5900, 6379, 8000, 8080, 8443, 8888, 9000, 9100, 27017,
+}
+
+const (
+	DefaultPortConcurrency = 100
+	DefaultPortTimeout     = time.Second
+	DefaultBannerTimeout   = 2 * time.Second
+	maxBanner              = 512
+)
+
+// wellKnown 识别不出banner时按端口猜测服务
+var wellKnown = map[int]string{
+	21: "ftp", 22: "ssh", 23: "telnet", 25: "smtp", 53: "dns", 80: "http", 110: "pop3",
+	139: "netbios", 143: "imap", 443: "https", 445: "smb", 548: "afp", 554: "rtsp", 631: "ipp",
+	1883: "mqtt", 3306: "mysql", 3389: "rdp", 5432: "postgresql", 5900: "vnc", 6379: "redis",
+	8080: "http", 8443: "https", 9100: "jetdirect", 27017: "mongodb",
+}
+
+// Port 开放的TCP端口
+type Port struct {
+	Port int
+	// Service 识别出的服务，如 ssh、http、redis，识别不出时按端口猜测
+	Service string
+	// Banner 服务返回的第一段数据，去掉了不可见字符
+	Banner string
+}
+
+// PortOptions 端口扫描参数
+type PortOptions struct {
+	// Ports 要扫描的端口，默认DefaultPorts
+	Ports []int
+	// Concurrency 同时建立的连接数，默认100
+	Concurrency int
+	// Timeout 建立连接的超时时间，默认1秒
+	Timeout time.Duration
+	// BannerTimeout 读取banner的超时时间，默认2秒，小于0不读banner
+	BannerTimeout time.Duration
+}
+
+func (opts *PortOptions) setDefaults() {
+	if len(opts.Ports) == 0 {
+		opts.Ports = DefaultPorts
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultPortConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultPortTimeout
+	}
+	if opts.BannerTimeout == 0 {
+		opts.BannerTimeout = DefaultBannerTimeout
+	}
+}
+
+// ParsePorts 解析端口列表，如 22,80,8000-8100
+func ParsePorts(s string) ([]int, error) {
+	var ports []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, fmt.Errorf("lanscan: invalid port %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil {
+				return nil, fmt.Errorf("lanscan: invalid port %q", part)
+			}
+		}
+		if start < 1 || end > 65535 || start > end {
+			return nil, fmt.Errorf("lanscan: invalid port %q", part)
+		}
+		for p := start; p <= end; p++ {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	return ports, nil
+}
+
+// ScanPorts 扫描每个主机的端口，结果放到返回的LanIpInfo.Ports里
+// ctx取消时返回已经扫描到的结果和ctx的错误
+func (s *Scanner) ScanPorts(ctx context.Context, infos []LanIpInfo, opts PortOptions) ([]LanIpInfo, error) {
+	opts.setDefaults()
+	out := make([]LanIpInfo, len(infos))
+	copy(out, infos)
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, opts.Concurrency)
+	)
+	found := make([][]Port, len(out))
+FOR:
+	for i, info := range out {
+		addr := info.IP
+		if addr == "" {
+			// 链路本地地址要带网卡名才能连接，只用全局地址
+			for _, ip := range info.IPv6 {
+				if !net.ParseIP(ip).IsLinkLocalUnicast() {
+					addr = ip
+					break
+				}
+			}
+		}
+		if addr == "" {
+			continue
+		}
+		for _, port := range opts.Ports {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				break FOR
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				p, ok := probePort(ctx, addr, port, opts)
+				if !ok {
+					return
+				}
+				mu.Lock()
+				found[i] = append(found[i], p)
+				mu.Unlock()
+			}()
+		}
+	}
+	wg.Wait()
+
+	for i, ports := range found {
+		for _, p := range ports {
+			out[i].addPort(p)
+		}
+		sort.Slice(out[i].Ports, func(a, b int) bool { return out[i].Ports[a].Port < out[i].Ports[b].Port })
+	}
+	return out, ctx.Err()
+}
+
+// probePort 连接端口，连上后读取banner
+func probePort(ctx context.Context, host string, port int, opts PortOptions) (Port, bool) {
+	d := net.Dialer{Timeout: opts.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return Port{}, false
+	}
+	defer conn.Close()
+	p := Port{Port: port}
+	if opts.BannerTimeout > 0 {
+		p.Service, p.Banner = grabBanner(conn, port, opts.BannerTimeout)
+	}
+	if p.Service == "" {
+		p.Service = wellKnown[port]
+	}
+	return p, true
+}
+
+// grabBanner 先等服务端主动发送(ssh、ftp、smtp、mysql)，没有数据再按端口发送探测请求
+func grabBanner(conn net.Conn, port int, timeout time.Duration) (service, banner string) {
+	deadline := time.Now().Add(timeout)
+	// 等待的时间占一半，留一半给探测请求
+	conn.SetReadDeadline(time.Now().Add(timeout / 2))
+	buf := make([]byte, maxBanner)
+	n, err := io.ReadAtLeast(conn, buf, 1)
+	if n == 0 && isTimeout(err) {
+		conn.SetDeadline(deadline)
+		if _, err := conn.Write(probeFor(port)); err != nil {
+			return "", ""
+		}
+		n, _ = io.ReadAtLeast(conn, buf, 1)
+	}
+	if n == 0 {
+		return "", ""
+	}
+	return classify(buf[:n], port)
+}
+
+// probeFor 客户端先发数据的服务用的探测请求
+func probeFor(port int) []byte {
+	switch port {
+	case 6379:
+		return []byte("PING\r\n")
+	default:
+		return []byte("HEAD / HTTP/1.0\r\n\r\n")
+	}
+}
+
+// classify 按banner识别服务，返回服务名和整理后的banner
+func classify(b []byte, port int) (service, banner string) {
+	switch {
+	case bytes.HasPrefix(b, []byte("SSH-")):
+		return "ssh", firstLine(b)
+	case bytes.HasPrefix(b, []byte("HTTP/")):
+		banner = firstLine(b)
+		if server := httpHeader(b, "Server"); server != "" {
+			banner += " " + server
+		}
+		return "http", banner
+	case bytes.HasPrefix(b, []byte("+PONG")), bytes.HasPrefix(b, []byte("-NOAUTH")),
+		bytes.HasPrefix(b, []byte("-DENIED")), port == 6379 && bytes.HasPrefix(b, []byte("-ERR")):
+		return "redis", firstLine(b)
+	case bytes.HasPrefix(b, []byte("RFB ")):
+		return "vnc", firstLine(b)
+	case bytes.HasPrefix(b, []byte("+OK")):
+		return "pop3", firstLine(b)
+	case bytes.HasPrefix(b, []byte("* OK")):
+		return "imap", firstLine(b)
+	case bytes.HasPrefix(b, []byte("220")):
+		line := firstLine(b)
+		upper := strings.ToUpper(line)
+		switch {
+		case strings.Contains(upper, "FTP"):
+			return "ftp", line
+		case strings.Contains(upper, "SMTP"), strings.Contains(upper, "MAIL"):
+			return "smtp", line
+		}
+		if port == 21 {
+			return "ftp", line
+		}
+		return "smtp", line
+	case isMySQLHandshake(b):
+		// 3字节长度，1字节序号，协议版本10，之后是以0结尾的版本号
+		version, _, _ := bytes.Cut(b[5:], []byte{0})
+		return "mysql", "MySQL " + printable(version)
+	case len(b) > 2 && b[0] == 0x15 && b[1] == 0x03:
+		// 对HTTP探测回了TLS alert
+		return "tls", ""
+	}
+	return "", printable(b)
+}
+
+func isMySQLHandshake(b []byte) bool {
+	if len(b) < 6 || b[4] != 0x0a {
+		return false
+	}
+	n := int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+	return n > 0 && n+4 >= len(b) && b[3] == 0
+}
+
+func firstLine(b []byte) string {
+	line, _, _ := bytes.Cut(b, []byte("\n"))
+	return printable(bytes.TrimRight(line, "\r"))
+}
+
+func httpHeader(b []byte, name string) string {
+	for _, line := range strings.Split(string(b), "\n") {
+		k, v, ok := strings.Cut(strings.TrimRight(line, "\r"), ":")
+		if ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return printable([]byte(strings.TrimSpace(v)))
+		}
+	}
+	return ""
+}
+
+// printable 去掉不可见字符
+func printable(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		if c >= 0x20 && c < 0x7f {
+			s.WriteByte(c)
+		} else if c == '\n' || c == '\t' {
+			s.WriteByte(' ')
+		}
+	}
+	return strings.TrimSpace(s.String())
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,144 @@
+package lanscan
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeService 在本地端口上模拟一个服务，handle处理每个连接
+func fakeService(t *testing.T, handle func(conn net.Conn)) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestScanPorts(t *testing.T) {
+	sshPort := fakeService(t, func(conn net.Conn) {
+		conn.Write([]byte("SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13\r\n"))
+		time.Sleep(100 * time.Millisecond)
+	})
+	httpPort := fakeService(t, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if strings.HasPrefix(line, "HEAD / HTTP/1.0") {
+			conn.Write([]byte("HTTP/1.0 200 OK\r\nServer: nginx/1.24.0\r\nContent-Length: 0\r\n\r\n"))
+		}
+	})
+	// 没有数据时发的是http探测，redis会回错误
+	redisPort := fakeService(t, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if strings.HasPrefix(line, "PING") {
+			conn.Write([]byte("+PONG\r\n"))
+			return
+		}
+		conn.Write([]byte("-ERR unknown command 'HEAD'\r\n"))
+	})
+	silentPort := fakeService(t, func(conn net.Conn) {
+		time.Sleep(time.Second)
+	})
+	// 关闭的端口
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	opts := PortOptions{
+		Ports:         []int{closedPort, silentPort, redisPort, httpPort, sshPort},
+		BannerTimeout: 400 * time.Millisecond,
+	}
+	infos, err := NewScanner().ScanPorts(context.Background(), []LanIpInfo{{IP: "127.0.0.1"}, {}}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ports := make(map[int]Port)
+	for _, p := range infos[0].Ports {
+		ports[p.Port] = p
+	}
+	if len(ports) != 4 {
+		t.Fatalf("应该有4个开放端口: %+v", infos[0].Ports)
+	}
+	if _, ok := ports[closedPort]; ok {
+		t.Errorf("关闭的端口不应该在结果里")
+	}
+	if p := ports[sshPort]; p.Service != "ssh" || p.Banner != "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13" {
+		t.Errorf("ssh识别错误: %+v", p)
+	}
+	if p := ports[httpPort]; p.Service != "http" || p.Banner != "HTTP/1.0 200 OK nginx/1.24.0" {
+		t.Errorf("http识别错误: %+v", p)
+	}
+	// 随机端口上的redis用http探测，按错误回复识别不出，只有banner
+	if p := ports[redisPort]; p.Banner != "-ERR unknown command 'HEAD'" {
+		t.Errorf("redis banner错误: %+v", p)
+	}
+	if p := ports[silentPort]; p.Banner != "" {
+		t.Errorf("不返回数据的服务不应该有banner: %+v", p)
+	}
+	for i := 1; i < len(infos[0].Ports); i++ {
+		if infos[0].Ports[i-1].Port > infos[0].Ports[i].Port {
+			t.Errorf("端口没有排序: %+v", infos[0].Ports)
+		}
+	}
+	if len(infos[1].Ports) != 0 {
+		t.Errorf("没有地址的主机不应该扫描")
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		banner  string
+		port    int
+		service string
+	}{
+		{"+PONG\r\n", 6379, "redis"},
+		{"-NOAUTH Authentication required.\r\n", 6379, "redis"},
+		{"220 (vsFTPd 3.0.5)\r\n", 21, "ftp"},
+		{"220 mail.example.com ESMTP Postfix\r\n", 25, "smtp"},
+		{"RFB 003.008\n", 5900, "vnc"},
+		{"\x4a\x00\x00\x00\x0a8.0.36\x00\x08\x00\x00\x00", 3306, "mysql"},
+		{"\x15\x03\x01\x00\x02\x02\x46", 443, "tls"},
+		{"hello", 1234, ""},
+	}
+	for _, tt := range tests {
+		if service, _ := classify([]byte(tt.banner), tt.port); service != tt.service {
+			t.Errorf("%q 应该识别为%q，实际%q", tt.banner, tt.service, service)
+		}
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := ParsePorts("22, 80,8000-8002,80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{22, 80, 8000, 8001, 8002}
+	if len(ports) != len(want) {
+		t.Fatalf("端口错误: %v", ports)
+	}
+	for i, p := range want {
+		if ports[i] != p {
+			t.Errorf("端口错误: %v", ports)
+		}
+	}
+	for _, s := range []string{"0", "70000", "ssh", "90-80"} {
+		if _, err := ParsePorts(s); err == nil {
+			t.Errorf("%s 应该返回错误", s)
+		}
+	}
+}
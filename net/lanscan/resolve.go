@@ -0,0 +1,141 @@
+package lanscan
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket"
+	"golang.org/x/net/ipv4"
+)
+
+// 主机名解析：mdns、nbns和llmnr，以及SSDP设备发现
+// 查询用普通udp socket发送，响应统一用pcap抓包解析，这样录制和回放也能覆盖
+
+// listenNames 从ps读取mdns、nbns、llmnr和ssdp报文，直到ctx结束或者数据源读完
+func listenNames(ctx context.Context, ps *gopacket.PacketSource, info chan<- LanIpInfo) error {
+	return listen(ctx, ps, parseNamePacket, info)
+}
+
+// parseNamePacket 按端口交给对应的解析器
+func parseNamePacket(p gopacket.Packet) []LanIpInfo {
+	if infos := parseMDNSPacket(p); len(infos) > 0 {
+		return infos
+	}
+	if infos := parseNBNSPacket(p); len(infos) > 0 {
+		return infos
+	}
+	if infos := parseSSDPPacket(p); len(infos) > 0 {
+		return infos
+	}
+	return parseLLMNRPacket(p)
+}
+
+// nameFilter 抓取名字解析响应的BPF过滤条件，都关闭时返回空
+func nameFilter(opts Options) string {
+	var ports []string
+	if !opts.DisableMDNS {
+		ports = append(ports, fmt.Sprintf("src port %d", mdnsPort))
+	}
+	if !opts.DisableNBNS {
+		ports = append(ports, fmt.Sprintf("src port %d", nbnsPort))
+	}
+	if !opts.DisableLLMNR {
+		ports = append(ports, fmt.Sprintf("src port %d", llmnrPort))
+	}
+	if !opts.DisableSSDP {
+		// NOTIFY是发到1900端口的组播
+		ports = append(ports, fmt.Sprintf("port %d", ssdpPort))
+	}
+	if len(ports) == 0 {
+		return ""
+	}
+	return "udp and (" + strings.Join(ports, " or ") + ")"
+}
+
+// resolver 向主机发送名字解析查询
+type resolver struct {
+	conn  *net.UDPConn
+	mdns  bool
+	nbns  bool
+	llmnr bool
+	ssdp  bool
+}
+
+// newResolver 在网卡localIp上发送查询
+func newResolver(ifName string, localIp net.IP, opts Options) (*resolver, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: localIp})
+	if err != nil {
+		return nil, err
+	}
+	if ifi, err := net.InterfaceByName(ifName); err == nil {
+		// 组播查询从指定网卡发出
+		_ = ipv4.NewPacketConn(conn).SetMulticastInterface(ifi)
+	}
+	return &resolver{
+		conn:  conn,
+		mdns:  !opts.DisableMDNS,
+		nbns:  !opts.DisableNBNS,
+		llmnr: !opts.DisableLLMNR,
+		ssdp:  !opts.DisableSSDP,
+	}, nil
+}
+
+// browse 组播查询常见的mdns服务类型和所有的SSDP设备
+func (r *resolver) browse() error {
+	if r.mdns {
+		b, err := mdnsBrowseQuery()
+		if err != nil {
+			return err
+		}
+		if _, err := r.conn.WriteToUDP(b, mdnsGroup); err != nil {
+			return err
+		}
+	}
+	if r.ssdp {
+		if _, err := r.conn.WriteToUDP(ssdpSearchRequest(), ssdpGroup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryHost 向ip单播发送mdns反向查询、nbns节点状态查询和llmnr反向查询
+// 源端口不是5353，按RFC 6762 6.7对方会单播回复到这个端口
+func (r *resolver) queryHost(ip string) error {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil
+	}
+	if r.mdns {
+		b, err := reverseQuery(0, addr)
+		if err != nil {
+			return err
+		}
+		if _, err := r.conn.WriteToUDP(b, &net.UDPAddr{IP: addr, Port: mdnsPort}); err != nil {
+			return err
+		}
+	}
+	if r.nbns {
+		b := nbnsStatusQuery(uint16(rand.Uint32()))
+		if _, err := r.conn.WriteToUDP(b, &net.UDPAddr{IP: addr, Port: nbnsPort}); err != nil {
+			return err
+		}
+	}
+	if r.llmnr {
+		b, err := reverseQuery(uint16(rand.Uint32()), addr)
+		if err != nil {
+			return err
+		}
+		if _, err := r.conn.WriteToUDP(b, &net.UDPAddr{IP: addr, Port: llmnrPort}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,863 @@
+// Package lanscan 局域网主机发现
+//
+// 向网段内每个IP发ARP请求，从ARP响应得到IP和MAC，MAC前缀查询制造商
+// 源项目：https://github.com/timest/goscan
+package lanscan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// https://haydz.github.io/2020/07/06/Go-Windows-NIC.html
+// https://github.com/google/gopacket/issues/456
+
+// 网卡信息
+type NetInterface struct {
+	// 名称
+	Name string
+	// pcap设备名，linux和网卡名一样，windows是\Device\NPF_{GUID}
+	Device string
+	// MAC地址
+	HardAddr net.HardwareAddr
+	// 本机ip，网卡没有IPv4地址时为nil
+	LocalIP net.IP
+	// 网段内网ip列表
+	LanIPs []IP
+	// 本机IPv6地址，一个网卡有多个IPv4地址时只放在第一条里
+	LocalIPv6 []net.IP
+}
+
+// 内网ip信息
+type LanIpInfo struct {
+	// IPv4地址，只从IPv6发现的主机为空
+	IP string
+	// IPv6地址，同一个mac的地址合并到一起
+	IPv6 []string
+	// IP Mac地址
+	Mac net.HardwareAddr
+	// 主机名，来自mdns或者llmnr，都没有时用NetBIOS计算机名
+	Hostname string
+	// NetBIOS计算机名
+	NetBIOSName string
+	// NetBIOS工作组或域
+	Workgroup string
+	// 制造商
+	Manufacturer string
+	// mDNS广播的服务
+	Services []Service
+	// 开放的TCP端口
+	Ports []Port
+	// SSDP发现的UPnP设备
+	UPnP []UPnPDevice
+}
+
+// merge 用other里非空的字段更新info，返回是否有变化
+func (info *LanIpInfo) merge(other LanIpInfo) bool {
+	changed := false
+	if len(other.Mac) > 0 && !bytes.Equal(info.Mac, other.Mac) {
+		info.Mac = other.Mac
+		changed = true
+	}
+	if other.Hostname != "" && info.Hostname != other.Hostname {
+		info.Hostname = other.Hostname
+		changed = true
+	}
+	if other.Manufacturer != "" && info.Manufacturer != other.Manufacturer {
+		info.Manufacturer = other.Manufacturer
+		changed = true
+	}
+	if other.NetBIOSName != "" && info.NetBIOSName != other.NetBIOSName {
+		info.NetBIOSName = other.NetBIOSName
+		changed = true
+	}
+	if other.Workgroup != "" && info.Workgroup != other.Workgroup {
+		info.Workgroup = other.Workgroup
+		changed = true
+	}
+	if info.Hostname == "" && info.NetBIOSName != "" {
+		info.Hostname = info.NetBIOSName
+		changed = true
+	}
+	for _, ip := range other.IPv6 {
+		if !slices.Contains(info.IPv6, ip) {
+			info.IPv6 = append(slices.Clip(info.IPv6), ip)
+			changed = true
+		}
+	}
+	for _, svc := range other.Services {
+		if info.addService(svc) {
+			changed = true
+		}
+	}
+	for _, p := range other.Ports {
+		if info.addPort(p) {
+			changed = true
+		}
+	}
+	for _, dev := range other.UPnP {
+		if info.addUPnP(dev) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// addUPnP 同一个描述文件地址只保留一条，新的空字段沿用旧值，返回是否有变化
+func (info *LanIpInfo) addUPnP(dev UPnPDevice) bool {
+	for i, old := range info.UPnP {
+		if old.Location != dev.Location {
+			continue
+		}
+		merged := old
+		for _, f := range []struct {
+			dst *string
+			src string
+		}{
+			{&merged.UUID, dev.UUID},
+			{&merged.Server, dev.Server},
+			{&merged.DeviceType, dev.DeviceType},
+			{&merged.FriendlyName, dev.FriendlyName},
+			{&merged.Manufacturer, dev.Manufacturer},
+			{&merged.ModelName, dev.ModelName},
+			{&merged.ModelNumber, dev.ModelNumber},
+		} {
+			if f.src != "" {
+				*f.dst = f.src
+			}
+		}
+		if merged == old {
+			return false
+		}
+		info.UPnP = slices.Clone(info.UPnP)
+		info.UPnP[i] = merged
+		return true
+	}
+	info.UPnP = append(slices.Clip(info.UPnP), dev)
+	return true
+}
+
+// addPort 同一个端口只保留一条，返回是否有变化
+func (info *LanIpInfo) addPort(p Port) bool {
+	for i, old := range info.Ports {
+		if old.Port != p.Port {
+			continue
+		}
+		if old == p {
+			return false
+		}
+		info.Ports = slices.Clone(info.Ports)
+		info.Ports[i] = p
+		return true
+	}
+	info.Ports = append(slices.Clip(info.Ports), p)
+	return true
+}
+
+// addService 同一个实例只保留一条，返回是否有变化
+func (info *LanIpInfo) addService(svc Service) bool {
+	for i, old := range info.Services {
+		if old.Type != svc.Type || old.Instance != svc.Instance {
+			continue
+		}
+		if svc.Port == 0 {
+			svc.Port = old.Port
+		}
+		if svc.TXT == nil {
+			svc.TXT = old.TXT
+		}
+		if svc.Port == old.Port && maps.Equal(svc.TXT, old.TXT) {
+			return false
+		}
+		info.Services = slices.Clone(info.Services)
+		info.Services[i] = svc
+		return true
+	}
+	// 复制一份，避免和其他记录共用底层数组
+	info.Services = append(slices.Clip(info.Services), svc)
+	return true
+}
+
+var ErrNoInterface = errors.New("lanscan: network interface is empty")
+
+const (
+	DefaultIdle      = 2 * time.Second
+	DefaultRate      = 2000
+	DefaultRetries   = 2
+	DefaultRetryWait = time.Second
+)
+
+// Options 扫描参数
+type Options struct {
+	// Interface 要扫描的网卡名，为空时扫描所有有IPv4地址的网卡
+	Interface string
+	// Timeout 发现主机的总时长上限，不包括端口扫描，0表示不限制，到时间正常结束
+	Timeout time.Duration
+	// Idle 请求发送完后，超过这个时间没有新的响应就认为扫描结束，默认2秒
+	Idle time.Duration
+	// Rate 每个网卡每秒最多发送的arp包数量，默认2000，小于0不限速
+	Rate int
+	// Retries 第一轮发送完后，对没有响应的IP重发的轮数，默认2，小于0不重发
+	Retries int
+	// RetryWait 每轮发送完后等待响应的时间，默认1秒
+	RetryWait time.Duration
+	// DisableIPv6 不做IPv6邻居发现
+	DisableIPv6 bool
+	// DisableMDNS 不发送mdns查询，也不解析mdns响应
+	DisableMDNS bool
+	// DisableNBNS 不发送NetBIOS节点状态查询，也不解析响应
+	DisableNBNS bool
+	// DisableLLMNR 不发送LLMNR反向查询，也不解析响应
+	DisableLLMNR bool
+	// DisableSSDP 不发送SSDP查询，不解析SSDP报文，也不获取UPnP设备描述
+	DisableSSDP bool
+	// Passive 被动模式，只抓包不发送任何数据包，Timeout是监听时长，默认DefaultPassiveWindow
+	// 被动模式下忽略Ports、Rate和重发参数
+	Passive bool
+	// Ports 不为nil时发现主机后扫描TCP端口
+	Ports *PortOptions
+	// Record 不为nil时把实时扫描抓到的数据包以pcap格式写入，可以用Replay回放
+	Record io.Writer
+	// Results 每发现一个主机或者主机信息有更新时发送到这个channel，可以为nil
+	// Scan返回前不会关闭，调用方需要及时读取
+	Results chan<- LanIpInfo
+}
+
+func (opts *Options) setDefaults() {
+	if opts.Idle <= 0 {
+		opts.Idle = DefaultIdle
+	}
+	if opts.Rate == 0 {
+		opts.Rate = DefaultRate
+	}
+	if opts.Retries == 0 {
+		opts.Retries = DefaultRetries
+	} else if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.RetryWait <= 0 {
+		opts.RetryWait = DefaultRetryWait
+	}
+}
+
+// Scanner 局域网扫描器
+type Scanner struct{}
+
+func NewScanner() *Scanner {
+	return &Scanner{}
+}
+
+// Interfaces 可以扫描的网卡，name为空时返回所有有IPv4或IPv6地址的非回环网卡
+func Interfaces(name string) ([]NetInterface, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var nci []NetInterface
+	for _, it := range ifs {
+		if name != "" && it.Name != name {
+			continue
+		}
+		addrs, err := it.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		first := len(nci)
+		var ipv6 []net.IP
+		for _, addr := range addrs {
+			ip, ok := addr.(*net.IPNet)
+			if !ok || ip.IP.IsLoopback() {
+				continue
+			}
+			if ip.IP.To4() == nil {
+				ipv6 = append(ipv6, ip.IP)
+				continue
+			}
+			device := it.Name
+			if runtime.GOOS == "windows" {
+				device = findDevice(ip.IP)
+				if device == "" {
+					continue
+				}
+			}
+			nci = append(nci, NetInterface{
+				Name:     it.Name,
+				Device:   device,
+				HardAddr: it.HardwareAddr,
+				LocalIP:  ip.IP,
+				LanIPs:   listLanIps(ip),
+			})
+		}
+		if len(ipv6) == 0 || len(it.HardwareAddr) == 0 {
+			continue
+		}
+		if first < len(nci) {
+			nci[first].LocalIPv6 = ipv6
+			continue
+		}
+		// 只有IPv6地址的网卡
+		device := it.Name
+		if runtime.GOOS == "windows" {
+			if device = findDevice(ipv6[0]); device == "" {
+				continue
+			}
+		}
+		nci = append(nci, NetInterface{
+			Name:      it.Name,
+			Device:    device,
+			HardAddr:  it.HardwareAddr,
+			LocalIPv6: ipv6,
+		})
+	}
+	if len(nci) == 0 {
+		return nil, ErrNoInterface
+	}
+	return nci, nil
+}
+
+// Scan 扫描局域网，返回发现的主机，按IP排序
+// 请求发送完并且空闲超过Idle、或者到了Timeout时发现结束，设置了Ports时再扫描端口
+// ctx取消时返回已经发现的主机和ctx的错误
+func (s *Scanner) Scan(ctx context.Context, opts Options) ([]LanIpInfo, error) {
+	opts.setDefaults()
+	if opts.Passive {
+		return s.sniff(ctx, opts)
+	}
+	infos, err := s.discover(ctx, opts)
+	if err != nil || opts.Ports == nil {
+		return infos, err
+	}
+	infos, err = s.ScanPorts(ctx, infos, *opts.Ports)
+	if opts.Results != nil {
+		for _, info := range infos {
+			if len(info.Ports) == 0 {
+				continue
+			}
+			select {
+			case opts.Results <- info:
+			case <-ctx.Done():
+				return infos, ctx.Err()
+			}
+		}
+	}
+	return infos, err
+}
+
+// discover 发现主机
+func (s *Scanner) discover(ctx context.Context, opts Options) ([]LanIpInfo, error) {
+	nci, err := Interfaces(opts.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	// 每个pcap设备一个arp句柄，收发共用，另外一个句柄抓名字解析的响应
+	devices := groupByDevice(nci)
+	var handles []*pcap.Handle
+	// 最后关闭，抓包的goroutine退出后才能关
+	defer func() {
+		for _, h := range handles {
+			h.Close()
+		}
+	}()
+	filter := nameFilter(opts)
+	arpHandles := make([]*pcap.Handle, len(devices))
+	nameHandles := make([]*pcap.Handle, len(devices))
+	ndpSenders := make([]*ndpSender, len(devices))
+	ndpHandles := make([]*pcap.Handle, len(devices))
+	for i, d := range devices {
+		if local := d.localIPv6(); len(local) > 0 && !opts.DisableIPv6 {
+			if ndpHandles[i], err = openLive(d.device, "icmp6"); err != nil {
+				return nil, err
+			}
+			handles = append(handles, ndpHandles[i])
+			ndpSenders[i] = newNdpSender(ndpHandles[i], d.nci[0].HardAddr, local)
+		}
+		if arpHandles[i], err = openLive(d.device, "arp"); err != nil {
+			return nil, err
+		}
+		handles = append(handles, arpHandles[i])
+		if filter == "" {
+			continue
+		}
+		if nameHandles[i], err = openLive(d.device, filter); err != nil {
+			return nil, err
+		}
+		handles = append(handles, nameHandles[i])
+	}
+	packetSource, err := newPacketSources(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// 名字解析查询，每个地址一个
+	var resolvers []*resolver
+	defer func() {
+		for _, r := range resolvers {
+			if r != nil {
+				r.Close()
+			}
+		}
+	}()
+	if filter != "" {
+		for _, it := range nci {
+			if it.LocalIP == nil {
+				resolvers = append(resolvers, nil)
+				continue
+			}
+			r, err := newResolver(it.Name, it.LocalIP, opts)
+			if err != nil {
+				return nil, err
+			}
+			resolvers = append(resolvers, r)
+		}
+	}
+
+	parent := ctx
+	var cancel context.CancelFunc
+	if opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	var wg sync.WaitGroup
+	// 先取消再等待，保证抓包的goroutine都退出了
+	defer wg.Wait()
+	defer cancel()
+
+	res := newResult()
+	found := make(chan LanIpInfo, 64)
+	errc := make(chan error, 5*len(devices))
+
+	// 接收arp、ndp和名字解析的响应
+	for i, d := range devices {
+		if ndpHandles[i] != nil {
+			slog.Debug(fmt.Sprintf("listen ndp package: %s", d.device))
+			ps := packetSource(ndpHandles[i])
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := listenNDP(ctx, ps, found); err != nil {
+					errc <- err
+				}
+			}()
+		}
+		slog.Debug(fmt.Sprintf("listen arp package: %s", d.device))
+		ps := packetSource(arpHandles[i])
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := listenARP(ctx, ps, false, found); err != nil {
+				errc <- err
+			}
+		}()
+		if nameHandles[i] == nil {
+			continue
+		}
+		slog.Debug(fmt.Sprintf("listen name package: %s", d.device))
+		nps := packetSource(nameHandles[i])
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := listenNames(ctx, nps, found); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	for _, r := range resolvers {
+		if r == nil {
+			continue
+		}
+		if err := r.browse(); err != nil {
+			// 组播不通不影响arp扫描
+			slog.Debug(fmt.Sprintf("browse: %s", err))
+		}
+	}
+
+	// 发送arp包，每个设备一个goroutine
+	sendDone := make(chan struct{})
+	var sendWg sync.WaitGroup
+	for i, d := range devices {
+		sendWg.Add(1)
+		go func() {
+			defer sendWg.Done()
+			if err := s.sendARP(ctx, arpHandles[i], d.nci, opts, res.has); err != nil {
+				errc <- err
+			}
+		}()
+		if ndpSenders[i] == nil {
+			continue
+		}
+		sendWg.Add(1)
+		go func() {
+			defer sendWg.Done()
+			if err := s.sendNDP(ctx, ndpSenders[i], opts); err != nil {
+				errc <- err
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sendWg.Wait()
+		close(sendDone)
+	}()
+
+	// 新发现的主机单播查询主机名
+	queried := make(map[string]bool)
+	queryHost := func(ip string) {
+		if len(resolvers) == 0 || queried[ip] {
+			return
+		}
+		queried[ip] = true
+		target := ParseIPString(ip)
+		for i, it := range nci {
+			if len(it.LanIPs) == 0 || target < it.LanIPs[0] || target > it.LanIPs[len(it.LanIPs)-1] {
+				continue
+			}
+			if err := resolvers[i].queryHost(ip); err != nil {
+				slog.Debug(fmt.Sprintf("name query %s: %s", ip, err))
+			}
+			return
+		}
+	}
+
+	// 新发现的IPv6地址发送邻居请求，从邻居通告确认mac
+	solicited := make(map[string]bool)
+	solicit := func(addrs []string) {
+		for _, addr := range addrs {
+			if solicited[addr] {
+				continue
+			}
+			solicited[addr] = true
+			for _, sender := range ndpSenders {
+				if sender == nil {
+					continue
+				}
+				if err := sender.solicit(net.ParseIP(addr)); err != nil {
+					slog.Debug(fmt.Sprintf("ndp solicit %s: %s", addr, err))
+				}
+			}
+		}
+	}
+	// UPnP设备获取描述文件，结果和其他响应一样合并
+	// 获取中的描述文件没有结束前不算空闲
+	described := make(map[string]bool)
+	var describing atomic.Int32
+	describe := func(info LanIpInfo) {
+		for _, dev := range info.UPnP {
+			if described[dev.Location] || !describable(info.IP, dev) {
+				continue
+			}
+			described[dev.Location] = true
+			describing.Add(1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer describing.Add(-1)
+				dev, err := fetchDescription(ctx, descClient, dev)
+				if err != nil {
+					slog.Debug(fmt.Sprintf("upnp description %s: %s", dev.Location, err))
+					return
+				}
+				select {
+				case found <- LanIpInfo{IP: info.IP, UPnP: []UPnPDevice{dev}}:
+				case <-ctx.Done():
+				}
+			}()
+		}
+	}
+
+	// 本机发出的邻居请求也会被抓到，忽略本机的mac
+	localMACs := make(map[string]bool)
+	for _, it := range nci {
+		localMACs[it.HardAddr.String()] = true
+	}
+
+	sending := sendDone
+	ticker := time.NewTicker(opts.Idle / 10)
+	defer ticker.Stop()
+	receiveTime := time.Now()
+	for {
+		select {
+		case info := <-found:
+			if len(info.Mac) > 0 && localMACs[info.Mac.String()] {
+				continue
+			}
+			receiveTime = time.Now()
+			if len(info.Mac) > 0 && info.IP != "" {
+				queryHost(info.IP)
+			}
+			solicit(info.IPv6)
+			describe(info)
+			if updated, ok := res.add(info); ok && opts.Results != nil {
+				select {
+				case opts.Results <- updated:
+				case <-ctx.Done():
+				}
+			}
+		case err := <-errc:
+			return res.list(), err
+		case <-sending:
+			slog.Debug("send arp and ndp over")
+			sending = nil
+			receiveTime = time.Now()
+		case <-ticker.C:
+			if sending == nil && describing.Load() == 0 && time.Since(receiveTime) > opts.Idle {
+				return res.list(), nil
+			}
+		case <-ctx.Done():
+			// 到了Timeout是正常结束，调用方取消才返回错误
+			return res.list(), parent.Err()
+		}
+	}
+}
+
+// device 一个pcap设备和它上面的地址
+type device struct {
+	device string
+	nci    []NetInterface
+}
+
+func (d device) localIPv6() []net.IP {
+	var ips []net.IP
+	for _, it := range d.nci {
+		ips = append(ips, it.LocalIPv6...)
+	}
+	return ips
+}
+
+func groupByDevice(nci []NetInterface) []device {
+	var devices []device
+	idx := make(map[string]int)
+	for _, it := range nci {
+		i, ok := idx[it.Device]
+		if !ok {
+			i = len(devices)
+			idx[it.Device] = i
+			devices = append(devices, device{device: it.Device})
+		}
+		devices[i].nci = append(devices[i].nci, it)
+	}
+	return devices
+}
+
+const snaplen = 65536
+
+// descClient 获取UPnP描述文件，局域网地址不走代理，不跟随重定向
+var descClient = &http.Client{
+	Transport: &http.Transport{},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// openLive 打开网卡，只抓filter过滤后的包
+func openLive(device, filter string) (*pcap.Handle, error) {
+	handle, err := pcap.OpenLive(device, snaplen, false, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("pcap打开失败: %w", err)
+	}
+	if err := handle.SetBPFFilter(filter); err != nil {
+		handle.Close()
+		return nil, err
+	}
+	return handle, nil
+}
+
+// sendARP 向网段内的IP发送arp请求
+// 第一轮发给所有IP，之后每轮只发给还没有响应的IP
+func (s *Scanner) sendARP(ctx context.Context, w packetWriter, nci []NetInterface, opts Options, seen func(ip string) bool) error {
+	senders := make([]*arpSender, len(nci))
+	for i, it := range nci {
+		if it.LocalIP == nil {
+			// 只有IPv6地址的网卡不发arp
+			continue
+		}
+		sender, err := newArpSender(w, it.LocalIP, it.HardAddr)
+		if err != nil {
+			return err
+		}
+		senders[i] = sender
+	}
+	limiter := newRateLimiter(opts.Rate)
+	for round := 0; round <= opts.Retries; round++ {
+		if round > 0 {
+			slog.Debug(fmt.Sprintf("retry arp round %d", round))
+		}
+		for i, it := range nci {
+			if senders[i] == nil {
+				continue
+			}
+			slog.Debug(fmt.Sprintf("send arp package, interface name: %s", it.Name))
+			local := ParseIP(it.LocalIP.To4())
+			for _, ip := range it.LanIPs {
+				if ip == local {
+					// 略过本机ip
+					continue
+				}
+				if round > 0 && seen(ip.String()) {
+					continue
+				}
+				if !limiter.wait(ctx) {
+					return nil
+				}
+				if err := senders[i].send(ip); err != nil {
+					return err
+				}
+			}
+		}
+		if round < opts.Retries {
+			t := time.NewTimer(opts.RetryWait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// sendNDP 每轮向ff02::1发送一次组播ping，轮数和arp的重试一样
+func (s *Scanner) sendNDP(ctx context.Context, sender *ndpSender, opts Options) error {
+	for round := 0; round <= opts.Retries; round++ {
+		if err := sender.ping(); err != nil {
+			return err
+		}
+		if round < opts.Retries {
+			t := time.NewTimer(opts.RetryWait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// result 一次扫描的结果，同一个IP的多个来源合并成一条
+type result struct {
+	mu    sync.Mutex
+	infos map[string]*LanIpInfo
+	// mac -> infos的key，用来把同一个主机的IPv4和IPv6合并
+	byMAC map[string]string
+}
+
+func newResult() *result {
+	return &result{
+		infos: make(map[string]*LanIpInfo),
+		byMAC: make(map[string]string),
+	}
+}
+
+// add 合并一条信息，返回合并后的记录和是否是新记录或有更新
+// 有IPv4地址的记录用IP做key，只有IPv6地址的记录按mac合并
+func (r *result) add(info LanIpInfo) (LanIpInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mac := info.Mac.String()
+	key := info.IP
+	moved := false
+	if key == "" {
+		if len(info.Mac) == 0 {
+			// 没有地址也没有mac，无法归到哪个主机
+			return info, false
+		}
+		if key = r.byMAC[mac]; key == "" {
+			key = "mac " + mac
+		}
+	} else if _, ok := r.infos[key]; !ok && len(info.Mac) > 0 {
+		// 之前只从IPv6发现的主机，拿到IPv4地址后改用IP做key
+		if k, ok := r.byMAC[mac]; ok && r.infos[k].IP == "" {
+			old := r.infos[k]
+			delete(r.infos, k)
+			old.IP = info.IP
+			r.infos[key] = old
+			moved = true
+		}
+	}
+	old, ok := r.infos[key]
+	if !ok {
+		old = &LanIpInfo{IP: info.IP}
+		r.infos[key] = old
+	}
+	changed := old.merge(info)
+	if len(old.Mac) > 0 {
+		m := old.Mac.String()
+		if k, ok := r.byMAC[m]; !ok || r.infos[k] == nil {
+			r.byMAC[m] = key
+		}
+	}
+	return *old, changed || moved || !ok
+}
+
+// has ip是否已经有响应
+func (r *result) has(ip string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.infos[ip]
+	return ok
+}
+
+// list 按IPv4地址排序，只有IPv6地址的主机排在后面
+func (r *result) list() []LanIpInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]LanIpInfo, 0, len(r.infos))
+	for _, info := range r.infos {
+		list = append(list, *info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if (a.IP == "") != (b.IP == "") {
+			return a.IP != ""
+		}
+		if a.IP != "" {
+			return ParseIPString(a.IP) < ParseIPString(b.IP)
+		}
+		return firstIPv6(a) < firstIPv6(b)
+	})
+	return list
+}
+
+func firstIPv6(info LanIpInfo) string {
+	if len(info.IPv6) == 0 {
+		return ""
+	}
+	return info.IPv6[0]
+}
+
+func findDevice(ip net.IP) string {
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		slog.Error(err.Error())
+		return ""
+	}
+	for _, d := range devices {
+		for _, address := range d.Addresses {
+			if address.IP.Equal(ip) {
+				return d.Name
+			}
+
+		}
+	}
+	return ""
+}
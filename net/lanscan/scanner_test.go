@@ -0,0 +1,33 @@
+package lanscan
+
+import (
+	"net"
+	"testing"
+)
+
+func TestResultMerge(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	r := newResult()
+	if _, ok := r.add(LanIpInfo{IP: "192.168.1.20", Mac: mac}); !ok {
+		t.Errorf("新主机应该返回有更新")
+	}
+	if _, ok := r.add(LanIpInfo{IP: "192.168.1.20", Mac: mac}); ok {
+		t.Errorf("重复信息不应该返回有更新")
+	}
+	info, ok := r.add(LanIpInfo{IP: "192.168.1.20", Hostname: "nas"})
+	if !ok || info.Hostname != "nas" || info.Mac.String() != mac.String() {
+		t.Errorf("合并结果错误: %+v", info)
+	}
+	r.add(LanIpInfo{IP: "192.168.1.3"})
+	r.add(LanIpInfo{IP: "10.0.0.1"})
+	list := r.list()
+	want := []string{"10.0.0.1", "192.168.1.3", "192.168.1.20"}
+	if len(list) != len(want) {
+		t.Fatalf("结果数量错误: %d", len(list))
+	}
+	for i, ip := range want {
+		if list[i].IP != ip {
+			t.Errorf("第%d个应该是%s，实际%s", i, ip, list[i].IP)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package lanscan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// SSDP/UPnP设备发现
+// 组播M-SEARCH的响应单播回到发送端口，设备上线下线的NOTIFY组播到1900端口
+// 两种报文都用pcap抓包解析，得到描述文件地址后用http获取设备名称和型号
+
+const ssdpPort = 1900
+
+var ssdpGroup = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: ssdpPort}
+
+const (
+	// ssdpMX 设备在0到MX秒之间随机延迟响应
+	ssdpMX = 2
+	// descTimeout 获取设备描述文件的超时时间
+	descTimeout = 3 * time.Second
+	// descMaxSize 描述文件大小上限
+	descMaxSize = 1 << 20
+)
+
+// UPnPDevice SSDP发现的UPnP设备，一个主机可以有多个根设备
+type UPnPDevice struct {
+	// Location 设备描述文件地址
+	Location string
+	// UUID 设备唯一标识，来自USN
+	UUID string
+	// Server 响应头里的操作系统和UPnP版本
+	Server string
+	// 以下字段来自描述文件
+	DeviceType   string
+	FriendlyName string
+	Manufacturer string
+	ModelName    string
+	ModelNumber  string
+}
+
+// ssdpSearchRequest 查询所有设备和服务的M-SEARCH请求
+func ssdpSearchRequest() []byte {
+	return []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: 239.255.255.250:1900\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		fmt.Sprintf("MX: %d\r\n", ssdpMX) +
+		"ST: ssdp:all\r\n" +
+		"\r\n")
+}
+
+// parseSSDPPacket 解析源端口或目标端口是1900的udp包
+func parseSSDPPacket(p gopacket.Packet) []LanIpInfo {
+	udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	if !ok || (udp.SrcPort != ssdpPort && udp.DstPort != ssdpPort) {
+		return nil
+	}
+	ip, ok := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	if !ok {
+		return nil
+	}
+	if info, ok := parseSSDP(udp.Payload, ip.SrcIP); ok {
+		return []LanIpInfo{info}
+	}
+	return nil
+}
+
+// parseSSDP 解析M-SEARCH响应和ssdp:alive通知，src是报文的来源地址
+// M-SEARCH请求和ssdp:byebye通知忽略
+func parseSSDP(payload []byte, src net.IP) (LanIpInfo, bool) {
+	var header http.Header
+	r := bufio.NewReader(bytes.NewReader(payload))
+	switch {
+	case bytes.HasPrefix(payload, []byte("HTTP/")):
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return LanIpInfo{}, false
+		}
+		header = resp.Header
+	case bytes.HasPrefix(payload, []byte("NOTIFY ")):
+		req, err := http.ReadRequest(r)
+		if err != nil || req.Header.Get("NTS") != "ssdp:alive" {
+			return LanIpInfo{}, false
+		}
+		header = req.Header
+	default:
+		return LanIpInfo{}, false
+	}
+	location := header.Get("Location")
+	if location == "" || src.To4() == nil {
+		return LanIpInfo{}, false
+	}
+	return LanIpInfo{
+		IP: src.String(),
+		UPnP: []UPnPDevice{{
+			Location: location,
+			UUID:     usnUUID(header.Get("USN")),
+			Server:   header.Get("Server"),
+		}},
+	}, true
+}
+
+// usnUUID 从 uuid:xxx::urn:... 里取出uuid
+func usnUUID(usn string) string {
+	usn, _, _ = strings.Cut(usn, "::")
+	return strings.TrimPrefix(usn, "uuid:")
+}
+
+// deviceDescription UPnP设备描述文件，只解析需要的字段
+type deviceDescription struct {
+	Device struct {
+		DeviceType   string `xml:"deviceType"`
+		FriendlyName string `xml:"friendlyName"`
+		Manufacturer string `xml:"manufacturer"`
+		ModelName    string `xml:"modelName"`
+		ModelNumber  string `xml:"modelNumber"`
+		UDN          string `xml:"UDN"`
+	} `xml:"device"`
+}
+
+// parseDescription 用描述文件的内容补充dev
+func parseDescription(r io.Reader, dev UPnPDevice) (UPnPDevice, error) {
+	var desc deviceDescription
+	if err := xml.NewDecoder(io.LimitReader(r, descMaxSize)).Decode(&desc); err != nil {
+		return dev, fmt.Errorf("解析设备描述失败: %w", err)
+	}
+	d := desc.Device
+	dev.DeviceType = strings.TrimSpace(d.DeviceType)
+	dev.FriendlyName = strings.TrimSpace(d.FriendlyName)
+	dev.Manufacturer = strings.TrimSpace(d.Manufacturer)
+	dev.ModelName = strings.TrimSpace(d.ModelName)
+	dev.ModelNumber = strings.TrimSpace(d.ModelNumber)
+	if dev.UUID == "" {
+		dev.UUID = strings.TrimPrefix(strings.TrimSpace(d.UDN), "uuid:")
+	}
+	return dev, nil
+}
+
+// describable 描述文件地址是http并且指向报文的来源地址，才去获取
+// 避免伪造的通知让扫描器访问局域网外的地址
+func describable(ip string, dev UPnPDevice) bool {
+	u, err := url.Parse(dev.Location)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	return u.Hostname() == ip
+}
+
+// fetchDescription 获取并解析设备描述文件
+func fetchDescription(ctx context.Context, client *http.Client, dev UPnPDevice) (UPnPDevice, error) {
+	ctx, cancel := context.WithTimeout(ctx, descTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, dev.Location, nil)
+	if err != nil {
+		return dev, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return dev, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return dev, fmt.Errorf("获取设备描述失败: %s", resp.Status)
+	}
+	return parseDescription(resp.Body, dev)
+}
@@ -0,0 +1,122 @@
+package lanscan
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const routerDesc = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <specVersion><major>1</major><minor>0</minor></specVersion>
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <friendlyName> Home Router </friendlyName>
+    <manufacturer>ASUSTeK</manufacturer>
+    <modelName>RT-AX58U</modelName>
+    <modelNumber>3.0.0.4</modelNumber>
+    <UDN>uuid:824ff22b-8c7d-41c5-a131-44f534e12555</UDN>
+    <deviceList><device><friendlyName>WANDevice</friendlyName></device></deviceList>
+  </device>
+</root>`
+
+func TestParseSSDP(t *testing.T) {
+	src := net.ParseIP("192.168.1.1")
+	resp := "HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\nUSN: uuid:abc::upnp:rootdevice\r\nSERVER: miniupnpd/2.1\r\nLOCATION: http://192.168.1.1:5000/rootDesc.xml\r\n\r\n"
+	info, ok := parseSSDP([]byte(resp), src)
+	if !ok {
+		t.Fatal("M-SEARCH响应没有解析出来")
+	}
+	want := UPnPDevice{Location: "http://192.168.1.1:5000/rootDesc.xml", UUID: "abc", Server: "miniupnpd/2.1"}
+	if info.IP != "192.168.1.1" || len(info.UPnP) != 1 || info.UPnP[0] != want {
+		t.Errorf("解析结果 %+v", info)
+	}
+
+	for name, payload := range map[string]string{
+		"请求":         "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nST: ssdp:all\r\n\r\n",
+		"下线通知":       "NOTIFY * HTTP/1.1\r\nNTS: ssdp:byebye\r\nLOCATION: http://192.168.1.1/d.xml\r\n\r\n",
+		"没有LOCATION": "HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\n\r\n",
+		"错误状态":       "HTTP/1.1 404 Not Found\r\nLOCATION: http://192.168.1.1/d.xml\r\n\r\n",
+		"乱码":         "\x00\x01",
+	} {
+		if _, ok := parseSSDP([]byte(payload), src); ok {
+			t.Errorf("%s不应该解析出设备", name)
+		}
+	}
+}
+
+func TestDescribable(t *testing.T) {
+	tests := []struct {
+		location string
+		want     bool
+	}{
+		{"http://192.168.1.1:5000/rootDesc.xml", true},
+		{"http://192.168.1.9:5000/rootDesc.xml", false},
+		{"https://192.168.1.1/rootDesc.xml", false},
+		{"http://example.com/rootDesc.xml", false},
+		{"::bad", false},
+	}
+	for _, tt := range tests {
+		if got := describable("192.168.1.1", UPnPDevice{Location: tt.location}); got != tt.want {
+			t.Errorf("describable(%q) = %v, 期望 %v", tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestFetchDescription(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rootDesc.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(routerDesc))
+	}))
+	defer srv.Close()
+
+	dev, err := fetchDescription(context.Background(), descClient, UPnPDevice{Location: srv.URL + "/rootDesc.xml", Server: "miniupnpd/2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UPnPDevice{
+		Location:     srv.URL + "/rootDesc.xml",
+		UUID:         "824ff22b-8c7d-41c5-a131-44f534e12555",
+		Server:       "miniupnpd/2.1",
+		DeviceType:   "urn:schemas-upnp-org:device:InternetGatewayDevice:1",
+		FriendlyName: "Home Router",
+		Manufacturer: "ASUSTeK",
+		ModelName:    "RT-AX58U",
+		ModelNumber:  "3.0.0.4",
+	}
+	if dev != want {
+		t.Errorf("设备描述 %+v, 期望 %+v", dev, want)
+	}
+
+	if _, err := fetchDescription(context.Background(), descClient, UPnPDevice{Location: srv.URL + "/missing.xml"}); err == nil {
+		t.Error("404应该返回错误")
+	}
+	if _, err := parseDescription(strings.NewReader("<root><device>"), UPnPDevice{}); err == nil {
+		t.Error("不完整的xml应该返回错误")
+	}
+}
+
+func TestMergeUPnP(t *testing.T) {
+	info := LanIpInfo{IP: "192.168.1.1"}
+	loc := "http://192.168.1.1:5000/rootDesc.xml"
+	if !info.merge(LanIpInfo{UPnP: []UPnPDevice{{Location: loc, UUID: "abc", Server: "miniupnpd"}}}) {
+		t.Error("新设备应该有变化")
+	}
+	if info.merge(LanIpInfo{UPnP: []UPnPDevice{{Location: loc, UUID: "abc"}}}) {
+		t.Error("相同的设备不应该有变化")
+	}
+	if !info.merge(LanIpInfo{UPnP: []UPnPDevice{{Location: loc, FriendlyName: "Home Router"}}}) {
+		t.Error("补充描述应该有变化")
+	}
+	want := UPnPDevice{Location: loc, UUID: "abc", Server: "miniupnpd", FriendlyName: "Home Router"}
+	if len(info.UPnP) != 1 || info.UPnP[0] != want {
+		t.Errorf("合并结果 %+v", info.UPnP)
+	}
+}
@@ -1,23 +1,40 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
-	"net"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/rpc"
+	"github.com/ilaziness/gopkg/net/tcp/transport"
 )
 
+// 压测客户端
+// go run ./net/tcp/client -addr unix-abstract://tcp -n 100
+// rpc模式，服务端也要加 -rpc：go run ./net/tcp/client -rpc
 func main() {
-	addr := "127.0.0.1:9000"
-	clientNum := 10
+	var addr string
+	var clientNum int
+	var rpcMode bool
+	flag.StringVar(&addr, "addr", "127.0.0.1:9000", "server address, host:port, unix:///path or unix-abstract://name")
+	flag.IntVar(&clientNum, "n", 10, "number of clients")
+	flag.BoolVar(&rpcMode, "rpc", false, "call Calculator.Add via rpc instead of raw write")
+	flag.Parse()
+
 	for n := 0; n < clientNum; n++ {
-		go client(n, addr)
+		if rpcMode {
+			go rpcClient(n, addr)
+		} else {
+			go client(n, addr)
+		}
 	}
 
 	time.Sleep(time.Second * 30)
 }
 
 func client(n int, addr string) {
-	conn, err := net.Dial("tcp", addr)
+	conn, err := transport.Dial(addr)
 	if err != nil {
 		log.Println(err)
 		return
@@ -46,3 +63,45 @@ func client(n int, addr string) {
 		log.Printf("client %d receive: %s\n", n, data[:nr])
 	}
 }
+
+type calcArgs struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type calcReply struct {
+	Result int `json:"result"`
+}
+
+// rpcClient 一个连接上同时发起多个调用
+func rpcClient(n int, addr string) {
+	client, err := rpc.Dial(addr, rpc.JSON)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(time.Second * 10)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 500)
+		done := make(chan struct{}, 3)
+		for i := 0; i < 3; i++ {
+			go func(i int) {
+				defer func() { done <- struct{}{} }()
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				var reply calcReply
+				if err := client.Call(ctx, "Calculator.Add", &calcArgs{A: n, B: i}, &reply); err != nil {
+					log.Println(err)
+					return
+				}
+				log.Printf("client %d: %d + %d = %d\n", n, n, i, reply.Result)
+			}(i)
+		}
+		for i := 0; i < 3; i++ {
+			<-done
+		}
+	}
+	log.Printf("client %d exit\n", n)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+)
+
+// Calculator rpc演示服务，-rpc 模式下注册，方法名为 Calculator.Add、Calculator.Multiply
+type Calculator struct{}
+
+type CalcArgs struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type CalcReply struct {
+	Result int `json:"result"`
+}
+
+func (c Calculator) Add(ctx context.Context, args *CalcArgs, reply *CalcReply) error {
+	reqNum.Add(1)
+	reply.Result = args.A + args.B
+	return nil
+}
+
+func (c Calculator) Multiply(ctx context.Context, args *CalcArgs, reply *CalcReply) error {
+	reqNum.Add(1)
+	reply.Result = args.A * args.B
+	return nil
+}
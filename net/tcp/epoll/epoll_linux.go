@@ -90,6 +90,24 @@ retry:
 	return connections, nil
 }
 
+// Len 当前连接数
+func (e *epoll) Len() int {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return len(e.connections)
+}
+
+// CloseAll 关闭所有连接，用于优雅退出超时后强制关闭
+func (e *epoll) CloseAll() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for fd, conn := range e.connections {
+		unix.EpollCtl(e.fd, syscall.EPOLL_CTL_DEL, fd, nil)
+		conn.Close()
+		delete(e.connections, fd)
+	}
+}
+
 func socketFD(conn net.Conn) int {
 	//tls := reflect.TypeOf(conn.UnderlyingConn()) == reflect.TypeOf(&tls.Conn{})
 	// Extract the file descriptor associated with the connection
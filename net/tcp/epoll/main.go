@@ -1,25 +1,58 @@
 package main
 
 import (
+	"errors"
 	"expvar"
+	"flag"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/handoff"
+	"github.com/ilaziness/gopkg/net/tcp/rpc"
 )
 
 // epoll模式，不用标准库的net
 // 只支持linux类系统
+//
+// 不断连接重启：
+//   - kill -HUP <pid>：用当前可执行文件启动新进程，监听通过ExtraFiles交给新进程
+//   - -handoff unix:///tmp/epoll.handoff：新启动的进程通过交接socket从旧进程获取监听
+//
+// 新进程就绪后旧进程停止Accept，已有连接处理完(或超过-drain时间)后退出
 
 var connNum = expvar.NewInt("conns_number")
 var reqNum = expvar.NewInt("req_num")
 var qps = expvar.NewInt("qps")
 
+// 监听地址，支持 host:port、unix:///tmp/tcp.sock、unix-abstract://tcp
+var addr = flag.String("addr", ":9000", "listen address")
+var handoffSocket = flag.String("handoff", "", "unix socket for listener handoff, e.g. unix:///tmp/epoll.handoff")
+var drainTimeout = flag.Duration("drain", 30*time.Second, "max time to wait for connections to close on shutdown")
+
+// rpc模式：连接上跑rpc协议，注册Calculator服务
+var rpcMode = flag.Bool("rpc", false, "serve rpc instead of echo")
+
 var epoller *epoll
 
+var rpcServer = rpc.NewServer()
+
+// 每个连接的rpc会话，保存未解完的半包
+var sessions sync.Map
+
 func main() {
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
+	flag.Parse()
 
-	ln, err := net.Listen("tcp", ":9000")
+	up, err := handoff.New(handoff.Options{Socket: *handoffSocket})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	ln, err := up.Listen(*addr)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -39,16 +72,52 @@ func main() {
 		}
 	}()
 
+	if err := rpcServer.Register(Calculator{}); err != nil {
+		log.Fatalln(err)
+	}
+
 	epoller, err = MkEpoll()
 	if err != nil {
 		panic(err)
 	}
 
 	go start()
+	go accept(ln)
+
+	if err := up.Ready(); err != nil {
+		log.Println(err)
+	}
+	log.Printf("pid %d listen on %s", os.Getpid(), ln.Addr())
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+loop:
+	for {
+		select {
+		case s := <-quit:
+			if s != syscall.SIGHUP {
+				break loop
+			}
+			// 升级成功后Exit会关闭，下一轮循环退出
+			if err := up.Upgrade(); err != nil {
+				log.Println(err)
+			}
+		case <-up.Exit():
+			log.Println("listener handed off to new process")
+			break loop
+		}
+	}
+	up.Stop()
+	gracefulShutdown(ln, *drainTimeout)
+}
+
+func accept(ln net.Listener) {
 	for {
 		conn, e := ln.Accept()
 		if e != nil {
-			log.Println(err)
+			if !errors.Is(e, net.ErrClosed) {
+				log.Println(e)
+			}
 			return
 		}
 
@@ -61,7 +130,7 @@ func main() {
 }
 
 func start() {
-	var buf = make([]byte, 20)
+	var buf = make([]byte, 4096)
 	for {
 		// Wait 会阻塞等待消息就绪
 		connections, err := epoller.Wait()
@@ -73,13 +142,20 @@ func start() {
 			if conn == nil {
 				break
 			}
-			if _, err := conn.Read(buf); err != nil {
-				if err := epoller.Remove(conn); err != nil {
-					log.Printf("failed to remove %v", err)
-				}
+			n, err := conn.Read(buf)
+			if err != nil {
 				// 客户端连接关闭，会走到这里
 				log.Println("conn close")
-				conn.Close()
+				closeConn(conn)
+				continue
+			}
+			if *rpcMode {
+				// 读到的数据交给会话解帧，完整的请求在单独的goroutine里处理，不阻塞事件循环
+				if err := session(conn).Feed(buf[:n]); err != nil {
+					log.Println(err)
+					closeConn(conn)
+				}
+				continue
 			}
 			conn.Write([]byte("server hello"))
 
@@ -87,3 +163,20 @@ func start() {
 		}
 	}
 }
+
+func session(conn net.Conn) *rpc.Session {
+	if s, ok := sessions.Load(conn); ok {
+		return s.(*rpc.Session)
+	}
+	s := rpcServer.NewSession(conn)
+	sessions.Store(conn, s)
+	return s
+}
+
+func closeConn(conn net.Conn) {
+	if err := epoller.Remove(conn); err != nil {
+		log.Printf("failed to remove %v", err)
+	}
+	sessions.Delete(conn)
+	conn.Close()
+}
@@ -0,0 +1,119 @@
+package frame
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TCP是字节流，没有消息边界，一次Read可能读到半个包，也可能读到多个包（粘包）
+// 长度前缀分帧：4字节大端长度 + 数据
+//
+//	+--------+----------------+
+//	| length |    payload     |
+//	+--------+----------------+
+//	  4字节      length字节
+
+const (
+	HeaderSize = 4
+	// DefaultMaxSize 默认单帧最大4M，防止异常长度导致分配超大内存
+	DefaultMaxSize = 4 << 20
+)
+
+var ErrTooLarge = errors.New("frame: too large")
+
+// Encode 给payload加上长度头
+func Encode(payload []byte) []byte {
+	buf := make([]byte, HeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[HeaderSize:], payload)
+	return buf
+}
+
+// Write 写一帧，长度头和数据一次Write写出
+// 多个goroutine写同一个连接时，调用方需要加锁，见README：业务数据包分多次write协程不安全
+func Write(w io.Writer, payload []byte) error {
+	_, err := w.Write(Encode(payload))
+	return err
+}
+
+// Reader 阻塞读取，一个连接一个goroutine的模式使用
+type Reader struct {
+	r   *bufio.Reader
+	max int
+}
+
+// NewReader max为单帧最大长度，<=0时使用DefaultMaxSize
+func NewReader(r io.Reader, max int) *Reader {
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+	return &Reader{r: bufio.NewReader(r), max: max}
+}
+
+// Read 读取一帧，返回的切片归调用方所有
+func (r *Reader) Read() ([]byte, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint32(header[:]))
+	if n > r.max {
+		return nil, fmt.Errorf("%w: %d > %d", ErrTooLarge, n, r.max)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Decoder 增量解码，epoll等reactor模式使用
+// 连接可读时读到多少就Feed多少，不完整的数据留到下次
+type Decoder struct {
+	buf []byte
+	max int
+}
+
+// NewDecoder max为单帧最大长度，<=0时使用DefaultMaxSize
+func NewDecoder(max int) *Decoder {
+	if max <= 0 {
+		max = DefaultMaxSize
+	}
+	return &Decoder{max: max}
+}
+
+// Feed 追加数据，返回已完整的帧
+// 返回ErrTooLarge后解码器状态不可恢复，应关闭连接
+func (d *Decoder) Feed(data []byte) ([][]byte, error) {
+	d.buf = append(d.buf, data...)
+	var frames [][]byte
+	for len(d.buf) >= HeaderSize {
+		n := int(binary.BigEndian.Uint32(d.buf))
+		if n > d.max {
+			return frames, fmt.Errorf("%w: %d > %d", ErrTooLarge, n, d.max)
+		}
+		if len(d.buf) < HeaderSize+n {
+			break
+		}
+		payload := make([]byte, n)
+		copy(payload, d.buf[HeaderSize:HeaderSize+n])
+		frames = append(frames, payload)
+		d.buf = d.buf[HeaderSize+n:]
+	}
+	// 数据全部消费完时释放底层数组，避免一直持有大缓冲
+	if len(d.buf) == 0 {
+		d.buf = nil
+	}
+	return frames, nil
+}
+
+// Buffered 还没组成完整帧的字节数
+func (d *Decoder) Buffered() int {
+	return len(d.buf)
+}
@@ -0,0 +1,60 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	var buf bytes.Buffer
+	msgs := []string{"hello", "", "world"}
+	for _, m := range msgs {
+		Write(&buf, []byte(m))
+	}
+	r := NewReader(&buf, 0)
+	for _, m := range msgs {
+		got, err := r.Read()
+		if err != nil || string(got) != m {
+			t.Fatalf("Read() = %q, %v, want %q", got, err, m)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Fatalf("want EOF, got %v", err)
+	}
+}
+
+// 逐字节喂入，模拟半包和粘包
+func TestDecoder(t *testing.T) {
+	data := append(Encode([]byte("hello")), Encode([]byte("world"))...)
+	d := NewDecoder(0)
+	var got []string
+	for _, b := range data {
+		frames, err := d.Feed([]byte{b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range frames {
+			got = append(got, string(f))
+		}
+	}
+	if len(got) != 2 || got[0] != "hello" || got[1] != "world" || d.Buffered() != 0 {
+		t.Fatalf("got %q, buffered %d", got, d.Buffered())
+	}
+
+	frames, err := NewDecoder(0).Feed(data)
+	if err != nil || len(frames) != 2 {
+		t.Fatalf("feed all: %d frames, %v", len(frames), err)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	data := Encode(make([]byte, 10))
+	if _, err := NewDecoder(5).Feed(data); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("decoder: want ErrTooLarge, got %v", err)
+	}
+	if _, err := NewReader(bytes.NewReader(data), 5).Read(); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("reader: want ErrTooLarge, got %v", err)
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"expvar"
+	"flag"
 	"log"
 	"net"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/rpc"
+	"github.com/ilaziness/gopkg/net/tcp/transport"
 )
 
 // 一个连接一个goroutine处理的模式
@@ -15,14 +19,26 @@ var connNum = expvar.NewInt("conns_number")
 var reqNum = expvar.NewInt("req_num")
 var qps = expvar.NewInt("qps")
 
+// 监听地址，支持 host:port、unix:///tmp/tcp.sock、unix-abstract://tcp
+var addr = flag.String("addr", ":9000", "listen address")
+
+// rpc模式：连接上跑rpc协议，注册Calculator服务
+var rpcMode = flag.Bool("rpc", false, "serve rpc instead of echo")
+
 func main() {
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
+	flag.Parse()
 
-	ln, err := net.Listen("tcp", ":9000")
+	ln, err := transport.Listen(*addr)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.Register(Calculator{}); err != nil {
+		log.Fatalln(err)
+	}
+
 	// 计算qps
 	go func() {
 		var lastReqTotal int64
@@ -53,7 +69,11 @@ func main() {
 			return
 		}
 
-		go handleConn(conn)
+		if *rpcMode {
+			go rpcServer.ServeConn(conn)
+		} else {
+			go handleConn(conn)
+		}
 		connNum.Add(1)
 	}
 }
@@ -0,0 +1,397 @@
+package handoff
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/transport"
+)
+
+// 监听socket交接，实现不断连接的重启
+//
+// 旧进程把监听socket的fd交给新进程，新进程就绪后开始Accept，旧进程停止Accept并处理完已有连接后退出。
+// 内核里始终有进程持有监听socket，新连接会在backlog里排队，不会被拒绝。
+//
+// 两种交接方式：
+//   - Upgrade：旧进程用 exec.Cmd.ExtraFiles 启动新进程，fd通过继承传递，通过管道等待新进程就绪
+//   - 交接socket：旧进程在 Options.Socket 上等待，新进程(比如由systemd或部署脚本启动)连接后通过SCM_RIGHTS获取fd
+//
+// 使用方式：
+//
+//	up, _ := handoff.New(handoff.Options{Socket: "unix:///tmp/app.handoff"})
+//	ln, _ := up.Listen(":9000") // 有继承的监听就直接用，否则新建
+//	go serve(ln)
+//	up.Ready()                  // 通知旧进程可以退出了
+//	<-up.Exit()                 // 新进程已接管，停止Accept，处理完已有连接后退出
+
+const (
+	// 继承的监听地址列表(json)，顺序和ExtraFiles一致
+	envListeners = "GOPKG_HANDOFF_LISTENERS"
+	// 就绪通知管道的fd
+	envReady = "GOPKG_HANDOFF_READY"
+
+	// ExtraFiles 第一个文件在子进程里的fd是3
+	firstExtraFd = 3
+	// 一次交接最多传递的fd数量
+	maxFiles = 16
+
+	readyMsg = "ready"
+	// 交接socket自身也会传给新进程，用这个前缀和普通监听区分
+	handoffPrefix = "handoff:"
+)
+
+var ErrUpgrading = errors.New("handoff: upgrade in progress")
+
+// Options 交接配置
+type Options struct {
+	// Socket 交接socket地址，比如 unix:///tmp/app.handoff，为空时只能通过Upgrade交接
+	Socket string
+	// ReadyTimeout 等待新进程就绪的时间，默认10秒
+	ReadyTimeout time.Duration
+}
+
+// Upgrader 管理需要交接的监听
+type Upgrader struct {
+	opts Options
+
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+	inherited map[string]*os.File
+	upgrading bool
+	// 新进程就绪后通知旧进程：ExtraFiles方式是管道，交接socket方式是unix连接
+	notify    io.WriteCloser
+	handoffLn net.Listener
+
+	exitOnce sync.Once
+	exit     chan struct{}
+}
+
+// New 创建Upgrader，如果当前进程是被旧进程启动的，或者交接socket上有旧进程在等待，会先取得旧进程的监听
+func New(opts Options) (*Upgrader, error) {
+	if opts.ReadyTimeout <= 0 {
+		opts.ReadyTimeout = 10 * time.Second
+	}
+	u := &Upgrader{
+		opts:      opts,
+		listeners: make(map[string]net.Listener),
+		inherited: make(map[string]*os.File),
+		exit:      make(chan struct{}),
+	}
+	if os.Getenv(envListeners) != "" {
+		if err := u.inheritExtraFiles(); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	if opts.Socket != "" {
+		if err := u.fetch(); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// inheritExtraFiles 从ExtraFiles继承监听
+func (u *Upgrader) inheritExtraFiles() error {
+	var names []string
+	if err := json.Unmarshal([]byte(os.Getenv(envListeners)), &names); err != nil {
+		return fmt.Errorf("handoff: parse %s: %w", envListeners, err)
+	}
+	for i, name := range names {
+		u.inherited[name] = os.NewFile(uintptr(firstExtraFd+i), name)
+	}
+	if fd, err := strconv.Atoi(os.Getenv(envReady)); err == nil {
+		u.notify = os.NewFile(uintptr(fd), "handoff-ready")
+	}
+	// 不能让之后再启动的进程看到这些变量
+	os.Unsetenv(envListeners)
+	os.Unsetenv(envReady)
+	return nil
+}
+
+// fetch 连接交接socket，从旧进程获取监听，没有旧进程时正常启动
+func (u *Upgrader) fetch() error {
+	conn, err := transport.Dial(u.opts.Socket)
+	if err != nil {
+		return nil
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		conn.Close()
+		return fmt.Errorf("handoff: socket must be a unix address: %s", u.opts.Socket)
+	}
+	uc.SetReadDeadline(time.Now().Add(u.opts.ReadyTimeout))
+	msg, files, err := transport.RecvFiles(uc, maxFiles)
+	if err != nil {
+		uc.Close()
+		return fmt.Errorf("handoff: receive listeners: %w", err)
+	}
+	uc.SetReadDeadline(time.Time{})
+	var names []string
+	if err := json.Unmarshal(msg, &names); err != nil || len(names) != len(files) {
+		uc.Close()
+		for _, f := range files {
+			f.Close()
+		}
+		return fmt.Errorf("handoff: invalid listener list %q", msg)
+	}
+	for i, name := range names {
+		u.inherited[name] = files[i]
+	}
+	u.notify = uc
+	log.Printf("handoff: inherited %d listeners from %s", len(files), u.opts.Socket)
+	return nil
+}
+
+// Listen 获取addr的监听，优先使用旧进程交过来的，没有则新建
+// addr格式同transport.Listen，新旧进程需要用相同的addr
+func (u *Upgrader) Listen(addr string) (net.Listener, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if ln, ok := u.listeners[addr]; ok {
+		return ln, nil
+	}
+	var ln net.Listener
+	var err error
+	if f, ok := u.inherited[addr]; ok {
+		delete(u.inherited, addr)
+		ln, err = transport.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = transport.Listen(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	u.listeners[addr] = ln
+	return ln, nil
+}
+
+// Ready 当前进程已开始Accept，通知旧进程退出，并开始在交接socket上等待下一个新进程
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.notify != nil {
+		_, err := u.notify.Write([]byte(readyMsg))
+		u.notify.Close()
+		u.notify = nil
+		if err != nil {
+			return fmt.Errorf("handoff: notify parent: %w", err)
+		}
+	}
+
+	var handoffFile *os.File
+	for name, f := range u.inherited {
+		if name == handoffPrefix+u.opts.Socket {
+			handoffFile = f
+			continue
+		}
+		// 旧进程有但新进程不再使用的监听
+		f.Close()
+	}
+	u.inherited = make(map[string]*os.File)
+
+	if u.opts.Socket == "" || u.handoffLn != nil {
+		return nil
+	}
+	var ln net.Listener
+	var err error
+	if handoffFile != nil {
+		ln, err = transport.FileListener(handoffFile)
+		handoffFile.Close()
+	} else {
+		ln, err = transport.Listen(u.opts.Socket)
+	}
+	if err != nil {
+		return err
+	}
+	u.handoffLn = ln
+	go u.serveHandoff(ln)
+	return nil
+}
+
+// Exit 新进程接管后关闭，收到后应该停止Accept，处理完已有连接后退出
+func (u *Upgrader) Exit() <-chan struct{} {
+	return u.exit
+}
+
+// Stop 停止交接socket，进程正常退出时调用
+func (u *Upgrader) Stop() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.handoffLn != nil {
+		u.handoffLn.Close()
+		u.handoffLn = nil
+	}
+}
+
+// Upgrade 用当前可执行文件和参数启动新进程，监听通过ExtraFiles传递，新进程调用Ready后返回
+func (u *Upgrader) Upgrade() error {
+	names, files, err := u.begin()
+	if err != nil {
+		return err
+	}
+	defer u.end()
+	defer closeFiles(files)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	data, _ := json.Marshal(names)
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(),
+		envListeners+"="+string(data),
+		envReady+"="+strconv.Itoa(firstExtraFd+len(files)),
+	)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	log.Printf("handoff: started new process %d", cmd.Process.Pid)
+
+	if err := waitReady(r, u.opts.ReadyTimeout); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("handoff: new process not ready: %w", err)
+	}
+	// 新进程不是当前进程的子进程也能独立运行，这里不等待它退出
+	go cmd.Wait()
+	u.done()
+	return nil
+}
+
+// serveHandoff 在交接socket上等待新进程
+func (u *Upgrader) serveHandoff(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		err = u.handoff(conn)
+		conn.Close()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		u.Stop()
+		u.done()
+		return
+	}
+}
+
+// handoff 把监听发给新进程，并等待它就绪
+func (u *Upgrader) handoff(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("handoff: unexpected connection %T", conn)
+	}
+	names, files, err := u.begin()
+	if err != nil {
+		return err
+	}
+	defer u.end()
+	defer closeFiles(files)
+
+	data, _ := json.Marshal(names)
+	if err := transport.SendFiles(uc, data, files...); err != nil {
+		return fmt.Errorf("handoff: send listeners: %w", err)
+	}
+	if err := waitReady(uc, u.opts.ReadyTimeout); err != nil {
+		return fmt.Errorf("handoff: new process not ready: %w", err)
+	}
+	log.Printf("handoff: %d listeners handed off", len(files))
+	return nil
+}
+
+// begin 开始一次交接，返回要传递的监听地址和对应的文件
+func (u *Upgrader) begin() ([]string, []*os.File, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.upgrading {
+		return nil, nil, ErrUpgrading
+	}
+	var names []string
+	var files []*os.File
+	add := func(name string, ln net.Listener) error {
+		f, err := transport.ListenerFile(ln)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		files = append(files, f)
+		return nil
+	}
+	for addr, ln := range u.listeners {
+		if err := add(addr, ln); err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+	}
+	if u.handoffLn != nil {
+		if err := add(handoffPrefix+u.opts.Socket, u.handoffLn); err != nil {
+			closeFiles(files)
+			return nil, nil, err
+		}
+	}
+	if len(files) > maxFiles {
+		closeFiles(files)
+		return nil, nil, fmt.Errorf("handoff: too many listeners: %d", len(files))
+	}
+	u.upgrading = true
+	return names, files, nil
+}
+
+func (u *Upgrader) end() {
+	u.mu.Lock()
+	u.upgrading = false
+	u.mu.Unlock()
+}
+
+func (u *Upgrader) done() {
+	u.exitOnce.Do(func() { close(u.exit) })
+}
+
+type deadlineReader interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+// waitReady 等待新进程发送就绪消息
+func waitReady(r deadlineReader, timeout time.Duration) error {
+	r.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, len(readyMsg))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return err
+	}
+	if string(buf) != readyMsg {
+		return fmt.Errorf("unexpected message %q", buf)
+	}
+	return nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
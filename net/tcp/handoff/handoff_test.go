@@ -0,0 +1,87 @@
+package handoff
+
+import (
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// 同一个进程里模拟新旧两个进程，通过交接socket传递监听
+func TestSocketHandoff(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fd passing is only supported on linux")
+	}
+	socket := "unix://" + filepath.Join(t.TempDir(), "handoff.sock")
+	addr := "unix://" + filepath.Join(t.TempDir(), "app.sock")
+
+	old, err := New(Options{Socket: socket, ReadyTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldLn, err := old.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Ready(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 旧进程上已经建立但还没Accept的连接，交接后由新进程Accept
+	pending, err := net.Dial("unix", oldLn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pending.Close()
+
+	next, err := New(Options{Socket: socket, ReadyTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newLn, err := next.Listen(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := next.Ready(); err != nil {
+		t.Fatal(err)
+	}
+	defer next.Stop()
+
+	select {
+	case <-old.Exit():
+	case <-time.After(time.Second):
+		t.Fatal("old process did not get exit notification")
+	}
+	// 旧进程停止Accept，socket文件不能被删除
+	oldLn.Close()
+
+	conn, err := newLn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	c2, err := net.Dial("unix", newLn.Addr().String())
+	if err != nil {
+		t.Fatalf("dial after old listener closed: %v", err)
+	}
+	c2.Close()
+	defer newLn.Close()
+
+	// 交接socket已经由新进程持有，第三个进程还能继续交接
+	third, err := New(Options{Socket: socket, ReadyTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third.inherited) == 0 {
+		t.Fatal("third process inherited nothing")
+	}
+	third.Ready()
+	third.Stop()
+	select {
+	case <-next.Exit():
+	case <-time.After(time.Second):
+		t.Fatal("second process did not get exit notification")
+	}
+}
@@ -1,13 +1,21 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/transport"
 )
 
+// 监听和连接的地址，除了host:port还支持 unix:///tmp/tcp.sock 和 unix-abstract://tcp
+// 服务端监听 :8080 时，客户端Dial :8080 连接的是本机
+var addr = flag.String("addr", ":8080", "listen and dial address")
+
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	flag.Parse()
 
 	//simple()
 	//output:
@@ -29,7 +37,7 @@ func simple() {
 }
 
 func simpleServer() {
-	ln, err := net.Listen("tcp", ":8080")
+	ln, err := transport.Listen(*addr)
 	if err != nil {
 		log.Println(err)
 		return
@@ -61,8 +69,8 @@ func handleConn(conn net.Conn) {
 }
 
 func simpleClient() {
-	// net.DialTimeout 设置超时时间
-	conn, err := net.Dial("tcp", "127.0.0.1:8080")
+	// 设置连接超时时间
+	conn, err := transport.DialTimeout(*addr, 5*time.Second)
 	if err != nil {
 		log.Println(err)
 		return
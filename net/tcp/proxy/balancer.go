@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/ilaziness/gopkg/serviceregdisc"
+)
+
+var errNoBackend = errors.New("no available backend")
+
+// balancer 后端选择接口
+type balancer interface {
+	// Next 返回下一个后端地址，host:port 格式
+	Next() (string, error)
+}
+
+// staticBalancer 静态后端列表，轮询选择
+type staticBalancer struct {
+	mu       sync.Mutex
+	index    int
+	backends []string
+}
+
+func newStaticBalancer(backends []string) *staticBalancer {
+	return &staticBalancer{backends: backends}
+}
+
+func (b *staticBalancer) Next() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.backends) == 0 {
+		return "", errNoBackend
+	}
+	if b.index >= len(b.backends) {
+		b.index = 0
+	}
+	addr := b.backends[b.index]
+	b.index++
+	return addr, nil
+}
+
+// discoverBalancer 从服务发现获取后端地址
+// serviceregdisc.Server 本身已经是轮询返回节点
+type discoverBalancer struct {
+	server *serviceregdisc.Server
+}
+
+func newDiscoverBalancer(server *serviceregdisc.Server) *discoverBalancer {
+	return &discoverBalancer{server: server}
+}
+
+func (b *discoverBalancer) Next() (string, error) {
+	// GetServer 会修改轮询下标，和服务列表更新共用一把锁
+	b.server.Lock()
+	addr := b.server.GetServer()
+	b.server.Unlock()
+	if addr == "" {
+		return "", errNoBackend
+	}
+	// 服务地址格式是 schema://ip:port，去掉协议部分
+	if _, hostPort, ok := strings.Cut(addr, "://"); ok {
+		addr = hostPort
+	}
+	return addr, nil
+}
@@ -0,0 +1,105 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// splice(2) 在两个fd之间移动数据，其中一端必须是管道
+// socket -> pipe -> socket，数据不经过用户态，实现零拷贝转发
+
+// 每次splice最多搬运的字节数，默认管道容量是64k
+const maxSpliceSize = 64 << 10
+
+// forward 把src的数据转发到dst，直到src读到EOF
+// 两端都是socket连接(TCP或unix)时使用splice，否则退回io.Copy
+func forward(dst, src net.Conn) (int64, error) {
+	if !spliceable(dst) || !spliceable(src) {
+		return io.Copy(dst, src)
+	}
+	return splice(dst.(syscall.Conn), src.(syscall.Conn))
+}
+
+func spliceable(c net.Conn) bool {
+	switch c.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return true
+	}
+	return false
+}
+
+func splice(dst, src syscall.Conn) (int64, error) {
+	srcRaw, err := src.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	dstRaw, err := dst.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	// p[0] 读端，p[1] 写端
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return 0, err
+	}
+	defer unix.Close(p[0])
+	defer unix.Close(p[1])
+
+	var written int64
+	for {
+		// socket -> pipe
+		// RawConn.Read 在fd不可读时交给runtime的netpoller等待，不会阻塞线程，读超时同样生效
+		var n int64
+		var serr error
+		err = srcRaw.Read(func(fd uintptr) bool {
+			for {
+				n, serr = unix.Splice(int(fd), nil, p[1], nil, maxSpliceSize, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+				if serr != unix.EINTR {
+					break
+				}
+			}
+			return serr != unix.EAGAIN
+		})
+		if err != nil {
+			return written, err
+		}
+		if serr != nil {
+			return written, os.NewSyscallError("splice", serr)
+		}
+		if n == 0 {
+			// 对端关闭写方向，EOF
+			return written, nil
+		}
+
+		// pipe -> socket，管道里的数据必须全部写出去
+		for n > 0 {
+			var m int64
+			var werr error
+			err = dstRaw.Write(func(fd uintptr) bool {
+				for {
+					m, werr = unix.Splice(p[0], nil, int(fd), nil, int(n), unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+					if werr != unix.EINTR {
+						break
+					}
+				}
+				return werr != unix.EAGAIN
+			})
+			if err != nil {
+				return written, err
+			}
+			if werr != nil {
+				return written, os.NewSyscallError("splice", werr)
+			}
+			n -= m
+			written += m
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"io"
+	"net"
+)
+
+// forward 非linux系统没有splice，直接用io.Copy
+func forward(dst, src net.Conn) (int64, error) {
+	return io.Copy(dst, src)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/transport"
+	"github.com/ilaziness/gopkg/serviceregdisc"
+	"github.com/ilaziness/gopkg/serviceregdisc/client"
+)
+
+// 四层TCP反向代理
+// 后端来源：
+//   - 静态列表：-backends 127.0.0.1:9001,unix:///tmp/backend.sock
+//   - 服务发现：-etcd 127.0.0.1:2379 -prefix crm -service servicea
+//
+// linux下两端都是TCP连接时用splice零拷贝转发，其他系统用io.Copy
+//
+// go run ./net/tcp/proxy -listen :9000 -backends 127.0.0.1:9001
+// 后端连接数可以在 http://127.0.0.1:8081/debug/vars 的 backend_conns 查看
+
+func main() {
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
+
+	var (
+		listen      string
+		backends    string
+		etcd        string
+		prefix      string
+		service     string
+		dialTimeout time.Duration
+		retries     int
+		debugAddr   string
+	)
+	flag.StringVar(&listen, "listen", ":9000", "listen address, host:port, unix:///path or unix-abstract://name")
+	flag.StringVar(&backends, "backends", "", "static backend list, comma separated")
+	flag.StringVar(&etcd, "etcd", "", "etcd endpoints for service discovery, comma separated")
+	flag.StringVar(&prefix, "prefix", "crm", "service discovery prefix")
+	flag.StringVar(&service, "service", "", "service id to discover")
+	flag.DurationVar(&dialTimeout, "dial-timeout", 3*time.Second, "backend connect timeout")
+	flag.IntVar(&retries, "retries", 3, "number of backends to try when connect fails")
+	flag.StringVar(&debugAddr, "debug", ":8081", "expvar http address, empty to disable")
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var b balancer
+	switch {
+	case backends != "":
+		b = newStaticBalancer(strings.Split(backends, ","))
+	case etcd != "" && service != "":
+		cli, err := client.NewEtcdClient(ctx, strings.Split(etcd, ","), "", "")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		regdisc := serviceregdisc.NewRegDisc(prefix, cli)
+		ser, err := serviceregdisc.NewServerDiscover(ctx, regdisc.GetServicePath(service), *regdisc)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		b = newDiscoverBalancer(ser)
+	default:
+		log.Fatalln("either -backends or -etcd and -service is required")
+	}
+
+	if debugAddr != "" {
+		// expvar 注册在 http.DefaultServeMux
+		go func() {
+			log.Println(http.ListenAndServe(debugAddr, nil))
+		}()
+	}
+
+	ln, err := transport.Listen(listen)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("proxy listen on", ln.Addr())
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+		log.Println("proxy quiting....")
+		ln.Close()
+	}()
+
+	p := newProxy(b, dialTimeout, retries)
+	if err := p.serve(ln); err != nil {
+		log.Println(err)
+	}
+}
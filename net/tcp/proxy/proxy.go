@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/transport"
+)
+
+// 每个后端当前的连接数
+var backendConns = expvar.NewMap("backend_conns")
+
+// proxy 四层TCP代理
+type proxy struct {
+	balancer    balancer
+	dialTimeout time.Duration
+	// 后端连接失败时最多尝试几个后端
+	retries int
+
+	wg sync.WaitGroup
+}
+
+func newProxy(b balancer, dialTimeout time.Duration, retries int) *proxy {
+	if retries < 1 {
+		retries = 1
+	}
+	return &proxy{
+		balancer:    b,
+		dialTimeout: dialTimeout,
+		retries:     retries,
+	}
+}
+
+// serve 接收客户端连接，ln关闭后返回，并等待已有连接处理完
+func (p *proxy) serve(ln net.Listener) error {
+	defer p.wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.handle(conn)
+		}()
+	}
+}
+
+func (p *proxy) handle(client net.Conn) {
+	defer client.Close()
+
+	backend, addr, err := p.dial()
+	if err != nil {
+		log.Printf("client %s: %v", client.RemoteAddr(), err)
+		return
+	}
+	defer backend.Close()
+
+	backendConns.Add(addr, 1)
+	defer backendConns.Add(addr, -1)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		pipe(backend, client)
+		done <- struct{}{}
+	}()
+	go func() {
+		pipe(client, backend)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// dial 选择后端并连接，失败时换下一个后端重试
+// 后端地址可以是 host:port、unix:///path 或 unix-abstract://name
+func (p *proxy) dial() (net.Conn, string, error) {
+	var lastErr error
+	for i := 0; i < p.retries; i++ {
+		addr, err := p.balancer.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		conn, err := transport.DialTimeout(addr, p.dialTimeout)
+		if err != nil {
+			log.Printf("dial backend %s: %v", addr, err)
+			lastErr = err
+			continue
+		}
+		return conn, addr, nil
+	}
+	return nil, "", lastErr
+}
+
+// pipe 单向转发src到dst
+// src正常读到EOF时只关闭dst的写方向（半关闭），另一个方向的数据还可以继续传输
+// 出错时关闭两端，让另一个方向的转发也退出
+func pipe(dst, src net.Conn) {
+	_, err := forward(dst, src)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		log.Printf("forward %s -> %s: %v", src.RemoteAddr(), dst.RemoteAddr(), err)
+		src.Close()
+		dst.Close()
+		return
+	}
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	dst.Close()
+}
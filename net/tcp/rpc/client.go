@@ -0,0 +1,158 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/frame"
+	"github.com/ilaziness/gopkg/net/tcp/transport"
+)
+
+var ErrShutdown = errors.New("rpc: connection is shut down")
+
+// ServerError 服务端方法返回的错误
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+// Client 一个连接上复用多个并发调用
+// 每个调用分配请求ID，单独的goroutine读取响应并按ID分发
+type Client struct {
+	conn  net.Conn
+	codec Codec
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan *message
+	err     error
+}
+
+// Dial 连接服务端，addr格式同transport.Dial
+func Dial(addr string, codec Codec) (*Client, error) {
+	conn, err := transport.DialTimeout(addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, codec), nil
+}
+
+func NewClient(conn net.Conn, codec Codec) *Client {
+	if codec == nil {
+		codec = JSON
+	}
+	c := &Client{
+		conn:    conn,
+		codec:   codec,
+		pending: make(map[uint64]chan *message),
+	}
+	go c.readLoop()
+	return c
+}
+
+// Call 调用服务端方法，ctx的截止时间会通过消息头传给服务端
+func (c *Client) Call(ctx context.Context, method string, args, reply any) error {
+	if len(method) > maxStringLen {
+		return errors.New("rpc: method name too long")
+	}
+	payload, err := c.codec.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req := &message{Type: typeRequest, Codec: c.codec.ID(), Method: method, Payload: payload}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = time.Until(deadline)
+		if req.Timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+
+	ch := make(chan *message, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.seq++
+	req.ID = c.seq
+	c.pending[req.ID] = ch
+	c.mu.Unlock()
+
+	c.wmu.Lock()
+	err = frame.Write(c.conn, req.marshal())
+	c.wmu.Unlock()
+	if err != nil {
+		c.remove(req.ID)
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		c.remove(req.ID)
+		return ctx.Err()
+	case resp, ok := <-ch:
+		if !ok {
+			return c.closeErr()
+		}
+		if resp.Error != "" {
+			return ServerError(resp.Error)
+		}
+		return c.codec.Unmarshal(resp.Payload, reply)
+	}
+}
+
+// Close 关闭连接，未完成的调用返回ErrShutdown
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) readLoop() {
+	r := frame.NewReader(c.conn, 0)
+	var err error
+	for {
+		var data []byte
+		data, err = r.Read()
+		if err != nil {
+			break
+		}
+		var resp message
+		if err = resp.unmarshal(data); err != nil {
+			break
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.mu.Unlock()
+		// 调用已经超时返回的，丢弃响应
+		if ok {
+			ch <- &resp
+		}
+	}
+
+	c.mu.Lock()
+	c.err = ErrShutdown
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+func (c *Client) remove(id uint64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+func (c *Client) closeErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
@@ -0,0 +1,59 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec 参数和返回值的编解码
+type Codec interface {
+	// ID 写入消息头，服务端按请求的codec解码参数、编码返回值
+	ID() byte
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON  Codec = jsonCodec{}
+	Proto Codec = protoCodec{}
+)
+
+var codecs = map[byte]Codec{
+	JSON.ID():  JSON,
+	Proto.ID(): Proto,
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte { return 1 }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// protoCodec 参数和返回值必须是proto.Message
+type protoCodec struct{}
+
+func (protoCodec) ID() byte { return 2 }
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("rpc: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("rpc: %T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
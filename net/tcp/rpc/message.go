@@ -0,0 +1,99 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+// 消息格式，整个消息作为一帧的payload：
+//
+//	+------+-------+----+---------+-----------+--------+-----------+-------+---------+
+//	| type | codec | id | timeout | methodLen | method | errorLen  | error | payload |
+//	+------+-------+----+---------+-----------+--------+-----------+-------+---------+
+//	   1       1     8       4          2                    2
+//
+// id：请求ID，响应带回相同的ID，客户端据此把响应交给对应的调用，一个连接上可以同时有多个调用
+// timeout：剩余超时毫秒数，0表示不限制，服务端据此设置handler的context超时
+// error：只在响应中使用，服务端方法返回的错误
+
+const (
+	typeRequest  byte = 1
+	typeResponse byte = 2
+
+	headerFixedSize = 1 + 1 + 8 + 4 + 2 + 2
+	maxStringLen    = 1<<16 - 1
+)
+
+var errBadMessage = errors.New("rpc: malformed message")
+
+type message struct {
+	Type    byte
+	Codec   byte
+	ID      uint64
+	Timeout time.Duration
+	Method  string
+	Error   string
+	Payload []byte
+}
+
+func (m *message) marshal() []byte {
+	// 长度字段只有2字节，超长的错误信息截断
+	if len(m.Error) > maxStringLen {
+		m.Error = m.Error[:maxStringLen]
+	}
+	buf := make([]byte, 0, headerFixedSize+len(m.Method)+len(m.Error)+len(m.Payload))
+	buf = append(buf, m.Type, m.Codec)
+	buf = binary.BigEndian.AppendUint64(buf, m.ID)
+	buf = binary.BigEndian.AppendUint32(buf, timeoutMillis(m.Timeout))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(m.Method)))
+	buf = append(buf, m.Method...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(m.Error)))
+	buf = append(buf, m.Error...)
+	buf = append(buf, m.Payload...)
+	return buf
+}
+
+// timeoutMillis 不足1毫秒的向上取整，不能变成0（不限制），超过上限的按最大值
+func timeoutMillis(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	ms := d / time.Millisecond
+	if d%time.Millisecond != 0 {
+		ms++
+	}
+	if ms > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(ms)
+}
+
+func (m *message) unmarshal(data []byte) error {
+	if len(data) < headerFixedSize {
+		return errBadMessage
+	}
+	m.Type = data[0]
+	m.Codec = data[1]
+	m.ID = binary.BigEndian.Uint64(data[2:])
+	m.Timeout = time.Duration(binary.BigEndian.Uint32(data[10:])) * time.Millisecond
+	data = data[14:]
+
+	n := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < n+2 {
+		return errBadMessage
+	}
+	m.Method = string(data[:n])
+	data = data[n:]
+
+	n = int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < n {
+		return errBadMessage
+	}
+	m.Error = string(data[:n])
+	m.Payload = data[n:]
+	return nil
+}
@@ -0,0 +1,239 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Reply struct {
+	C int
+}
+
+type Arith struct{}
+
+func (Arith) Add(ctx context.Context, args *Args, reply *Reply) error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+func (Arith) Div(ctx context.Context, args *Args, reply *Reply) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	reply.C = args.A / args.B
+	return nil
+}
+
+// Wait 等到context结束，reply返回服务端是否拿到了截止时间
+func (Arith) Wait(ctx context.Context, args *Args, reply *Reply) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no deadline")
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (Arith) Echo(ctx context.Context, args *wrapperspb.StringValue, reply *wrapperspb.StringValue) error {
+	reply.Value = args.Value
+	return nil
+}
+
+// 不符合签名，不会被注册
+func (Arith) Ignore(a int) int { return a }
+
+func newServer(t *testing.T) *Server {
+	s := NewServer()
+	if err := s.Register(Arith{}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRegister(t *testing.T) {
+	s := newServer(t)
+	if len(s.methods) != 4 {
+		t.Fatalf("want 4 methods, got %d", len(s.methods))
+	}
+	if err := s.Register(Arith{}); err == nil {
+		t.Fatal("duplicate register should fail")
+	}
+}
+
+func TestCall(t *testing.T) {
+	s := newServer(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.ServeConn(conn)
+		}
+	}()
+
+	client, err := Dial(ln.Addr().String(), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	// 一个连接上并发调用
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var reply Reply
+			if err := client.Call(ctx, "Arith.Add", &Args{i, i}, &reply); err != nil || reply.C != 2*i {
+				t.Errorf("Add(%d, %d) = %d, %v", i, i, reply.C, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var reply Reply
+	err = client.Call(ctx, "Arith.Div", &Args{1, 0}, &reply)
+	var se ServerError
+	if !errors.As(err, &se) || se.Error() != "divide by zero" {
+		t.Errorf("Div by zero: %v", err)
+	}
+	if err := client.Call(ctx, "Arith.Nope", &Args{}, &reply); err == nil {
+		t.Error("unknown method should fail")
+	}
+
+	// 超时通过消息头传给服务端
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = client.Call(tctx, "Arith.Wait", &Args{}, &reply)
+	if err == nil || time.Since(start) > time.Second {
+		t.Errorf("Wait: %v after %v", err, time.Since(start))
+	}
+	if errors.As(err, &se) && se.Error() == "no deadline" {
+		t.Error("deadline was not propagated")
+	}
+
+	// 超时后连接仍然可用
+	if err := client.Call(ctx, "Arith.Add", &Args{1, 2}, &reply); err != nil || reply.C != 3 {
+		t.Errorf("Add after timeout = %d, %v", reply.C, err)
+	}
+}
+
+func TestProto(t *testing.T) {
+	s := newServer(t)
+	cconn, sconn := net.Pipe()
+	go s.ServeConn(sconn)
+	client := NewClient(cconn, Proto)
+	defer client.Close()
+
+	reply := &wrapperspb.StringValue{}
+	if err := client.Call(context.Background(), "Arith.Echo", wrapperspb.String("hello"), reply); err != nil || reply.Value != "hello" {
+		t.Fatalf("Echo = %q, %v", reply.Value, err)
+	}
+}
+
+// reactor模式：事件循环读到多少数据就Feed多少，这里每次只送一个字节
+func TestSessionFeed(t *testing.T) {
+	s := newServer(t)
+	cconn, sconn := net.Pipe()
+	sess := s.NewSession(sconn)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := sconn.Read(buf); err != nil {
+				return
+			}
+			if err := sess.Feed(buf); err != nil {
+				sconn.Close()
+				return
+			}
+		}
+	}()
+	client := NewClient(cconn, JSON)
+	defer client.Close()
+
+	var reply Reply
+	if err := client.Call(context.Background(), "Arith.Add", &Args{20, 22}, &reply); err != nil || reply.C != 42 {
+		t.Fatalf("Add = %d, %v", reply.C, err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	cconn, sconn := net.Pipe()
+	client := NewClient(cconn, JSON)
+	go func() {
+		// 读到请求后直接断开
+		buf := make([]byte, 64)
+		sconn.Read(buf)
+		sconn.Close()
+	}()
+	var reply Reply
+	if err := client.Call(context.Background(), "Arith.Add", &Args{}, &reply); !errors.Is(err, ErrShutdown) {
+		t.Fatalf("want ErrShutdown, got %v", err)
+	}
+}
+
+type Faulty struct{}
+
+func (Faulty) Panic(ctx context.Context, args *Args, reply *Reply) error {
+	var m map[int]int
+	m[args.A] = args.B
+	return nil
+}
+
+func TestPanic(t *testing.T) {
+	s := newServer(t)
+	if err := s.Register(Faulty{}); err != nil {
+		t.Fatal(err)
+	}
+	cconn, sconn := net.Pipe()
+	go s.ServeConn(sconn)
+	client := NewClient(cconn, JSON)
+	defer client.Close()
+
+	var reply Reply
+	err := client.Call(context.Background(), "Faulty.Panic", &Args{1, 2}, &reply)
+	if err == nil || !strings.Contains(err.Error(), "panic") {
+		t.Fatalf("Panic err = %v", err)
+	}
+	// panic之后服务端和连接都还能用
+	if err := client.Call(context.Background(), "Arith.Add", &Args{1, 2}, &reply); err != nil || reply.C != 3 {
+		t.Errorf("Add after panic = %d, %v", reply.C, err)
+	}
+}
+
+func TestTimeoutMillis(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want uint32
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{time.Nanosecond, 1},
+		{time.Millisecond, 1},
+		{1500 * time.Microsecond, 2},
+		{math.MaxInt64, math.MaxUint32},
+	} {
+		if got := timeoutMillis(tc.d); got != tc.want {
+			t.Errorf("timeoutMillis(%v) = %d, want %d", tc.d, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"runtime/debug"
+	"sync"
+
+	"github.com/ilaziness/gopkg/net/tcp/frame"
+)
+
+// 服务端方法通过反射注册和调用，参考reflect/main.go的callStructMethod
+// 方法签名必须是：
+//
+//	func (t *T) Method(ctx context.Context, args *Args, reply *Reply) error
+//
+// 注册后的方法名是 "T.Method"
+
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+type methodType struct {
+	rcvr      reflect.Value
+	method    reflect.Method
+	argType   reflect.Type
+	replyType reflect.Type
+}
+
+// Server 方法注册表
+type Server struct {
+	mu      sync.RWMutex
+	methods map[string]*methodType
+}
+
+func NewServer() *Server {
+	return &Server{methods: make(map[string]*methodType)}
+}
+
+// Register 注册rcvr所有符合签名的导出方法，服务名为类型名
+func (s *Server) Register(rcvr any) error {
+	return s.RegisterName(reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name(), rcvr)
+}
+
+// RegisterName 用指定的服务名注册
+func (s *Server) RegisterName(name string, rcvr any) error {
+	v := reflect.ValueOf(rcvr)
+	t := v.Type()
+	if name == "" {
+		return errors.New("rpc: no service name for type " + t.String())
+	}
+	methods := make(map[string]*methodType)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		if mt := suitableMethod(v, m); mt != nil {
+			methods[name+"."+m.Name] = mt
+		}
+	}
+	if len(methods) == 0 {
+		return fmt.Errorf("rpc: type %s has no suitable methods", t)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, mt := range methods {
+		if _, dup := s.methods[k]; dup {
+			return fmt.Errorf("rpc: method already defined: %s", k)
+		}
+		s.methods[k] = mt
+	}
+	return nil
+}
+
+// suitableMethod 检查方法签名，第一个入参是接收者
+func suitableMethod(rcvr reflect.Value, m reflect.Method) *methodType {
+	mtype := m.Type
+	if !m.IsExported() || mtype.NumIn() != 4 || mtype.NumOut() != 1 {
+		return nil
+	}
+	if mtype.In(1) != typeOfContext || mtype.Out(0) != typeOfError {
+		return nil
+	}
+	argType, replyType := mtype.In(2), mtype.In(3)
+	if argType.Kind() != reflect.Pointer || replyType.Kind() != reflect.Pointer {
+		return nil
+	}
+	return &methodType{rcvr: rcvr, method: m, argType: argType.Elem(), replyType: replyType.Elem()}
+}
+
+// ServeConn 一个连接一个goroutine的模式，阻塞直到连接关闭
+// 每个请求在独立的goroutine里处理，同一个连接上的请求可以并发执行
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	sess := s.NewSession(conn)
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if ferr := sess.Feed(buf[:n]); ferr != nil {
+				log.Println(ferr)
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Println(err)
+			}
+			return
+		}
+	}
+}
+
+// Session 一个连接的解码状态，reactor模式下每个连接一个Session
+// 连接可读时由reactor读取数据后调用Feed，不会阻塞事件循环
+type Session struct {
+	server *Server
+	conn   net.Conn
+	dec    *frame.Decoder
+	// 多个请求的响应可能同时写
+	wmu sync.Mutex
+}
+
+func (s *Server) NewSession(conn net.Conn) *Session {
+	return &Session{server: s, conn: conn, dec: frame.NewDecoder(0)}
+}
+
+// Feed 送入从连接读到的数据，解出完整的请求后在新的goroutine里处理
+// 返回错误时应该关闭连接
+func (ss *Session) Feed(data []byte) error {
+	frames, err := ss.dec.Feed(data)
+	for _, f := range frames {
+		var req message
+		if uerr := req.unmarshal(f); uerr != nil || req.Type != typeRequest {
+			return errBadMessage
+		}
+		go ss.handle(&req)
+	}
+	return err
+}
+
+func (ss *Session) handle(req *message) {
+	resp := &message{Type: typeResponse, Codec: req.Codec, ID: req.ID}
+	payload, err := ss.server.call(req)
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Payload = payload
+	}
+	ss.wmu.Lock()
+	defer ss.wmu.Unlock()
+	if err := frame.Write(ss.conn, resp.marshal()); err != nil {
+		log.Printf("rpc: write response %s: %v", req.Method, err)
+	}
+}
+
+func (s *Server) call(req *message) (payload []byte, err error) {
+	codec, ok := codecs[req.Codec]
+	if !ok {
+		return nil, fmt.Errorf("rpc: unknown codec %d", req.Codec)
+	}
+	s.mu.RLock()
+	mt, ok := s.methods[req.Method]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("rpc: can't find method %s", req.Method)
+	}
+
+	argv := reflect.New(mt.argType)
+	if err := codec.Unmarshal(req.Payload, argv.Interface()); err != nil {
+		return nil, fmt.Errorf("rpc: decode args: %w", err)
+	}
+	replyv := reflect.New(mt.replyType)
+
+	// 客户端传过来的超时
+	ctx := context.Background()
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+
+	// 方法panic不能让整个进程退出，作为错误返回给调用方
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("rpc: method %s panic: %v\n%s", req.Method, r, debug.Stack())
+			payload, err = nil, fmt.Errorf("rpc: method %s panic: %v", req.Method, r)
+		}
+	}()
+	out := mt.method.Func.Call([]reflect.Value{mt.rcvr, reflect.ValueOf(ctx), argv, replyv})
+	if errInter := out[0].Interface(); errInter != nil {
+		return nil, errInter.(error)
+	}
+	return codec.Marshal(replyv.Interface())
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"log"
-	"net"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/transport"
 )
 
 func test1() {
@@ -36,7 +37,7 @@ func test1() {
 }
 
 func server1() {
-	ln, err := net.Listen("tcp", ":8080")
+	ln, err := transport.Listen(*addr)
 	if err != nil {
 		log.Println(err)
 		return
@@ -59,7 +60,7 @@ func server1() {
 }
 
 func client1() {
-	conn, err := net.Dial("tcp", "127.0.0.1:8080")
+	conn, err := transport.Dial(*addr)
 	if err != nil {
 		log.Println(err)
 		return
@@ -71,7 +72,7 @@ func client1() {
 
 func batchClient1() {
 	for i := 1; i <= 6000; i++ {
-		conn, err := net.Dial("tcp", "127.0.0.1:8080")
+		conn, err := transport.Dial(*addr)
 		if err != nil {
 			log.Println(err)
 			return
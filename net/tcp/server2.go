@@ -2,8 +2,9 @@ package main
 
 import (
 	"log"
-	"net"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/transport"
 )
 
 func test2() {
@@ -34,7 +35,7 @@ func test2() {
 }
 
 func server2() {
-	ln, err := net.Listen("tcp", ":8080")
+	ln, err := transport.Listen(*addr)
 	if err != nil {
 		log.Println(err)
 		return
@@ -72,7 +73,7 @@ func server2() {
 }
 
 func client2() {
-	conn, err := net.Dial("tcp", "127.0.0.1:8080")
+	conn, err := transport.Dial(*addr)
 	if err != nil {
 		log.Println(err)
 		return
@@ -2,8 +2,9 @@ package main
 
 import (
 	"log"
-	"net"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/transport"
 )
 
 func test3() {
@@ -17,7 +18,7 @@ func test3() {
 }
 
 func server31() {
-	ln, err := net.Listen("tcp", ":8080")
+	ln, err := transport.Listen(*addr)
 	if err != nil {
 		log.Println(err)
 		return
@@ -49,7 +50,7 @@ func server31() {
 }
 
 func client31() {
-	conn, err := net.Dial("tcp", "127.0.0.1:8080")
+	conn, err := transport.Dial(*addr)
 	if err != nil {
 		log.Println(err)
 		return
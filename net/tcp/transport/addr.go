@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// 支持的地址格式：
+//   - host:port 或 tcp://host:port      TCP
+//   - unix:///tmp/app.sock               unix domain socket，文件路径
+//   - unix-abstract://app                linux抽象命名空间，不在文件系统创建文件，进程退出自动释放
+
+const (
+	SchemeTCP          = "tcp"
+	SchemeUnix         = "unix"
+	SchemeUnixAbstract = "unix-abstract"
+)
+
+var ErrAbstractUnsupported = errors.New("unix abstract namespace is only supported on linux")
+
+// Parse 解析地址，返回net包使用的network和address
+func Parse(addr string) (network, address string, err error) {
+	scheme, rest, ok := strings.Cut(addr, "://")
+	if !ok {
+		return "tcp", addr, nil
+	}
+	switch scheme {
+	case SchemeTCP:
+		return "tcp", rest, nil
+	case SchemeUnix:
+		if rest == "" {
+			return "", "", fmt.Errorf("empty unix socket path: %q", addr)
+		}
+		return "unix", rest, nil
+	case SchemeUnixAbstract:
+		if runtime.GOOS != "linux" {
+			return "", "", ErrAbstractUnsupported
+		}
+		if rest == "" {
+			return "", "", fmt.Errorf("empty abstract socket name: %q", addr)
+		}
+		// go 用@开头表示抽象命名空间，实际地址第一个字节是\0
+		return "unix", "@" + rest, nil
+	}
+	return "", "", fmt.Errorf("unsupported address scheme: %q", addr)
+}
+
+// Listen 按地址格式创建监听
+// unix socket文件已存在但没有进程在监听时（上次异常退出残留），删除后重新监听
+func Listen(addr string) (net.Listener, error) {
+	network, address, err := Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen(network, address)
+	if err == nil || network != "unix" || strings.HasPrefix(address, "@") {
+		return ln, err
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) || !staleSocket(address) {
+		return nil, err
+	}
+	if rerr := os.Remove(address); rerr != nil {
+		return nil, err
+	}
+	return net.Listen(network, address)
+}
+
+// staleSocket socket文件存在，但是连不上
+func staleSocket(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return errors.Is(err, syscall.ECONNREFUSED)
+	}
+	conn.Close()
+	return false
+}
+
+// Dial 按地址格式建立连接
+func Dial(addr string) (net.Conn, error) {
+	return DialTimeout(addr, 0)
+}
+
+// DialTimeout 带连接超时的Dial，timeout为0表示不超时
+func DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	network, address, err := Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout(network, address, timeout)
+}
@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// SCM_RIGHTS：通过unix socket的辅助数据(控制消息)传递文件描述符
+// 接收方拿到的是新的fd，指向同一个内核对象（比如同一个监听socket）
+// 新进程拿到监听socket后可以直接Accept，实现不断连接的重启
+
+// SendFiles 通过unix连接发送文件描述符，msg是随fd一起发送的普通数据，不能为空
+func SendFiles(conn *net.UnixConn, msg []byte, files ...*os.File) error {
+	if len(msg) == 0 {
+		// 没有普通数据时，部分系统不会发送控制消息
+		return errors.New("transport: message must not be empty")
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	rights := unix.UnixRights(fds...)
+	n, oobn, err := conn.WriteMsgUnix(msg, rights, nil)
+	if err != nil {
+		return err
+	}
+	if n != len(msg) || oobn != len(rights) {
+		return fmt.Errorf("transport: short write, data %d/%d, oob %d/%d", n, len(msg), oobn, len(rights))
+	}
+	return nil
+}
+
+// RecvFiles 接收文件描述符，maxFiles是最多能接收的fd数量，用于分配控制消息缓冲
+// 返回的文件名为 "fd-<n>"，调用方负责关闭
+func RecvFiles(conn *net.UnixConn, maxFiles int) ([]byte, []*os.File, error) {
+	buf := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(maxFiles*4))
+	n, oobn, flags, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []*os.File
+	for _, m := range msgs {
+		fds, err := unix.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			// 接收的fd默认没有CLOEXEC
+			unix.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), fmt.Sprintf("fd-%d", fd)))
+		}
+	}
+	if flags&unix.MSG_CTRUNC != 0 {
+		// 截断前放得下的fd已经在本进程里了，关闭后再返回错误，不然会泄漏
+		for _, f := range files {
+			f.Close()
+		}
+		return nil, nil, errors.New("transport: control message truncated, maxFiles too small")
+	}
+	return buf[:n], files, nil
+}
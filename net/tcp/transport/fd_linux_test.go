@@ -0,0 +1,70 @@
+//go:build linux
+// +build linux
+
+package transport
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openFDs 当前进程打开的fd数量
+func openFDs(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	return len(entries)
+}
+
+func TestRecvFilesTruncated(t *testing.T) {
+	ca, cb, err := unixPair(filepath.Join(t.TempDir(), "fd.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ca.Close()
+	defer cb.Close()
+
+	files := make([]*os.File, 4)
+	for i := range files {
+		f, err := os.Open(os.DevNull)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	before := openFDs(t)
+	if err := SendFiles(ca, []byte("files"), files...); err != nil {
+		t.Fatal(err)
+	}
+	// 缓冲只够放1、2个fd，控制消息被截断
+	if _, _, err := RecvFiles(cb, 1); err == nil {
+		t.Fatal("控制消息截断应该返回错误")
+	}
+	if after := openFDs(t); after != before {
+		t.Errorf("截断后fd从 %d 变成 %d，收到的fd没有关闭", before, after)
+	}
+}
+
+// unixPair 一对互相连接的unix连接
+func unixPair(sock string) (*net.UnixConn, *net.UnixConn, error) {
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ln.Close()
+	ca, err := net.DialUnix("unix", nil, ln.Addr().(*net.UnixAddr))
+	if err != nil {
+		return nil, nil, err
+	}
+	cb, err := ln.AcceptUnix()
+	if err != nil {
+		ca.Close()
+		return nil, nil, err
+	}
+	return ca, cb, nil
+}
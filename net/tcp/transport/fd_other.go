@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package transport
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+var errFdPassingUnsupported = errors.New("transport: fd passing is only supported on linux")
+
+func SendFiles(conn *net.UnixConn, msg []byte, files ...*os.File) error {
+	return errFdPassingUnsupported
+}
+
+func RecvFiles(conn *net.UnixConn, maxFiles int) ([]byte, []*os.File, error) {
+	return nil, nil, errFdPassingUnsupported
+}
@@ -0,0 +1,34 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// ListenerFile 获取监听socket的文件，用于传给子进程(ExtraFiles)或者通过SendFiles发给其他进程
+// 返回的是dup出来的fd，和ln互不影响，都需要各自关闭
+func ListenerFile(ln net.Listener) (*os.File, error) {
+	switch l := ln.(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		// 监听交给其他进程后，本进程Close时不能删除socket文件
+		l.SetUnlinkOnClose(false)
+		return l.File()
+	}
+	return nil, fmt.Errorf("transport: unsupported listener type %T", ln)
+}
+
+// FileListener 从继承的文件还原监听，f可以在返回后关闭
+func FileListener(f *os.File) (net.Listener, error) {
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	// 继承来的unix监听，关闭时也不删除socket文件，由最后使用的进程决定
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	return ln, nil
+}
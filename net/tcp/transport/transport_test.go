@@ -0,0 +1,160 @@
+package transport
+
+import (
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+		wantErr bool
+	}{
+		{":9000", "tcp", ":9000", false},
+		{"tcp://127.0.0.1:9000", "tcp", "127.0.0.1:9000", false},
+		{"unix:///tmp/a.sock", "unix", "/tmp/a.sock", false},
+		{"unix://", "", "", true},
+		{"udp://127.0.0.1:53", "", "", true},
+	}
+	if runtime.GOOS == "linux" {
+		tests = append(tests, struct {
+			addr    string
+			network string
+			address string
+			wantErr bool
+		}{"unix-abstract://app", "unix", "@app", false})
+	}
+	for _, tt := range tests {
+		network, address, err := Parse(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v", tt.addr, err)
+			continue
+		}
+		if network != tt.network || address != tt.address {
+			t.Errorf("Parse(%q) = %s %s, want %s %s", tt.addr, network, address, tt.network, tt.address)
+		}
+	}
+}
+
+func TestListenDial(t *testing.T) {
+	addrs := []string{"127.0.0.1:0", "unix://" + filepath.Join(t.TempDir(), "t.sock")}
+	if runtime.GOOS == "linux" {
+		addrs = append(addrs, "unix-abstract://gopkg-transport-test")
+	}
+	for _, addr := range addrs {
+		ln, err := Listen(addr)
+		if err != nil {
+			t.Fatalf("Listen(%s): %v", addr, err)
+		}
+		dialAddr := addr
+		if ln.Addr().Network() == "tcp" {
+			dialAddr = ln.Addr().String()
+		}
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("hello"))
+			conn.Close()
+		}()
+		conn, err := Dial(dialAddr)
+		if err != nil {
+			t.Fatalf("Dial(%s): %v", dialAddr, err)
+		}
+		buf := make([]byte, 5)
+		if _, err := conn.Read(buf); err != nil || string(buf) != "hello" {
+			t.Errorf("%s read %q, %v", addr, buf, err)
+		}
+		conn.Close()
+		ln.Close()
+	}
+}
+
+func TestListenStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stale.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 模拟异常退出：关闭监听但保留socket文件
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+
+	ln, err = Listen("unix://" + path)
+	if err != nil {
+		t.Fatalf("listen on stale socket: %v", err)
+	}
+	ln.Close()
+}
+
+func TestSendRecvFiles(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("fd passing is only supported on linux")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ListenerFile(ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	sock := filepath.Join(t.TempDir(), "fd.sock")
+	ul, err := net.ListenUnix("unix", &net.UnixAddr{Name: sock, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	sent := make(chan error, 1)
+	go func() {
+		c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: sock, Net: "unix"})
+		if err != nil {
+			sent <- err
+			return
+		}
+		defer c.Close()
+		sent <- SendFiles(c, []byte("listener"), f)
+	}()
+	c, err := ul.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	msg, files, err := RecvFiles(c, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "listener" || len(files) != 1 {
+		t.Fatalf("got msg %q, %d files", msg, len(files))
+	}
+
+	// 用收到的fd还原监听，连接原来的地址能被新监听Accept
+	inherited, err := FileListener(files[0])
+	files[0].Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := inherited.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -1,20 +1,41 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/tcp/handoff"
 )
 
 // workerpool模式
 // epool模式加上worker pool
+//
+// 不断连接重启同epoll模式：kill -HUP <pid> 或者 -handoff 指定交接socket
 
 var epoller *epoll
 var workerPool *pool
 
+// 监听地址，支持 host:port、unix:///tmp/tcp.sock、unix-abstract://tcp
+var addr = flag.String("addr", ":9000", "listen address")
+var handoffSocket = flag.String("handoff", "", "unix socket for listener handoff, e.g. unix:///tmp/workpool.handoff")
+var drainTimeout = flag.Duration("drain", 30*time.Second, "max time to wait for connections to close on shutdown")
+
 func main() {
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
+	flag.Parse()
 
-	ln, err := net.Listen("tcp", ":9000")
+	up, err := handoff.New(handoff.Options{Socket: *handoffSocket})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	ln, err := up.Listen(*addr)
 	if err != nil {
 		log.Println(err)
 		return
@@ -30,11 +51,41 @@ func main() {
 	}
 
 	go start()
+	go accept(ln)
+
+	if err := up.Ready(); err != nil {
+		log.Println(err)
+	}
+	log.Printf("pid %d listen on %s", os.Getpid(), ln.Addr())
 
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+loop:
+	for {
+		select {
+		case s := <-quit:
+			if s != syscall.SIGHUP {
+				break loop
+			}
+			if err := up.Upgrade(); err != nil {
+				log.Println(err)
+			}
+		case <-up.Exit():
+			log.Println("listener handed off to new process")
+			break loop
+		}
+	}
+	up.Stop()
+	gracefulShutdown(ln, *drainTimeout)
+}
+
+func accept(ln net.Listener) {
 	for {
 		conn, e := ln.Accept()
 		if e != nil {
-			log.Printf("accept err: %v", e)
+			if !errors.Is(e, net.ErrClosed) {
+				log.Printf("accept err: %v", e)
+			}
 			return
 		}
 
@@ -67,6 +118,8 @@ func hancleConn(conn net.Conn) {
 	// read 已关闭无数据的连接会io.EOF错误
 	_, err := conn.Read(data)
 	if err != nil {
+		// 从epoll移除，连接数才准确，优雅退出依赖连接数判断是否处理完
+		epoller.Remove(conn)
 		conn.Close()
 		return
 	}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+// gracefulShutdown 优雅退出
+// 先关闭监听停止Accept（监听已交给新进程时，新连接由新进程处理），再等待已有连接关闭，超时后强制关闭
+func gracefulShutdown(ln net.Listener, timeout time.Duration) {
+	ln.Close()
+	log.Printf("stop accepting, draining %d connections", epoller.Len())
+
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for epoller.Len() > 0 {
+		select {
+		case <-deadline:
+			log.Printf("drain timeout, close %d connections", epoller.Len())
+			epoller.CloseAll()
+			return
+		case <-ticker.C:
+		}
+	}
+	log.Println("all connections closed")
+}
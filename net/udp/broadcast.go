@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"log"
 	"net"
 	"time"
+
+	"github.com/ilaziness/gopkg/net/udp/fragment"
 )
 
 // 广播
 // 广播都是限制在局域网中的，所以只有局域网有效
+// 收发都经过fragment分片，可以发送超过MTU的消息
 
 func boradcast() {
 	go server()
@@ -25,14 +30,21 @@ func server() {
 	}
 	log.Printf("server local: <%s> \n", listener.LocalAddr().String())
 
-	data := make([]byte, 1024)
+	r := fragment.NewReader(listener, 0, 0)
+	w := fragment.NewWriter(listener, 0)
 	for {
-		n, remoteAddr, err := listener.ReadFromUDP(data)
+		data, remoteAddr, err := r.ReadFrom()
+		var incomplete *fragment.IncompleteError
+		if errors.As(err, &incomplete) {
+			log.Println(err)
+			continue
+		}
 		if err != nil {
 			log.Printf("error during read: %s", err)
+			return
 		}
-		log.Printf("boradcast server receive: <%s> %s\n", remoteAddr, data[:n])
-		_, err = listener.WriteToUDP([]byte("world"), remoteAddr)
+		log.Printf("boradcast server receive: <%s> %d bytes\n", remoteAddr, len(data))
+		err = w.WriteTo([]byte("world"), remoteAddr)
 		if err != nil {
 			log.Println(err)
 		}
@@ -52,16 +64,17 @@ func client() {
 	}
 	defer conn.Close()
 
-	_, err = conn.WriteToUDP([]byte("hello"), dstAddr)
+	// 8KB，超过MTU，分成多个分片发送
+	err = fragment.NewWriter(conn, 0).WriteTo(bytes.Repeat([]byte("hello"), 1600), dstAddr)
 	if err != nil {
 		log.Println(err)
 	}
-	data := make([]byte, 1024)
-	// 设置读取超时时间
-	conn.SetReadDeadline(time.Now().Add(time.Second))
-	n, _, err := conn.ReadFrom(data)
+	// 设置读取超时时间，Reader自己管理conn的超时，要设置在Reader上
+	r := fragment.NewReader(conn, 0, 0)
+	r.SetReadDeadline(time.Now().Add(time.Second))
+	data, _, err := r.ReadFrom()
 	if err != nil {
 		log.Println(err)
 	}
-	log.Printf("boradcast client read: <%s> %s\n", dstAddr, data[:n])
+	log.Printf("boradcast client read: <%s> %s\n", dstAddr, data)
 }
@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Exchange 向server发送查询，UDP回复被截断时自动改用TCP重试
+// network是udp或tcp
+func Exchange(network, server string, req *Message, timeout time.Duration) (*Message, error) {
+	if req.ID == 0 {
+		req.ID = uint16(rand.Intn(0xFFFF) + 1)
+	}
+	b, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchange(network, server, b, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Truncated && network == "udp" {
+		resp, err = exchange("tcp", server, b, timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.ID != req.ID {
+		return nil, errors.New("dns: response id mismatch")
+	}
+	return resp, nil
+}
+
+func exchange(network, server string, msg []byte, timeout time.Duration) (*Message, error) {
+	conn, err := net.DialTimeout(network, server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	var b []byte
+	if network == "tcp" {
+		if err := writeTCP(conn, msg); err != nil {
+			return nil, err
+		}
+		if b, err = readTCP(conn); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		b = make([]byte, maxUDPSize)
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		b = b[:n]
+	}
+	resp := new(Message)
+	if err := resp.Unpack(b); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,315 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackUnpack(t *testing.T) {
+	m := &Message{
+		Header: Header{ID: 0x1234, Response: true, Authoritative: true, RecursionDesired: true, RCode: RCodeNameError},
+		Questions: []Question{
+			{Name: "www.example.com.", Type: TypeA, Class: ClassINET},
+		},
+		Answers: []RR{
+			{Name: "www.example.com.", Class: ClassINET, TTL: 60, Data: &A{IP: net.IPv4(1, 2, 3, 4).To4()}},
+			{Name: "www.example.com.", Class: ClassINET, TTL: 60, Data: &AAAA{IP: net.ParseIP("fe80::1")}},
+			{Name: "alias.example.com.", Class: ClassINET, TTL: 60, Data: &CNAME{Target: "www.example.com."}},
+			{Name: "example.com.", Class: ClassINET, TTL: 60, Data: &MX{Preference: 10, Host: "mail.example.com."}},
+			{Name: "example.com.", Class: ClassINET, TTL: 60, Data: &NS{Host: "ns1.example.com."}},
+			{Name: "4.3.2.1.in-addr.arpa.", Class: ClassINET, TTL: 60, Data: &PTR{Target: "www.example.com."}},
+			{Name: "_http._tcp.example.com.", Class: ClassINET, TTL: 60, Data: &SRV{Priority: 1, Weight: 2, Port: 80, Target: "www.example.com."}},
+			{Name: "txt.example.com.", Class: ClassINET | ClassCacheFlush, TTL: 60, Data: &TXT{Text: []string{"a=1", "", "hello world"}}},
+			{Name: `My\.Printer._ipp._tcp.local.`, Class: ClassINET, TTL: 60, Data: &Unknown{T: 99, Data: []byte{1, 2, 3}}},
+		},
+		Authorities: []RR{
+			{Name: "example.com.", Class: ClassINET, TTL: 60, Data: &SOA{NS: "ns1.example.com.", MBox: "admin.example.com.", Serial: 1, Refresh: 2, Retry: 3, Expire: 4, MinTTL: 5}},
+		},
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(Message)
+	if err := got.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, got) {
+		t.Errorf("编解码结果不一致\n期望:\n%s\n实际:\n%s", m, got)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	m := &Message{Questions: []Question{{Name: "a.example.com.", Type: TypeA, Class: ClassINET}}}
+	for i := 0; i < 10; i++ {
+		m.Answers = append(m.Answers, RR{Name: "a.example.com.", Class: ClassINET, Data: &CNAME{Target: fmt.Sprintf("b%d.example.com.", i)}})
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// example.com 只应该完整出现一次
+	if n := bytes.Count(b, []byte("\x07example\x03com\x00")); n != 1 {
+		t.Errorf("example.com 出现 %d 次，没有压缩", n)
+	}
+}
+
+func TestPointerLoop(t *testing.T) {
+	// 问题的名字是指向自己的指针
+	msg := []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0xC0, 12, 0, 1, 0, 1}
+	if err := new(Message).Unpack(msg); err != ErrPointerLoop {
+		t.Errorf("期望 ErrPointerLoop，实际 %v", err)
+	}
+	// 截断的报文
+	b, _ := NewQuery(1, "example.com", TypeA).Pack()
+	for i := 0; i < len(b); i++ {
+		if err := new(Message).Unpack(b[:i]); err == nil {
+			t.Errorf("长度 %d 的截断报文应该解码失败", i)
+		}
+	}
+}
+
+const testZone = `
+$ORIGIN example.com.
+$TTL 1h
+@       IN SOA ns1 admin (
+            2024010101 ; serial
+            7200 3600 1209600 300 )
+        IN NS  ns1
+ns1     IN A   10.0.0.1
+www 300 IN A   10.0.0.2
+        IN AAAA fd00::2
+alias   IN CNAME www
+_http._tcp  IN SRV 0 5 8080 www
+txt     IN TXT "hello world" "k=v" bare
+2.0.0.10.in-addr.arpa. IN PTR www
+big     IN TXT "` + "0123456789012345678901234567890123456789" + `"
+`
+
+func loadTestZone(t *testing.T) *Zone {
+	t.Helper()
+	z, err := ParseZone(strings.NewReader(testZone), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 很多A记录，UDP回复会超过512字节
+	for i := 0; i < 40; i++ {
+		z.Add(RR{Name: "many", TTL: 60, Data: &A{IP: net.IPv4(10, 1, 0, byte(i)).To4()}})
+	}
+	return z
+}
+
+func TestParseZone(t *testing.T) {
+	z := loadTestZone(t)
+	if z.Origin != "example.com." {
+		t.Errorf("Origin %s", z.Origin)
+	}
+	soa := z.Lookup("example.com", TypeSOA)
+	if len(soa) != 1 || soa[0].Data.(*SOA).Serial != 2024010101 || soa[0].Data.(*SOA).MinTTL != 300 || soa[0].TTL != 3600 {
+		t.Errorf("SOA解析错误: %v", soa)
+	}
+	www := z.Lookup("WWW.example.com.", TypeANY)
+	if len(www) != 2 || www[0].TTL != 300 || www[1].Type() != TypeAAAA || www[1].Name != "www.example.com." {
+		t.Errorf("www解析错误: %v", www)
+	}
+	txt := z.Lookup("txt.example.com", TypeTXT)
+	if len(txt) != 1 || !reflect.DeepEqual(txt[0].Data.(*TXT).Text, []string{"hello world", "k=v", "bare"}) {
+		t.Errorf("TXT解析错误: %v", txt)
+	}
+	srv := z.Lookup("_http._tcp.example.com", TypeSRV)
+	if len(srv) != 1 || srv[0].Data.(*SRV).Target != "www.example.com." || srv[0].Data.(*SRV).Port != 8080 {
+		t.Errorf("SRV解析错误: %v", srv)
+	}
+	ptr := z.Lookup(ReverseName(net.IPv4(10, 0, 0, 2)), TypePTR)
+	if len(ptr) != 1 || ptr[0].Data.(*PTR).Target != "www.example.com." {
+		t.Errorf("PTR解析错误: %v", ptr)
+	}
+
+	for _, bad := range []string{"www IN A 1.2.3", "www IN FOO x", "www IN SRV 1 2 www", "www IN TXT \"x", "x ( IN A 1.1.1.1"} {
+		if _, err := ParseZone(strings.NewReader(bad), "example.com"); err == nil {
+			t.Errorf("%q 应该解析失败", bad)
+		}
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	if n := ReverseName(net.ParseIP("192.168.1.2")); n != "2.1.168.192.in-addr.arpa." {
+		t.Error(n)
+	}
+	if n := ReverseName(net.ParseIP("2001:db8::1")); n != "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa." {
+		t.Error(n)
+	}
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(loadTestZone(t))
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeUDP(pc)
+	go s.ServeTCP(ln)
+	defer s.Close()
+	addr := pc.LocalAddr().String()
+
+	query := func(network, name string, typ Type) *Message {
+		t.Helper()
+		resp, err := Exchange(network, addr, NewQuery(0, name, typ), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		r := query(network, "www.example.com", TypeA)
+		if !r.Authoritative || r.RCode != RCodeSuccess || len(r.Answers) != 1 || r.Answers[0].Data.String() != "10.0.0.2" {
+			t.Errorf("%s A查询错误: %s", network, r)
+		}
+	}
+
+	r := query("udp", "alias.example.com", TypeAAAA)
+	if len(r.Answers) != 2 || r.Answers[0].Type() != TypeCNAME || r.Answers[1].Type() != TypeAAAA {
+		t.Errorf("CNAME没有跟随: %s", r)
+	}
+
+	r = query("udp", "_http._tcp.example.com", TypeSRV)
+	if len(r.Answers) != 1 || len(r.Additionals) != 2 {
+		t.Errorf("SRV附加区错误: %s", r)
+	}
+
+	r = query("udp", "nope.example.com", TypeA)
+	if r.RCode != RCodeNameError || len(r.Authorities) != 1 {
+		t.Errorf("期望NXDOMAIN和SOA: %s", r)
+	}
+	// 名字存在但没有这种类型
+	r = query("udp", "www.example.com", TypeTXT)
+	if r.RCode != RCodeSuccess || len(r.Answers) != 0 {
+		t.Errorf("期望NOERROR无记录: %s", r)
+	}
+	// 中间名字存在
+	r = query("udp", "_tcp.example.com", TypeSRV)
+	if r.RCode != RCodeSuccess {
+		t.Errorf("中间名字应该是NOERROR: %s", r)
+	}
+	r = query("udp", "www.other.org", TypeA)
+	if r.RCode != RCodeRefused {
+		t.Errorf("zone外的名字应该REFUSED: %s", r)
+	}
+
+	// UDP被截断后自动改用TCP
+	r = query("udp", "many.example.com", TypeA)
+	if r.Truncated || len(r.Answers) != 40 {
+		t.Errorf("截断后没有用TCP重试: tc=%v answers=%d", r.Truncated, len(r.Answers))
+	}
+	// 直接发UDP能看到TC
+	b, _ := NewQuery(7, "many.example.com", TypeA).Pack()
+	resp, err := exchange("udp", addr, b, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Truncated || len(resp.Answers) >= 40 {
+		t.Errorf("UDP回复应该被截断: tc=%v answers=%d", resp.Truncated, len(resp.Answers))
+	}
+	// 带EDNS0的查询可以收更大的UDP回复
+	q := NewQuery(8, "many.example.com", TypeA)
+	q.Additionals = []RR{{Name: ".", Class: 4096, Data: &Unknown{T: TypeOPT}}}
+	b, _ = q.Pack()
+	resp, err = exchange("udp", addr, b, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Truncated || len(resp.Answers) != 40 {
+		t.Errorf("EDNS0回复不应截断: tc=%v answers=%d", resp.Truncated, len(resp.Answers))
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	s := NewServer(loadTestZone(t))
+	errc := make(chan error, 1)
+	go func() { errc <- s.ListenAndServe("127.0.0.1:0") }()
+	var ln net.Listener
+	var pc net.PacketConn
+	deadline := time.Now().Add(2 * time.Second)
+	for ln == nil || pc == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("没有开始监听")
+		}
+		time.Sleep(5 * time.Millisecond)
+		s.mu.Lock()
+		for _, l := range s.listeners {
+			switch l := l.(type) {
+			case net.Listener:
+				ln = l
+			case net.PacketConn:
+				pc = l
+			}
+		}
+		s.mu.Unlock()
+	}
+	if ln.Addr().String() != pc.LocalAddr().String() {
+		t.Errorf("UDP %s 和TCP %s 端口不同", pc.LocalAddr(), ln.Addr())
+	}
+	addr := pc.LocalAddr().String()
+	for _, network := range []string{"udp", "tcp"} {
+		if _, err := Exchange(network, addr, NewQuery(0, "www.example.com", TypeA), time.Second); err != nil {
+			t.Errorf("%s查询失败: %v", network, err)
+		}
+	}
+
+	// 不能解析的查询回复FORMERR，不能解析的响应不回复
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 512)
+	conn.Write([]byte{0x12, 0x34, 0x01, 0x00, 0xff})
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	var resp Message
+	if err != nil || resp.Unpack(buf[:n]) != nil || resp.ID != 0x1234 || resp.RCode != RCodeFormatError {
+		t.Errorf("期望FORMERR: %v %s", err, &resp)
+	}
+	conn.Write([]byte{0x12, 0x34, 0x81, 0x00, 0xff})
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Errorf("不应该回复响应包: %x", buf[:n])
+	}
+
+	// TCP同样回复FORMERR，连接还能继续查询
+	tc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.SetDeadline(time.Now().Add(time.Second))
+	writeTCP(tc, []byte{0x12, 0x34, 0x01, 0x00, 0xff})
+	msg, err := readTCP(tc)
+	resp = Message{}
+	if err != nil || resp.Unpack(msg) != nil || resp.ID != 0x1234 || resp.RCode != RCodeFormatError {
+		t.Errorf("TCP期望FORMERR: %v %s", err, &resp)
+	}
+	b, _ := NewQuery(9, "www.example.com", TypeA).Pack()
+	writeTCP(tc, b)
+	msg, err = readTCP(tc)
+	resp = Message{}
+	if err != nil || resp.Unpack(msg) != nil || resp.ID != 9 || len(resp.Answers) != 1 {
+		t.Errorf("FORMERR之后查询失败: %v %s", err, &resp)
+	}
+	// 连接不关闭的话Close要等到空闲超时
+	tc.Close()
+
+	s.Close()
+	if err := <-errc; err != nil {
+		t.Errorf("ListenAndServe返回 %v", err)
+	}
+}
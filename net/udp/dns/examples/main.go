@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/ilaziness/gopkg/net/udp/dns"
+	"github.com/ilaziness/gopkg/serviceregdisc"
+	"github.com/ilaziness/gopkg/serviceregdisc/client"
+)
+
+// 权威DNS服务器
+// go run . -zone example.zone -listen :5353
+// dig @127.0.0.1 -p 5353 nas.lan
+// dig @127.0.0.1 -p 5353 _smb._tcp.lan SRV
+// dig @127.0.0.1 -p 5353 -x 192.168.1.10
+//
+// 把服务发现的节点发布成SRV记录，只会DNS的客户端也能发现服务
+// go run . -zone example.zone -etcd 127.0.0.1:2379 -prefix crm -services servicea,serviceb
+// dig @127.0.0.1 -p 5353 _servicea._tcp.lan SRV
+
+func main() {
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
+
+	var (
+		listen   string
+		zoneFile string
+		origin   string
+		etcd     string
+		prefix   string
+		services string
+	)
+	flag.StringVar(&listen, "listen", ":5353", "udp and tcp listen address")
+	flag.StringVar(&zoneFile, "zone", "", "zone file")
+	flag.StringVar(&origin, "origin", "lan.", "zone origin when no zone file or $ORIGIN")
+	flag.StringVar(&etcd, "etcd", "", "etcd endpoints for service discovery, comma separated")
+	flag.StringVar(&prefix, "prefix", "crm", "service discovery prefix")
+	flag.StringVar(&services, "services", "", "service ids published as SRV records, comma separated")
+	flag.Parse()
+
+	zone := dns.NewZone(origin)
+	if zoneFile != "" {
+		var err error
+		zone, err = dns.LoadZoneFile(zoneFile, origin)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	log.Printf("zone %s, %d records", zone.Origin, len(zone.Records()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if etcd != "" && services != "" {
+		cli, err := client.NewEtcdClient(ctx, strings.Split(etcd, ","), "", "")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		regdisc := serviceregdisc.NewRegDisc(prefix, cli)
+		for _, id := range strings.Split(services, ",") {
+			events, err := regdisc.Discovery(ctx, regdisc.GetServicePath(id))
+			if err != nil {
+				log.Fatalln(err)
+			}
+			go publish(zone, id, events)
+		}
+	}
+
+	server := dns.NewServer(zone)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		server.Close()
+	}()
+	log.Println("dns server listen on", listen)
+	if err := server.ListenAndServe(listen); err != nil {
+		log.Println(err)
+	}
+}
+
+// publish 服务节点变化时更新SRV记录
+// _<id>._tcp.<origin> SRV 指向每个节点，节点的A记录是 <ip>.<id>.<origin>
+func publish(zone *dns.Zone, id string, events <-chan *serviceregdisc.DiscoverEvent) {
+	srvName := "_" + id + "._tcp"
+	hosts := map[string]bool{}
+	for ev := range events {
+		zone.Remove(srvName, dns.TypeSRV)
+		for h := range hosts {
+			zone.Remove(h, dns.TypeANY)
+		}
+		hosts = map[string]bool{}
+
+		for _, data := range ev.Server {
+			info := serviceregdisc.ServerInfo{}
+			if err := json.Unmarshal(data, &info); err != nil {
+				log.Println("unmarshal server info error:", err)
+				continue
+			}
+			ip := net.ParseIP(info.IP)
+			port, err := strconv.Atoi(info.Port)
+			if ip == nil || err != nil {
+				log.Printf("bad server address %s:%s", info.IP, info.Port)
+				continue
+			}
+			host := strings.NewReplacer(".", "-", ":", "-").Replace(info.IP) + "." + id
+			hosts[host] = true
+			if ip.To4() != nil {
+				zone.Add(dns.RR{Name: host, TTL: 10, Data: &dns.A{IP: ip.To4()}})
+			} else {
+				zone.Add(dns.RR{Name: host, TTL: 10, Data: &dns.AAAA{IP: ip}})
+			}
+			zone.Add(dns.RR{Name: srvName, TTL: 10, Data: &dns.SRV{Port: uint16(port), Target: host + "." + zone.Origin}})
+		}
+		log.Printf("service %s: %d nodes", id, len(hosts))
+	}
+}
@@ -0,0 +1,247 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const headerLen = 12
+
+// Header 报文头
+type Header struct {
+	ID                 uint16
+	Response           bool
+	Opcode             uint8
+	Authoritative      bool
+	Truncated          bool
+	RecursionDesired   bool
+	RecursionAvailable bool
+	RCode              RCode
+}
+
+func (h *Header) flags() uint16 {
+	f := uint16(h.Opcode&0xF)<<11 | uint16(h.RCode&0xF)
+	if h.Response {
+		f |= 1 << 15
+	}
+	if h.Authoritative {
+		f |= 1 << 10
+	}
+	if h.Truncated {
+		f |= 1 << 9
+	}
+	if h.RecursionDesired {
+		f |= 1 << 8
+	}
+	if h.RecursionAvailable {
+		f |= 1 << 7
+	}
+	return f
+}
+
+func (h *Header) setFlags(f uint16) {
+	h.Response = f&(1<<15) != 0
+	h.Opcode = uint8(f>>11) & 0xF
+	h.Authoritative = f&(1<<10) != 0
+	h.Truncated = f&(1<<9) != 0
+	h.RecursionDesired = f&(1<<8) != 0
+	h.RecursionAvailable = f&(1<<7) != 0
+	h.RCode = RCode(f & 0xF)
+}
+
+type Question struct {
+	Name  string
+	Type  Type
+	Class Class
+}
+
+func (q Question) String() string {
+	return fmt.Sprintf("%s %s", q.Name, q.Type)
+}
+
+// RR 资源记录
+type RR struct {
+	Name  string
+	Class Class
+	TTL   uint32
+	Data  RData
+}
+
+func (r *RR) Type() Type {
+	return r.Data.Type()
+}
+
+// String zone文件格式
+func (r *RR) String() string {
+	return fmt.Sprintf("%s\t%d\tIN\t%s\t%s", r.Name, r.TTL, r.Data.Type(), r.Data)
+}
+
+// Message DNS报文
+type Message struct {
+	Header
+	Questions   []Question
+	Answers     []RR
+	Authorities []RR
+	Additionals []RR
+}
+
+func (m *Message) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "id=%d rcode=%s qr=%v aa=%v tc=%v\n", m.ID, m.RCode, m.Response, m.Authoritative, m.Truncated)
+	for _, q := range m.Questions {
+		fmt.Fprintf(&b, ";%s\n", q)
+	}
+	for _, sec := range [][]RR{m.Answers, m.Authorities, m.Additionals} {
+		for i := range sec {
+			b.WriteString(sec[i].String())
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// Pack 编码，域名使用压缩
+func (m *Message) Pack() ([]byte, error) {
+	b := &builder{buf: make([]byte, 0, 512), names: make(map[string]int)}
+	b.uint16(m.ID)
+	b.uint16(m.flags())
+	for _, n := range []int{len(m.Questions), len(m.Answers), len(m.Authorities), len(m.Additionals)} {
+		if n > 0xFFFF {
+			return nil, errors.New("dns: too many records")
+		}
+		b.uint16(uint16(n))
+	}
+	for _, q := range m.Questions {
+		if err := b.name(q.Name, true); err != nil {
+			return nil, err
+		}
+		b.uint16(uint16(q.Type))
+		b.uint16(uint16(q.Class))
+	}
+	for _, sec := range [][]RR{m.Answers, m.Authorities, m.Additionals} {
+		for i := range sec {
+			if err := packRR(b, &sec[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b.buf, nil
+}
+
+func packRR(b *builder, rr *RR) error {
+	if rr.Data == nil {
+		return errors.New("dns: record without data")
+	}
+	if err := b.name(rr.Name, true); err != nil {
+		return err
+	}
+	b.uint16(uint16(rr.Data.Type()))
+	b.uint16(uint16(rr.Class))
+	b.uint32(rr.TTL)
+	// 先占位，写完数据再回填长度
+	lenOff := len(b.buf)
+	b.uint16(0)
+	if err := rr.Data.pack(b); err != nil {
+		return err
+	}
+	n := len(b.buf) - lenOff - 2
+	if n > 0xFFFF {
+		return errors.New("dns: record data too long")
+	}
+	b.buf[lenOff] = byte(n >> 8)
+	b.buf[lenOff+1] = byte(n)
+	return nil
+}
+
+// Unpack 解码
+func (m *Message) Unpack(msg []byte) error {
+	if len(msg) < headerLen {
+		return ErrShortBuffer
+	}
+	p := &parser{msg: msg}
+	// id、flags和4个区的记录数
+	var counts [6]uint16
+	for i := range counts {
+		counts[i], _ = p.uint16()
+	}
+	m.ID = counts[0]
+	m.setFlags(counts[1])
+
+	m.Questions = nil
+	for i := 0; i < int(counts[2]); i++ {
+		name, err := p.name()
+		if err != nil {
+			return err
+		}
+		t, err := p.uint16()
+		if err != nil {
+			return err
+		}
+		c, err := p.uint16()
+		if err != nil {
+			return err
+		}
+		m.Questions = append(m.Questions, Question{Name: name, Type: Type(t), Class: Class(c)})
+	}
+
+	sections := []*[]RR{&m.Answers, &m.Authorities, &m.Additionals}
+	for i, sec := range sections {
+		*sec = nil
+		for j := 0; j < int(counts[3+i]); j++ {
+			rr, err := unpackRR(p)
+			if err != nil {
+				return fmt.Errorf("dns: section %d record %d: %w", i, j, err)
+			}
+			*sec = append(*sec, rr)
+		}
+	}
+	return nil
+}
+
+func unpackRR(p *parser) (RR, error) {
+	var rr RR
+	var err error
+	if rr.Name, err = p.name(); err != nil {
+		return rr, err
+	}
+	t, err := p.uint16()
+	if err != nil {
+		return rr, err
+	}
+	c, err := p.uint16()
+	if err != nil {
+		return rr, err
+	}
+	rr.Class = Class(c)
+	if rr.TTL, err = p.uint32(); err != nil {
+		return rr, err
+	}
+	length, err := p.uint16()
+	if err != nil {
+		return rr, err
+	}
+	rr.Data, err = unpackRData(p, Type(t), int(length))
+	return rr, err
+}
+
+// NewQuery 构造一个查询
+func NewQuery(id uint16, name string, t Type) *Message {
+	return &Message{
+		Header:    Header{ID: id, RecursionDesired: true},
+		Questions: []Question{{Name: Fqdn(name), Type: t, Class: ClassINET}},
+	}
+}
+
+// Reply 构造对m的回复，复制ID和问题
+func (m *Message) Reply() *Message {
+	return &Message{
+		Header: Header{
+			ID:               m.ID,
+			Response:         true,
+			Opcode:           m.Opcode,
+			RecursionDesired: m.RecursionDesired,
+		},
+		Questions: m.Questions,
+	}
+}
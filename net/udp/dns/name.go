@@ -0,0 +1,231 @@
+package dns
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	maxLabelLen = 63
+	maxNameLen  = 255
+	// 压缩指针只有14位
+	maxPointer = 0x3FFF
+)
+
+var (
+	ErrLabelTooLong = errors.New("dns: label too long")
+	ErrNameTooLong  = errors.New("dns: name too long")
+	ErrPointerLoop  = errors.New("dns: bad compression pointer")
+	ErrShortBuffer  = errors.New("dns: message too short")
+)
+
+// Fqdn 补上末尾的点
+func Fqdn(name string) string {
+	if strings.HasSuffix(name, ".") && !strings.HasSuffix(name, `\.`) {
+		return name
+	}
+	return name + "."
+}
+
+// CanonicalName 小写的完整域名，作为Zone里的key
+func CanonicalName(name string) string {
+	return strings.ToLower(Fqdn(name))
+}
+
+// splitLabels 按点拆分域名，\. 表示标签里的点，\\ 表示反斜杠
+func splitLabels(name string) ([]string, error) {
+	name = Fqdn(name)
+	if name == "." {
+		return nil, nil
+	}
+	var labels []string
+	var cur []byte
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '\\' && i+1 < len(name):
+			i++
+			cur = append(cur, name[i])
+		case c == '.':
+			if len(cur) == 0 {
+				return nil, errors.New("dns: empty label in " + name)
+			}
+			if len(cur) > maxLabelLen {
+				return nil, ErrLabelTooLong
+			}
+			labels = append(labels, string(cur))
+			cur = cur[:0]
+		default:
+			cur = append(cur, c)
+		}
+	}
+	return labels, nil
+}
+
+func escapeLabel(label []byte) string {
+	var b strings.Builder
+	for _, c := range label {
+		if c == '.' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// builder 编码报文，记录已经写过的域名后缀用于压缩
+type builder struct {
+	buf   []byte
+	names map[string]int
+}
+
+func (b *builder) uint8(v uint8) {
+	b.buf = append(b.buf, v)
+}
+
+func (b *builder) uint16(v uint16) {
+	b.buf = append(b.buf, byte(v>>8), byte(v))
+}
+
+func (b *builder) uint32(v uint32) {
+	b.buf = append(b.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// name 写域名，compress为true时用之前出现过的后缀压缩
+func (b *builder) name(name string, compress bool) error {
+	labels, err := splitLabels(name)
+	if err != nil {
+		return err
+	}
+	size := 1
+	for _, l := range labels {
+		size += 1 + len(l)
+	}
+	if size > maxNameLen {
+		return ErrNameTooLong
+	}
+	for i := range labels {
+		key := suffixKey(labels[i:])
+		if compress {
+			if off, ok := b.names[key]; ok {
+				b.uint16(0xC000 | uint16(off))
+				return nil
+			}
+		}
+		// 所有写出的后缀都记录，后面的域名不管是否压缩都可以引用
+		if len(b.buf) <= maxPointer {
+			if _, ok := b.names[key]; !ok {
+				b.names[key] = len(b.buf)
+			}
+		}
+		b.uint8(uint8(len(labels[i])))
+		b.buf = append(b.buf, labels[i]...)
+	}
+	b.uint8(0)
+	return nil
+}
+
+func suffixKey(labels []string) string {
+	var b strings.Builder
+	for _, l := range labels {
+		b.WriteString(strings.ToLower(escapeLabel([]byte(l))))
+		b.WriteByte('.')
+	}
+	return b.String()
+}
+
+// parser 解码报文
+type parser struct {
+	msg []byte
+	off int
+}
+
+func (p *parser) uint8() (uint8, error) {
+	if p.off+1 > len(p.msg) {
+		return 0, ErrShortBuffer
+	}
+	v := p.msg[p.off]
+	p.off++
+	return v, nil
+}
+
+func (p *parser) uint16() (uint16, error) {
+	if p.off+2 > len(p.msg) {
+		return 0, ErrShortBuffer
+	}
+	v := uint16(p.msg[p.off])<<8 | uint16(p.msg[p.off+1])
+	p.off += 2
+	return v, nil
+}
+
+func (p *parser) uint32() (uint32, error) {
+	if p.off+4 > len(p.msg) {
+		return 0, ErrShortBuffer
+	}
+	v := uint32(p.msg[p.off])<<24 | uint32(p.msg[p.off+1])<<16 | uint32(p.msg[p.off+2])<<8 | uint32(p.msg[p.off+3])
+	p.off += 4
+	return v, nil
+}
+
+func (p *parser) bytes(n int) ([]byte, error) {
+	if n < 0 || p.off+n > len(p.msg) {
+		return nil, ErrShortBuffer
+	}
+	v := p.msg[p.off : p.off+n]
+	p.off += n
+	return v, nil
+}
+
+// name 读域名，处理压缩指针
+// 指针只能指向当前位置之前，避免循环
+func (p *parser) name() (string, error) {
+	var b strings.Builder
+	off := p.off
+	jumped := false
+	size := 0
+	for {
+		if off >= len(p.msg) {
+			return "", ErrShortBuffer
+		}
+		c := int(p.msg[off])
+		switch c & 0xC0 {
+		case 0x00:
+			off++
+			if c == 0 {
+				if !jumped {
+					p.off = off
+				}
+				if b.Len() == 0 {
+					return ".", nil
+				}
+				return b.String(), nil
+			}
+			if off+c > len(p.msg) {
+				return "", ErrShortBuffer
+			}
+			size += 1 + c
+			if size > maxNameLen {
+				return "", ErrNameTooLong
+			}
+			b.WriteString(escapeLabel(p.msg[off : off+c]))
+			b.WriteByte('.')
+			off += c
+		case 0xC0:
+			if off+2 > len(p.msg) {
+				return "", ErrShortBuffer
+			}
+			ptr := (c&0x3F)<<8 | int(p.msg[off+1])
+			if ptr >= off {
+				return "", ErrPointerLoop
+			}
+			if !jumped {
+				p.off = off + 2
+			}
+			jumped = true
+			off = ptr
+		default:
+			// 0x40和0x80是废弃的扩展标签
+			return "", errors.New("dns: unsupported label type")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// RData 记录数据
+type RData interface {
+	Type() Type
+	String() string
+	pack(b *builder) error
+}
+
+type A struct {
+	IP net.IP
+}
+
+func (r *A) Type() Type     { return TypeA }
+func (r *A) String() string { return r.IP.String() }
+func (r *A) pack(b *builder) error {
+	ip := r.IP.To4()
+	if ip == nil {
+		return errors.New("dns: A record with non IPv4 address")
+	}
+	b.buf = append(b.buf, ip...)
+	return nil
+}
+
+type AAAA struct {
+	IP net.IP
+}
+
+func (r *AAAA) Type() Type     { return TypeAAAA }
+func (r *AAAA) String() string { return r.IP.String() }
+func (r *AAAA) pack(b *builder) error {
+	ip := r.IP.To16()
+	if ip == nil {
+		return errors.New("dns: AAAA record with bad address")
+	}
+	b.buf = append(b.buf, ip...)
+	return nil
+}
+
+// NS、CNAME、PTR的数据都是一个域名
+type NS struct {
+	Host string
+}
+
+func (r *NS) Type() Type            { return TypeNS }
+func (r *NS) String() string        { return r.Host }
+func (r *NS) pack(b *builder) error { return b.name(r.Host, true) }
+
+type CNAME struct {
+	Target string
+}
+
+func (r *CNAME) Type() Type            { return TypeCNAME }
+func (r *CNAME) String() string        { return r.Target }
+func (r *CNAME) pack(b *builder) error { return b.name(r.Target, true) }
+
+type PTR struct {
+	Target string
+}
+
+func (r *PTR) Type() Type            { return TypePTR }
+func (r *PTR) String() string        { return r.Target }
+func (r *PTR) pack(b *builder) error { return b.name(r.Target, true) }
+
+type MX struct {
+	Preference uint16
+	Host       string
+}
+
+func (r *MX) Type() Type     { return TypeMX }
+func (r *MX) String() string { return fmt.Sprintf("%d %s", r.Preference, r.Host) }
+func (r *MX) pack(b *builder) error {
+	b.uint16(r.Preference)
+	return b.name(r.Host, true)
+}
+
+type SOA struct {
+	NS      string
+	MBox    string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	MinTTL  uint32
+}
+
+func (r *SOA) Type() Type { return TypeSOA }
+func (r *SOA) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", r.NS, r.MBox, r.Serial, r.Refresh, r.Retry, r.Expire, r.MinTTL)
+}
+func (r *SOA) pack(b *builder) error {
+	if err := b.name(r.NS, true); err != nil {
+		return err
+	}
+	if err := b.name(r.MBox, true); err != nil {
+		return err
+	}
+	for _, v := range []uint32{r.Serial, r.Refresh, r.Retry, r.Expire, r.MinTTL} {
+		b.uint32(v)
+	}
+	return nil
+}
+
+// TXT 多个字符串，每个最长255字节，DNS-SD里每个字符串是一个key=value
+type TXT struct {
+	Text []string
+}
+
+func (r *TXT) Type() Type { return TypeTXT }
+func (r *TXT) String() string {
+	q := make([]string, len(r.Text))
+	for i, t := range r.Text {
+		q[i] = strconv.Quote(t)
+	}
+	return strings.Join(q, " ")
+}
+func (r *TXT) pack(b *builder) error {
+	if len(r.Text) == 0 {
+		// 空TXT至少有一个空字符串
+		b.uint8(0)
+		return nil
+	}
+	for _, t := range r.Text {
+		if len(t) > 255 {
+			return errors.New("dns: TXT string longer than 255")
+		}
+		b.uint8(uint8(len(t)))
+		b.buf = append(b.buf, t...)
+	}
+	return nil
+}
+
+// SRV RFC 2782规定Target不能压缩
+type SRV struct {
+	Priority uint16
+	Weight   uint16
+	Port     uint16
+	Target   string
+}
+
+func (r *SRV) Type() Type { return TypeSRV }
+func (r *SRV) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.Priority, r.Weight, r.Port, r.Target)
+}
+func (r *SRV) pack(b *builder) error {
+	b.uint16(r.Priority)
+	b.uint16(r.Weight)
+	b.uint16(r.Port)
+	return b.name(r.Target, false)
+}
+
+// Unknown 不认识的类型，保留原始数据
+type Unknown struct {
+	T    Type
+	Data []byte
+}
+
+func (r *Unknown) Type() Type     { return r.T }
+func (r *Unknown) String() string { return fmt.Sprintf(`\# %d %x`, len(r.Data), r.Data) }
+func (r *Unknown) pack(b *builder) error {
+	b.buf = append(b.buf, r.Data...)
+	return nil
+}
+
+// unpackRData 解析length长度的记录数据，域名可能引用前面的内容所以需要整个报文
+func unpackRData(p *parser, t Type, length int) (RData, error) {
+	end := p.off + length
+	if end > len(p.msg) {
+		return nil, ErrShortBuffer
+	}
+	var rd RData
+	var err error
+	switch t {
+	case TypeA:
+		if length != net.IPv4len {
+			return nil, errors.New("dns: bad A record length")
+		}
+		rd = &A{IP: net.IP(append([]byte(nil), p.msg[p.off:end]...))}
+		p.off = end
+	case TypeAAAA:
+		if length != net.IPv6len {
+			return nil, errors.New("dns: bad AAAA record length")
+		}
+		rd = &AAAA{IP: net.IP(append([]byte(nil), p.msg[p.off:end]...))}
+		p.off = end
+	case TypeNS:
+		r := &NS{}
+		r.Host, err = p.name()
+		rd = r
+	case TypeCNAME:
+		r := &CNAME{}
+		r.Target, err = p.name()
+		rd = r
+	case TypePTR:
+		r := &PTR{}
+		r.Target, err = p.name()
+		rd = r
+	case TypeMX:
+		r := &MX{}
+		if r.Preference, err = p.uint16(); err == nil {
+			r.Host, err = p.name()
+		}
+		rd = r
+	case TypeSOA:
+		r := &SOA{}
+		if r.NS, err = p.name(); err != nil {
+			return nil, err
+		}
+		if r.MBox, err = p.name(); err != nil {
+			return nil, err
+		}
+		for _, v := range []*uint32{&r.Serial, &r.Refresh, &r.Retry, &r.Expire, &r.MinTTL} {
+			if *v, err = p.uint32(); err != nil {
+				return nil, err
+			}
+		}
+		rd = r
+	case TypeTXT:
+		r := &TXT{}
+		for p.off < end {
+			n := int(p.msg[p.off])
+			p.off++
+			if p.off+n > end {
+				return nil, ErrShortBuffer
+			}
+			r.Text = append(r.Text, string(p.msg[p.off:p.off+n]))
+			p.off += n
+		}
+		rd = r
+	case TypeSRV:
+		r := &SRV{}
+		for _, v := range []*uint16{&r.Priority, &r.Weight, &r.Port} {
+			if *v, err = p.uint16(); err != nil {
+				return nil, err
+			}
+		}
+		r.Target, err = p.name()
+		rd = r
+	default:
+		rd = &Unknown{T: t, Data: append([]byte(nil), p.msg[p.off:end]...)}
+		p.off = end
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.off != end {
+		return nil, fmt.Errorf("dns: %s record length mismatch", t)
+	}
+	return rd, nil
+}
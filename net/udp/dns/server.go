@@ -0,0 +1,309 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 没有EDNS时UDP回复最长512字节，超过的设置TC，客户端改用TCP
+	minUDPSize = 512
+	maxUDPSize = 4096
+
+	tcpIdleTimeout = 10 * time.Second
+)
+
+// Server 权威DNS服务器，回答Zone里的记录
+type Server struct {
+	Zone *Zone
+
+	mu        sync.Mutex
+	listeners []io.Closer
+	wg        sync.WaitGroup
+}
+
+func NewServer(zone *Zone) *Server {
+	return &Server{Zone: zone}
+}
+
+// ListenAndServe 在addr上同时监听UDP和TCP，直到Close
+// 端口为0时UDP使用和TCP相同的端口
+func (s *Server) ListenAndServe(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	errc := make(chan error, 2)
+	go func() { errc <- s.ServeUDP(pc) }()
+	go func() { errc <- s.ServeTCP(ln) }()
+	err = <-errc
+	s.Close()
+	<-errc
+	return err
+}
+
+func (s *Server) track(c io.Closer) {
+	s.mu.Lock()
+	s.listeners = append(s.listeners, c)
+	s.mu.Unlock()
+}
+
+// ServeUDP 在pc上处理查询，pc关闭时返回
+func (s *Server) ServeUDP(pc net.PacketConn) error {
+	s.track(pc)
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		req := new(Message)
+		if err := req.Unpack(buf[:n]); err != nil {
+			log.Println("dns:", addr, err)
+			if b := formErr(buf[:n]); b != nil {
+				pc.WriteTo(b, addr)
+			}
+			continue
+		}
+		if req.Response {
+			continue
+		}
+		resp := s.Handle(req)
+		b, err := packUDP(resp, udpSize(req))
+		if err != nil {
+			log.Println("dns:", err)
+			continue
+		}
+		if _, err := pc.WriteTo(b, addr); err != nil {
+			log.Println("dns:", err)
+		}
+	}
+}
+
+// formErr 不能解析的查询回复FORMERR，客户端不用等超时
+// 响应包(QR=1)不回复，避免两个服务器互相回复
+func formErr(msg []byte) []byte {
+	if len(msg) < 3 || msg[2]&0x80 != 0 {
+		return nil
+	}
+	resp := &Message{Header: Header{ID: binary.BigEndian.Uint16(msg), Response: true, RCode: RCodeFormatError}}
+	b, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// ServeTCP 每个报文前面有2字节长度，一个连接可以有多个查询
+func (s *Server) ServeTCP(ln net.Listener) error {
+	s.track(ln)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				s.wg.Wait()
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		msg, err := readTCP(conn)
+		if err != nil {
+			return
+		}
+		req := new(Message)
+		if err := req.Unpack(msg); err != nil {
+			log.Println("dns:", conn.RemoteAddr(), err)
+			// 有长度前缀，报文解析失败不影响后面的查询
+			if b := formErr(msg); b != nil {
+				if err := writeTCP(conn, b); err != nil {
+					return
+				}
+			}
+			continue
+		}
+		if req.Response {
+			continue
+		}
+		b, err := s.Handle(req).Pack()
+		if err != nil {
+			log.Println("dns:", err)
+			return
+		}
+		if err := writeTCP(conn, b); err != nil {
+			return
+		}
+	}
+}
+
+// Close 关闭所有监听
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.listeners = nil
+	return nil
+}
+
+// Handle 根据Zone生成回复
+func (s *Server) Handle(req *Message) *Message {
+	resp := req.Reply()
+	if req.Opcode != 0 {
+		resp.RCode = RCodeNotImplemented
+		return resp
+	}
+	if len(req.Questions) != 1 {
+		resp.RCode = RCodeFormatError
+		return resp
+	}
+	q := req.Questions[0]
+	if !s.Zone.Contains(q.Name) {
+		resp.RCode = RCodeRefused
+		return resp
+	}
+	resp.Authoritative = true
+	if opt := findOPT(req); opt != nil {
+		resp.Additionals = append(resp.Additionals, RR{Name: ".", Class: Class(maxUDPSize), Data: &Unknown{T: TypeOPT}})
+	}
+
+	name := q.Name
+	// 跟随CNAME，最多8层，防止循环
+	for i := 0; i < 8; i++ {
+		answers := s.Zone.Lookup(name, q.Type)
+		if len(answers) > 0 {
+			resp.Answers = append(resp.Answers, answers...)
+			break
+		}
+		cname := s.Zone.Lookup(name, TypeCNAME)
+		if len(cname) == 0 || q.Type == TypeCNAME {
+			break
+		}
+		resp.Answers = append(resp.Answers, cname[0])
+		name = cname[0].Data.(*CNAME).Target
+		if !s.Zone.Contains(name) {
+			break
+		}
+	}
+
+	if len(resp.Answers) == 0 {
+		if !s.Zone.exists(q.Name) {
+			resp.RCode = RCodeNameError
+		}
+		// 否定回答带上SOA，客户端据此缓存否定结果
+		resp.Authorities = s.Zone.Lookup(s.Zone.Origin, TypeSOA)
+		return resp
+	}
+
+	// SRV和MX的目标地址放在附加区，客户端不用再查一次
+	seen := map[string]bool{}
+	for _, rr := range resp.Answers {
+		var target string
+		switch d := rr.Data.(type) {
+		case *SRV:
+			target = d.Target
+		case *MX:
+			target = d.Host
+		default:
+			continue
+		}
+		key := CanonicalName(target)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		resp.Additionals = append(resp.Additionals, s.Zone.Lookup(target, TypeA)...)
+		resp.Additionals = append(resp.Additionals, s.Zone.Lookup(target, TypeAAAA)...)
+	}
+	return resp
+}
+
+func findOPT(m *Message) *RR {
+	for i := range m.Additionals {
+		if m.Additionals[i].Type() == TypeOPT {
+			return &m.Additionals[i]
+		}
+	}
+	return nil
+}
+
+// udpSize 客户端能接收的UDP报文长度，EDNS0的OPT记录里class是UDP长度
+func udpSize(req *Message) int {
+	opt := findOPT(req)
+	if opt == nil {
+		return minUDPSize
+	}
+	return min(max(int(opt.Class), minUDPSize), maxUDPSize)
+}
+
+// packUDP 超过size时从后往前去掉记录并设置TC
+func packUDP(m *Message, size int) ([]byte, error) {
+	b, err := m.Pack()
+	if err != nil || len(b) <= size {
+		return b, err
+	}
+	t := *m
+	t.Truncated = true
+	t.Additionals, t.Authorities = nil, nil
+	for {
+		b, err = t.Pack()
+		if err != nil || len(b) <= size || len(t.Answers) == 0 {
+			return b, err
+		}
+		t.Answers = t.Answers[:len(t.Answers)-1]
+	}
+}
+
+func readTCP(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCP(w io.Writer, msg []byte) error {
+	if len(msg) > 0xFFFF {
+		return errors.New("dns: message too long for tcp")
+	}
+	b := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,111 @@
+// Package dns DNS报文编解码和一个简单的权威DNS服务器
+//
+// 支持A、AAAA、CNAME、NS、SOA、PTR、MX、TXT、SRV记录，编码时压缩域名
+// 记录放在内存Zone里，也可以从zone文件加载，服务器同时监听UDP和TCP
+package dns
+
+import "strconv"
+
+type Type uint16
+
+const (
+	TypeA     Type = 1
+	TypeNS    Type = 2
+	TypeCNAME Type = 5
+	TypeSOA   Type = 6
+	TypePTR   Type = 12
+	TypeMX    Type = 15
+	TypeTXT   Type = 16
+	TypeAAAA  Type = 28
+	TypeSRV   Type = 33
+	TypeOPT   Type = 41
+	TypeNSEC  Type = 47
+	TypeANY   Type = 255
+)
+
+var typeNames = map[Type]string{
+	TypeA:     "A",
+	TypeNS:    "NS",
+	TypeCNAME: "CNAME",
+	TypeSOA:   "SOA",
+	TypePTR:   "PTR",
+	TypeMX:    "MX",
+	TypeTXT:   "TXT",
+	TypeAAAA:  "AAAA",
+	TypeSRV:   "SRV",
+	TypeOPT:   "OPT",
+	TypeNSEC:  "NSEC",
+	TypeANY:   "ANY",
+}
+
+func (t Type) String() string {
+	if s, ok := typeNames[t]; ok {
+		return s
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// ParseType 记录类型名转Type，不区分大小写，也支持TYPE123格式
+func ParseType(s string) (Type, bool) {
+	for t, name := range typeNames {
+		if equalFold(name, s) {
+			return t, true
+		}
+	}
+	if len(s) > 4 && equalFold(s[:4], "TYPE") {
+		n, err := strconv.ParseUint(s[4:], 10, 16)
+		if err == nil {
+			return Type(n), true
+		}
+	}
+	return 0, false
+}
+
+type Class uint16
+
+const (
+	ClassINET Class = 1
+	ClassANY  Class = 255
+
+	// mDNS里class的最高位，在回答里表示缓存刷新，在问题里表示希望单播回复
+	ClassCacheFlush Class = 1 << 15
+)
+
+type RCode uint8
+
+const (
+	RCodeSuccess        RCode = 0
+	RCodeFormatError    RCode = 1
+	RCodeServerFailure  RCode = 2
+	RCodeNameError      RCode = 3 // NXDOMAIN
+	RCodeNotImplemented RCode = 4
+	RCodeRefused        RCode = 5
+)
+
+var rcodeNames = [...]string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+func (r RCode) String() string {
+	if int(r) < len(rcodeNames) {
+		return rcodeNames[r]
+	}
+	return "RCODE" + strconv.Itoa(int(r))
+}
+
+func equalFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if lower(a[i]) != lower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultTTL 没有指定TTL的记录使用
+const DefaultTTL = 3600
+
+// Zone 内存里的记录，按域名和类型索引
+type Zone struct {
+	// Origin zone的根域名，服务器只回答这个域名下的问题，"."表示所有
+	Origin string
+
+	mu      sync.RWMutex
+	records map[string][]RR
+}
+
+func NewZone(origin string) *Zone {
+	return &Zone{Origin: CanonicalName(origin), records: make(map[string][]RR)}
+}
+
+// Add 添加记录，名字不是完整域名时相对于Origin
+func (z *Zone) Add(rr RR) {
+	rr.Name = z.absolute(rr.Name)
+	if rr.Class == 0 {
+		rr.Class = ClassINET
+	}
+	key := CanonicalName(rr.Name)
+	z.mu.Lock()
+	z.records[key] = append(z.records[key], rr)
+	z.mu.Unlock()
+}
+
+// Remove 删除name下type类型的记录，TypeANY删除所有类型
+func (z *Zone) Remove(name string, t Type) {
+	key := CanonicalName(z.absolute(name))
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if t == TypeANY {
+		delete(z.records, key)
+		return
+	}
+	list := z.records[key][:0]
+	for _, rr := range z.records[key] {
+		if rr.Type() != t {
+			list = append(list, rr)
+		}
+	}
+	if len(list) == 0 {
+		delete(z.records, key)
+	} else {
+		z.records[key] = list
+	}
+}
+
+// Lookup 返回name下type类型的记录，TypeANY返回所有
+func (z *Zone) Lookup(name string, t Type) []RR {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	var list []RR
+	for _, rr := range z.records[CanonicalName(name)] {
+		if t == TypeANY || rr.Type() == t {
+			list = append(list, rr)
+		}
+	}
+	return list
+}
+
+// exists 名字是否存在，没有记录但有子域名的中间名字也算存在
+func (z *Zone) exists(name string) bool {
+	key := CanonicalName(name)
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	if _, ok := z.records[key]; ok {
+		return true
+	}
+	for k := range z.records {
+		if strings.HasSuffix(k, "."+key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains name是否在zone内
+func (z *Zone) Contains(name string) bool {
+	if z.Origin == "." {
+		return true
+	}
+	key := CanonicalName(name)
+	return key == z.Origin || strings.HasSuffix(key, "."+z.Origin)
+}
+
+// Records 所有记录
+func (z *Zone) Records() []RR {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	var list []RR
+	for _, rrs := range z.records {
+		list = append(list, rrs...)
+	}
+	return list
+}
+
+func (z *Zone) absolute(name string) string {
+	if name == "" || name == "@" {
+		return z.Origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	if z.Origin == "." {
+		return name + "."
+	}
+	return name + "." + z.Origin
+}
+
+// ReverseName IP对应的PTR查询域名，如 4.3.2.1.in-addr.arpa.
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		var b strings.Builder
+		for i := 3; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(ip4[i])))
+			b.WriteByte('.')
+		}
+		return b.String() + "in-addr.arpa."
+	}
+	const hex = "0123456789abcdef"
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ""
+	}
+	var b strings.Builder
+	for i := 15; i >= 0; i-- {
+		b.WriteByte(hex[ip16[i]&0xF])
+		b.WriteByte('.')
+		b.WriteByte(hex[ip16[i]>>4])
+		b.WriteByte('.')
+	}
+	return b.String() + "ip6.arpa."
+}
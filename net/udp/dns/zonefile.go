@@ -0,0 +1,294 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadZoneFile 从zone文件加载，origin可以被文件里的$ORIGIN覆盖
+func LoadZoneFile(path, origin string) (*Zone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseZone(f, origin)
+}
+
+// ParseZone 解析RFC 1035 zone文件的常用子集
+//   - $ORIGIN 和 $TTL 指令
+//   - @ 表示origin，不以点结尾的名字相对于origin
+//   - 行首是空白时沿用上一条记录的名字
+//   - ; 开头的注释，括号跨行，TXT的引号字符串
+//   - TTL可以带 s m h d w 单位
+func ParseZone(r io.Reader, origin string) (*Zone, error) {
+	z := NewZone(origin)
+	ttl := uint32(DefaultTTL)
+	owner := ""
+
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	var pending []string
+	pendingStart := 0
+	depth := 0
+	blankOwner := false
+	for sc.Scan() {
+		lineNo++
+		line := sc.Text()
+		tokens, d, err := tokenize(line)
+		if err != nil {
+			return nil, fmt.Errorf("zone line %d: %w", lineNo, err)
+		}
+		if depth == 0 {
+			if len(tokens) == 0 {
+				continue
+			}
+			pendingStart = lineNo
+			blankOwner = line[0] == ' ' || line[0] == '\t'
+		}
+		pending = append(pending, tokens...)
+		depth += d
+		if depth < 0 {
+			return nil, fmt.Errorf("zone line %d: unbalanced parentheses", lineNo)
+		}
+		if depth > 0 {
+			continue
+		}
+		tokens, pending = pending, nil
+
+		switch strings.ToUpper(tokens[0]) {
+		case "$ORIGIN":
+			if len(tokens) != 2 {
+				return nil, fmt.Errorf("zone line %d: bad $ORIGIN", pendingStart)
+			}
+			z.Origin = CanonicalName(z.absolute(tokens[1]))
+			continue
+		case "$TTL":
+			if len(tokens) != 2 {
+				return nil, fmt.Errorf("zone line %d: bad $TTL", pendingStart)
+			}
+			v, err := parseTTL(tokens[1])
+			if err != nil {
+				return nil, fmt.Errorf("zone line %d: %w", pendingStart, err)
+			}
+			ttl = v
+			continue
+		}
+
+		if !blankOwner {
+			owner = z.absolute(tokens[0])
+			tokens = tokens[1:]
+		}
+		if owner == "" {
+			return nil, fmt.Errorf("zone line %d: no owner name", pendingStart)
+		}
+		rr, err := parseRecord(z, owner, ttl, tokens)
+		if err != nil {
+			return nil, fmt.Errorf("zone line %d: %w", pendingStart, err)
+		}
+		z.Add(rr)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("zone: unclosed parentheses at line %d", pendingStart)
+	}
+	return z, nil
+}
+
+// tokenize 拆分一行，去掉注释，返回括号深度的变化
+// 引号字符串返回时保留引号，用于区分TXT里的空字符串
+func tokenize(line string) ([]string, int, error) {
+	var tokens []string
+	depth := 0
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ';':
+			return tokens, depth, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			depth--
+			i++
+		case c == '"':
+			j := i + 1
+			var b strings.Builder
+			b.WriteByte('"')
+			for ; j < len(line) && line[j] != '"'; j++ {
+				if line[j] == '\\' && j+1 < len(line) {
+					j++
+				}
+				b.WriteByte(line[j])
+			}
+			if j >= len(line) {
+				return nil, 0, fmt.Errorf("unterminated string")
+			}
+			b.WriteByte('"')
+			tokens = append(tokens, b.String())
+			i = j + 1
+		default:
+			j := i
+			for j < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[j])) {
+				j++
+			}
+			tokens = append(tokens, line[i:j])
+			i = j
+		}
+	}
+	return tokens, depth, nil
+}
+
+func parseTTL(s string) (uint32, error) {
+	var total, cur uint64
+	digits := false
+	for _, c := range strings.ToLower(s) {
+		if c >= '0' && c <= '9' {
+			cur = cur*10 + uint64(c-'0')
+			digits = true
+			continue
+		}
+		unit, ok := map[rune]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}[c]
+		if !ok || !digits {
+			return 0, fmt.Errorf("bad ttl %q", s)
+		}
+		total += cur * unit
+		cur, digits = 0, false
+	}
+	total += cur
+	if total > 0x7FFFFFFF {
+		return 0, fmt.Errorf("ttl %q too large", s)
+	}
+	return uint32(total), nil
+}
+
+// parseRecord [ttl] [class] type rdata...，ttl和class顺序可以互换
+func parseRecord(z *Zone, owner string, ttl uint32, tokens []string) (RR, error) {
+	rr := RR{Name: owner, Class: ClassINET, TTL: ttl}
+	for len(tokens) > 0 {
+		tok := tokens[0]
+		if strings.EqualFold(tok, "IN") {
+			tokens = tokens[1:]
+			continue
+		}
+		if tok[0] >= '0' && tok[0] <= '9' {
+			v, err := parseTTL(tok)
+			if err != nil {
+				return rr, err
+			}
+			rr.TTL = v
+			tokens = tokens[1:]
+			continue
+		}
+		break
+	}
+	if len(tokens) == 0 {
+		return rr, fmt.Errorf("missing record type")
+	}
+	t, ok := ParseType(tokens[0])
+	if !ok {
+		return rr, fmt.Errorf("unknown record type %q", tokens[0])
+	}
+	args := tokens[1:]
+	need := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s needs %d fields, got %d", t, n, len(args))
+		}
+		return nil
+	}
+	uint16s := func(s ...string) ([]uint16, error) {
+		out := make([]uint16, len(s))
+		for i, v := range s {
+			n, err := strconv.ParseUint(v, 10, 16)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = uint16(n)
+		}
+		return out, nil
+	}
+
+	switch t {
+	case TypeA, TypeAAAA:
+		if err := need(1); err != nil {
+			return rr, err
+		}
+		ip := net.ParseIP(args[0])
+		if ip == nil || (t == TypeA) != (ip.To4() != nil) {
+			return rr, fmt.Errorf("bad %s address %q", t, args[0])
+		}
+		if t == TypeA {
+			rr.Data = &A{IP: ip.To4()}
+		} else {
+			rr.Data = &AAAA{IP: ip}
+		}
+	case TypeNS, TypeCNAME, TypePTR:
+		if err := need(1); err != nil {
+			return rr, err
+		}
+		name := z.absolute(args[0])
+		switch t {
+		case TypeNS:
+			rr.Data = &NS{Host: name}
+		case TypeCNAME:
+			rr.Data = &CNAME{Target: name}
+		default:
+			rr.Data = &PTR{Target: name}
+		}
+	case TypeMX:
+		if err := need(2); err != nil {
+			return rr, err
+		}
+		v, err := uint16s(args[0])
+		if err != nil {
+			return rr, err
+		}
+		rr.Data = &MX{Preference: v[0], Host: z.absolute(args[1])}
+	case TypeSRV:
+		if err := need(4); err != nil {
+			return rr, err
+		}
+		v, err := uint16s(args[:3]...)
+		if err != nil {
+			return rr, err
+		}
+		rr.Data = &SRV{Priority: v[0], Weight: v[1], Port: v[2], Target: z.absolute(args[3])}
+	case TypeSOA:
+		if err := need(7); err != nil {
+			return rr, err
+		}
+		soa := &SOA{NS: z.absolute(args[0]), MBox: z.absolute(args[1])}
+		for i, v := range []*uint32{&soa.Serial, &soa.Refresh, &soa.Retry, &soa.Expire, &soa.MinTTL} {
+			n, err := parseTTL(args[2+i])
+			if err != nil {
+				return rr, err
+			}
+			*v = n
+		}
+		rr.Data = soa
+	case TypeTXT:
+		if len(args) == 0 {
+			return rr, fmt.Errorf("TXT needs at least one string")
+		}
+		txt := &TXT{}
+		for _, a := range args {
+			if len(a) >= 2 && a[0] == '"' {
+				a = a[1 : len(a)-1]
+			}
+			txt.Text = append(txt.Text, a)
+		}
+		rr.Data = txt
+	default:
+		return rr, fmt.Errorf("record type %s not supported in zone file", t)
+	}
+	return rr, nil
+}
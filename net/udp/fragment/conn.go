@@ -0,0 +1,118 @@
+package fragment
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Writer 发送消息，超过MTU自动分片
+type Writer struct {
+	pc  net.PacketConn
+	mtu int
+	id  atomic.Uint32
+}
+
+// NewWriter mtu<=0时使用DefaultMTU
+func NewWriter(pc net.PacketConn, mtu int) *Writer {
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+	w := &Writer{pc: pc, mtu: mtu}
+	// 随机起始ID，进程重启后不容易和接收方还缓存着的旧消息ID重复
+	w.id.Store(rand.Uint32())
+	return w
+}
+
+// WriteTo 发送一个消息
+func (w *Writer) WriteTo(msg []byte, addr net.Addr) error {
+	frags, err := Split(w.id.Add(1), msg, w.mtu)
+	if err != nil {
+		return err
+	}
+	for _, f := range frags {
+		if _, err := w.pc.WriteTo(f, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reader 接收分片并返回重组后的完整消息
+// Reader用pc的读超时定时清理没收齐的消息，调用方要用Reader.SetReadDeadline设置超时
+type Reader struct {
+	pc  net.PacketConn
+	buf []byte
+	*Reassembler
+
+	dlMu     sync.Mutex
+	deadline time.Time
+}
+
+// NewReader timeout和maxBytes<=0时使用默认值
+func NewReader(pc net.PacketConn, timeout time.Duration, maxBytes int) *Reader {
+	return &Reader{
+		pc:          pc,
+		buf:         make([]byte, 65535),
+		Reassembler: NewReassembler(timeout, maxBytes),
+	}
+}
+
+// SetReadDeadline 设置ReadFrom的超时，零值表示不超时
+// 可以在其他goroutine调用，用来唤醒阻塞的ReadFrom
+func (r *Reader) SetReadDeadline(t time.Time) error {
+	r.dlMu.Lock()
+	r.deadline = t
+	r.dlMu.Unlock()
+	return r.pc.SetReadDeadline(t)
+}
+
+func (r *Reader) readDeadline() time.Time {
+	r.dlMu.Lock()
+	defer r.dlMu.Unlock()
+	return r.deadline
+}
+
+// ReadFrom 读取一个完整消息
+// 有消息没收齐被丢弃时返回*IncompleteError，这个错误不影响后续读取
+// 没有新的包时也会按超时时间报告没收齐的消息
+// 不是分片格式的包直接忽略
+func (r *Reader) ReadFrom() ([]byte, net.Addr, error) {
+	for {
+		if d := r.Incomplete(); len(d) > 0 {
+			// 一次只报告一个，剩下的放回去
+			r.mu.Lock()
+			r.dropped = append(d[1:], r.dropped...)
+			r.mu.Unlock()
+			return nil, nil, d[0]
+		}
+		// 读超时取调用方的超时和最早的消息超时中较早的一个
+		dl := r.readDeadline()
+		if next, ok := r.nextExpiry(); ok && (dl.IsZero() || next.Before(dl)) {
+			dl = next
+		}
+		if err := r.pc.SetReadDeadline(dl); err != nil {
+			return nil, nil, err
+		}
+		n, addr, err := r.pc.ReadFrom(r.buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				if user := r.readDeadline(); user.IsZero() || time.Now().Before(user) {
+					// 是消息超时触发的，清理后继续读
+					r.Expire()
+					continue
+				}
+			}
+			return nil, nil, err
+		}
+		msg, err := r.Add(addr.String(), r.buf[:n])
+		if err != nil || msg == nil {
+			continue
+		}
+		return msg, addr, nil
+	}
+}
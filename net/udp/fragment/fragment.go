@@ -0,0 +1,99 @@
+// Package fragment 在UDP上传输超过MTU的消息
+//
+// 大消息按MTU拆成多个分片发送，接收方按消息ID重组
+// 分片丢失时整个消息在超时后丢弃并报告为不完整，不做重传，需要可靠传输用rudp
+package fragment
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// 分片头
+//
+//	+-------+---------+----+-------+-------+
+//	| magic | version | id | index | count |
+//	+-------+---------+----+-------+-------+
+//	    1        1      4     2       2
+const (
+	magic      = 0xFA
+	version    = 1
+	HeaderSize = 10
+
+	// DefaultMTU 以太网MTU 1500减去IPv4头20和UDP头8
+	DefaultMTU = 1472
+	// MaxFragments 一个消息最多的分片数
+	MaxFragments = 0xFFFF
+)
+
+var (
+	ErrTooLarge  = errors.New("fragment: message too large")
+	ErrMTU       = errors.New("fragment: mtu too small")
+	ErrMalformed = errors.New("fragment: malformed packet")
+)
+
+// Header 分片头
+type Header struct {
+	ID    uint32
+	Index uint16
+	Count uint16
+}
+
+// Split 把msg拆成分片，每个分片(含头)不超过mtu
+func Split(id uint32, msg []byte, mtu int) ([][]byte, error) {
+	size := mtu - HeaderSize
+	if size <= 0 {
+		return nil, ErrMTU
+	}
+	count := (len(msg) + size - 1) / size
+	if count == 0 {
+		// 空消息也要发一个分片
+		count = 1
+	}
+	if count > MaxFragments {
+		return nil, ErrTooLarge
+	}
+	frags := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*size, len(msg))
+		payload := msg[i*size : end]
+		buf := make([]byte, HeaderSize+len(payload))
+		buf[0] = magic
+		buf[1] = version
+		binary.BigEndian.PutUint32(buf[2:], id)
+		binary.BigEndian.PutUint16(buf[6:], uint16(i))
+		binary.BigEndian.PutUint16(buf[8:], uint16(count))
+		copy(buf[HeaderSize:], payload)
+		frags = append(frags, buf)
+	}
+	return frags, nil
+}
+
+// Parse 解析分片，返回的payload引用pkt
+func Parse(pkt []byte) (Header, []byte, error) {
+	var h Header
+	if len(pkt) < HeaderSize || pkt[0] != magic || pkt[1] != version {
+		return h, nil, ErrMalformed
+	}
+	h.ID = binary.BigEndian.Uint32(pkt[2:])
+	h.Index = binary.BigEndian.Uint16(pkt[6:])
+	h.Count = binary.BigEndian.Uint16(pkt[8:])
+	if h.Count == 0 || h.Index >= h.Count {
+		return h, nil, ErrMalformed
+	}
+	return h, pkt[HeaderSize:], nil
+}
+
+// IncompleteError 消息没有收齐，超时或者超过内存上限被丢弃
+type IncompleteError struct {
+	Src    string
+	ID     uint32
+	Got    int
+	Count  int
+	Reason string
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("fragment: incomplete message %d from %s: %d/%d fragments, %s", e.ID, e.Src, e.Got, e.Count, e.Reason)
+}
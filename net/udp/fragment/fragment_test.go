@@ -0,0 +1,220 @@
+package fragment
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSplitReassemble(t *testing.T) {
+	for _, size := range []int{0, 1, 100, DefaultMTU - HeaderSize, DefaultMTU - HeaderSize + 1, 10000} {
+		msg := make([]byte, size)
+		rand.Read(msg)
+		frags, err := Split(1, msg, DefaultMTU)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range frags {
+			if len(f) > DefaultMTU {
+				t.Errorf("分片长度 %d 超过MTU", len(f))
+			}
+		}
+		r := NewReassembler(0, 0)
+		// 倒序加上重复分片
+		var got []byte
+		for i := len(frags) - 1; i >= 0; i-- {
+			for j := 0; j < 2; j++ {
+				m, err := r.Add("a", frags[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if m != nil {
+					got = m
+				}
+			}
+		}
+		if !bytes.Equal(got, msg) {
+			t.Errorf("size %d: 重组结果不一致", size)
+		}
+		if n, b := r.Pending(); n != 0 || b != 0 {
+			t.Errorf("size %d: 还有未完成的消息 %d %d", size, n, b)
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	if _, err := Split(1, []byte("x"), HeaderSize); err != ErrMTU {
+		t.Errorf("期望 ErrMTU，实际 %v", err)
+	}
+	if _, err := Split(1, make([]byte, MaxFragments*2+1), HeaderSize+2); err != ErrTooLarge {
+		t.Errorf("期望 ErrTooLarge，实际 %v", err)
+	}
+	if _, _, err := Parse([]byte("hello world")); err != ErrMalformed {
+		t.Errorf("期望 ErrMalformed，实际 %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	r := NewReassembler(20*time.Millisecond, 0)
+	frags, _ := Split(7, make([]byte, 5000), 1000)
+	r.Add("a", frags[0])
+	time.Sleep(30 * time.Millisecond)
+	r.Expire()
+	d := r.Incomplete()
+	if len(d) != 1 || d[0].ID != 7 || d[0].Got != 1 || d[0].Count != len(frags) {
+		t.Fatalf("超时消息报告错误: %v", d)
+	}
+	// 超时后剩下的分片重新开始计数，不会拼出错误的消息
+	for _, f := range frags[1:] {
+		if m, _ := r.Add("a", f); m != nil {
+			t.Error("不完整的消息被交付")
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	r := NewReassembler(0, 4500)
+	a, _ := Split(1, make([]byte, 3000), 1000)
+	b, _ := Split(2, make([]byte, 3000), 1000)
+	r.Add("a", a[0])
+	r.Add("a", a[1])
+	r.Add("a", a[2])
+	r.Add("a", b[0])
+	r.Add("a", b[1])
+	if _, n := r.Pending(); n > 4500 {
+		t.Errorf("缓存 %d 超过上限", n)
+	}
+	d := r.Incomplete()
+	if len(d) != 1 || d[0].ID != 1 {
+		t.Fatalf("应该丢弃最早的消息: %v", d)
+	}
+	// 消息2还能收齐
+	var got []byte
+	for _, f := range b[2:] {
+		got, _ = r.Add("a", f)
+	}
+	if len(got) != 3000 {
+		t.Errorf("消息2长度 %d", len(got))
+	}
+}
+
+func TestSlotLimit(t *testing.T) {
+	r := NewReassembler(0, 1<<20)
+	// 分片数很大的小包，槽位也要计入上限
+	frags, _ := Split(0, make([]byte, MaxFragments), 1+HeaderSize)
+	first := frags[0]
+	for id := uint32(0); id < 100; id++ {
+		binary.BigEndian.PutUint32(first[2:], id)
+		r.Add("a", first)
+		if _, n := r.Pending(); n > 1<<20 {
+			t.Fatalf("缓存 %d 超过上限", n)
+		}
+	}
+	if len(r.Incomplete()) == 0 {
+		t.Error("超过上限应该丢弃消息")
+	}
+
+	// 只是槽位就超过上限的消息直接拒绝
+	r = NewReassembler(0, 1000)
+	frags, _ = Split(1, make([]byte, 100), 1+HeaderSize)
+	r.Add("a", frags[0])
+	if p, n := r.Pending(); p != 0 || n != 0 {
+		t.Errorf("不应该缓存: %d %d", p, n)
+	}
+	if d := r.Incomplete(); len(d) != 1 || d[0].Reason != "memory limit" {
+		t.Errorf("拒绝的消息报告错误: %v", d)
+	}
+}
+
+func TestReaderIdleExpire(t *testing.T) {
+	spc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spc.Close()
+	cpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cpc.Close()
+
+	frags, _ := Split(1, make([]byte, 5000), 1000)
+	cpc.WriteTo(frags[0], spc.LocalAddr())
+
+	// 之后没有新的包，也要按时报告没收齐的消息
+	r := NewReader(spc, 50*time.Millisecond, 0)
+	r.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = r.ReadFrom()
+	var ie *IncompleteError
+	if !errors.As(err, &ie) || ie.ID != 1 || ie.Got != 1 {
+		t.Fatalf("期望 IncompleteError，实际 %v", err)
+	}
+	if p, n := r.Pending(); p != 0 || n != 0 {
+		t.Errorf("超时后缓存没有释放: %d %d", p, n)
+	}
+
+	// 调用方的超时照常返回
+	r.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err = r.ReadFrom()
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("期望超时错误，实际 %v", err)
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	spc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spc.Close()
+	cpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cpc.Close()
+
+	msg := make([]byte, 20000)
+	rand.Read(msg)
+	// 先发一个只有第一个分片的消息
+	frags, _ := Split(1, msg, DefaultMTU)
+	cpc.WriteTo(frags[0], spc.LocalAddr())
+	w := NewWriter(cpc, 0)
+	if err := w.WriteTo(msg, spc.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(spc, 50*time.Millisecond, 0)
+	r.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, addr, err := r.ReadFrom()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) || addr.String() != cpc.LocalAddr().String() {
+		t.Error("收到的消息不一致")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	// 再来一个包触发超时检查，不完整消息的错误和新消息都要收到
+	w.WriteTo([]byte("small"), spc.LocalAddr())
+	var incomplete, small bool
+	for i := 0; i < 2; i++ {
+		got, _, err = r.ReadFrom()
+		var ie *IncompleteError
+		switch {
+		case errors.As(err, &ie) && ie.Got == 1:
+			incomplete = true
+		case err == nil && string(got) == "small":
+			small = true
+		default:
+			t.Fatalf("读取错误: %q %v", got, err)
+		}
+	}
+	if !incomplete || !small {
+		t.Errorf("incomplete=%v small=%v", incomplete, small)
+	}
+}
@@ -0,0 +1,224 @@
+package fragment
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const (
+	DefaultTimeout  = 5 * time.Second
+	DefaultMaxBytes = 16 << 20
+)
+
+// slotSize 每个分片槽位占用的内存，槽位数由发送方的Count决定，也要计入MaxBytes
+const slotSize = int(unsafe.Sizeof([]byte(nil)))
+
+type partial struct {
+	key   string
+	src   string
+	id    uint32
+	frags [][]byte
+	got   int
+	// size 已收到的payload字节数，bytes再加上槽位占用
+	size    int
+	bytes   int
+	created time.Time
+}
+
+// Reassembler 按来源地址和消息ID重组分片
+type Reassembler struct {
+	// Timeout 消息从收到第一个分片开始，超过这个时间没收齐就丢弃
+	Timeout time.Duration
+	// MaxBytes 所有未收齐消息占用的内存上限，超过时丢弃最早的消息
+	MaxBytes int
+
+	mu       sync.Mutex
+	partials map[string]*partial
+	bytes    int
+	// 最近收齐的消息，迟到的重复分片直接忽略
+	done map[string]time.Time
+	// 丢弃的不完整消息，等待调用方取走
+	dropped []*IncompleteError
+}
+
+func NewReassembler(timeout time.Duration, maxBytes int) *Reassembler {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	return &Reassembler{
+		Timeout:  timeout,
+		MaxBytes: maxBytes,
+		partials: make(map[string]*partial),
+		done:     make(map[string]time.Time),
+	}
+}
+
+// Add 加入一个分片，消息收齐时返回完整消息，否则返回nil
+func (r *Reassembler) Add(src string, pkt []byte) ([]byte, error) {
+	h, payload, err := Parse(pkt)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(now)
+
+	// 只有一个分片，不用缓存
+	if h.Count == 1 {
+		msg := make([]byte, len(payload))
+		copy(msg, payload)
+		return msg, nil
+	}
+
+	key := src + "/" + strconv.FormatUint(uint64(h.ID), 10)
+	if _, ok := r.done[key]; ok {
+		return nil, nil
+	}
+	p, ok := r.partials[key]
+	if ok && int(h.Count) != len(p.frags) {
+		// 同一个ID分片数不一致，可能是发送方重启后ID重复，丢弃旧的
+		r.drop(p, "fragment count changed")
+		ok = false
+	}
+	if !ok {
+		slots := int(h.Count) * slotSize
+		if slots > r.MaxBytes {
+			// 只是槽位就超过上限，不分配
+			r.record(&IncompleteError{Src: src, ID: h.ID, Count: int(h.Count), Reason: "memory limit"})
+			return nil, nil
+		}
+		p = &partial{key: key, src: src, id: h.ID, bytes: slots, created: now}
+		r.bytes += slots
+		if r.bytes > r.MaxBytes {
+			// 先腾出空间再分配槽位
+			r.evict(nil)
+		}
+		p.frags = make([][]byte, h.Count)
+		r.partials[key] = p
+	}
+	if p.frags[h.Index] != nil {
+		// 重复分片
+		return nil, nil
+	}
+	frag := make([]byte, len(payload))
+	copy(frag, payload)
+	p.frags[h.Index] = frag
+	p.got++
+	p.size += len(frag)
+	p.bytes += len(frag)
+	r.bytes += len(frag)
+
+	if p.got == len(p.frags) {
+		delete(r.partials, key)
+		r.done[key] = now
+		r.bytes -= p.bytes
+		msg := make([]byte, 0, p.size)
+		for _, f := range p.frags {
+			msg = append(msg, f...)
+		}
+		return msg, nil
+	}
+
+	if r.bytes > r.MaxBytes {
+		r.evict(p)
+	}
+	return nil, nil
+}
+
+// Expire 丢弃超时的消息
+func (r *Reassembler) Expire() {
+	r.mu.Lock()
+	r.expire(time.Now())
+	r.mu.Unlock()
+}
+
+// Incomplete 取走被丢弃的不完整消息
+func (r *Reassembler) Incomplete() []*IncompleteError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := r.dropped
+	r.dropped = nil
+	return d
+}
+
+// Pending 未收齐的消息数和占用字节
+func (r *Reassembler) Pending() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.partials), r.bytes
+}
+
+// nextExpiry 最早超时的未收齐消息的超时时间
+func (r *Reassembler) nextExpiry() (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var next time.Time
+	for _, p := range r.partials {
+		if t := p.created.Add(r.Timeout); next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func (r *Reassembler) expire(now time.Time) {
+	for _, p := range r.partials {
+		if !now.Before(p.created.Add(r.Timeout)) {
+			r.drop(p, "timeout")
+		}
+	}
+	for key, t := range r.done {
+		if now.Sub(t) > r.Timeout {
+			delete(r.done, key)
+		}
+	}
+}
+
+// evict 超过内存上限，从最早的开始丢弃，尽量保留正在接收的消息
+// current为nil时新消息的槽位已经计入r.bytes，但是还没有加入partials
+func (r *Reassembler) evict(current *partial) {
+	list := make([]*partial, 0, len(r.partials))
+	for _, p := range r.partials {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].created.Before(list[j].created) })
+	for _, p := range list {
+		if r.bytes <= r.MaxBytes {
+			return
+		}
+		if p == current {
+			continue
+		}
+		r.drop(p, "memory limit")
+	}
+	if current != nil && r.bytes > r.MaxBytes {
+		r.drop(current, "memory limit")
+	}
+}
+
+func (r *Reassembler) drop(p *partial, reason string) {
+	delete(r.partials, p.key)
+	r.bytes -= p.bytes
+	r.record(&IncompleteError{
+		Src:    p.src,
+		ID:     p.id,
+		Got:    p.got,
+		Count:  len(p.frags),
+		Reason: reason,
+	})
+}
+
+func (r *Reassembler) record(e *IncompleteError) {
+	// 最多保留最近的一部分记录，调用方一直不取也不会无限增长
+	if len(r.dropped) >= 1024 {
+		r.dropped = r.dropped[1:]
+	}
+	r.dropped = append(r.dropped, e)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"log"
+	"net"
+	"os"
+
+	"github.com/ilaziness/gopkg/net/udp/holepunch"
+)
+
+// UDP打洞
+// 服务器：go run . -server :7000
+// peer b：go run . -rendezvous 1.2.3.4:7000 -id b
+// peer a：go run . -rendezvous 1.2.3.4:7000 -id a -connect b
+// 连接后输入的内容发给对方，打洞失败时自动通过服务器中转
+
+func main() {
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
+
+	var (
+		serverAddr string
+		rendezvous string
+		id         string
+		target     string
+	)
+	flag.StringVar(&serverAddr, "server", "", "run rendezvous server on this address")
+	flag.StringVar(&rendezvous, "rendezvous", "127.0.0.1:7000", "rendezvous server address")
+	flag.StringVar(&id, "id", "", "peer id")
+	flag.StringVar(&target, "connect", "", "peer id to connect, empty to wait for connection")
+	flag.Parse()
+
+	if serverAddr != "" {
+		log.Println("rendezvous server listen on", serverAddr)
+		log.Println(holepunch.NewServer().ListenAndServe(serverAddr))
+		return
+	}
+
+	if id == "" {
+		log.Fatalln("-id is required")
+	}
+	raddr, err := net.ResolveUDPAddr("udp", rendezvous)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	peer := holepunch.NewPeer(pc, id, raddr)
+	defer peer.Close()
+
+	ctx := context.Background()
+	public, err := peer.Register(ctx)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("registered as %s, public address %s", id, public)
+
+	var s *holepunch.Session
+	if target != "" {
+		s, err = peer.Connect(ctx, target)
+	} else {
+		s, err = peer.Accept(ctx)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if s.Relayed() {
+		log.Printf("connected to %s via relay", s.PeerID())
+	} else {
+		log.Printf("connected to %s directly at %s", s.PeerID(), s.RemoteAddr())
+	}
+
+	go func() {
+		for {
+			b, err := s.Recv(ctx)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			log.Printf("%s: %s", s.PeerID(), b)
+		}
+	}()
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		if err := s.Send(sc.Bytes()); err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package holepunch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+type packet struct {
+	data []byte
+	from net.Addr
+}
+
+// natConn 模拟NAT后面的socket
+//   - 端口受限锥形NAT：所有目的地址共用一个公网端口，只接收发送过包的地址回来的包
+//   - 对称NAT：每个目的地址用不同的公网端口，同样只接收发送过的地址
+//
+// 公网端口是回环地址上真实的UDP socket，对外看到的源地址就是它
+type natConn struct {
+	symmetric bool
+	private   net.Addr
+
+	mu      sync.Mutex
+	public  map[string]net.PacketConn // 目的地址 -> 公网socket，锥形NAT只有一个key ""
+	allowed map[string]bool           // 公网socket地址+远端地址，允许进入
+	in      chan packet
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newNAT(symmetric bool, n int) *natConn {
+	return &natConn{
+		symmetric: symmetric,
+		private:   &net.UDPAddr{IP: net.IPv4(192, 168, 1, byte(n)), Port: 5000},
+		public:    make(map[string]net.PacketConn),
+		allowed:   make(map[string]bool),
+		in:        make(chan packet, 256),
+		closed:    make(chan struct{}),
+	}
+}
+
+// mapping 获取发往dst要用的公网socket
+func (c *natConn) mapping(dst net.Addr) (net.PacketConn, error) {
+	key := ""
+	if c.symmetric {
+		key = dst.String()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pc, ok := c.public[key]; ok {
+		return pc, nil
+	}
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	c.public[key] = pc
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			c.mu.Lock()
+			ok := c.allowed[pc.LocalAddr().String()+"|"+from.String()]
+			c.mu.Unlock()
+			if !ok {
+				// 没有主动发过包的地址，丢弃
+				continue
+			}
+			select {
+			case c.in <- packet{append([]byte(nil), buf[:n]...), from}:
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+	return pc, nil
+}
+
+func (c *natConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	pc, err := c.mapping(addr)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.allowed[pc.LocalAddr().String()+"|"+addr.String()] = true
+	c.mu.Unlock()
+	return pc.WriteTo(b, addr)
+}
+
+func (c *natConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.in:
+		return copy(b, p.data), p.from, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *natConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		for _, pc := range c.public {
+			pc.Close()
+		}
+		c.mu.Unlock()
+	})
+	return nil
+}
+
+func (c *natConn) LocalAddr() net.Addr                { return c.private }
+func (c *natConn) SetDeadline(t time.Time) error      { return nil }
+func (c *natConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *natConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func startServer(t *testing.T) net.Addr {
+	t.Helper()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	go s.Serve(pc)
+	t.Cleanup(func() { s.Close() })
+	return pc.LocalAddr()
+}
+
+func newTestPeer(t *testing.T, pc net.PacketConn, id string, server net.Addr) *Peer {
+	t.Helper()
+	p := NewPeer(pc, id, server)
+	p.PunchTimeout = 500 * time.Millisecond
+	p.PunchInterval = 20 * time.Millisecond
+	t.Cleanup(func() { p.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := p.Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// connect a连接b，双向收发消息，返回双方的会话
+func connect(t *testing.T, a, b *Peer) (*Session, *Session) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var sb *Session
+	var errB error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sb, errB = b.Accept(ctx)
+	}()
+	sa, err := a.Connect(ctx, b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if errB != nil {
+		t.Fatal(errB)
+	}
+	if sa.PeerID() != b.ID || sb.PeerID() != a.ID {
+		t.Fatalf("会话对方ID错误: %s %s", sa.PeerID(), sb.PeerID())
+	}
+
+	for i := 0; i < 3; i++ {
+		msg := fmt.Sprintf("ping %d", i)
+		if err := sa.Send([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := sb.Recv(ctx)
+		if err != nil || string(got) != msg {
+			t.Fatalf("b收到 %q %v", got, err)
+		}
+		if err := sb.Send([]byte("pong")); err != nil {
+			t.Fatal(err)
+		}
+		got, err = sa.Recv(ctx)
+		if err != nil || string(got) != "pong" {
+			t.Fatalf("a收到 %q %v", got, err)
+		}
+	}
+	return sa, sb
+}
+
+func TestConeNAT(t *testing.T) {
+	server := startServer(t)
+	natA, natB := newNAT(false, 1), newNAT(false, 2)
+	a := newTestPeer(t, natA, "a", server)
+	b := newTestPeer(t, natB, "b", server)
+	if a.PublicAddr().String() == natA.LocalAddr().String() {
+		t.Error("服务器看到的应该是NAT后的公网地址")
+	}
+
+	sa, sb := connect(t, a, b)
+	if sa.Relayed() || sb.Relayed() {
+		t.Errorf("锥形NAT应该能打洞成功: a relayed=%v b relayed=%v", sa.Relayed(), sb.Relayed())
+	}
+	if sa.RemoteAddr().String() != b.PublicAddr().String() {
+		t.Errorf("直连地址 %s，期望b的公网地址 %s", sa.RemoteAddr(), b.PublicAddr())
+	}
+}
+
+func TestSymmetricNAT(t *testing.T) {
+	server := startServer(t)
+	a := newTestPeer(t, newNAT(true, 1), "a", server)
+	b := newTestPeer(t, newNAT(true, 2), "b", server)
+
+	sa, sb := connect(t, a, b)
+	if !sa.Relayed() || !sb.Relayed() {
+		t.Errorf("对称NAT之间应该中转: a relayed=%v b relayed=%v", sa.Relayed(), sb.Relayed())
+	}
+}
+
+func TestNATFilter(t *testing.T) {
+	// 确认模拟的NAT确实会丢弃没有主动联系过的地址发来的包
+	nat := newNAT(false, 1)
+	defer nat.Close()
+	other, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	stranger, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+
+	nat.WriteTo([]byte("hi"), other.LocalAddr())
+	buf := make([]byte, 10)
+	other.SetReadDeadline(time.Now().Add(time.Second))
+	_, public, err := other.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger.WriteTo([]byte("stranger"), public)
+	other.WriteTo([]byte("reply"), public)
+
+	got := make(chan string, 2)
+	go func() {
+		for {
+			n, _, err := nat.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			got <- string(buf[:n])
+		}
+	}()
+	select {
+	case s := <-got:
+		if s != "reply" {
+			t.Errorf("收到 %q，陌生地址的包应该被丢弃", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("没有收到回复")
+	}
+}
+
+func TestNoNAT(t *testing.T) {
+	server := startServer(t)
+	pcA, _ := net.ListenPacket("udp4", "127.0.0.1:0")
+	pcB, _ := net.ListenPacket("udp4", "127.0.0.1:0")
+	a := newTestPeer(t, pcA, "a", server)
+	b := newTestPeer(t, pcB, "b", server)
+	sa, _ := connect(t, a, b)
+	if sa.Relayed() {
+		t.Error("没有NAT应该直连")
+	}
+}
+
+func TestUnknownPeer(t *testing.T) {
+	server := startServer(t)
+	pc, _ := net.ListenPacket("udp4", "127.0.0.1:0")
+	a := newTestPeer(t, pc, "a", server)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := a.Connect(ctx, "nobody")
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("期望peer未注册的错误，实际 %v", err)
+	}
+	// 失败后可以重新连接
+	if _, err := a.Connect(ctx, "nobody"); err == nil || err.Error() == "holepunch: session with nobody already exists" {
+		t.Errorf("失败的会话没有清理: %v", err)
+	}
+}
+
+func TestSpoofedPunch(t *testing.T) {
+	server := startServer(t)
+	pc, _ := net.ListenPacket("udp4", "127.0.0.1:0")
+	a := newTestPeer(t, pc, "a", server)
+	// 重复注册不会启动多个keepAlive
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := a.Register(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	stranger, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+	stranger.WriteTo(marshal(typePunch, &control{ID: "b"}), pc.LocalAddr())
+	stranger.WriteTo(marshal(typePunchAck, &control{ID: "b"}), pc.LocalAddr())
+
+	actx, acancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer acancel()
+	if s, err := a.Accept(actx); err == nil {
+		t.Fatalf("陌生地址的PUNCH建立了会话 %s %s", s.PeerID(), s.RemoteAddr())
+	}
+	a.mu.Lock()
+	n := len(a.sessions)
+	a.mu.Unlock()
+	if n != 0 {
+		t.Errorf("陌生地址的PUNCH创建了 %d 个会话", n)
+	}
+
+	// 真正的b还能正常连接
+	b := newTestPeer(t, newNAT(false, 2), "b", server)
+	connect(t, b, a)
+}
+
+func TestRegisterTakeover(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	s.PeerTimeout = 300 * time.Millisecond
+	go s.Serve(pc)
+	defer s.Close()
+	server := pc.LocalAddr()
+
+	pcA, _ := net.ListenPacket("udp4", "127.0.0.1:0")
+	newTestPeer(t, pcA, "a", server)
+
+	// 其他地址不能用已经注册的ID
+	pcB, _ := net.ListenPacket("udp4", "127.0.0.1:0")
+	b := NewPeer(pcB, "a", server)
+	defer b.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := b.Register(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("期望ID已注册的错误，实际 %v", err)
+	}
+
+	// 原来的注册过期后可以换地址
+	s.mu.Lock()
+	s.peers["a"].lastSeen = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+	ctx2, cancel2 := context.WithTimeout(context.Background(), time.Second)
+	defer cancel2()
+	if addr, err := b.Register(ctx2); err != nil || addr.String() != pcB.LocalAddr().String() {
+		t.Errorf("过期后注册 %v %v", addr, err)
+	}
+}
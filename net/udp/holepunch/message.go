@@ -0,0 +1,50 @@
+package holepunch
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// 每个包第一个字节是类型，控制消息后面是JSON，DATA后面直接是数据
+const (
+	typeRegister   byte = 1 // peer -> server {id}
+	typeRegistered byte = 2 // server -> peer {addr} 服务器看到的peer公网地址
+	typeConnect    byte = 3 // peer -> server {id, target}
+	typePeer       byte = 4 // server -> 双方 {id, addr} 对方的公网地址
+	typePunch      byte = 5 // peer -> peer {id}
+	typePunchAck   byte = 6 // peer -> peer {id}
+	typeRelay      byte = 7 // peer -> server {target, payload}
+	typeRelayed    byte = 8 // server -> peer {id, payload}
+	typeData       byte = 9 // peer -> peer 直连数据
+	typeError      byte = 10
+)
+
+var errMalformed = errors.New("holepunch: malformed packet")
+
+type control struct {
+	ID      string `json:"id,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Addr    string `json:"addr,omitempty"`
+	Payload []byte `json:"payload,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func marshal(typ byte, c *control) []byte {
+	b, _ := json.Marshal(c)
+	return append([]byte{typ}, b...)
+}
+
+func unmarshal(pkt []byte) (byte, *control, error) {
+	if len(pkt) < 1 {
+		return 0, nil, errMalformed
+	}
+	c := &control{}
+	if pkt[0] == typeData {
+		c.Payload = pkt[1:]
+		return pkt[0], c, nil
+	}
+	if err := json.Unmarshal(pkt[1:], c); err != nil {
+		return 0, nil, errMalformed
+	}
+	return pkt[0], c, nil
+}
@@ -0,0 +1,476 @@
+package holepunch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPunchTimeout  = 3 * time.Second
+	DefaultPunchInterval = 100 * time.Millisecond
+	DefaultKeepAlive     = 15 * time.Second
+
+	// 没收到回复时，注册和连接请求的重发间隔
+	retryInterval = 500 * time.Millisecond
+)
+
+var ErrClosed = errors.New("holepunch: peer closed")
+
+// Peer 通过rendezvous服务器和其他peer建立连接
+//
+// 打洞过程：
+//  1. 双方向服务器注册，服务器记下看到的公网地址(NAT映射后的地址)
+//  2. 一方请求连接，服务器把双方的公网地址发给对方
+//  3. 双方同时向对方公网地址发PUNCH，自己的NAT出现对方地址的映射后，对方的包就能进来
+//  4. 收到对方的PUNCH或PUNCH_ACK即打洞成功，超时则通过服务器中转
+//
+// 对称NAT每个目的地址用不同的端口，服务器看到的地址对方用不了，只能中转
+type Peer struct {
+	ID            string
+	PunchTimeout  time.Duration
+	PunchInterval time.Duration
+	// KeepAlive 定时重新注册并给直连的peer发包，保持NAT映射
+	KeepAlive time.Duration
+
+	pc     net.PacketConn
+	server net.Addr
+
+	mu         sync.Mutex
+	public     net.Addr
+	registered chan struct{}
+	// regErr 服务器拒绝注册
+	regErr   chan error
+	waiters  map[string]chan *control
+	sessions map[string]*Session
+	incoming chan *Session
+
+	keepAliveOnce sync.Once
+	closed        chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewPeer pc由peer独占，Close时关闭
+func NewPeer(pc net.PacketConn, id string, server net.Addr) *Peer {
+	p := &Peer{
+		ID:            id,
+		PunchTimeout:  DefaultPunchTimeout,
+		PunchInterval: DefaultPunchInterval,
+		KeepAlive:     DefaultKeepAlive,
+		pc:            pc,
+		server:        server,
+		registered:    make(chan struct{}),
+		regErr:        make(chan error, 1),
+		waiters:       make(map[string]chan *control),
+		sessions:      make(map[string]*Session),
+		incoming:      make(chan *Session, 16),
+		closed:        make(chan struct{}),
+	}
+	go p.readLoop()
+	return p
+}
+
+// Register 向服务器注册，返回服务器看到的公网地址
+func (p *Peer) Register(ctx context.Context) (net.Addr, error) {
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		if err := p.send(p.server, typeRegister, &control{ID: p.ID}); err != nil {
+			return nil, err
+		}
+		select {
+		case <-p.registered:
+			p.keepAliveOnce.Do(func() { go p.keepAlive() })
+			return p.PublicAddr(), nil
+		case err := <-p.regErr:
+			return nil, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-p.closed:
+			return nil, ErrClosed
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublicAddr 服务器看到的地址，注册前为nil
+func (p *Peer) PublicAddr() net.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.public
+}
+
+// Connect 连接target，打洞失败时返回中转的会话
+func (p *Peer) Connect(ctx context.Context, target string) (*Session, error) {
+	wait := make(chan *control, 1)
+	p.mu.Lock()
+	p.waiters[target] = wait
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.waiters, target)
+		p.mu.Unlock()
+	}()
+
+	// 先创建会话，服务器的回复到了之后对方的PUNCH才会被接受
+	s, created := p.session(target, false)
+	if !created {
+		return nil, errors.New("holepunch: session with " + target + " already exists")
+	}
+	fail := func(err error) (*Session, error) {
+		p.mu.Lock()
+		delete(p.sessions, target)
+		p.mu.Unlock()
+		return nil, err
+	}
+
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	var info *control
+	for info == nil {
+		if err := p.send(p.server, typeConnect, &control{ID: p.ID, Target: target}); err != nil {
+			return fail(err)
+		}
+		select {
+		case info = <-wait:
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		case <-p.closed:
+			return nil, ErrClosed
+		case <-ticker.C:
+		}
+	}
+	if info.Error != "" {
+		return fail(errors.New(info.Error))
+	}
+	addr, err := net.ResolveUDPAddr("udp", info.Addr)
+	if err != nil {
+		return fail(err)
+	}
+
+	go p.punch(s, addr)
+	select {
+	case <-s.done:
+		return s, nil
+	case <-ctx.Done():
+		return fail(ctx.Err())
+	case <-p.closed:
+		return nil, ErrClosed
+	}
+}
+
+// Accept 等待其他peer发起的连接
+func (p *Peer) Accept(ctx context.Context) (*Session, error) {
+	select {
+	case s := <-p.incoming:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.closed:
+		return nil, ErrClosed
+	}
+}
+
+// session 获取或创建和id的会话，remote表示对方发起
+// 对方发起的会话在打洞结束后交给Accept
+func (p *Peer) session(id string, remote bool) (*Session, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.sessions[id]; ok {
+		return s, false
+	}
+	s := &Session{
+		peer: p,
+		id:   id,
+		done: make(chan struct{}),
+		in:   make(chan []byte, 64),
+	}
+	p.sessions[id] = s
+	if remote {
+		go func() {
+			select {
+			case <-s.done:
+			case <-p.closed:
+				return
+			}
+			select {
+			case p.incoming <- s:
+			default:
+				log.Println("holepunch: accept queue full, drop session", id)
+			}
+		}()
+	}
+	return s, true
+}
+
+// punch 向addr发PUNCH直到打洞成功或超时，超时改为中转
+// addr是服务器告诉的对方地址，之后只接受这个地址发来的PUNCH
+func (p *Peer) punch(s *Session, addr net.Addr) {
+	s.mu.Lock()
+	s.expect = addr
+	s.mu.Unlock()
+	ticker := time.NewTicker(p.PunchInterval)
+	defer ticker.Stop()
+	timeout := time.NewTimer(p.PunchTimeout)
+	defer timeout.Stop()
+	for {
+		p.send(addr, typePunch, &control{ID: p.ID})
+		select {
+		case <-s.done:
+			return
+		case <-p.closed:
+			return
+		case <-timeout.C:
+			s.finish(nil)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Peer) readLoop() {
+	defer p.Close()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := p.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		typ, c, err := unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		p.handle(typ, c, addr)
+	}
+}
+
+func (p *Peer) handle(typ byte, c *control, addr net.Addr) {
+	fromServer := addr.String() == p.server.String()
+	switch typ {
+	case typeRegistered:
+		if !fromServer {
+			return
+		}
+		public, err := net.ResolveUDPAddr("udp", c.Addr)
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		p.public = public
+		select {
+		case <-p.registered:
+		default:
+			close(p.registered)
+		}
+		p.mu.Unlock()
+	case typePeer, typeError:
+		if !fromServer {
+			return
+		}
+		id := c.ID
+		if typ == typeError {
+			if c.Target == "" {
+				// 注册被拒绝
+				select {
+				case p.regErr <- errors.New(c.Error):
+				default:
+				}
+				return
+			}
+			id = c.Target
+		}
+		p.mu.Lock()
+		wait, ok := p.waiters[id]
+		p.mu.Unlock()
+		if ok {
+			select {
+			case wait <- c:
+			default:
+			}
+			return
+		}
+		if typ == typeError {
+			return
+		}
+		// 对方发起的连接，一起打洞
+		addr, err := net.ResolveUDPAddr("udp", c.Addr)
+		if err != nil {
+			return
+		}
+		if s, created := p.session(c.ID, true); created {
+			go p.punch(s, addr)
+		}
+	case typePunch, typePunchAck:
+		// 会话只由自己Connect或者服务器通知创建，PUNCH必须来自服务器告诉的地址，
+		// 否则任何人都可以冒充对方的ID
+		p.mu.Lock()
+		s, ok := p.sessions[c.ID]
+		p.mu.Unlock()
+		if !ok || !s.from(addr) {
+			return
+		}
+		if typ == typePunch {
+			p.send(addr, typePunchAck, &control{ID: p.ID})
+		}
+		s.finish(addr)
+	case typeRelayed:
+		if !fromServer {
+			return
+		}
+		s, created := p.session(c.ID, true)
+		if created {
+			s.finish(nil)
+		}
+		s.deliver(c.Payload)
+	case typeData:
+		if s := p.sessionByAddr(addr); s != nil {
+			s.deliver(append([]byte(nil), c.Payload...))
+		}
+	}
+}
+
+func (p *Peer) sessionByAddr(addr net.Addr) *Session {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.sessions {
+		if r := s.RemoteAddr(); r != nil && r.String() == addr.String() {
+			return s
+		}
+	}
+	return nil
+}
+
+// keepAlive 保持和服务器、直连peer之间的NAT映射
+func (p *Peer) keepAlive() {
+	ticker := time.NewTicker(p.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+		}
+		p.send(p.server, typeRegister, &control{ID: p.ID})
+		p.mu.Lock()
+		var remotes []net.Addr
+		for _, s := range p.sessions {
+			if r := s.RemoteAddr(); r != nil {
+				remotes = append(remotes, r)
+			}
+		}
+		p.mu.Unlock()
+		for _, r := range remotes {
+			p.send(r, typePunch, &control{ID: p.ID})
+		}
+	}
+}
+
+func (p *Peer) send(addr net.Addr, typ byte, c *control) error {
+	_, err := p.pc.WriteTo(marshal(typ, c), addr)
+	return err
+}
+
+func (p *Peer) sendData(addr net.Addr, b []byte) error {
+	_, err := p.pc.WriteTo(append([]byte{typeData}, b...), addr)
+	return err
+}
+
+// Close 关闭peer和所有会话
+func (p *Peer) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.pc.Close()
+	})
+	return nil
+}
+
+// Session 和另一个peer的会话，直连或者通过服务器中转
+// 和UDP一样不保证送达
+type Session struct {
+	peer *Peer
+	id   string
+
+	mu     sync.Mutex
+	remote net.Addr
+	// expect 服务器告诉的对方地址
+	expect net.Addr
+
+	done     chan struct{}
+	doneOnce sync.Once
+	in       chan []byte
+}
+
+// finish 打洞结束，remote为nil表示失败，改为中转
+func (s *Session) finish(remote net.Addr) {
+	s.doneOnce.Do(func() {
+		s.mu.Lock()
+		s.remote = remote
+		s.mu.Unlock()
+		close(s.done)
+	})
+}
+
+// from addr是不是服务器告诉的对方地址或者已经直连的地址
+func (s *Session) from(addr net.Addr) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range []net.Addr{s.expect, s.remote} {
+		if a != nil && a.String() == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Session) deliver(b []byte) {
+	select {
+	case s.in <- b:
+	default:
+		// 接收太慢，丢弃
+	}
+}
+
+// PeerID 对方的ID
+func (s *Session) PeerID() string {
+	return s.id
+}
+
+// RemoteAddr 直连时对方的地址，中转时为nil
+func (s *Session) RemoteAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remote
+}
+
+// Relayed 是否通过服务器中转
+func (s *Session) Relayed() bool {
+	return s.RemoteAddr() == nil
+}
+
+// Send 发送一个数据包
+func (s *Session) Send(b []byte) error {
+	select {
+	case <-s.done:
+	default:
+		return errors.New("holepunch: session not established")
+	}
+	if r := s.RemoteAddr(); r != nil {
+		return s.peer.sendData(r, b)
+	}
+	return s.peer.send(s.peer.server, typeRelay, &control{Target: s.id, Payload: b})
+}
+
+// Recv 接收一个数据包
+func (s *Session) Recv(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-s.in:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.peer.closed:
+		return nil, ErrClosed
+	}
+}
@@ -0,0 +1,142 @@
+package holepunch
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultPeerTimeout 超过这个时间没有重新注册的peer被移除
+const DefaultPeerTimeout = 60 * time.Second
+
+var relayBytes = expvar.NewInt("holepunch_relay_bytes")
+
+type peerInfo struct {
+	addr     net.Addr
+	lastSeen time.Time
+}
+
+// Server 交换peer的公网地址，打洞失败时中转数据
+type Server struct {
+	PeerTimeout time.Duration
+
+	pc    net.PacketConn
+	mu    sync.Mutex
+	peers map[string]*peerInfo
+}
+
+func NewServer() *Server {
+	return &Server{PeerTimeout: DefaultPeerTimeout, peers: make(map[string]*peerInfo)}
+}
+
+// ListenAndServe 监听UDP地址
+func (s *Server) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(pc)
+}
+
+// Serve 在pc上处理请求，pc关闭时返回
+func (s *Server) Serve(pc net.PacketConn) error {
+	s.mu.Lock()
+	s.pc = pc
+	s.mu.Unlock()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		typ, c, err := unmarshal(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.handle(typ, c, addr)
+	}
+}
+
+func (s *Server) handle(typ byte, c *control, addr net.Addr) {
+	switch typ {
+	case typeRegister:
+		if c.ID == "" {
+			return
+		}
+		s.mu.Lock()
+		s.expire()
+		if old, ok := s.peers[c.ID]; ok && old.addr.String() != addr.String() {
+			// ID还在别的地址上使用，等它过期才能换地址，不然任何人都能抢走别人的ID
+			s.mu.Unlock()
+			s.send(addr, typeError, &control{ID: c.ID, Error: "peer " + c.ID + " already registered"})
+			return
+		}
+		s.peers[c.ID] = &peerInfo{addr: addr, lastSeen: time.Now()}
+		s.mu.Unlock()
+		s.send(addr, typeRegistered, &control{Addr: addr.String()})
+	case typeConnect:
+		s.mu.Lock()
+		self, ok1 := s.peers[c.ID]
+		target, ok2 := s.peers[c.Target]
+		s.mu.Unlock()
+		if !ok1 || !ok2 || self.addr.String() != addr.String() {
+			s.send(addr, typeError, &control{Target: c.Target, Error: "peer " + c.Target + " not registered"})
+			return
+		}
+		// 同时告诉双方对方的地址，双方一起打洞
+		s.send(addr, typePeer, &control{ID: c.Target, Addr: target.addr.String()})
+		s.send(target.addr, typePeer, &control{ID: c.ID, Addr: addr.String()})
+	case typeRelay:
+		s.mu.Lock()
+		from := s.idOf(addr)
+		target, ok := s.peers[c.Target]
+		s.mu.Unlock()
+		if from == "" || !ok {
+			return
+		}
+		relayBytes.Add(int64(len(c.Payload)))
+		s.send(target.addr, typeRelayed, &control{ID: from, Payload: c.Payload})
+	}
+}
+
+// idOf 根据地址找peer，需持有锁
+func (s *Server) idOf(addr net.Addr) string {
+	for id, p := range s.peers {
+		if p.addr.String() == addr.String() {
+			return id
+		}
+	}
+	return ""
+}
+
+// expire 需持有锁
+func (s *Server) expire() {
+	now := time.Now()
+	for id, p := range s.peers {
+		if now.Sub(p.lastSeen) > s.PeerTimeout {
+			delete(s.peers, id)
+		}
+	}
+}
+
+func (s *Server) send(addr net.Addr, typ byte, c *control) {
+	if _, err := s.pc.WriteTo(marshal(typ, c), addr); err != nil {
+		log.Println("holepunch:", err)
+	}
+}
+
+// Close 关闭监听
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pc == nil {
+		return nil
+	}
+	return s.pc.Close()
+}
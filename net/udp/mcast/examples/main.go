@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/ilaziness/gopkg/net/udp/mcast"
+)
+
+// 组播聊天室，同一网段的多个进程输入的内容互相可见
+// go run . -name a
+// go run . -name b
+
+var (
+	topic = flag.String("topic", "chat", "topic")
+	name  = flag.String("name", "", "成员名，默认主机名")
+	iface = flag.String("iface", "", "网卡名，默认自动选择")
+	ipv6  = flag.Bool("6", false, "使用IPv6组播")
+	loop  = flag.Bool("loopback", true, "本机其他进程能否收到")
+)
+
+func main() {
+	flag.Parse()
+	node, err := mcast.New(mcast.Config{
+		Interface: *iface,
+		IPv6:      *ipv6,
+		Loopback:  *loop,
+		Name:      *name,
+		OnMember: func(topic string, m mcast.Member, joined bool) {
+			if joined {
+				log.Printf("[%s] %s(%s) 加入", topic, m.Name, m.Addr)
+			} else {
+				log.Printf("[%s] %s 离开", topic, m.Name)
+			}
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer node.Close()
+
+	ch, err := node.Subscribe(*topic)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	go func() {
+		for m := range ch {
+			log.Printf("[%s] %s: %s", m.Topic, m.From.Name, m.Payload)
+		}
+	}()
+
+	go func() {
+		sc := bufio.NewScanner(os.Stdin)
+		for sc.Scan() {
+			switch sc.Text() {
+			case "":
+			case "/members":
+				for _, m := range node.Members(*topic) {
+					log.Printf("%s %s %s", m.Name, m.Addr, m.LastSeen.Format("15:04:05"))
+				}
+			default:
+				if err := node.Publish(*topic, sc.Bytes()); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	<-sig
+}
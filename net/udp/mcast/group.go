@@ -0,0 +1,75 @@
+package mcast
+
+import (
+	"errors"
+	"hash/fnv"
+	"net"
+)
+
+// GroupFor topic映射到组播地址
+//   - IPv4：239.255.0.0/16，组织内部使用的管理权限地址
+//   - IPv6：ff15::/16，站点范围的临时地址
+//
+// 不同topic可能映射到同一个地址，收到消息后还会按topic过滤
+func GroupFor(topic string, ipv6 bool) net.IP {
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+	sum := h.Sum32()
+	if ipv6 {
+		ip := make(net.IP, net.IPv6len)
+		ip[0], ip[1] = 0xff, 0x15
+		ip[12], ip[13], ip[14], ip[15] = byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum)
+		return ip
+	}
+	// 避开x.x.x.0和x.x.x.255
+	b := byte(sum)
+	if b == 0 || b == 255 {
+		b = 1
+	}
+	return net.IPv4(239, 255, byte(sum>>8), b)
+}
+
+// SelectInterface 自动选择网卡：优先启用、支持组播、有对应地址族地址的非回环网卡，没有时用回环网卡
+func SelectInterface(ipv6 bool) (*net.Interface, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var loopback *net.Interface
+	for i := range ifs {
+		ifi := &ifs[i]
+		if ifi.Flags&net.FlagUp == 0 || !hasAddr(ifi, ipv6) {
+			continue
+		}
+		if ifi.Flags&net.FlagLoopback != 0 {
+			if loopback == nil {
+				loopback = ifi
+			}
+			continue
+		}
+		if ifi.Flags&net.FlagMulticast != 0 {
+			return ifi, nil
+		}
+	}
+	if loopback != nil {
+		return loopback, nil
+	}
+	return nil, errors.New("mcast: no multicast interface")
+}
+
+func hasAddr(ifi *net.Interface, ipv6 bool) bool {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if (ipn.IP.To4() == nil) == ipv6 {
+			return true
+		}
+	}
+	return false
+}
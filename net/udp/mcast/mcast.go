@@ -0,0 +1,424 @@
+// Package mcast 基于组播的发布订阅
+//
+// 每个topic映射到一个组播地址，订阅时加入组播组，发布时发往对应的组
+// 订阅的节点定时在组内发心跳，据此维护每个topic的在线成员
+// 消息经过fragment分片，可以超过MTU，但和组播本身一样不保证送达
+package mcast
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ilaziness/gopkg/net/udp/fragment"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	DefaultPort      = 9990
+	DefaultHeartbeat = time.Second
+)
+
+var ErrClosed = errors.New("mcast: node closed")
+
+type Config struct {
+	// Interface 网卡名，为空时自动选择
+	Interface string
+	IPv6      bool
+	// Port 所有topic共用一个端口
+	Port int
+	// Loopback 本机其他节点是否能收到本节点发送的消息，自己发的消息不会投递给自己
+	Loopback bool
+	// TTL IPv4 TTL或IPv6 hop limit，默认1，不出本网段
+	TTL int
+	// Name 成员名，默认主机名
+	Name string
+	// Groups 指定topic的组播地址，没有指定的按GroupFor计算
+	Groups map[string]net.IP
+	// Heartbeat 心跳间隔
+	Heartbeat time.Duration
+	// MemberTimeout 超过这个时间没有心跳的成员被移除，默认3倍心跳间隔
+	MemberTimeout time.Duration
+	// OnMember 成员加入(joined=true)或离开时调用
+	OnMember func(topic string, m Member, joined bool)
+}
+
+// groupConn ipv4.PacketConn和ipv6.PacketConn共同的方法
+type groupConn interface {
+	JoinGroup(ifi *net.Interface, group net.Addr) error
+	LeaveGroup(ifi *net.Interface, group net.Addr) error
+}
+
+type subscription struct {
+	group   *net.UDPAddr
+	ch      chan Message
+	members map[string]*Member
+}
+
+// Node 一个发布订阅节点
+type Node struct {
+	cfg   Config
+	id    string
+	ifi   *net.Interface
+	conn  net.PacketConn
+	gc    groupConn
+	w     *fragment.Writer
+	dropC atomic.Int64
+
+	mu     sync.Mutex
+	subs   map[string]*subscription
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New 创建节点
+func New(cfg Config) (*Node, error) {
+	if cfg.Port == 0 {
+		cfg.Port = DefaultPort
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = 1
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = DefaultHeartbeat
+	}
+	if cfg.MemberTimeout <= 0 {
+		cfg.MemberTimeout = 3 * cfg.Heartbeat
+	}
+	if cfg.Name == "" {
+		cfg.Name, _ = os.Hostname()
+	}
+
+	var ifi *net.Interface
+	var err error
+	if cfg.Interface != "" {
+		ifi, err = net.InterfaceByName(cfg.Interface)
+	} else {
+		ifi, err = SelectInterface(cfg.IPv6)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	network, addr := "udp4", "0.0.0.0:"
+	if cfg.IPv6 {
+		network, addr = "udp6", "[::]:"
+	}
+	lc := net.ListenConfig{Control: reuseControl}
+	conn, err := lc.ListenPacket(context.Background(), network, addr+strconv.Itoa(cfg.Port))
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		cfg:  cfg,
+		id:   newID(),
+		ifi:  ifi,
+		conn: conn,
+		w:    fragment.NewWriter(conn, mtu(ifi, cfg.IPv6)),
+		subs: make(map[string]*subscription),
+		done: make(chan struct{}),
+	}
+	if err := n.setup(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	n.wg.Add(2)
+	go n.readLoop()
+	go n.heartbeatLoop()
+	return n, nil
+}
+
+// setup 设置组播网卡、TTL和回环
+func (n *Node) setup() error {
+	if n.cfg.IPv6 {
+		p := ipv6.NewPacketConn(n.conn)
+		n.gc = p
+		if err := p.SetMulticastInterface(n.ifi); err != nil {
+			return err
+		}
+		if err := p.SetMulticastHopLimit(n.cfg.TTL); err != nil {
+			return err
+		}
+		return p.SetMulticastLoopback(n.cfg.Loopback)
+	}
+	p := ipv4.NewPacketConn(n.conn)
+	n.gc = p
+	if err := p.SetMulticastInterface(n.ifi); err != nil {
+		return err
+	}
+	if err := p.SetMulticastTTL(n.cfg.TTL); err != nil {
+		return err
+	}
+	return p.SetMulticastLoopback(n.cfg.Loopback)
+}
+
+// mtu 去掉IP和UDP头后一个包能携带的长度
+func mtu(ifi *net.Interface, ipv6 bool) int {
+	if ifi.MTU <= 0 {
+		return fragment.DefaultMTU
+	}
+	overhead := 20 + 8
+	if ipv6 {
+		overhead = 40 + 8
+	}
+	// 回环网卡MTU是65536，不能超过UDP包的最大长度
+	return min(ifi.MTU-overhead, 65507)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ID 节点ID，每次创建都不同
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) group(topic string) *net.UDPAddr {
+	ip, ok := n.cfg.Groups[topic]
+	if !ok {
+		ip = GroupFor(topic, n.cfg.IPv6)
+	}
+	return &net.UDPAddr{IP: ip, Port: n.cfg.Port}
+}
+
+// Subscribe 订阅topic，返回的channel在取消订阅或节点关闭时关闭
+// channel满时新消息被丢弃
+func (n *Node) Subscribe(topic string) (<-chan Message, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return nil, ErrClosed
+	}
+	if _, ok := n.subs[topic]; ok {
+		return nil, fmt.Errorf("mcast: topic %q already subscribed", topic)
+	}
+	group := n.group(topic)
+	if !n.joined(group) {
+		if err := n.gc.JoinGroup(n.ifi, group); err != nil {
+			return nil, err
+		}
+	}
+	sub := &subscription{group: group, ch: make(chan Message, 64), members: make(map[string]*Member)}
+	n.subs[topic] = sub
+	// 立即发一次心跳，其他成员不用等一个心跳周期
+	go n.send(typeHeartbeat, topic, nil)
+	return sub.ch, nil
+}
+
+// joined 组播地址是否已经被其他topic加入，需持有锁
+func (n *Node) joined(group *net.UDPAddr) bool {
+	for _, s := range n.subs {
+		if s.group.IP.Equal(group.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unsubscribe 取消订阅，通知其他成员离开
+func (n *Node) Unsubscribe(topic string) error {
+	n.mu.Lock()
+	sub, ok := n.subs[topic]
+	if !ok {
+		n.mu.Unlock()
+		return nil
+	}
+	delete(n.subs, topic)
+	close(sub.ch)
+	var err error
+	if !n.joined(sub.group) {
+		err = n.gc.LeaveGroup(n.ifi, sub.group)
+	}
+	n.mu.Unlock()
+	n.send(typeLeave, topic, nil)
+	return err
+}
+
+// Publish 发布消息，不需要订阅topic
+func (n *Node) Publish(topic string, payload []byte) error {
+	return n.send(typeData, topic, payload)
+}
+
+func (n *Node) send(typ byte, topic string, payload []byte) error {
+	b, err := (&message{typ: typ, id: n.id, name: n.cfg.Name, topic: topic, payload: payload}).marshal()
+	if err != nil {
+		return err
+	}
+	return n.w.WriteTo(b, n.group(topic))
+}
+
+// Members topic当前在线的成员，不包括自己
+func (n *Node) Members(topic string) []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sub, ok := n.subs[topic]
+	if !ok {
+		return nil
+	}
+	list := make([]Member, 0, len(sub.members))
+	for _, m := range sub.members {
+		list = append(list, *m)
+	}
+	return list
+}
+
+// Dropped 因为订阅channel满而丢弃的消息数
+func (n *Node) Dropped() int64 {
+	return n.dropC.Load()
+}
+
+func (n *Node) readLoop() {
+	defer n.wg.Done()
+	r := fragment.NewReader(n.conn, 0, 0)
+	for {
+		b, src, err := r.ReadFrom()
+		var incomplete *fragment.IncompleteError
+		if errors.As(err, &incomplete) {
+			continue
+		}
+		if err != nil {
+			select {
+			case <-n.done:
+			default:
+				log.Println(err)
+			}
+			return
+		}
+		m, err := unmarshal(b)
+		if err != nil || m.id == n.id {
+			continue
+		}
+		n.handle(m, src)
+	}
+}
+
+func (n *Node) handle(m *message, src net.Addr) {
+	var events []Member
+	joined := true
+	n.mu.Lock()
+	sub, ok := n.subs[m.topic]
+	if !ok {
+		n.mu.Unlock()
+		return
+	}
+	switch m.typ {
+	case typeLeave:
+		if member, ok := sub.members[m.id]; ok {
+			delete(sub.members, m.id)
+			events = append(events, *member)
+			joined = false
+		}
+	case typeHeartbeat:
+		// 只有心跳维护成员，只发布不订阅的节点不发心跳，不算成员
+		member, ok := sub.members[m.id]
+		if !ok {
+			member = &Member{ID: m.id}
+			sub.members[m.id] = member
+		}
+		member.Name = m.name
+		member.Addr = src
+		member.LastSeen = time.Now()
+		if !ok {
+			events = append(events, *member)
+		}
+	case typeData:
+		from := Member{ID: m.id, Name: m.name, Addr: src}
+		if member, ok := sub.members[m.id]; ok {
+			from.LastSeen = member.LastSeen
+		}
+		msg := Message{Topic: m.topic, From: from, Payload: m.payload}
+		select {
+		case sub.ch <- msg:
+		default:
+			// 消费太慢，丢弃，组播本身也不保证送达
+			n.dropC.Add(1)
+		}
+	}
+	n.mu.Unlock()
+	if n.cfg.OnMember != nil {
+		for _, e := range events {
+			n.cfg.OnMember(m.topic, e, joined)
+		}
+	}
+}
+
+// heartbeatLoop 给订阅的topic发心跳，移除超时的成员
+func (n *Node) heartbeatLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.Heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+		type expired struct {
+			topic  string
+			member Member
+		}
+		var topics []string
+		var gone []expired
+		now := time.Now()
+		n.mu.Lock()
+		for topic, sub := range n.subs {
+			topics = append(topics, topic)
+			for id, m := range sub.members {
+				if now.Sub(m.LastSeen) > n.cfg.MemberTimeout {
+					delete(sub.members, id)
+					gone = append(gone, expired{topic, *m})
+				}
+			}
+		}
+		n.mu.Unlock()
+		for _, t := range topics {
+			if err := n.send(typeHeartbeat, t, nil); err != nil {
+				log.Println(err)
+			}
+		}
+		if n.cfg.OnMember != nil {
+			for _, e := range gone {
+				n.cfg.OnMember(e.topic, e.member, false)
+			}
+		}
+	}
+}
+
+// Close 取消所有订阅并关闭节点
+func (n *Node) Close() error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	n.closed = true
+	topics := make([]string, 0, len(n.subs))
+	for t := range n.subs {
+		topics = append(topics, t)
+	}
+	n.mu.Unlock()
+
+	for _, t := range topics {
+		n.Unsubscribe(t)
+	}
+	close(n.done)
+	err := n.conn.Close()
+	n.wg.Wait()
+	return err
+}
@@ -0,0 +1,232 @@
+package mcast
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.LocalAddr().(*net.UDPAddr).Port
+}
+
+// loNode 回环网卡上的节点
+func loNode(t *testing.T, port int, name string, onMember func(string, Member, bool)) *Node {
+	t.Helper()
+	n, err := New(Config{
+		Interface: "lo",
+		Port:      port,
+		Loopback:  true,
+		Name:      name,
+		Heartbeat: 50 * time.Millisecond,
+		OnMember:  onMember,
+	})
+	if err != nil {
+		t.Skip("回环网卡不支持组播:", err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func recv(t *testing.T, ch <-chan Message) Message {
+	t.Helper()
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(2 * time.Second):
+		t.Fatal("接收消息超时")
+	}
+	return Message{}
+}
+
+func TestPubSub(t *testing.T) {
+	port := freePort(t)
+	a := loNode(t, port, "a", nil)
+	b := loNode(t, port, "b", nil)
+
+	chA, err := a.Subscribe("news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chB, err := b.Subscribe("news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 另一个topic的消息不会收到
+	if _, err := b.Subscribe("other"); err != nil {
+		t.Fatal(err)
+	}
+
+	big := bytes.Repeat([]byte("0123456789"), 2000)
+	a.Publish("nobody", []byte("x"))
+	if err := a.Publish("news", big); err != nil {
+		t.Fatal(err)
+	}
+	m := recv(t, chB)
+	if m.Topic != "news" || m.From.ID != a.ID() || m.From.Name != "a" || !bytes.Equal(m.Payload, big) {
+		t.Errorf("收到的消息错误: %s %s %s %d", m.Topic, m.From.ID, m.From.Name, len(m.Payload))
+	}
+
+	// 自己发的消息不投递给自己
+	select {
+	case m := <-chA:
+		t.Errorf("收到了自己的消息: %v", m.From)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestMembership(t *testing.T) {
+	port := freePort(t)
+	var mu sync.Mutex
+	events := map[string]bool{}
+	a := loNode(t, port, "a", func(topic string, m Member, joined bool) {
+		mu.Lock()
+		events[m.Name] = joined
+		mu.Unlock()
+	})
+	b := loNode(t, port, "b", nil)
+
+	if _, err := a.Subscribe("room"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Subscribe("room"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, func() bool {
+		ms := a.Members("room")
+		return len(ms) == 1 && ms[0].ID == b.ID() && ms[0].Name == "b"
+	})
+	waitFor(t, func() bool { return len(b.Members("room")) == 1 })
+
+	b.Close()
+	waitFor(t, func() bool { return len(a.Members("room")) == 0 })
+	mu.Lock()
+	joined, ok := events["b"]
+	mu.Unlock()
+	if !ok || joined {
+		t.Errorf("没有收到b离开的通知")
+	}
+}
+
+func TestPublishOnly(t *testing.T) {
+	port := freePort(t)
+	var mu sync.Mutex
+	var events int
+	a := loNode(t, port, "a", func(string, Member, bool) {
+		mu.Lock()
+		events++
+		mu.Unlock()
+	})
+	b := loNode(t, port, "b", nil)
+	ch, err := a.Subscribe("news")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只发布不订阅的节点收到消息，但不是成员
+	b.Publish("news", []byte("hi"))
+	m := recv(t, ch)
+	if m.From.ID != b.ID() || m.From.Name != "b" || m.From.Addr == nil || string(m.Payload) != "hi" {
+		t.Errorf("收到的消息错误: %+v", m)
+	}
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	n := events
+	mu.Unlock()
+	if len(a.Members("news")) != 0 || n != 0 {
+		t.Errorf("只发布的节点不应该成为成员: members=%v events=%d", a.Members("news"), n)
+	}
+}
+
+func TestMemberTimeout(t *testing.T) {
+	port := freePort(t)
+	a := loNode(t, port, "a", nil)
+	b := loNode(t, port, "b", nil)
+	a.Subscribe("room")
+	b.Subscribe("room")
+	waitFor(t, func() bool { return len(a.Members("room")) == 1 })
+
+	// b不发离开消息直接停止心跳，a在超时后移除b
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	close(b.done)
+	b.conn.Close()
+	b.wg.Wait()
+	waitFor(t, func() bool { return len(a.Members("room")) == 0 })
+}
+
+func TestIPv6(t *testing.T) {
+	ifi, err := SelectInterface(true)
+	if err != nil || ifi.Flags&net.FlagLoopback != 0 {
+		t.Skip("没有支持IPv6组播的网卡")
+	}
+	port := freePort(t)
+	cfg := Config{Interface: ifi.Name, IPv6: true, Port: port, Loopback: true, Heartbeat: 50 * time.Millisecond}
+	a, err := New(cfg)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer a.Close()
+	b, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	ch, err := b.Subscribe("v6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Publish("v6", []byte("hello")); err != nil {
+		t.Skip(err)
+	}
+	if m := recv(t, ch); string(m.Payload) != "hello" {
+		t.Errorf("收到 %q", m.Payload)
+	}
+}
+
+func TestGroupFor(t *testing.T) {
+	g := GroupFor("news", false)
+	if !g.Equal(GroupFor("news", false)) || !g.IsMulticast() || g.To4()[0] != 239 {
+		t.Errorf("IPv4组播地址错误: %s", g)
+	}
+	g6 := GroupFor("news", true)
+	if !g6.IsMulticast() || g6.To4() != nil {
+		t.Errorf("IPv6组播地址错误: %s", g6)
+	}
+}
+
+func TestMessageCodec(t *testing.T) {
+	m := &message{typ: typeData, id: "id", name: "name", topic: "topic", payload: []byte("payload")}
+	b, err := m.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := unmarshal(b)
+	if err != nil || got.id != "id" || got.name != "name" || got.topic != "topic" || string(got.payload) != "payload" {
+		t.Errorf("编解码错误: %+v %v", got, err)
+	}
+	if _, err := unmarshal(b[:5]); err == nil {
+		t.Error("截断的消息应该解码失败")
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("等待超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,76 @@
+package mcast
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// 消息格式，整体再经过fragment分片
+//
+//	+------+-------+----+---------+------+-----------+-------+---------+
+//	| type | idLen | id | nameLen | name | topicLen  | topic | payload |
+//	+------+-------+----+---------+------+-----------+-------+---------+
+//	   1       1             1                1
+const (
+	typeData      byte = 1
+	typeHeartbeat byte = 2
+	typeLeave     byte = 3
+)
+
+var errMalformed = errors.New("mcast: malformed message")
+
+type message struct {
+	typ     byte
+	id      string
+	name    string
+	topic   string
+	payload []byte
+}
+
+func (m *message) marshal() ([]byte, error) {
+	if len(m.id) > 255 || len(m.name) > 255 || len(m.topic) > 255 {
+		return nil, errors.New("mcast: id, name or topic longer than 255")
+	}
+	buf := make([]byte, 0, 4+len(m.id)+len(m.name)+len(m.topic)+len(m.payload))
+	buf = append(buf, m.typ)
+	for _, s := range []string{m.id, m.name, m.topic} {
+		buf = append(buf, byte(len(s)))
+		buf = append(buf, s...)
+	}
+	return append(buf, m.payload...), nil
+}
+
+func unmarshal(b []byte) (*message, error) {
+	if len(b) < 1 {
+		return nil, errMalformed
+	}
+	m := &message{typ: b[0]}
+	b = b[1:]
+	var fields [3]string
+	for i := range fields {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return nil, errMalformed
+		}
+		fields[i] = string(b[1 : 1+int(b[0])])
+		b = b[1+int(b[0]):]
+	}
+	m.id, m.name, m.topic = fields[0], fields[1], fields[2]
+	m.payload = b
+	return m, nil
+}
+
+// Member 组内的一个成员
+type Member struct {
+	ID       string
+	Name     string
+	Addr     net.Addr
+	LastSeen time.Time
+}
+
+// Message 收到的消息
+type Message struct {
+	Topic   string
+	From    Member
+	Payload []byte
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package mcast
+
+import "syscall"
+
+func reuseControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
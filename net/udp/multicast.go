@@ -1,15 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"log"
 	"net"
 	"time"
 
+	"github.com/ilaziness/gopkg/net/udp/fragment"
+	"github.com/ilaziness/gopkg/net/udp/mcast"
 	"golang.org/x/net/ipv4"
 )
 
 // 多播(组播)
 // 组播理论上可以用在广域网，实际需要路由设备支持，运营商一般会关闭，所以实际广域网做不了组播
+// 收发都经过fragment分片，可以发送超过MTU的消息
 
 func multicast() {
 	general()
@@ -18,10 +23,9 @@ func multicast() {
 
 // 通用多播
 func general() {
-	// 网络接口, linux ifconfig
-	en4, err := net.InterfaceByName("enp0s3")
-	// windows
-	// en4, err := net.InterfaceByName("以太网")
+	// 网络接口，自动选择支持组播的网卡
+	// 也可以指定名字，linux ifconfig: net.InterfaceByName("enp0s3")，windows: net.InterfaceByName("以太网")
+	en4, err := mcast.SelectInterface(false)
 	if err != nil {
 		log.Println(err)
 		return
@@ -57,13 +61,15 @@ func general() {
 	//接收数据包
 	go func() {
 		b := make([]byte, 1500)
+		// ipv4.PacketConn需要控制消息，不能直接用fragment.Reader，自己把分片交给Reassembler
+		r := fragment.NewReassembler(0, 0)
 		for {
 			n, cm, src, err := p.ReadFrom(b)
 			if err != nil {
 				log.Println(err)
 				return
 			}
-			log.Printf("received1: %s from <%s>\n", b[:n], src)
+			log.Printf("received1: %d bytes from <%s>\n", n, src)
 			// 需要设置SetControlMessage
 			if cm.Dst.IsMulticast() {
 				// 检查包是否同一个组的包
@@ -71,8 +77,20 @@ func general() {
 					log.Println("Unknown group")
 					continue
 				}
-				log.Printf("received: %s from <%s>\n", b[:n], src)
-				_, err = p.WriteTo([]byte("world"), cm, src)
+				msg, err := r.Add(src.String(), b[:n])
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				for _, e := range r.Incomplete() {
+					log.Println(e)
+				}
+				if msg == nil {
+					continue
+				}
+				log.Printf("received: %d bytes from <%s>\n", len(msg), src)
+				frags, _ := fragment.Split(0, []byte("world"), fragment.DefaultMTU)
+				_, err = p.WriteTo(frags[0], cm, src)
 				if err != nil {
 					log.Println(err)
 				}
@@ -87,10 +105,18 @@ func general() {
 		return
 	}
 	p.SetMulticastTTL(5)
-	if _, err := p.WriteTo([]byte("hello"), nil, dst); err != nil {
+	// 8KB，超过MTU，分成多个分片发送
+	frags, err := fragment.Split(1, bytes.Repeat([]byte("hello"), 1600), fragment.DefaultMTU)
+	if err != nil {
 		log.Println(err)
 		return
 	}
+	for _, f := range frags {
+		if _, err := p.WriteTo(f, nil, dst); err != nil {
+			log.Println(err)
+			return
+		}
+	}
 
 	generalClient()
 	time.Sleep(time.Second * 2)
@@ -105,7 +131,7 @@ func generalClient() {
 		log.Println(err)
 	}
 	defer conn.Close()
-	conn.Write([]byte("hello2 1024 server"))
+	sendFragments(conn, 2, bytes.Repeat([]byte("hello2 1024 server"), 500))
 	log.Printf("generalClient <%s>\n", conn.RemoteAddr())
 }
 
@@ -125,13 +151,19 @@ func stdlib() {
 	log.Printf("Local: <%s> \n", listener.LocalAddr().String())
 
 	go func() {
-		data := make([]byte, 1024)
+		r := fragment.NewReader(listener, 0, 0)
 		for {
-			n, remoteAddr, err := listener.ReadFromUDP(data)
+			data, remoteAddr, err := r.ReadFrom()
+			var incomplete *fragment.IncompleteError
+			if errors.As(err, &incomplete) {
+				log.Println(err)
+				continue
+			}
 			if err != nil {
 				log.Printf("error during read: %s", err)
+				return
 			}
-			log.Printf("stdlib receive <%s> %s\n", remoteAddr, data[:n])
+			log.Printf("stdlib receive <%s> %d bytes\n", remoteAddr, len(data))
 		}
 	}()
 	stdlibClient()
@@ -148,6 +180,21 @@ func stdlibClient() {
 		log.Println(err)
 	}
 	defer conn.Close()
-	conn.Write([]byte("hello"))
+	sendFragments(conn, 1, bytes.Repeat([]byte("hello"), 1600))
 	log.Printf("stdlibClient <%s>\n", conn.RemoteAddr())
 }
+
+// sendFragments 已连接的UDPConn不能用WriteTo，分片后逐个Write
+func sendFragments(conn *net.UDPConn, id uint32, msg []byte) {
+	frags, err := fragment.Split(id, msg, fragment.DefaultMTU)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, f := range frags {
+		if _, err := conn.Write(f); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
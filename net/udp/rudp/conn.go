@@ -0,0 +1,561 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// 基于UDP的可靠有序传输
+//   - 每个数据包有序号，接收方按序号重新排序后交给Read，重复的包直接丢弃
+//   - 确认包带累计确认和选择确认(SACK)，只重传真正丢失的包
+//   - 超时重传，RTO按RFC 6298根据RTT估算，超时后指数退避
+//   - 滑动窗口限制未确认包的数量，接收方通过wnd通告剩余缓冲
+//   - Close发送FIN，对端Read读完数据后返回io.EOF
+
+const (
+	// MSS 每个包最多携带的数据，加上IP/UDP头和包头不超过以太网MTU 1500
+	MSS = 1200
+	// windowSize 最多未确认的包数，和SACK位图长度一致
+	windowSize = 64
+	// 接收缓冲上限，超过后通告窗口为0，发送方暂停
+	maxReadBuffer = windowSize * MSS * 4
+
+	initRTO = 200 * time.Millisecond
+	// 局域网RTT很小，最小RTO比RFC 6298的1秒小
+	minRTO = 20 * time.Millisecond
+	maxRTO = 5 * time.Second
+
+	tickInterval  = 10 * time.Millisecond
+	lingerTimeout = 5 * time.Second
+)
+
+// DeadPeerTimeout 新连接的对端不可达超时
+var DeadPeerTimeout = 15 * time.Second
+
+var ErrBroken = errors.New("rudp: peer not responding")
+
+type segment struct {
+	seq      uint32
+	fin      bool
+	data     []byte
+	sentAt   time.Time
+	deadline time.Time
+	retries  int
+	// 被SACK越过的次数，达到3次快速重传
+	skipped int
+}
+
+// Conn 可靠UDP连接，实现net.Conn
+type Conn struct {
+	pc      net.PacketConn
+	raddr   net.Addr
+	onClose func()
+	// 有未确认数据时，超过这个时间没有收到任何确认，认为对端已不可达
+	deadTimeout time.Duration
+
+	mu sync.Mutex
+
+	// 发送
+	sndUna    uint32
+	sndNxt    uint32
+	inflight  map[uint32]*segment
+	peerWnd   int
+	lastAckAt time.Time
+	finSent   bool
+	srtt      time.Duration
+	rttvar    time.Duration
+	rto       time.Duration
+
+	// 接收
+	rcvNxt     uint32
+	ooo        map[uint32]*segment
+	ready      [][]byte
+	readyBytes int
+	eof        bool
+
+	err           error
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readable  chan struct{}
+	writable  chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(pc net.PacketConn, raddr net.Addr, onClose func()) *Conn {
+	c := &Conn{
+		pc:          pc,
+		raddr:       raddr,
+		onClose:     onClose,
+		deadTimeout: DeadPeerTimeout,
+		inflight:    make(map[uint32]*segment),
+		peerWnd:     windowSize,
+		rto:         initRTO,
+		ooo:         make(map[uint32]*segment),
+		readable:    make(chan struct{}, 1),
+		writable:    make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+	}
+	go c.timerLoop()
+	return c
+}
+
+// Dial 连接raddr，network是udp、udp4或udp6
+func Dial(network, address string) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenPacket(network, "")
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(pc, raddr), nil
+}
+
+// NewConn 在pc上建立到raddr的连接，pc由连接独占，Close时一起关闭
+func NewConn(pc net.PacketConn, raddr net.Addr) *Conn {
+	c := newConn(pc, raddr, func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				c.mu.Lock()
+				c.fail(net.ErrClosed)
+				c.mu.Unlock()
+				return
+			}
+			if addr.String() == raddr.String() {
+				c.input(buf[:n])
+			}
+		}
+	}()
+	return c
+}
+
+// input 处理收到的包
+func (c *Conn) input(pkt []byte) {
+	if len(pkt) < headerSize {
+		return
+	}
+	seq := binary.BigEndian.Uint32(pkt[2:])
+	switch pkt[0] {
+	case typeData:
+		c.onData(seq, pkt[1]&flagFin != 0, pkt[headerSize:])
+	case typeAck:
+		if len(pkt) < ackSize {
+			return
+		}
+		c.onAck(seq, binary.BigEndian.Uint64(pkt[6:]), int(binary.BigEndian.Uint16(pkt[14:])))
+	}
+}
+
+func (c *Conn) onData(seq uint32, fin bool, payload []byte) {
+	c.mu.Lock()
+	d := seqDiff(seq, c.rcvNxt)
+	// d<0 是已经收到过的重复包，只需要回确认；超出窗口和缓冲已满时丢弃
+	if d >= 0 && d < windowSize && c.readyBytes < maxReadBuffer {
+		if _, dup := c.ooo[seq]; !dup {
+			data := make([]byte, len(payload))
+			copy(data, payload)
+			c.ooo[seq] = &segment{seq: seq, fin: fin, data: data}
+		}
+		// 按序交付
+		delivered := false
+		for {
+			s, ok := c.ooo[c.rcvNxt]
+			if !ok {
+				break
+			}
+			delete(c.ooo, c.rcvNxt)
+			c.rcvNxt++
+			if s.fin {
+				c.eof = true
+			} else if len(s.data) > 0 {
+				c.ready = append(c.ready, s.data)
+				c.readyBytes += len(s.data)
+			}
+			delivered = true
+		}
+		if delivered {
+			notify(c.readable)
+		}
+	}
+	ack := c.ackPacket()
+	c.mu.Unlock()
+	c.pc.WriteTo(ack, c.raddr)
+}
+
+// ackPacket 当前接收状态的确认包，需持有锁
+func (c *Conn) ackPacket() []byte {
+	var sack uint64
+	for seq := range c.ooo {
+		if i := seqDiff(seq, c.rcvNxt) - 1; i >= 0 && i < 64 {
+			sack |= 1 << uint(i)
+		}
+	}
+	wnd := (maxReadBuffer - c.readyBytes) / MSS
+	if wnd > windowSize {
+		wnd = windowSize
+	}
+	if wnd < 0 {
+		wnd = 0
+	}
+	return marshalAck(c.rcvNxt, sack, uint16(wnd))
+}
+
+func (c *Conn) onAck(ack uint32, sack uint64, wnd int) {
+	now := time.Now()
+	var fast []*segment
+	c.mu.Lock()
+	c.lastAckAt = now
+	c.peerWnd = wnd
+	for seq, s := range c.inflight {
+		d := seqDiff(seq, ack)
+		acked := d < 0
+		if !acked && d > 0 && d <= 64 && sack&(1<<uint(d-1)) != 0 {
+			acked = true
+		}
+		if !acked {
+			continue
+		}
+		// Karn算法：重传过的包无法判断确认对应哪次发送，不参与RTT估算
+		if s.retries == 0 {
+			c.updateRTO(now.Sub(s.sentAt))
+		}
+		delete(c.inflight, seq)
+	}
+	// 窗口左边界移到最早未确认的包
+	for c.sndUna != c.sndNxt {
+		if _, ok := c.inflight[c.sndUna]; ok {
+			break
+		}
+		c.sndUna++
+	}
+	// 有后面的包被SACK确认，说明ack对应的包大概率丢了，不等超时，3次后快速重传
+	if sack != 0 {
+		if s, ok := c.inflight[ack]; ok {
+			s.skipped++
+			if s.skipped == 3 {
+				s.retries++
+				s.sentAt = now
+				s.deadline = now.Add(c.backoff(s))
+				fast = append(fast, s)
+			}
+		}
+	}
+	notify(c.writable)
+	c.mu.Unlock()
+	for _, s := range fast {
+		c.send(s)
+	}
+}
+
+// updateRTO RFC 6298
+func (c *Conn) updateRTO(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		delta := c.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < minRTO {
+		c.rto = minRTO
+	}
+	if c.rto > maxRTO {
+		c.rto = maxRTO
+	}
+}
+
+// backoff 包每超时一次，RTO翻倍
+func (c *Conn) backoff(s *segment) time.Duration {
+	rto := c.rto
+	for i := 0; i < s.retries && rto < maxRTO; i++ {
+		rto *= 2
+	}
+	if rto > maxRTO {
+		rto = maxRTO
+	}
+	return rto
+}
+
+// timerLoop 超时重传
+func (c *Conn) timerLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		var resend []*segment
+		c.mu.Lock()
+		for _, s := range c.inflight {
+			if now.After(s.deadline) {
+				s.retries++
+				s.sentAt = now
+				resend = append(resend, s)
+			}
+		}
+		for _, s := range resend {
+			s.deadline = now.Add(c.backoff(s))
+		}
+		if len(c.inflight) > 0 && now.Sub(c.lastAckAt) > c.deadTimeout {
+			c.fail(ErrBroken)
+			resend = nil
+		}
+		c.mu.Unlock()
+		for _, s := range resend {
+			c.send(s)
+		}
+	}
+}
+
+func (c *Conn) send(s *segment) error {
+	_, err := c.pc.WriteTo(marshalData(s.seq, s.fin, s.data), c.raddr)
+	return err
+}
+
+// queue 分配序号放入发送窗口，需持有锁
+func (c *Conn) queue(data []byte, fin bool) *segment {
+	now := time.Now()
+	if len(c.inflight) == 0 {
+		c.lastAckAt = now
+	}
+	s := &segment{seq: c.sndNxt, fin: fin, data: data, sentAt: now, deadline: now.Add(c.rto)}
+	c.sndNxt++
+	c.inflight[s.seq] = s
+	return s
+}
+
+// sendWindow 窗口大小，[sndUna, sndUna+窗口) 内的序号可以发送
+// 至少为1，接收方窗口为0时也能探测
+func (c *Conn) sendWindow() int {
+	w := c.peerWnd
+	if w > windowSize {
+		w = windowSize
+	}
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
+
+// fail 连接出错，唤醒所有等待的读写，需持有锁
+func (c *Conn) fail(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	notify(c.readable)
+	notify(c.writable)
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.ready) > 0 {
+			n := copy(b, c.ready[0])
+			if n < len(c.ready[0]) {
+				c.ready[0] = c.ready[0][n:]
+			} else {
+				c.ready = c.ready[1:]
+			}
+			full := c.readyBytes >= maxReadBuffer
+			c.readyBytes -= n
+			var update []byte
+			// 缓冲从满变为不满，通知发送方窗口更新
+			if full && c.readyBytes < maxReadBuffer {
+				update = c.ackPacket()
+			}
+			if len(c.ready) > 0 {
+				notify(c.readable)
+			}
+			c.mu.Unlock()
+			if update != nil {
+				c.pc.WriteTo(update, c.raddr)
+			}
+			return n, nil
+		}
+		if c.eof {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+		if err := c.wait(c.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 数据按MSS分包放入发送窗口，窗口满时阻塞，返回时数据不一定已被确认
+func (c *Conn) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		c.mu.Lock()
+		if c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return n, err
+		}
+		if c.finSent {
+			c.mu.Unlock()
+			return n, net.ErrClosed
+		}
+		if int(seqDiff(c.sndNxt, c.sndUna)) >= c.sendWindow() {
+			deadline := c.writeDeadline
+			c.mu.Unlock()
+			if err := c.wait(c.writable, deadline); err != nil {
+				return n, err
+			}
+			continue
+		}
+		size := len(b)
+		if size > MSS {
+			size = MSS
+		}
+		data := make([]byte, size)
+		copy(data, b)
+		s := c.queue(data, false)
+		c.mu.Unlock()
+
+		if err := c.send(s); err != nil {
+			return n, err
+		}
+		n += size
+		b = b[size:]
+	}
+	return n, nil
+}
+
+// wait 等待状态变化，deadline为零值表示不超时
+func (c *Conn) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
+
+// CloseWrite 关闭写方向，对端读完已发送的数据后返回io.EOF，本端还可以继续读
+func (c *Conn) CloseWrite() error {
+	c.mu.Lock()
+	if c.err != nil || c.finSent {
+		c.mu.Unlock()
+		return nil
+	}
+	c.finSent = true
+	fin := c.queue(nil, true)
+	c.mu.Unlock()
+	return c.send(fin)
+}
+
+// Close 发送FIN，等待已发送的数据全部确认(最多lingerTimeout)后释放连接
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		// 没发过任何数据的话对端并不知道这个连接，不需要FIN
+		silent := c.sndNxt == 0
+		c.mu.Unlock()
+		if !silent {
+			c.CloseWrite()
+		}
+
+		linger := time.NewTimer(lingerTimeout)
+		defer linger.Stop()
+	wait:
+		for {
+			c.mu.Lock()
+			done := len(c.inflight) == 0 || c.err != nil
+			c.mu.Unlock()
+			if done {
+				break
+			}
+			select {
+			case <-c.writable:
+			case <-linger.C:
+				break wait
+			}
+		}
+
+		c.mu.Lock()
+		c.fail(net.ErrClosed)
+		c.mu.Unlock()
+		close(c.closed)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.pc.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	c.SetWriteDeadline(t)
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	// 唤醒等待中的Read，按新的截止时间重新等待
+	notify(c.readable)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	notify(c.writable)
+	return nil
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,109 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// Listener 在一个UDP socket上按对端地址区分连接
+type Listener struct {
+	pc net.PacketConn
+
+	mu     sync.Mutex
+	conns  map[string]*Conn
+	accept chan *Conn
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen network是udp、udp4或udp6
+func Listen(network, address string) (*Listener, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(pc), nil
+}
+
+// NewListener 在已有的pc上监听，Close时关闭pc
+func NewListener(pc net.PacketConn) *Listener {
+	l := &Listener{
+		pc:     pc,
+		conns:  make(map[string]*Conn),
+		accept: make(chan *Conn, 128),
+		closed: make(chan struct{}),
+	}
+	go l.readLoop()
+	return l
+}
+
+func (l *Listener) readLoop() {
+	defer l.Close()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		key := addr.String()
+		l.mu.Lock()
+		c, ok := l.conns[key]
+		if !ok {
+			// 只有序号为0的数据包能建立新连接，其他包可能是已关闭连接迟到的重传
+			if n < headerSize || buf[0] != typeData || buf[1]&flagFin != 0 || binary.BigEndian.Uint32(buf[2:]) != 0 {
+				l.mu.Unlock()
+				continue
+			}
+			c = newConn(l.pc, addr, func() { l.remove(key) })
+			select {
+			case l.accept <- c:
+				l.conns[key] = c
+			default:
+				// Accept来不及处理，丢弃，对端会重传
+				l.mu.Unlock()
+				close(c.closed)
+				continue
+			}
+		}
+		l.mu.Unlock()
+		c.input(buf[:n])
+	}
+}
+
+func (l *Listener) remove(key string) {
+	l.mu.Lock()
+	delete(l.conns, key)
+	l.mu.Unlock()
+}
+
+// Accept 等待新连接
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 关闭监听，已建立的连接也无法继续收发
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.pc.Close()
+		l.mu.Lock()
+		for _, c := range l.conns {
+			c.mu.Lock()
+			c.fail(net.ErrClosed)
+			c.mu.Unlock()
+		}
+		l.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
@@ -0,0 +1,60 @@
+package rudp
+
+import (
+	"encoding/binary"
+)
+
+// 包格式
+//
+// 数据包：
+//
+//	+------+-------+-----+---------+
+//	| type | flags | seq | payload |
+//	+------+-------+-----+---------+
+//	   1       1     4
+//
+// 确认包：
+//
+//	+------+-------+-----+------+-----+
+//	| type | flags | ack | sack | wnd |
+//	+------+-------+-----+------+-----+
+//	   1       1     4      8      2
+//
+// ack：累计确认，期望收到的下一个序号，之前的都已收到
+// sack：选择确认位图，第i位表示 ack+1+i 已收到，发送方不用重传这些包
+// wnd：接收方还能缓存多少个包，用于流量控制
+
+const (
+	typeData byte = 1
+	typeAck  byte = 2
+
+	flagFin byte = 1
+
+	headerSize = 6
+	ackSize    = headerSize + 8 + 2
+)
+
+func marshalData(seq uint32, fin bool, payload []byte) []byte {
+	buf := make([]byte, headerSize+len(payload))
+	buf[0] = typeData
+	if fin {
+		buf[1] = flagFin
+	}
+	binary.BigEndian.PutUint32(buf[2:], seq)
+	copy(buf[headerSize:], payload)
+	return buf
+}
+
+func marshalAck(ack uint32, sack uint64, wnd uint16) []byte {
+	buf := make([]byte, ackSize)
+	buf[0] = typeAck
+	binary.BigEndian.PutUint32(buf[2:], ack)
+	binary.BigEndian.PutUint64(buf[6:], sack)
+	binary.BigEndian.PutUint16(buf[14:], wnd)
+	return buf
+}
+
+// seqDiff a-b，考虑uint32回绕
+func seqDiff(a, b uint32) int32 {
+	return int32(a - b)
+}
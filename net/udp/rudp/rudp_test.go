@@ -0,0 +1,172 @@
+package rudp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	mrand "math/rand"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn 模拟不可靠网络：丢包、重复、乱序
+type lossyConn struct {
+	net.PacketConn
+	loss, dup, reorder float64
+
+	mu  sync.Mutex
+	rnd *mrand.Rand
+}
+
+func newLossyConn(pc net.PacketConn, loss, dup, reorder float64) *lossyConn {
+	return &lossyConn{PacketConn: pc, loss: loss, dup: dup, reorder: reorder, rnd: mrand.New(mrand.NewSource(1))}
+}
+
+func (l *lossyConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	l.mu.Lock()
+	drop := l.rnd.Float64() < l.loss
+	dup := l.rnd.Float64() < l.dup
+	delay := time.Duration(0)
+	if l.rnd.Float64() < l.reorder {
+		delay = time.Duration(l.rnd.Intn(20)+1) * time.Millisecond
+	}
+	l.mu.Unlock()
+	if drop {
+		return len(p), nil
+	}
+	n := 1
+	if dup {
+		n = 2
+	}
+	for i := 0; i < n; i++ {
+		if delay > 0 {
+			// 延迟发送，后发的包先到
+			b := append([]byte(nil), p...)
+			time.AfterFunc(delay, func() { l.PacketConn.WriteTo(b, addr) })
+			continue
+		}
+		if _, err := l.PacketConn.WriteTo(p, addr); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func pair(t *testing.T, loss, dup, reorder float64) (*Listener, *Conn) {
+	t.Helper()
+	spc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(newLossyConn(spc, loss, dup, reorder))
+	c := NewConn(newLossyConn(cpc, loss, dup, reorder), spc.LocalAddr())
+	t.Cleanup(func() { l.Close() })
+	return l, c
+}
+
+func TestTransfer(t *testing.T) {
+	cases := []struct {
+		name               string
+		loss, dup, reorder float64
+	}{
+		{"clean", 0, 0, 0},
+		{"loss", 0.2, 0, 0},
+		{"dup", 0, 0.3, 0},
+		{"reorder", 0, 0, 0.3},
+		{"all", 0.1, 0.1, 0.2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, c := pair(t, tc.loss, tc.dup, tc.reorder)
+
+			data := make([]byte, 300*1024)
+			rand.Read(data)
+
+			// 服务端读完后原样写回
+			go func() {
+				sc, err := l.Accept()
+				if err != nil {
+					return
+				}
+				got, _ := io.ReadAll(sc)
+				sc.Write(got)
+				sc.Close()
+			}()
+
+			go func() {
+				c.Write(data)
+				// 只关闭写，还要读回显
+				c.CloseWrite()
+			}()
+
+			c.SetReadDeadline(time.Now().Add(30 * time.Second))
+			echo, err := io.ReadAll(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(echo, data) {
+				t.Errorf("回显数据不一致: 长度 %d, 期望 %d", len(echo), len(data))
+			}
+			c.Close()
+		})
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	_, c := pair(t, 0, 0, 0)
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err := c.Read(make([]byte, 10))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("期望超时错误，实际 %v", err)
+	}
+}
+
+func TestPeerDead(t *testing.T) {
+	old := DeadPeerTimeout
+	DeadPeerTimeout = 300 * time.Millisecond
+	defer func() { DeadPeerTimeout = old }()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 对端收到包但从不确认
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	c := NewConn(pc, peer.LocalAddr())
+	defer c.Close()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = c.Read(make([]byte, 10))
+	if !errors.Is(err, ErrBroken) {
+		t.Errorf("期望 ErrBroken，实际 %v", err)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Error("对端不可达没有被检测到")
+	}
+}
+
+func TestSeqWrap(t *testing.T) {
+	if seqDiff(1, 0xffffffff) != 2 {
+		t.Errorf("seqDiff 回绕错误: %d", seqDiff(1, 0xffffffff))
+	}
+	if seqDiff(0xffffffff, 1) != -2 {
+		t.Errorf("seqDiff 回绕错误: %d", seqDiff(0xffffffff, 1))
+	}
+}